@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccountCode is a chart-of-accounts entry a category is booked against
+type AccountCode struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// ExportTransaction is a transaction submitted for accounting export
+type ExportTransaction struct {
+	TransactionRequest
+	Date      string `json:"date" binding:"required"`
+	Reference string `json:"reference"`
+}
+
+// AccountingExportRequest is the body accepted by the accounting export endpoint
+type AccountingExportRequest struct {
+	Transactions []ExportTransaction `json:"transactions" binding:"required,dive"`
+}
+
+// defaultChartOfAccounts maps categories onto the Xero default UK chart of accounts. Money
+// moved rather than spent is booked to balance-sheet accounts: transfers and investments to
+// the transfers clearing account, debt repayments to the loan liability. Transactions the
+// classifier couldn't settle go to suspense for the bookkeeper to clear.
+var defaultChartOfAccounts = map[string]AccountCode{
+	"Income":               {Code: "200", Name: "Sales"},
+	"Transport":            {Code: "493", Name: "Travel - National"},
+	"Food & Drink":         {Code: "420", Name: "Entertainment"},
+	"Shopping":             {Code: "429", Name: "General Expenses"},
+	"Groceries":            {Code: "429", Name: "General Expenses"},
+	"Entertainment":        {Code: "420", Name: "Entertainment"},
+	"Bills & Utilities":    {Code: "445", Name: "Light, Power, Heating"},
+	"ATM":                  {Code: "429", Name: "General Expenses"},
+	"Housing":              {Code: "469", Name: "Rent"},
+	"Fees":                 {Code: "404", Name: "Bank Fees"},
+	"Card Verification":    {Code: "404", Name: "Bank Fees"},
+	"Donations":            {Code: "429", Name: "General Expenses"},
+	CategoryTransfers:      {Code: "877", Name: "Tracking Transfers"},
+	CategoryInvestments:    {Code: "877", Name: "Tracking Transfers"},
+	CategoryDebtRepayments: {Code: "900", Name: "Loan"},
+	CategoryUncategorized:  {Code: "850", Name: "Suspense"},
+	CategoryNeedsReview:    {Code: "850", Name: "Suspense"},
+	"Other":                {Code: "429", Name: "General Expenses"},
+}
+
+// chartOfAccounts is the active category to account code mapping
+var chartOfAccounts = defaultChartOfAccounts
+
+// loadChartOfAccounts overlays category mappings from a JSON file onto the defaults
+func loadChartOfAccounts(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	overrides := map[string]AccountCode{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	chart := make(map[string]AccountCode, len(defaultChartOfAccounts)+len(overrides))
+	for category, account := range defaultChartOfAccounts {
+		chart[category] = account
+	}
+	for category, account := range overrides {
+		if account.Code == "" && account.Name == "" {
+			return fmt.Errorf("category %q has neither a code nor a name", category)
+		}
+		chart[category] = account
+	}
+
+	chartOfAccounts = chart
+	return nil
+}
+
+// accountFor returns the account a category is booked against, falling back to Other
+func accountFor(category string) AccountCode {
+	if account, ok := chartOfAccounts[category]; ok {
+		return account
+	}
+	return chartOfAccounts["Other"]
+}
+
+// csvCell neutralises a text cell a spreadsheet would otherwise evaluate as a formula, such
+// as a merchant name starting with "=", by prefixing it with an apostrophe
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// signedAmount returns the amount as money in (positive) or money out (negative)
+func signedAmount(tx ExportTransaction) float64 {
+	if strings.ToLower(tx.TransactionType) == "credit" {
+		return tx.Amount
+	}
+	return -tx.Amount
+}
+
+// writeXeroCSV writes transactions in Xero's bank statement import layout with an account code column
+func (s *Server) writeXeroCSV(w *csv.Writer, transactions []ExportTransaction) error {
+	if err := w.Write([]string{"*Date", "*Amount", "Payee", "Description", "Reference", "Account Code"}); err != nil {
+		return err
+	}
+	for _, tx := range transactions {
+		category := s.categorizeTransaction(tx.Merchant, tx.Description, tx.Amount, tx.TransactionType)
+		account := accountFor(category)
+		record := []string{
+			tx.Date,
+			strconv.FormatFloat(signedAmount(tx), 'f', 2, 64),
+			csvCell(tx.Merchant),
+			csvCell(tx.Description),
+			csvCell(tx.Reference),
+			csvCell(account.Code),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeQuickBooksCSV writes transactions in QuickBooks' three-column bank import layout with an account column
+func (s *Server) writeQuickBooksCSV(w *csv.Writer, transactions []ExportTransaction) error {
+	if err := w.Write([]string{"Date", "Description", "Amount", "Account"}); err != nil {
+		return err
+	}
+	for _, tx := range transactions {
+		category := s.categorizeTransaction(tx.Merchant, tx.Description, tx.Amount, tx.TransactionType)
+		account := accountFor(category)
+		description := tx.Merchant
+		if tx.Description != "" {
+			description = tx.Merchant + " - " + tx.Description
+		}
+		record := []string{
+			tx.Date,
+			csvCell(description),
+			strconv.FormatFloat(signedAmount(tx), 'f', 2, 64),
+			csvCell(account.Name),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountingExporters maps export formats to their CSV writers. Transactions are exported as
+// the packages' bank statement imports rather than pushed through the Xero and QuickBooks APIs:
+// a push needs an OAuth connection to each business's ledger, which the service doesn't hold.
+var accountingExporters = map[string]func(*Server, *csv.Writer, []ExportTransaction) error{
+	"xero":       (*Server).writeXeroCSV,
+	"quickbooks": (*Server).writeQuickBooksCSV,
+}
+
+// handleAccountingExport serves POST /export/accounting
+func (s *Server) handleAccountingExport(c *gin.Context) {
+	format := strings.ToLower(c.DefaultQuery("format", "xero"))
+	exporter, ok := accountingExporters[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported export format %q", format)})
+		return
+	}
+
+	var req AccountingExportRequest
+	err := c.ShouldBindJSON(&req)
+	for i := 0; err == nil && i < len(req.Transactions); i++ {
+		if err = s.normalizeTransaction(c, &req.Transactions[i].TransactionRequest); err != nil {
+			err = fmt.Errorf("transactions[%d]: %w", i, err)
+		}
+	}
+	if err != nil {
+		s.metrics.recordCategorizationError("bad_request")
+		s.requestLogger(c).logCategorizationError("bad_request", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := exporter(s, w, req.Transactions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-export.csv", format))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestChartOfAccountsCoversCategories checks every category the classifier can assign has its
+// own account, so none silently falls back to Other's
+func TestChartOfAccountsCoversCategories(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry(), "test", nil)
+	s := NewServer(Config{}, NewStructuredLogger("test", nil), m, newMemoryStore(0), NewClassifier(defaultRuleSet(), nil, m))
+
+	categories := s.knownCategories()
+	categories[CategoryUncategorized] = true
+	categories[CategoryNeedsReview] = true
+	for category := range categories {
+		if _, ok := defaultChartOfAccounts[category]; !ok {
+			t.Errorf("category %q has no account in the default chart", category)
+		}
+	}
+}
+
+// TestCSVCellEscapesFormulas checks text cells a spreadsheet would evaluate are neutralised
+func TestCSVCellEscapesFormulas(t *testing.T) {
+	for value, want := range map[string]string{
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+44 20 7946":       "'+44 20 7946",
+		"-2+3":              "'-2+3",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"Tesco":             "Tesco",
+		"":                  "",
+	} {
+		if got := csvCell(value); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
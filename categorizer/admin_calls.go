@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCallBodyRecorded is the largest JSON request body kept verbatim in an admin call record;
+// larger or non-JSON bodies, such as restored snapshots, are recorded by size and digest
+const maxCallBodyRecorded = 8 << 10
+
+// maxAdminCallsHeld is how many of the latest admin calls are held in memory to serve
+// queries; older ones are only in the log file
+const maxAdminCallsHeld = 10000
+
+// genesisHash is the previous hash of the first admin call in a chain
+var genesisHash = strings.Repeat("0", 64)
+
+// AdminCall records one request to an admin endpoint: who made it, what they asked for and
+// how it was answered. Each call's hash covers its contents and the previous call's hash, so
+// editing, removing or reordering a recorded call breaks the chain from that point on.
+type AdminCall struct {
+	Sequence   int64             `json:"seq"`
+	Time       time.Time         `json:"time"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Caller     string            `json:"caller"`
+	ClientIP   string            `json:"client_ip"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Path       string            `json:"path"`
+	Params     map[string]string `json:"params,omitempty"`
+	Query      map[string]string `json:"query,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	BodyBytes  int64             `json:"body_bytes,omitempty"`
+	BodySHA256 string            `json:"body_sha256,omitempty"`
+	Status     int               `json:"status"`
+	DurationMS float64           `json:"duration_ms"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
+
+// digest returns the hash of a call chained onto its previous hash
+func (call AdminCall) digest() string {
+	call.Hash = ""
+	data, _ := json.Marshal(call)
+	sum := sha256.Sum256(append([]byte(call.PrevHash), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainCheck is the outcome of verifying the admin call chain
+type ChainCheck struct {
+	Valid    bool   `json:"valid"`
+	Calls    int    `json:"calls"`
+	Head     string `json:"head"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+
+	// seq is the sequence number of the last call checked
+	seq int64
+}
+
+// add checks the next call in the chain, recording where and why it broke if it doesn't follow
+// the calls checked so far
+func (check *ChainCheck) add(call AdminCall) bool {
+	switch {
+	case call.Sequence != check.seq+1:
+		check.Reason = fmt.Sprintf("expected seq %d", check.seq+1)
+	case call.PrevHash != check.Head:
+		check.Reason = "previous hash does not match"
+	case call.digest() != call.Hash:
+		check.Reason = "hash does not match contents"
+	default:
+		check.Head, check.seq = call.Hash, call.Sequence
+		return true
+	}
+	check.Valid, check.BrokenAt = false, call.Sequence
+	return false
+}
+
+// adminCallLog is a hash-chained record of admin API usage, kept apart from the audit log of
+// data changes. The latest calls are held in memory to serve queries; with a file configured
+// each is first appended to it as a JSON line and reloaded on startup, extending the same
+// chain.
+type adminCallLog struct {
+	mu    sync.RWMutex
+	calls []AdminCall
+	limit int
+	// trimmedSeq and trimmedHash are the sequence number and hash of the last call dropped
+	// from memory, which the held calls chain on from
+	trimmedSeq  int64
+	trimmedHash string
+	file        *os.File
+}
+
+// newAdminCallLog creates an empty log holding at most limit calls in memory
+func newAdminCallLog(limit int) *adminCallLog {
+	return &adminCallLog{limit: limit, trimmedHash: genesisHash}
+}
+
+// hold adds a call to those held in memory, dropping the oldest tenth once over the limit
+func (l *adminCallLog) hold(call AdminCall) {
+	l.calls = append(l.calls, call)
+	if len(l.calls) <= l.limit {
+		return
+	}
+	drop := len(l.calls) - l.limit + l.limit/10
+	l.trimmedSeq, l.trimmedHash = l.calls[drop-1].Sequence, l.calls[drop-1].Hash
+	l.calls = append([]AdminCall{}, l.calls[drop:]...)
+}
+
+// Open reloads the calls in a log file, verifying the whole chain as it goes, and appends new
+// calls to it. A file whose chain no longer verifies is still loaded, and reported to logger,
+// so the evidence isn't lost.
+func (l *adminCallLog) Open(path string, logger *StructuredLogger) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	check := ChainCheck{Valid: true, Head: genesisHash}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var call AdminCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			l.mu.Unlock()
+			f.Close()
+			return fmt.Errorf("parse %s line %d: %w", path, line, err)
+		}
+		if check.Valid {
+			check.add(call)
+		}
+		l.hold(call)
+	}
+	if err := scanner.Err(); err != nil {
+		l.mu.Unlock()
+		f.Close()
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	l.file = f
+	l.mu.Unlock()
+
+	if !check.Valid {
+		logger.Error("Admin call log failed verification", map[string]interface{}{
+			"event_type":    "admin_call_log",
+			"error_type":    "chain_broken",
+			"error_message": fmt.Sprintf("%s at seq %d", check.Reason, check.BrokenAt),
+		})
+	}
+	return nil
+}
+
+// Append numbers a call, chains it onto the last one and records it. With a file configured
+// the call is written there first, and is neither held nor chained onto if that fails.
+func (l *adminCallLog) Append(call AdminCall) (AdminCall, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	call.Sequence, call.PrevHash = l.trimmedSeq+1, l.trimmedHash
+	if n := len(l.calls); n > 0 {
+		call.Sequence, call.PrevHash = l.calls[n-1].Sequence+1, l.calls[n-1].Hash
+	}
+	call.Hash = call.digest()
+	if l.file != nil {
+		data, err := json.Marshal(call)
+		if err == nil {
+			_, err = l.file.Write(append(data, '\n'))
+		}
+		if err != nil {
+			return AdminCall{}, err
+		}
+	}
+	l.hold(call)
+	return call, nil
+}
+
+// Verify recomputes the chain of the calls held in memory, from the last one dropped, reporting
+// the first call that doesn't match. Open has verified the file's older calls.
+func (l *adminCallLog) Verify() ChainCheck {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	check := ChainCheck{Valid: true, Calls: len(l.calls), Head: l.trimmedHash, seq: l.trimmedSeq}
+	for _, call := range l.calls {
+		if !check.add(call) {
+			return check
+		}
+	}
+	return check
+}
+
+// List returns the calls matching filter, newest first
+func (l *adminCallLog) List(filter func(AdminCall) bool) []AdminCall {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	list := []AdminCall{}
+	for i := len(l.calls) - 1; i >= 0; i-- {
+		if filter(l.calls[i]) {
+			list = append(list, l.calls[i])
+		}
+	}
+	return list
+}
+
+// Global admin call log
+var adminCalls = newAdminCallLog(maxAdminCallsHeld)
+
+// recordAdminCalls records every request to the admin group, including those rejected before
+// reaching a handler. It runs ahead of authentication, reading the caller once the chain has
+// run.
+func (s *Server) recordAdminCalls(l *adminCallLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		call := AdminCall{
+			Time:     start.UTC(),
+			ClientIP: c.ClientIP(),
+			Method:   c.Request.Method,
+			Route:    c.FullPath(),
+			Path:     c.Request.URL.Path,
+		}
+		for _, p := range c.Params {
+			if call.Params == nil {
+				call.Params = map[string]string{}
+			}
+			call.Params[p.Key] = p.Value
+		}
+		for key, values := range c.Request.URL.Query() {
+			if call.Query == nil {
+				call.Query = map[string]string{}
+			}
+			call.Query[key] = strings.Join(values, ",")
+		}
+		body := watchCallBody(c)
+
+		c.Next()
+
+		body.record(&call)
+		call.RequestID = c.Writer.Header().Get(RequestIDHeader)
+		call.Caller = callerName(c)
+		call.Status = c.Writer.Status()
+		call.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		if _, err := l.Append(call); err != nil {
+			s.logger.Error("Failed to record admin call", map[string]interface{}{
+				"event_type":    "admin_call_log",
+				"error_type":    "write_failed",
+				"error_message": err.Error(),
+			})
+		}
+	}
+}
+
+// callBody captures the request body as a handler reads it: its size and digest, and the
+// first bytes so small JSON bodies can be recorded verbatim
+type callBody struct {
+	io.ReadCloser
+	head   []byte
+	digest hash.Hash
+	n      int64
+}
+
+// watchCallBody wraps a request's body to capture what its handler reads
+func watchCallBody(c *gin.Context) *callBody {
+	body := &callBody{ReadCloser: c.Request.Body, digest: sha256.New()}
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		c.Request.Body = body
+	}
+	return body
+}
+
+// Read reads from the body, capturing what was read
+func (b *callBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxCallBodyRecorded + 1 - len(b.head); room > 0 {
+		b.head = append(b.head, p[:min(n, room)]...)
+	}
+	b.digest.Write(p[:n])
+	b.n += int64(n)
+	return n, err
+}
+
+// record notes the body on a call, verbatim when it is small JSON and otherwise by size and
+// digest. A body the handler never read, such as one sent without credentials, is left out.
+func (b *callBody) record(call *AdminCall) {
+	if b.n == 0 {
+		return
+	}
+	if b.n <= maxCallBodyRecorded {
+		var compact bytes.Buffer
+		if json.Compact(&compact, b.head) == nil {
+			call.Body = compact.Bytes()
+			return
+		}
+	}
+	call.BodyBytes = b.n
+	call.BodySHA256 = hex.EncodeToString(b.digest.Sum(nil))
+}
+
+// handleListAdminCalls serves GET /admin/calls from the calls held in memory, newest first,
+// optionally filtered by ?caller=, ?route=, ?method=, ?status= and the from and to of
+// /history, and limited with ?limit= (default 100, at most 1000)
+func (s *Server) handleListAdminCalls(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status := 0
+	if raw := c.Query("status"); raw != "" {
+		status, err = strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be an HTTP status code"})
+			return
+		}
+	}
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+	}
+	caller, route, method := c.Query("caller"), c.Query("route"), strings.ToUpper(c.Query("method"))
+
+	list := adminCalls.List(func(call AdminCall) bool {
+		return (caller == "" || call.Caller == caller) &&
+			(route == "" || call.Route == route) &&
+			(method == "" || call.Method == method) &&
+			(status == 0 || call.Status == status) &&
+			!call.Time.Before(from) && (to.IsZero() || call.Time.Before(to))
+	})
+	total := len(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "count": len(list), "calls": list})
+}
+
+// handleVerifyAdminCalls serves GET /admin/calls/verify, recomputing the admin call chain
+func (s *Server) handleVerifyAdminCalls(c *gin.Context) {
+	check := adminCalls.Verify()
+	status := http.StatusOK
+	if !check.Valid {
+		status = http.StatusConflict
+	}
+	c.JSON(status, check)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAdminCallLogTrims checks the log holds at most its limit of calls while new calls keep
+// numbering and chaining on from the dropped ones
+func TestAdminCallLogTrims(t *testing.T) {
+	l := newAdminCallLog(10)
+	for i := 0; i < 25; i++ {
+		if _, err := l.Append(AdminCall{Method: "GET", Route: "/admin/calls"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.calls) > 10 {
+		t.Errorf("holding %d calls, want at most 10", len(l.calls))
+	}
+	if last := l.calls[len(l.calls)-1]; last.Sequence != 25 {
+		t.Errorf("last call has seq %d, want 25", last.Sequence)
+	}
+	if check := l.Verify(); !check.Valid {
+		t.Errorf("chain of held calls broken at %d: %s", check.BrokenAt, check.Reason)
+	}
+}
+
+// TestAdminCallLogWriteFailure checks a call that can't be written to the file is neither held
+// nor chained onto
+func TestAdminCallLogWriteFailure(t *testing.T) {
+	l := newAdminCallLog(10)
+	if err := l.Open(filepath.Join(t.TempDir(), "admin_calls.jsonl"), NewStructuredLogger("test", nil)); err != nil {
+		t.Fatal(err)
+	}
+	first, err := l.Append(AdminCall{Method: "POST", Route: "/admin/rules/reload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.file.Close()
+	if _, err := l.Append(AdminCall{Method: "POST", Route: "/admin/rules/reload"}); err == nil {
+		t.Fatal("appended to a closed file without an error")
+	}
+	if len(l.calls) != 1 {
+		t.Fatalf("holding %d calls after a failed write, want 1", len(l.calls))
+	}
+
+	f, err := os.OpenFile(l.file.Name(), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.file = f
+	defer f.Close()
+	next, err := l.Append(AdminCall{Method: "POST", Route: "/admin/rules/reload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Sequence != 2 || next.PrevHash != first.Hash {
+		t.Errorf("next call has seq %d chained on %s, want seq 2 chained on %s", next.Sequence, next.PrevHash, first.Hash)
+	}
+}
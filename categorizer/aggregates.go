@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AggregateStat is a differentially private statistic for one category over opted-in users
+type AggregateStat struct {
+	Category string  `json:"category"`
+	Spenders int     `json:"spenders"`
+	Mean     float64 `json:"mean"`
+}
+
+// AggregateSnapshot is one run of the aggregation job over a month
+type AggregateSnapshot struct {
+	Month       string          `json:"month"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Population  int             `json:"population"`
+	Epsilon     float64         `json:"epsilon"`
+	MinUsers    int             `json:"min_users"`
+	Categories  []AggregateStat `json:"categories"`
+	Suppressed  int             `json:"suppressed"`
+}
+
+// aggregateStore keeps the latest anonymized snapshot per month, apart from per-user history
+type aggregateStore struct {
+	mu        sync.RWMutex
+	snapshots map[string]AggregateSnapshot
+}
+
+// Put replaces a month's snapshot
+func (a *aggregateStore) Put(snapshot AggregateSnapshot) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.snapshots[snapshot.Month] = snapshot
+}
+
+// Get returns a month's snapshot
+func (a *aggregateStore) Get(month string) (AggregateSnapshot, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	snapshot, ok := a.snapshots[month]
+	return snapshot, ok
+}
+
+// Months returns the months with a snapshot, oldest first
+func (a *aggregateStore) Months() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	months := make([]string, 0, len(a.snapshots))
+	for month := range a.snapshots {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	return months
+}
+
+// Global anonymized aggregates
+var aggregates = &aggregateStore{snapshots: map[string]AggregateSnapshot{}}
+
+// laplaceNoise samples from a zero-centred Laplace distribution with the given scale
+func laplaceNoise(scale float64) float64 {
+	u := rand.Float64() - 0.5
+	return -scale * math.Copysign(1, u) * math.Log(1-2*math.Abs(u))
+}
+
+// aggregateMonth computes per-category statistics across opted-in users for a month. Each
+// user's spending is clipped to AGGREGATION_CLIP to bound their influence, Laplace noise
+// is added with the privacy budget split between the spender count and the mean, and
+// categories with fewer than ANALYTICS_MIN_COHORT spenders are suppressed.
+func (s *Server) aggregateMonth(month string) AggregateSnapshot {
+	population := consent.Users()
+	snapshot := AggregateSnapshot{
+		Month:       month,
+		GeneratedAt: time.Now().UTC(),
+		Population:  len(population),
+		Epsilon:     s.config.DPEpsilon,
+		MinUsers:    s.config.AnalyticsMinCohort,
+		Categories:  []AggregateStat{},
+	}
+	if len(population) < s.config.AnalyticsMinCohort {
+		return snapshot
+	}
+
+	from, _ := time.Parse(monthLayout, month)
+	to := from.AddDate(0, 1, 0)
+	totals := map[string][]float64{}
+	for _, userID := range population {
+		for category, total := range s.loadMonthlySpending(userID, from, to)[month] {
+			totals[category] = append(totals[category], math.Min(total, s.config.AggregationClip))
+		}
+	}
+
+	epsilon := s.config.DPEpsilon / 2
+	n := float64(len(population))
+	for category, values := range totals {
+		spenders := int(math.Round(float64(len(values)) + laplaceNoise(1/epsilon)))
+		if len(values) < s.config.AnalyticsMinCohort || spenders < s.config.AnalyticsMinCohort {
+			snapshot.Suppressed++
+			continue
+		}
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		mean := sum/n + laplaceNoise(s.config.AggregationClip/n/epsilon)
+		snapshot.Categories = append(snapshot.Categories, AggregateStat{
+			Category: category,
+			Spenders: spenders,
+			Mean:     roundPence(math.Max(mean, 0)),
+		})
+	}
+	sort.Slice(snapshot.Categories, func(i, j int) bool {
+		return snapshot.Categories[i].Category < snapshot.Categories[j].Category
+	})
+	return snapshot
+}
+
+// runAggregation refreshes the snapshots for the current and previous month
+func (s *Server) runAggregation() {
+	current := monthStart(time.Now())
+	var snapshots []AggregateSnapshot
+	for _, month := range []time.Time{current.AddDate(0, -1, 0), current} {
+		snapshot := s.aggregateMonth(month.Format(monthLayout))
+		aggregates.Put(snapshot)
+		snapshots = append(snapshots, snapshot)
+	}
+	s.exportAggregates(snapshots)
+	s.logger.Info("Aggregation completed", map[string]interface{}{
+		"event_type": "aggregation_completed",
+	})
+}
+
+// startAggregationJob runs the aggregation job now and then on every interval
+func (s *Server) startAggregationJob(interval time.Duration) {
+	go func() {
+		s.runAggregation()
+		for range time.Tick(interval) {
+			s.runAggregation()
+		}
+	}()
+}
+
+// internalOnly rejects requests whose peer isn't a loopback or private network address.
+// Forwarding headers are ignored so external callers can't spoof an internal address.
+func internalOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
+		ip := net.ParseIP(host)
+		if ip == nil || !(ip.IsLoopback() || ip.IsPrivate()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "internal endpoint"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) handleAggregates(c *gin.Context) {
+	if month := c.Query("month"); month != "" {
+		snapshot, ok := aggregates.Get(month)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no aggregates for " + month})
+			return
+		}
+		c.JSON(http.StatusOK, snapshot)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"months": aggregates.Months()})
+}
+
+func (s *Server) handleRunAggregation(c *gin.Context) {
+	s.runAggregation()
+	c.JSON(http.StatusOK, gin.H{"months": aggregates.Months()})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// monthLayout formats calendar months in analytics responses
+const monthLayout = "2006-01"
+
+// insightThreshold is the month-over-month change, in percent, worth calling out
+const insightThreshold = 20
+
+// analyticsConsent records which users opted in to the anonymized population baseline
+type analyticsConsent struct {
+	mu    sync.RWMutex
+	users map[string]bool
+}
+
+// Set records a user's opt-in choice
+func (a *analyticsConsent) Set(userID string, optIn bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if optIn {
+		a.users[userID] = true
+	} else {
+		delete(a.users, userID)
+	}
+}
+
+// OptedIn reports whether a user is part of the population baseline
+func (a *analyticsConsent) OptedIn(userID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[userID]
+}
+
+// Users returns every opted-in user
+func (a *analyticsConsent) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	users := make([]string, 0, len(a.users))
+	for userID := range a.users {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// Global analytics opt-ins
+var consent = &analyticsConsent{users: map[string]bool{}}
+
+// TrendPoint is a user's spending in a category for one month
+type TrendPoint struct {
+	Month         string   `json:"month"`
+	Total         float64  `json:"total"`
+	Change        *float64 `json:"change_pct,omitempty"`
+	MovingAverage float64  `json:"moving_average"`
+}
+
+// CategoryTrend is a user's monthly spending series in one category
+type CategoryTrend struct {
+	Category   string       `json:"category"`
+	Months     []TrendPoint `json:"months"`
+	Percentile *float64     `json:"percentile,omitempty"`
+	Cohort     int          `json:"cohort,omitempty"`
+}
+
+// TrendsResponse is a user's spending trends per category
+type TrendsResponse struct {
+	UserID     string          `json:"user_id"`
+	Months     []string        `json:"months"`
+	Categories []CategoryTrend `json:"categories"`
+	Insights   []string        `json:"insights"`
+	OptedIn    bool            `json:"opted_in"`
+}
+
+// monthStart returns the first instant of the month containing t
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// monthlySpending totals settled and pending debits per month and category
+func monthlySpending(transactions []StoredTransaction) map[string]map[string]float64 {
+	months := map[string]map[string]float64{}
+	for _, tx := range transactions {
+		if tx.Duplicate || tx.Status == StatusDeclined || strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		month := tx.CreatedAt.UTC().Format(monthLayout)
+		if months[month] == nil {
+			months[month] = map[string]float64{}
+		}
+		months[month][tx.Category] += tx.Amount
+	}
+	return months
+}
+
+// percentileOf returns the mid-rank percentile of value within population
+func percentileOf(value float64, population []float64) float64 {
+	below, equal := 0, 0
+	for _, v := range population {
+		if v < value {
+			below++
+		} else if v == value {
+			equal++
+		}
+	}
+	return math.Round((float64(below)+float64(equal)/2)/float64(len(population))*1000) / 10
+}
+
+// buildTrends computes per-category month-over-month changes and moving averages over the
+// given months, the last of which is the current partial month. Insights and percentiles
+// compare the last complete month.
+func (s *Server) buildTrends(userID string, months []string, window int) TrendsResponse {
+	// Extend the series back far enough for the first month's change and moving average
+	offset := window - 1
+	if offset < 1 {
+		offset = 1
+	}
+	first, _ := time.Parse(monthLayout, months[0])
+	series := make([]string, 0, offset+len(months))
+	for i := offset; i > 0; i-- {
+		series = append(series, first.AddDate(0, -i, 0).Format(monthLayout))
+	}
+	series = append(series, months...)
+	spending := s.loadMonthlySpending(userID, first.AddDate(0, -offset, 0), time.Time{})
+
+	categories := map[string]bool{}
+	for _, month := range months {
+		for category := range spending[month] {
+			categories[category] = true
+		}
+	}
+
+	response := TrendsResponse{
+		UserID:     userID,
+		Months:     months,
+		Categories: make([]CategoryTrend, 0, len(categories)),
+		Insights:   []string{},
+		OptedIn:    consent.OptedIn(userID),
+	}
+	for category := range categories {
+		trend := CategoryTrend{Category: category}
+		for i, month := range months {
+			idx := i + offset
+			point := TrendPoint{Month: month, Total: roundPence(spending[month][category])}
+			if previous := spending[series[idx-1]][category]; previous > 0 {
+				change := math.Round((spending[month][category]-previous)/previous*1000) / 10
+				point.Change = &change
+			}
+			sum := 0.0
+			for _, m := range series[idx-window+1 : idx+1] {
+				sum += spending[m][category]
+			}
+			point.MovingAverage = roundPence(sum / float64(window))
+			trend.Months = append(trend.Months, point)
+		}
+
+		if len(trend.Months) >= 2 {
+			last := trend.Months[len(trend.Months)-2]
+			if last.Change != nil && math.Abs(*last.Change) >= insightThreshold {
+				direction := "more"
+				if *last.Change < 0 {
+					direction = "less"
+				}
+				response.Insights = append(response.Insights,
+					fmt.Sprintf("You spent %.0f%% %s on %s in %s than the month before", math.Abs(*last.Change), direction, category, last.Month))
+			}
+		}
+		response.Categories = append(response.Categories, trend)
+	}
+
+	if response.OptedIn && len(months) >= 2 {
+		s.addPopulationPercentiles(userID, months[len(months)-2], response.Categories)
+	}
+
+	sort.Slice(response.Categories, func(i, j int) bool {
+		return response.Categories[i].Category < response.Categories[j].Category
+	})
+	sort.Strings(response.Insights)
+	return response
+}
+
+// addPopulationPercentiles ranks a user's spending in a month against every opted-in user.
+// Percentiles are only given for cohorts of at least ANALYTICS_MIN_COHORT users so no
+// individual's spending can be inferred.
+func (s *Server) addPopulationPercentiles(userID, month string, trends []CategoryTrend) {
+	population := consent.Users()
+	if len(population) < s.config.AnalyticsMinCohort {
+		return
+	}
+
+	from, _ := time.Parse(monthLayout, month)
+	to := from.AddDate(0, 1, 0)
+	spending := map[string]map[string]float64{}
+	for _, member := range population {
+		spending[member] = s.loadMonthlySpending(member, from, to)[month]
+	}
+
+	for i := range trends {
+		category := trends[i].Category
+		values := make([]float64, 0, len(population))
+		for _, member := range population {
+			values = append(values, spending[member][category])
+		}
+		percentile := percentileOf(spending[userID][category], values)
+		trends[i].Percentile = &percentile
+		trends[i].Cohort = len(values)
+	}
+}
+
+func (s *Server) handleTrends(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	count := 6
+	if value := c.Query("months"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 2 || n > 36 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "months must be between 2 and 36"})
+			return
+		}
+		count = n
+	}
+	window := 3
+	if value := c.Query("window"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > count {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be between 1 and months"})
+			return
+		}
+		window = n
+	}
+
+	current := monthStart(time.Now())
+	months := make([]string, count)
+	for i := range months {
+		months[i] = current.AddDate(0, i-count+1, 0).Format(monthLayout)
+	}
+	c.JSON(http.StatusOK, s.buildTrends(userID, months, window))
+}
+
+func (s *Server) handleAnalyticsConsent(c *gin.Context) {
+	var req struct {
+		OptIn *bool `json:"opt_in" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.Param("user_id")
+	consent.Set(userID, *req.OptIn)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"opt_in":  *req.OptIn,
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEntry records an administrative action: who did what to which object
+type AuditEntry struct {
+	ID      string                 `json:"id"`
+	Time    time.Time              `json:"time"`
+	Actor   string                 `json:"actor"`
+	Action  string                 `json:"action"`
+	Target  string                 `json:"target"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// auditLog keeps administrative actions in memory in the order they happened
+type auditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+// Record appends an action to the log
+func (a *auditLog) Record(actor, action, target string, details map[string]interface{}) AuditEntry {
+	entry := AuditEntry{
+		ID:      newID("audit_"),
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	a.mu.Lock()
+	a.entries = append(a.entries, entry)
+	a.mu.Unlock()
+	return entry
+}
+
+// List returns the entries for a target, or every entry when target is empty, newest first
+func (a *auditLog) List(target string) []AuditEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	list := []AuditEntry{}
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if target == "" || a.entries[i].Target == target {
+			list = append(list, a.entries[i])
+		}
+	}
+	return list
+}
+
+// Global admin audit log
+var audit = &auditLog{}
+
+// recordAudit records an administrative action in the audit log and writes it to the
+// structured log
+func (s *Server) recordAudit(actor, action, target string, details map[string]interface{}) AuditEntry {
+	entry := audit.Record(actor, action, target, details)
+	s.logger.Info(fmt.Sprintf("%s: %s on %s", actor, action, target), map[string]interface{}{
+		"event_type": "audit",
+	})
+	return entry
+}
+
+// handleListAudit serves GET /admin/audit, optionally filtered with ?target= and limited
+// with ?limit=
+func (s *Server) handleListAudit(c *gin.Context) {
+	list := audit.List(c.Query("target"))
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		if limit < len(list) {
+			list = list[:limit]
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(list), "entries": list})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes incompatibly
+const snapshotVersion = 1
+
+// maxSnapshotSize bounds restore uploads
+const maxSnapshotSize = 512 << 20
+
+// Snapshot is a point-in-time copy of the service's user data. Each store is copied under
+// its own lock, so the copy is consistent per store.
+type Snapshot struct {
+	Version           int                            `json:"version"`
+	CreatedAt         time.Time                      `json:"created_at"`
+	Transactions      map[string][]StoredTransaction `json:"transactions"`
+	Overrides         []Override                     `json:"overrides"`
+	Blocks            map[string][]Block             `json:"blocks"`
+	NotificationRules map[string][]NotificationRule  `json:"notification_rules"`
+	CashAllocations   map[string][]CashAllocation    `json:"cash_allocations,omitempty"`
+	MerchantPolicies  map[string][]MerchantPolicy    `json:"merchant_policies,omitempty"`
+	ConfidenceLevels  map[string]float64             `json:"confidence_thresholds,omitempty"`
+	StickyPins        []StickyPin                    `json:"sticky_pins,omitempty"`
+	Views             map[string][]SavedView         `json:"views,omitempty"`
+	LoanTerms         map[string][]LoanTerms         `json:"loan_terms,omitempty"`
+	TenantTaxonomies  map[string][]TenantCategory    `json:"tenant_taxonomies,omitempty"`
+}
+
+// SnapshotEnvelope wraps a snapshot with the SHA-256 of its encoding so restores can verify it
+type SnapshotEnvelope struct {
+	Checksum string          `json:"checksum"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// takeSnapshot copies the user data held by every store
+func (s *Server) takeSnapshot() Snapshot {
+	return Snapshot{
+		Version:           snapshotVersion,
+		CreatedAt:         time.Now().UTC(),
+		Transactions:      s.store.Snapshot(),
+		Overrides:         overrides.List("", ""),
+		Blocks:            blocks.Snapshot(),
+		NotificationRules: notifications.Snapshot(),
+		CashAllocations:   cash.Snapshot(),
+		MerchantPolicies:  merchantPolicies.Snapshot(),
+		ConfidenceLevels:  confidenceThresholds.Snapshot(),
+		StickyPins:        stickiness.Snapshot(),
+		Views:             views.Snapshot(),
+		LoanTerms:         loans.Snapshot(),
+		TenantTaxonomies:  tenantTaxonomies.Snapshot(),
+	}
+}
+
+// encodeSnapshot serialises a snapshot inside a checksummed envelope
+func encodeSnapshot(snapshot Snapshot) ([]byte, error) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return json.Marshal(SnapshotEnvelope{Checksum: hex.EncodeToString(sum[:]), Snapshot: data})
+}
+
+// decodeSnapshot verifies a snapshot envelope's checksum and version and decodes it
+func decodeSnapshot(data []byte) (Snapshot, error) {
+	var envelope SnapshotEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return Snapshot{}, fmt.Errorf("parse snapshot: %w", err)
+	}
+	if len(envelope.Snapshot) == 0 {
+		return Snapshot{}, errors.New("snapshot is empty")
+	}
+	sum := sha256.Sum256(envelope.Snapshot)
+	if hex.EncodeToString(sum[:]) != envelope.Checksum {
+		return Snapshot{}, errors.New("snapshot checksum mismatch: file is corrupt or was modified")
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(envelope.Snapshot, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("parse snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return Snapshot{}, fmt.Errorf("snapshot version %d is not supported, want %d", snapshot.Version, snapshotVersion)
+	}
+	return snapshot, nil
+}
+
+// restoreSnapshot replaces the user data in every store
+func (s *Server) restoreSnapshot(snapshot Snapshot) {
+	if snapshot.Transactions == nil {
+		snapshot.Transactions = map[string][]StoredTransaction{}
+	}
+	if snapshot.Blocks == nil {
+		snapshot.Blocks = map[string][]Block{}
+	}
+	if snapshot.CashAllocations == nil {
+		snapshot.CashAllocations = map[string][]CashAllocation{}
+	}
+	s.store.Restore(snapshot.Transactions)
+	overrides.Restore(snapshot.Overrides)
+	blocks.Restore(snapshot.Blocks)
+	notifications.Restore(snapshot.NotificationRules)
+	cash.Restore(snapshot.CashAllocations)
+	merchantPolicies.Restore(snapshot.MerchantPolicies)
+	confidenceThresholds.Restore(snapshot.ConfidenceLevels)
+	stickiness.Restore(snapshot.StickyPins)
+	views.Restore(snapshot.Views)
+	loans.Restore(snapshot.LoanTerms)
+	tenantTaxonomies.Restore(snapshot.TenantTaxonomies)
+}
+
+// snapshotSummary counts what a snapshot holds
+func snapshotSummary(snapshot Snapshot) gin.H {
+	transactions := 0
+	for _, list := range snapshot.Transactions {
+		transactions += len(list)
+	}
+	return gin.H{
+		"created_at":   snapshot.CreatedAt,
+		"users":        len(snapshot.Transactions),
+		"transactions": transactions,
+		"overrides":    len(snapshot.Overrides),
+	}
+}
+
+// handleBackup serves POST /admin/backup. With ?name= the snapshot is written to that file
+// in BACKUP_DIR; otherwise it is returned as a download.
+func (s *Server) handleBackup(c *gin.Context) {
+	snapshot := s.takeSnapshot()
+	data, err := encodeSnapshot(snapshot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.Query("name")
+	if name == "" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=backup-%s.json", snapshot.CreatedAt.Format("20060102T150405Z")))
+		c.Data(http.StatusOK, "application/json", data)
+		return
+	}
+	if s.config.BackupDir == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "BACKUP_DIR is not configured"})
+		return
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated backup behind
+	path := filepath.Join(s.config.BackupDir, filepath.Base(name))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.requestLogger(c).Info("Backup written", map[string]interface{}{
+		"event_type": "backup_written",
+	})
+	summary := snapshotSummary(snapshot)
+	summary["path"] = path
+	c.JSON(http.StatusOK, summary)
+}
+
+// handleRestore serves POST /admin/restore with a snapshot envelope as the body
+func (s *Server) handleRestore(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSnapshotSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.restoreSnapshot(snapshot)
+	s.requestLogger(c).Info("Backup restored", map[string]interface{}{
+		"event_type": "backup_restored",
+	})
+	c.JSON(http.StatusOK, snapshotSummary(snapshot))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// BankAccount is a user's account at a bank provider, reached through one of its connections
+type BankAccount struct {
+	Provider     string `json:"provider"`
+	ConnectionID string `json:"connection_id"`
+	UserID       string `json:"user_id"`
+	AccountID    string `json:"account_id"`
+}
+
+// BankTransaction is a transaction read from a bank provider. Amount is in pounds, negative
+// for money out.
+type BankTransaction struct {
+	ID            string
+	CreatedAt     time.Time
+	Merchant      string
+	Description   string
+	Amount        float64
+	Currency      string
+	Status        string
+	DeclineReason string
+}
+
+// BankProvider is a bank transactions are synced from and categories written back to. Each
+// provider keeps its own connections and configuration; the sync and write-back code only
+// goes through this interface.
+type BankProvider interface {
+	Name() string
+	// ListTransactions lists a page of an account's transactions oldest first. since is a
+	// transaction ID to continue after or an RFC 3339 time, and before an RFC 3339 time;
+	// either may be empty to leave that end open.
+	ListTransactions(account BankAccount, since, before string, limit int) ([]BankTransaction, error)
+	// Subscribe asks the provider to post the account's transaction events to url, returning
+	// the subscription's ID
+	Subscribe(account BankAccount, url string) (string, error)
+	// WriteAnnotation sets a key on a transaction, such as its category
+	WriteAnnotation(account BankAccount, transactionID, key, value string) error
+}
+
+// providerRegistry maps configurable bank provider names to their constructors, which read
+// the provider's own settings from the configuration and record API calls in m
+var providerRegistry = map[string]func(Config, *Metrics) BankProvider{
+	"monzo": func(cfg Config, m *Metrics) BankProvider { return monzoProvider{cfg: cfg, metrics: m} },
+}
+
+// newBankProviders builds the bank providers named
+func newBankProviders(names []string, cfg Config, m *Metrics) (map[string]BankProvider, error) {
+	providers := map[string]BankProvider{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		constructor, ok := providerRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown bank provider %q", name)
+		}
+		if _, ok := providers[name]; ok {
+			return nil, fmt.Errorf("bank provider %q listed twice", name)
+		}
+		providers[name] = constructor(cfg, m)
+	}
+	return providers, nil
+}
+
+// Global bank providers, replaced at startup by those configured
+var bankProviders = map[string]BankProvider{}
+
+// bankProvider returns an enabled bank provider
+func bankProvider(name string) (BankProvider, error) {
+	provider, ok := bankProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("bank provider %q is not enabled", name)
+	}
+	return provider, nil
+}
+
+// ingestBankTransaction stores a bank transaction in its account holder's history, reporting
+// whether it was imported, settled a pending transaction or was skipped as already stored
+func (s *Server) ingestBankTransaction(account BankAccount, bt BankTransaction, rs *RuleSet) string {
+	tx := StoredTransaction{
+		UserID:          account.UserID,
+		Merchant:        bt.Merchant,
+		Description:     bt.Description,
+		Amount:          math.Abs(bt.Amount),
+		TransactionType: "debit",
+		CreatedAt:       bt.CreatedAt.UTC(),
+		TransactionID:   bt.ID,
+		Status:          bt.Status,
+		Account:         &account,
+	}
+	if tx.Merchant == "" {
+		tx.Merchant = bt.Description
+	}
+	if bt.Amount > 0 {
+		tx.TransactionType = "credit"
+	}
+	if tx.Status == StatusDeclined {
+		tx.DeclineReason = categorizeDeclineReason(bt.DeclineReason)
+	}
+
+	existing, err := s.store.GetTransaction(account.UserID, bt.ID)
+	if err == nil && (existing.Status != StatusPending || tx.Status == StatusPending) {
+		return "skipped"
+	}
+	cl := s.classifier.Classify(TransactionRequest{
+		Merchant:        tx.Merchant,
+		Description:     tx.Description,
+		Amount:          tx.Amount,
+		TransactionType: tx.TransactionType,
+		UserID:          tx.UserID,
+		CreatedAt:       tx.CreatedAt,
+		TransactionID:   tx.TransactionID,
+		Status:          tx.Status,
+	}, rs)
+	tx.Category, tx.DecidedBy = cl.Category, cl.DecidedBy
+
+	if err == nil {
+		updated, _, ok := s.store.ResolvePending(tx)
+		if !ok {
+			return "skipped"
+		}
+		s.queueWriteBack(updated)
+		return "settled"
+	}
+	s.queueWriteBack(s.store.AddTransaction(tx))
+	return "imported"
+}
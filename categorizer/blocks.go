@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Block types
+const (
+	BlockMerchant = "merchant"
+	BlockCategory = "category"
+	BlockRiskFlag = "risk_flag"
+)
+
+// EventTransactionBlocked is emitted when a transaction matches one of its user's blocks
+const EventTransactionBlocked = "transaction.blocked"
+
+// Block is a user's self-exclusion from a merchant, category or risk flag
+type Block struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Type      string    `json:"type" binding:"required,oneof=merchant category risk_flag"`
+	Value     string    `json:"value" binding:"required"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// validate checks a merchant block names a merchant once normalized, since an empty name
+// would match every transaction
+func (b Block) validate() error {
+	if b.Type == BlockMerchant && blockMerchant(b.Value) == "" {
+		return fmt.Errorf("merchant %q has no name left once normalized", b.Value)
+	}
+	return nil
+}
+
+// blockMerchant returns the canonical merchant a merchant block or transaction names
+func blockMerchant(merchant string) string {
+	return resolveMerchant(normalizeDescriptor(merchant))
+}
+
+// Matches reports whether a categorized transaction falls under the block
+func (b Block) Matches(merchant, category string, riskFlags []string) bool {
+	switch b.Type {
+	case BlockMerchant:
+		blocked := blockMerchant(b.Value)
+		return blocked != "" && strings.Contains(blockMerchant(merchant), blocked)
+	case BlockCategory:
+		return strings.EqualFold(category, b.Value)
+	case BlockRiskFlag:
+		return containsString(riskFlags, strings.ToLower(b.Value))
+	}
+	return false
+}
+
+// blockList keeps each user's blocks in memory
+type blockList struct {
+	mu     sync.RWMutex
+	blocks map[string][]Block
+}
+
+// newBlockList creates an empty block list
+func newBlockList() *blockList {
+	return &blockList{blocks: map[string][]Block{}}
+}
+
+// Add registers a block for its user
+func (l *blockList) Add(block Block) Block {
+	block.ID = newID("blk_")
+	block.CreatedAt = time.Now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.blocks[block.UserID] = append(l.blocks[block.UserID], block)
+	return block
+}
+
+// List returns a user's blocks
+func (l *blockList) List(userID string) []Block {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]Block{}, l.blocks[userID]...)
+}
+
+// Remove deletes a user's block, reporting whether it existed
+func (l *blockList) Remove(userID, id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	blocks := l.blocks[userID]
+	for i, block := range blocks {
+		if block.ID == id {
+			l.blocks[userID] = append(blocks[:i:i], blocks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the first of a user's blocks the transaction falls under
+func (l *blockList) Match(userID, merchant, category string, riskFlags []string) (Block, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, block := range l.blocks[userID] {
+		if block.Matches(merchant, category, riskFlags) {
+			return block, true
+		}
+	}
+	return Block{}, false
+}
+
+// Global per-user block list
+var blocks = newBlockList()
+
+// checkBlocks flags a transaction matching one of its user's blocks and emits a blocked event.
+// Blocks name base categories, so category is the canonical one rather than the tenant's.
+func (s *Server) checkBlocks(req TransactionRequest, category string, response *CategoryResponse) {
+	block, ok := blocks.Match(req.UserID, req.Merchant, category, response.RiskFlags)
+	if !ok {
+		return
+	}
+
+	response.Blocked = true
+	response.BlockID = block.ID
+	s.metrics.recordBlockedTransaction(block.Type)
+	s.emitEvent(EventTransactionBlocked, req.TenantID, map[string]interface{}{
+		"user_id":        req.UserID,
+		"transaction_id": req.TransactionID,
+		"merchant":       req.Merchant,
+		"amount":         req.Amount,
+		"category":       category,
+		"block":          block,
+	})
+}
+
+func (s *Server) handleCreateBlock(c *gin.Context) {
+	var block Block
+	err := c.ShouldBindJSON(&block)
+	if err == nil {
+		err = block.validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	block.UserID = c.Param("user_id")
+	c.JSON(http.StatusCreated, blocks.Add(block))
+}
+
+func (s *Server) handleListBlocks(c *gin.Context) {
+	userID := c.Param("user_id")
+	list := blocks.List(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"count":   len(list),
+		"blocks":  list,
+	})
+}
+
+func (s *Server) handleDeleteBlock(c *gin.Context) {
+	if !blocks.Remove(c.Param("user_id"), c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "block not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Snapshot returns a copy of every user's blocks
+func (l *blockList) Snapshot() map[string][]Block {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	snapshot := make(map[string][]Block, len(l.blocks))
+	for userID, blocks := range l.blocks {
+		snapshot[userID] = append([]Block{}, blocks...)
+	}
+	return snapshot
+}
+
+// Restore replaces every user's blocks
+func (l *blockList) Restore(blocks map[string][]Block) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.blocks = blocks
+}
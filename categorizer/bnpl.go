@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// bnplProviders are the buy-now-pay-later providers whose installment payments are linked
+// back to the purchase they pay for, with the keywords that name them in a descriptor
+var bnplProviders = []struct {
+	name     string
+	keywords []string
+}{
+	{"Klarna", []string{"klarna"}},
+	{"Clearpay", []string{"clearpay"}},
+	{"Afterpay", []string{"afterpay"}},
+	{"Laybuy", []string{"laybuy"}},
+	{"Zilch", []string{"zilch"}},
+	// PayPal is a processor prefix, so the resolved merchant may have lost it
+	{"PayPal Pay in 3", []string{"paypal pay in 3", "pay in 3"}},
+}
+
+// bnplFillerWords are the words around a retailer's name in an installment descriptor
+var bnplFillerWords = map[string]bool{
+	"instalment": true, "installment": true, "payment": true, "repayment": true, "pay": true,
+	"in": true, "later": true, "order": true, "plan": true, "of": true, "days": true,
+	"uk": true, "ltd": true, "gb": true, "com": true, "www": true,
+}
+
+// bnplLinkWindow is how far back an earlier installment of the same plan is looked for
+const bnplLinkWindow = 120 * 24 * time.Hour
+
+// bnplProviderFor returns the buy-now-pay-later provider named by a normalized merchant or
+// description, and the keyword that named it
+func bnplProviderFor(merchant, description string) (provider, keyword string) {
+	for _, p := range bnplProviders {
+		for _, k := range p.keywords {
+			if strings.Contains(merchant, k) || strings.Contains(description, k) {
+				return p.name, k
+			}
+		}
+	}
+	return "", ""
+}
+
+// bnplRetailer returns what is left of a descriptor once the provider and the installment
+// wording are taken out, which is the retailer's name when the provider includes it
+func bnplRetailer(text, keyword string) string {
+	var retailer []string
+	for _, token := range strings.Fields(strings.ReplaceAll(text, keyword, " ")) {
+		if !bnplFillerWords[token] && !isNumericNoise(token) {
+			retailer = append(retailer, token)
+		}
+	}
+	return resolveMerchant(strings.Join(retailer, " "))
+}
+
+// bnplPurchaseCategory returns the category of the purchase an installment pays for: the
+// retailer's category when the descriptor names one the rules know, otherwise the category an
+// earlier installment of the same amount to the same provider was linked to
+func (s *Server) bnplPurchaseCategory(cl *Classification, provider, keyword string) (string, bool) {
+	for _, text := range []string{cl.NormalizedMerchant, cl.NormalizedDescription} {
+		retailer := bnplRetailer(text, keyword)
+		if retailer == "" {
+			continue
+		}
+		if hits := cl.RuleSet.MatchAll(retailer, retailer, cl.Amount); len(hits) > 0 {
+			return hits[0].Rule.Category, true
+		}
+	}
+
+	if cl.UserID == "" {
+		return "", false
+	}
+	at := cl.CreatedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	history := s.store.ListTransactions(cl.UserID, at.Add(-bnplLinkWindow), at)
+	for i := len(history) - 1; i >= 0; i-- {
+		tx := history[i]
+		if tx.BNPLProvider == provider && math.Abs(math.Abs(tx.Amount)-math.Abs(cl.Amount)) < 0.005 {
+			return tx.Category, true
+		}
+	}
+	return "", false
+}
+
+// bnplStage categorizes buy-now-pay-later installments as the purchase they pay for, so a
+// Klarna payment for clothes counts towards Shopping rather than an opaque provider category.
+// Installments that can't be linked are left to the rules.
+type bnplStage struct {
+	s *Server
+}
+
+func (bnplStage) Name() string { return "bnpl" }
+
+func (bnplStage) Backend() string { return "bnpl" }
+
+func (st bnplStage) Process(cl *Classification) {
+	// Credits from a provider are refunds, which the income stage types
+	if cl.Decided || strings.ToLower(cl.TransactionType) == "credit" {
+		return
+	}
+	provider, keyword := bnplProviderFor(cl.NormalizedMerchant, cl.NormalizedDescription)
+	if provider == "" {
+		return
+	}
+	if category, ok := st.s.bnplPurchaseCategory(cl, provider, keyword); ok {
+		cl.BNPLProvider = provider
+		cl.decide(category)
+	}
+}
+
+// bnplLabel labels a category paid for through a provider, as summaries show it
+func bnplLabel(category, provider string) string {
+	return fmt.Sprintf("%s (via %s)", category, provider)
+}
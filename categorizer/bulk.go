@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk transaction actions
+const (
+	BulkDelete    = "delete"
+	BulkReprocess = "reprocess"
+)
+
+// BulkTransactionRequest selects historical transactions by a pattern on their raw merchant
+// descriptor, e.g. after one acquirer's descriptors turn out to be bad, and deletes or
+// re-categorizes them. With DryRun the job only counts what would be affected.
+type BulkTransactionRequest struct {
+	MerchantPattern string `json:"merchant_pattern" binding:"required"`
+	Action          string `json:"action" binding:"required,oneof=delete reprocess"`
+	UserID          string `json:"user_id,omitempty"`
+	DryRun          bool   `json:"dry_run,omitempty"`
+}
+
+// runBulkTransactions applies a bulk request user by user, so no lock is held for the whole run
+func (s *Server) runBulkTransactions(req BulkTransactionRequest, pattern *regexp.Regexp, add func(string, int)) error {
+	users := s.store.Users()
+	if req.UserID != "" {
+		users = []string{req.UserID}
+	}
+	matches := func(tx StoredTransaction) bool { return pattern.MatchString(tx.Merchant) }
+
+	for _, userID := range users {
+		var matched []StoredTransaction
+		for _, tx := range s.store.ListTransactions(userID, time.Time{}, time.Time{}) {
+			if matches(tx) {
+				matched = append(matched, tx)
+			}
+		}
+		add("users_scanned", 1)
+		add("matched", len(matched))
+		if req.DryRun || len(matched) == 0 {
+			continue
+		}
+
+		switch req.Action {
+		case BulkDelete:
+			withdrawals := map[string]bool{}
+			removed := s.store.RemoveWhere(userID, matches)
+			for _, tx := range removed {
+				withdrawals[tx.ID] = true
+			}
+			add("deleted", len(removed))
+			add("cash_allocations_deleted", cash.RemoveForWithdrawals(userID, withdrawals))
+		case BulkReprocess:
+			rs := s.activeRules()
+			decisions := make(map[string]Classification, len(matched))
+			for _, tx := range matched {
+				decisions[tx.ID] = s.classifier.Classify(TransactionRequest{
+					Merchant:        tx.Merchant,
+					Description:     tx.Description,
+					Amount:          tx.Amount,
+					TransactionType: tx.TransactionType,
+					MCC:             tx.MCC,
+					UserID:          tx.UserID,
+					TenantID:        tx.TenantID,
+				}, rs)
+			}
+			add("recategorized", s.store.UpdateWhere(userID, func(tx *StoredTransaction) bool {
+				cl, ok := decisions[tx.ID]
+				if !ok || cl.Category == tx.Category && cl.DecidedBy == tx.DecidedBy {
+					return false
+				}
+				tx.Category, tx.DecidedBy = cl.Category, cl.DecidedBy
+				return true
+			}))
+		}
+	}
+	return nil
+}
+
+// handleBulkTransactions serves POST /admin/transactions/bulk, starting a job that deletes or
+// re-categorizes every transaction whose merchant matches a case-insensitive regular expression
+func (s *Server) handleBulkTransactions(c *gin.Context) {
+	var req BulkTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pattern, err := regexp.Compile("(?i)" + req.MerchantPattern)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "merchant_pattern: " + err.Error()})
+		return
+	}
+
+	actor := callerName(c)
+	job := jobs.Start(s, "transactions."+req.Action, actor, req, func(add func(string, int)) error {
+		return s.runBulkTransactions(req, pattern, add)
+	})
+	s.recordAudit(actor, "transactions.bulk_"+req.Action, job.ID, map[string]interface{}{
+		"merchant_pattern": req.MerchantPattern,
+		"user_id":          req.UserID,
+		"dry_run":          req.DryRun,
+	})
+	c.JSON(http.StatusAccepted, job)
+}
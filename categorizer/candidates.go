@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	// candidateRankDecay is the weight lost per rank by later-matching rules
+	candidateRankDecay = 0.4
+	// fuzzyCandidateWeight discounts candidates found only by fuzzy matching
+	fuzzyCandidateWeight = 0.6
+	maxCandidates        = 10
+)
+
+// CategoryScore is a candidate category with its relative score
+type CategoryScore struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+}
+
+// keywordStrength rates how specific a keyword is: short keywords such as "tea" or "tfl"
+// are weaker evidence than long ones
+func keywordStrength(keyword string) float64 {
+	length := float64(len([]rune(keyword)))
+	return math.Max(0.5, length/(length+3))
+}
+
+// scoreRuleHits turns the rules matching a transaction into per-category scores that sum
+// to 1. Earlier rules win, so each later rank decays geometrically and the first hit is
+// always the top candidate.
+func scoreRuleHits(hits []RuleHit, fuzzy bool) []CategoryScore {
+	scores := []CategoryScore{}
+	seen := map[string]bool{}
+	total := 0.0
+	weight := 1.0
+	for _, hit := range hits {
+		if seen[hit.Rule.Category] {
+			continue
+		}
+		seen[hit.Rule.Category] = true
+		score := weight * keywordStrength(hit.Keyword)
+		if fuzzy {
+			score *= fuzzyCandidateWeight
+		}
+		scores = append(scores, CategoryScore{Category: hit.Rule.Category, Score: score})
+		total += score
+		weight *= candidateRankDecay
+	}
+	for i := range scores {
+		scores[i].Score = math.Round(scores[i].Score/total*1000) / 1000
+	}
+	return scores
+}
+
+// topCandidates returns up to n candidates for a classification, falling back to the
+// decided category alone when no rule produced candidates
+func topCandidates(cl Classification, n int) []CategoryScore {
+	candidates := cl.Candidates
+	if len(candidates) == 0 || candidates[0].Category != cl.Category {
+		candidates = []CategoryScore{{Category: cl.Category, Score: 1}}
+	}
+	if n > maxCandidates {
+		n = maxCandidates
+	}
+	if n < len(candidates) {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
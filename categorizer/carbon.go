@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CarbonFactors are spend-based emission factors in kg CO2e per pound spent. MCC factors
+// are more specific and take precedence over category factors.
+type CarbonFactors struct {
+	Categories map[string]float64 `json:"categories"`
+	MCCs       map[string]float64 `json:"mcc"`
+}
+
+// defaultCarbonFactors are rough UK spend-based averages
+var defaultCarbonFactors = CarbonFactors{
+	Categories: map[string]float64{
+		"Transport":         0.60,
+		"Food & Drink":      0.35,
+		"Groceries":         0.50,
+		"Shopping":          0.40,
+		"Entertainment":     0.15,
+		"Bills & Utilities": 0.80,
+		"Housing":           0.10,
+		"ATM":               0,
+		"Income":            0,
+		"Transfers":         0,
+		"Investments":       0,
+		"Debt Repayments":   0,
+		"Donations":         0,
+		"Other":             0.30,
+	},
+	MCCs: map[string]float64{
+		"4111": 0.15, // commuter transport
+		"4112": 0.10, // passenger railways
+		"4121": 0.50, // taxis and rideshare
+		"4511": 1.80, // airlines
+		"4900": 0.90, // utilities
+		"5311": 0.40, // department stores
+		"5411": 0.50, // grocery stores
+		"5541": 2.20, // service stations
+		"5542": 2.20, // automated fuel dispensers
+		"5651": 0.60, // family clothing
+		"5732": 0.50, // electronics
+		"5812": 0.35, // restaurants
+		"5814": 0.40, // fast food
+		"7011": 0.30, // hotels
+	},
+}
+
+// carbonFactors is the active emission factors table
+var carbonFactors = defaultCarbonFactors
+
+// loadCarbonFactors overlays emission factors from a JSON file onto the defaults
+func loadCarbonFactors(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var overrides CarbonFactors
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	factors := CarbonFactors{
+		Categories: make(map[string]float64, len(defaultCarbonFactors.Categories)+len(overrides.Categories)),
+		MCCs:       make(map[string]float64, len(defaultCarbonFactors.MCCs)+len(overrides.MCCs)),
+	}
+	for category, factor := range defaultCarbonFactors.Categories {
+		factors.Categories[category] = factor
+	}
+	for mcc, factor := range defaultCarbonFactors.MCCs {
+		factors.MCCs[mcc] = factor
+	}
+	for category, factor := range overrides.Categories {
+		if factor < 0 {
+			return fmt.Errorf("category %q has a negative factor", category)
+		}
+		factors.Categories[category] = factor
+	}
+	for mcc, factor := range overrides.MCCs {
+		if factor < 0 {
+			return fmt.Errorf("mcc %q has a negative factor", mcc)
+		}
+		factors.MCCs[mcc] = factor
+	}
+
+	carbonFactors = factors
+	return nil
+}
+
+// CarbonEstimate is the estimated footprint of a transaction
+type CarbonEstimate struct {
+	KgCO2e float64 `json:"kg_co2e"`
+	Factor float64 `json:"factor_kg_per_gbp"`
+	Source string  `json:"source"`
+}
+
+// roundKg rounds a weight to the nearest gram
+func roundKg(kg float64) float64 {
+	return math.Round(kg*1000) / 1000
+}
+
+// carbonEstimateFor estimates a debit's footprint from its MCC, falling back to its category.
+// Credits emit nothing.
+func carbonEstimateFor(category, mcc string, amount float64, transactionType string) CarbonEstimate {
+	if strings.ToLower(transactionType) == "credit" {
+		return CarbonEstimate{Source: "credit"}
+	}
+	if factor, ok := carbonFactors.MCCs[mcc]; ok {
+		return CarbonEstimate{KgCO2e: roundKg(amount * factor), Factor: factor, Source: "mcc"}
+	}
+	factor, ok := carbonFactors.Categories[category]
+	if !ok {
+		factor = carbonFactors.Categories["Other"]
+	}
+	return CarbonEstimate{KgCO2e: roundKg(amount * factor), Factor: factor, Source: "category"}
+}
+
+// carbonStage estimates the footprint of the categorized transaction. It is not in the
+// default pipeline; add "carbon" after post_process in PIPELINE_STAGES to enable it.
+type carbonStage struct{}
+
+func (carbonStage) Name() string { return "carbon" }
+
+func (carbonStage) Process(cl *Classification) {
+	category := cl.Category
+	if category == "" {
+		category = "Other"
+	}
+	estimate := carbonEstimateFor(category, cl.MCC, cl.Amount, cl.TransactionType)
+	cl.Carbon = &estimate
+}
+
+// CategoryCarbon totals a user's estimated footprint in one category
+type CategoryCarbon struct {
+	Category string  `json:"category"`
+	Spending float64 `json:"spending"`
+	KgCO2e   float64 `json:"kg_co2e"`
+}
+
+// CarbonSummary totals a user's estimated footprint per category over a period
+type CarbonSummary struct {
+	UserID       string           `json:"user_id"`
+	Transactions int              `json:"transactions"`
+	Spending     float64          `json:"spending"`
+	KgCO2e       float64          `json:"kg_co2e"`
+	Categories   []CategoryCarbon `json:"categories"`
+}
+
+// buildCarbonSummary estimates the footprint of settled debits, skipping duplicates
+func buildCarbonSummary(userID string, transactions []StoredTransaction) CarbonSummary {
+	summary := CarbonSummary{UserID: userID}
+	categories := map[string]*CategoryCarbon{}
+	for _, tx := range transactions {
+		if tx.Duplicate || tx.Status != StatusSettled || strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		estimate := carbonEstimateFor(tx.Category, tx.MCC, tx.Amount, tx.TransactionType)
+		category, ok := categories[tx.Category]
+		if !ok {
+			category = &CategoryCarbon{Category: tx.Category}
+			categories[tx.Category] = category
+		}
+		category.Spending += tx.Amount
+		category.KgCO2e += estimate.KgCO2e
+		summary.Transactions++
+		summary.Spending += tx.Amount
+		summary.KgCO2e += estimate.KgCO2e
+	}
+
+	summary.Spending = roundPence(summary.Spending)
+	summary.KgCO2e = roundKg(summary.KgCO2e)
+	summary.Categories = make([]CategoryCarbon, 0, len(categories))
+	for _, category := range categories {
+		category.Spending = roundPence(category.Spending)
+		category.KgCO2e = roundKg(category.KgCO2e)
+		summary.Categories = append(summary.Categories, *category)
+	}
+	sort.Slice(summary.Categories, func(i, j int) bool {
+		return summary.Categories[i].KgCO2e > summary.Categories[j].KgCO2e
+	})
+	return summary
+}
+
+func (s *Server) handleCarbonSummary(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, buildCarbonSummary(userID, s.store.ListTransactions(userID, from, to)))
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CategoryATM is the category cash withdrawals are assigned
+const CategoryATM = "ATM"
+
+// Cash allocation errors
+var (
+	errWithdrawalNotFound = errors.New("cash withdrawal not found")
+	errInsufficientCash   = errors.New("not enough unallocated cash")
+)
+
+// CashAllocation records how a user spent part of a cash withdrawal. Summaries count the
+// amount in its category instead of ATM, at the time of the withdrawal it came from.
+type CashAllocation struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id" binding:"required"`
+	WithdrawalID string    `json:"withdrawal_id"`
+	Category     string    `json:"category" binding:"required"`
+	Amount       float64   `json:"amount" binding:"required,gt=0"`
+	Note         string    `json:"note,omitempty"`
+	WithdrawnAt  time.Time `json:"withdrawn_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CashWithdrawal is an ATM withdrawal and how much of it is still unallocated
+type CashWithdrawal struct {
+	ID          string    `json:"id"`
+	Merchant    string    `json:"merchant"`
+	Location    string    `json:"location,omitempty"`
+	Country     string    `json:"country,omitempty"`
+	Amount      float64   `json:"amount"`
+	Allocated   float64   `json:"allocated"`
+	Unallocated float64   `json:"unallocated"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CashWallet is the cash a user has withdrawn and how much of it they have accounted for
+type CashWallet struct {
+	UserID      string           `json:"user_id"`
+	Withdrawn   float64          `json:"withdrawn"`
+	Allocated   float64          `json:"allocated"`
+	Unallocated float64          `json:"unallocated"`
+	Withdrawals []CashWithdrawal `json:"withdrawals"`
+	Allocations []CashAllocation `json:"allocations"`
+}
+
+// isCashWithdrawal reports whether a stored transaction took cash out
+func isCashWithdrawal(tx StoredTransaction) bool {
+	return tx.Category == CategoryATM && !tx.Duplicate && tx.Status != StatusDeclined &&
+		strings.ToLower(tx.TransactionType) != TypeCredit
+}
+
+// cashLedger keeps each user's cash allocations in memory
+type cashLedger struct {
+	mu          sync.RWMutex
+	allocations map[string][]CashAllocation
+}
+
+// newCashLedger creates an empty ledger
+func newCashLedger() *cashLedger {
+	return &cashLedger{allocations: map[string][]CashAllocation{}}
+}
+
+// wallet builds a user's wallet from their withdrawals in history and their allocations.
+// Callers must hold the lock.
+func (l *cashLedger) wallet(history Store, userID string) CashWallet {
+	wallet := CashWallet{UserID: userID, Withdrawals: []CashWithdrawal{}, Allocations: append([]CashAllocation{}, l.allocations[userID]...)}
+	allocated := map[string]float64{}
+	for _, a := range wallet.Allocations {
+		allocated[a.WithdrawalID] += a.Amount
+		wallet.Allocated += a.Amount
+	}
+	for _, tx := range history.ListTransactions(userID, time.Time{}, time.Time{}) {
+		if !isCashWithdrawal(tx) {
+			continue
+		}
+		wallet.Withdrawn += tx.Amount
+		wallet.Withdrawals = append(wallet.Withdrawals, CashWithdrawal{
+			ID:          tx.ID,
+			Merchant:    tx.Merchant,
+			Location:    tx.Location,
+			Country:     tx.Country,
+			Amount:      tx.Amount,
+			Allocated:   roundPence(allocated[tx.ID]),
+			Unallocated: roundPence(tx.Amount - allocated[tx.ID]),
+			CreatedAt:   tx.CreatedAt,
+		})
+	}
+	wallet.Withdrawn = roundPence(wallet.Withdrawn)
+	wallet.Allocated = roundPence(wallet.Allocated)
+	wallet.Unallocated = roundPence(wallet.Withdrawn - wallet.Allocated)
+	return wallet
+}
+
+// Wallet returns a user's cash wallet, built from their withdrawals in history
+func (l *cashLedger) Wallet(history Store, userID string) CashWallet {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.wallet(history, userID)
+}
+
+// Allocate records an allocation against the given withdrawal, or against the most recent
+// withdrawal in history with enough unallocated cash when none is given
+func (l *cashLedger) Allocate(history Store, a CashAllocation) (CashAllocation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wallet := l.wallet(history, a.UserID)
+
+	var withdrawal *CashWithdrawal
+	for i := len(wallet.Withdrawals) - 1; i >= 0; i-- {
+		w := &wallet.Withdrawals[i]
+		if (a.WithdrawalID == "" && w.Unallocated+0.005 >= a.Amount) || w.ID == a.WithdrawalID {
+			withdrawal = w
+			break
+		}
+	}
+	switch {
+	case withdrawal == nil && a.WithdrawalID != "":
+		return CashAllocation{}, errWithdrawalNotFound
+	case withdrawal == nil || withdrawal.Unallocated+0.005 < a.Amount:
+		return CashAllocation{}, errInsufficientCash
+	}
+
+	a.ID = newID("cash_")
+	a.WithdrawalID = withdrawal.ID
+	a.Amount = roundPence(a.Amount)
+	a.WithdrawnAt = withdrawal.CreatedAt
+	a.CreatedAt = time.Now().UTC()
+	l.allocations[a.UserID] = append(l.allocations[a.UserID], a)
+	summaries.Invalidate(a.UserID)
+	return a, nil
+}
+
+// Remove deletes an allocation, returning it cash to the wallet
+func (l *cashLedger) Remove(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for userID, allocations := range l.allocations {
+		for i, a := range allocations {
+			if a.ID == id {
+				l.allocations[userID] = append(allocations[:i:i], allocations[i+1:]...)
+				summaries.Invalidate(userID)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemoveForWithdrawals deletes a user's allocations of the given withdrawals, e.g. once the
+// withdrawals themselves are deleted, returning how many it removed
+func (l *cashLedger) RemoveForWithdrawals(userID string, withdrawalIDs map[string]bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kept := l.allocations[userID][:0]
+	for _, a := range l.allocations[userID] {
+		if !withdrawalIDs[a.WithdrawalID] {
+			kept = append(kept, a)
+		}
+	}
+	removed := len(l.allocations[userID]) - len(kept)
+	l.allocations[userID] = kept
+	if removed > 0 {
+		summaries.Invalidate(userID)
+	}
+	return removed
+}
+
+// InRange returns a user's allocations of cash withdrawn within [from, to); a zero bound is open
+func (l *cashLedger) InRange(userID string, from, to time.Time) []CashAllocation {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var list []CashAllocation
+	for _, a := range l.allocations[userID] {
+		if (from.IsZero() || !a.WithdrawnAt.Before(from)) && (to.IsZero() || a.WithdrawnAt.Before(to)) {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// Snapshot returns a copy of every user's allocations
+func (l *cashLedger) Snapshot() map[string][]CashAllocation {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	snapshot := make(map[string][]CashAllocation, len(l.allocations))
+	for userID, allocations := range l.allocations {
+		snapshot[userID] = append([]CashAllocation{}, allocations...)
+	}
+	return snapshot
+}
+
+// Restore replaces every user's allocations
+func (l *cashLedger) Restore(allocations map[string][]CashAllocation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.allocations = allocations
+}
+
+// Global cash allocation ledger
+var cash = newCashLedger()
+
+// applyCashAllocations moves allocated cash out of ATM and into the categories it was spent
+// on. An allocation never takes ATM below zero, e.g. after its withdrawal was declined.
+func applyCashAllocations(summary *Summary, allocations []CashAllocation) {
+	if len(allocations) == 0 {
+		return
+	}
+	categories := map[string]*CategorySummary{}
+	for i := range summary.Categories {
+		categories[summary.Categories[i].Category] = &summary.Categories[i]
+	}
+	moved := map[string]CategorySummary{}
+	for _, a := range allocations {
+		atm, ok := categories[CategoryATM]
+		if !ok {
+			break
+		}
+		amount := math.Min(a.Amount, atm.Total)
+		if amount <= 0 {
+			continue
+		}
+		atm.Total = roundPence(atm.Total - amount)
+		m := moved[a.Category]
+		m.Count++
+		m.Total += amount
+		moved[a.Category] = m
+	}
+	var added []CategorySummary
+	for category, m := range moved {
+		if existing, ok := categories[category]; ok {
+			existing.Count += m.Count
+			existing.Total = roundPence(existing.Total + m.Total)
+			continue
+		}
+		added = append(added, CategorySummary{Category: category, Count: m.Count, Total: roundPence(m.Total)})
+	}
+	summary.Categories = append(summary.Categories, added...)
+	sort.Slice(summary.Categories, func(i, j int) bool {
+		return summary.Categories[i].Total > summary.Categories[j].Total
+	})
+}
+
+// applyCashAllocationsMonthly does the same for monthly spending totals
+func applyCashAllocationsMonthly(months map[string]map[string]float64, allocations []CashAllocation) {
+	for _, a := range allocations {
+		month := months[a.WithdrawnAt.UTC().Format(monthLayout)]
+		if month == nil {
+			continue
+		}
+		amount := math.Min(a.Amount, month[CategoryATM])
+		if amount <= 0 {
+			continue
+		}
+		month[CategoryATM] -= amount
+		month[a.Category] += amount
+	}
+}
+
+// handleCreateCashAllocation serves POST /cash-allocations
+func (s *Server) handleCreateCashAllocation(c *gin.Context) {
+	var allocation CashAllocation
+	if err := c.ShouldBindJSON(&allocation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if allocation.Category == CategoryATM || allocation.Category == "Income" || !s.knownCategories()[allocation.Category] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cash can't be allocated to category " + allocation.Category})
+		return
+	}
+
+	created, err := cash.Allocate(s.store, allocation)
+	switch {
+	case errors.Is(err, errWithdrawalNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errInsufficientCash):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// handleDeleteCashAllocation serves DELETE /cash-allocations/:id
+func (s *Server) handleDeleteCashAllocation(c *gin.Context) {
+	if !cash.Remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cash allocation not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleCashWallet serves GET /users/:user_id/cash-wallet
+func (s *Server) handleCashWallet(c *gin.Context) {
+	c.JSON(http.StatusOK, cash.Wallet(s.store, c.Param("user_id")))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CashbackOffer is a cashback or loyalty offer at a merchant, or across a whole category
+// when Merchant is empty
+type CashbackOffer struct {
+	ID          string  `json:"id"`
+	Provider    string  `json:"provider"`
+	Merchant    string  `json:"merchant,omitempty"`
+	Category    string  `json:"category"`
+	Rate        float64 `json:"rate"`
+	MaxCashback float64 `json:"max_cashback,omitempty"`
+}
+
+// Applies reports whether the offer covers a transaction at the given merchant and category
+func (o CashbackOffer) Applies(merchant, category string) bool {
+	if o.Merchant == "" {
+		return o.Category == category
+	}
+	return strings.Contains(resolveMerchant(normalizeDescriptor(merchant)), strings.ToLower(o.Merchant))
+}
+
+// Estimate returns the cashback the offer pays on an amount
+func (o CashbackOffer) Estimate(amount float64) float64 {
+	cashback := amount * o.Rate
+	if o.MaxCashback > 0 {
+		cashback = math.Min(cashback, o.MaxCashback)
+	}
+	return roundPence(cashback)
+}
+
+// defaultCashbackOffers is a small demo registry
+var defaultCashbackOffers = []CashbackOffer{
+	{ID: "off_costa", Provider: "Costa Club", Merchant: "costa", Category: "Food & Drink", Rate: 0.05, MaxCashback: 5},
+	{ID: "off_pret", Provider: "Pret Perks", Merchant: "pret", Category: "Food & Drink", Rate: 0.05, MaxCashback: 5},
+	{ID: "off_sainsbury", Provider: "Nectar", Merchant: "sainsbury", Category: "Groceries", Rate: 0.01},
+	{ID: "off_tesco", Provider: "Tesco Clubcard", Merchant: "tesco", Category: "Groceries", Rate: 0.01},
+	{ID: "off_amazon", Provider: "Amazon Prime Card", Merchant: "amazon", Category: "Shopping", Rate: 0.02, MaxCashback: 10},
+	{ID: "off_uber", Provider: "Uber One", Merchant: "uber", Category: "Transport", Rate: 0.06, MaxCashback: 3},
+}
+
+// cashbackOffers is the active offer registry
+var cashbackOffers = defaultCashbackOffers
+
+// loadCashbackOffers replaces the offer registry with a JSON array from a file
+func loadCashbackOffers(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var offers []CashbackOffer
+	if err := json.Unmarshal(data, &offers); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, offer := range offers {
+		if offer.ID == "" || offer.Category == "" {
+			return fmt.Errorf("offer %d needs an id and a category", i)
+		}
+		if offer.Rate <= 0 || offer.Rate > 1 {
+			return fmt.Errorf("offer %q has rate %v, want a fraction between 0 and 1", offer.ID, offer.Rate)
+		}
+		offers[i].Merchant = strings.ToLower(offer.Merchant)
+	}
+
+	cashbackOffers = offers
+	return nil
+}
+
+// CashbackMatch is an offer relevant to a transaction with the cashback it would pay
+type CashbackMatch struct {
+	CashbackOffer
+	Estimate float64 `json:"estimate"`
+}
+
+// CashbackAnnotation lists offers a transaction likely qualified for, and competitor
+// offers in the same category it missed
+type CashbackAnnotation struct {
+	Qualified   []CashbackMatch `json:"qualified,omitempty"`
+	Competitors []CashbackMatch `json:"competitors,omitempty"`
+}
+
+// cashbackFor matches a debit against the offer registry, returning nil when no offer is relevant
+func cashbackFor(merchant, category string, amount float64, transactionType string) *CashbackAnnotation {
+	if strings.ToLower(transactionType) == "credit" || isChargeCategory(category) {
+		return nil
+	}
+
+	annotation := &CashbackAnnotation{}
+	for _, offer := range cashbackOffers {
+		match := CashbackMatch{CashbackOffer: offer, Estimate: offer.Estimate(amount)}
+		if offer.Applies(merchant, category) {
+			annotation.Qualified = append(annotation.Qualified, match)
+		} else if offer.Category == category {
+			annotation.Competitors = append(annotation.Competitors, match)
+		}
+	}
+	if len(annotation.Qualified) == 0 && len(annotation.Competitors) == 0 {
+		return nil
+	}
+	sort.SliceStable(annotation.Competitors, func(i, j int) bool {
+		return annotation.Competitors[i].Estimate > annotation.Competitors[j].Estimate
+	})
+	return annotation
+}
+
+// MonthlyCashback totals a user's cashback for one month
+type MonthlyCashback struct {
+	Month        string  `json:"month"`
+	Qualified    float64 `json:"qualified"`
+	Missed       float64 `json:"missed"`
+	Transactions int     `json:"missed_transactions"`
+}
+
+// buildMissedCashback totals per month the cashback settled debits likely earned, and the
+// best competitor offer for debits that earned none
+func buildMissedCashback(transactions []StoredTransaction) []MonthlyCashback {
+	months := map[string]*MonthlyCashback{}
+	for _, tx := range transactions {
+		if tx.Duplicate || tx.Status != StatusSettled {
+			continue
+		}
+		annotation := cashbackFor(tx.Merchant, tx.Category, tx.Amount, tx.TransactionType)
+		if annotation == nil {
+			continue
+		}
+
+		key := tx.CreatedAt.Format("2006-01")
+		month, ok := months[key]
+		if !ok {
+			month = &MonthlyCashback{Month: key}
+			months[key] = month
+		}
+		if len(annotation.Qualified) > 0 {
+			best := 0.0
+			for _, match := range annotation.Qualified {
+				best = math.Max(best, match.Estimate)
+			}
+			month.Qualified += best
+			continue
+		}
+		month.Missed += annotation.Competitors[0].Estimate
+		month.Transactions++
+	}
+
+	result := make([]MonthlyCashback, 0, len(months))
+	for _, month := range months {
+		month.Qualified = roundPence(month.Qualified)
+		month.Missed = roundPence(month.Missed)
+		result = append(result, *month)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Month < result[j].Month
+	})
+	return result
+}
+
+func (s *Server) handleMissedCashback(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	months := buildMissedCashback(s.store.ListTransactions(userID, from, to))
+	missed := 0.0
+	for _, month := range months {
+		missed += month.Missed
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"missed":  roundPence(missed),
+		"months":  months,
+	})
+}
+
+func (s *Server) handleListCashbackOffers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"count":  len(cashbackOffers),
+		"offers": cashbackOffers,
+	})
+}
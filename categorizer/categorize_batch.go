@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxCategorizeBatch bounds the transactions categorized in one batch request
+const maxCategorizeBatch = 5000
+
+// CategorizeBatchItem is the outcome for one transaction of a batch: the same response
+// /categorize would give, or why the transaction couldn't be categorized
+type CategorizeBatchItem struct {
+	Index int    `json:"index"`
+	Error string `json:"error,omitempty"`
+	*CategoryResponse
+}
+
+// handleCategorizeBatch serves POST /categorize/batch with an array of transactions,
+// categorizing each as /categorize would and answering with results in the same order. An
+// invalid transaction fails on its own without failing the batch. The ?top= and ?include=
+// options apply to every transaction.
+func (s *Server) handleCategorizeBatch(c *gin.Context) {
+	var raw []json.RawMessage
+	if err := c.ShouldBindJSON(&raw); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be an array of transactions"})
+		return
+	}
+	if len(raw) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "batch is empty"})
+		return
+	}
+	if len(raw) > maxCategorizeBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("batch has %d transactions, at most %d are allowed", len(raw), maxCategorizeBatch)})
+		return
+	}
+	rs, err := requestRuleSet(c, s.classifier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts, err := categorizeParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]CategorizeBatchItem, len(raw))
+	failed := 0
+	for i, item := range raw {
+		start := time.Now()
+		results[i].Index = i
+
+		var req TransactionRequest
+		err := json.Unmarshal(item, &req)
+		if err == nil {
+			err = binding.Validator.ValidateStruct(&req)
+		}
+		if err == nil {
+			err = s.normalizeTransaction(c, &req)
+		}
+		if err != nil {
+			s.metrics.recordCategorizationError("bad_request")
+			s.requestLogger(c).logCategorizationError("bad_request", fmt.Sprintf("batch item %d: %s", i, err))
+			results[i].Error = err.Error()
+			failed++
+			continue
+		}
+
+		response := s.categorize(c.Request.Context(), req, rs, opts, start)
+		results[i].CategoryResponse = &response
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":     len(results),
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"results":   results,
+	})
+}
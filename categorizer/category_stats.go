@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCategoryWindow is the longest window category counts are kept for
+const maxCategoryWindow = 24 * time.Hour
+
+// CategoryShare is how often one category was served within a window
+type CategoryShare struct {
+	Category string  `json:"category"`
+	Count    int     `json:"count"`
+	Percent  float64 `json:"percent"`
+}
+
+// categoryBucket counts the categories served in one minute
+type categoryBucket struct {
+	minute int64
+	counts map[string]int
+}
+
+// categoryCounter counts the categories served in per-minute buckets over the last
+// maxCategoryWindow, so the distribution in any window up to that can be read cheaply
+type categoryCounter struct {
+	mu      sync.Mutex
+	buckets []categoryBucket
+}
+
+// newCategoryCounter creates an empty counter
+func newCategoryCounter() *categoryCounter {
+	return &categoryCounter{buckets: make([]categoryBucket, int(maxCategoryWindow/time.Minute))}
+}
+
+// Record counts a category served at now
+func (c *categoryCounter) Record(category string, now time.Time) {
+	minute := now.Unix() / 60
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := &c.buckets[minute%int64(len(c.buckets))]
+	if b.minute != minute || b.counts == nil {
+		b.minute, b.counts = minute, map[string]int{}
+	}
+	b.counts[category]++
+}
+
+// Snapshot returns the categories served in the window up to now, most served first, and
+// their total. The window is counted in whole minutes, including the current one.
+func (c *categoryCounter) Snapshot(window time.Duration, now time.Time) ([]CategoryShare, int) {
+	latest := now.Unix() / 60
+	oldest := latest - int64(window/time.Minute) + 1
+	counts := map[string]int{}
+	total := 0
+	c.mu.Lock()
+	for _, b := range c.buckets {
+		if b.counts == nil || b.minute < oldest || b.minute > latest {
+			continue
+		}
+		for category, n := range b.counts {
+			counts[category] += n
+			total += n
+		}
+	}
+	c.mu.Unlock()
+
+	shares := make([]CategoryShare, 0, len(counts))
+	for category, n := range counts {
+		shares = append(shares, CategoryShare{Category: category, Count: n, Percent: roundPence(float64(n) / float64(total) * 100)})
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Count != shares[j].Count {
+			return shares[i].Count > shares[j].Count
+		}
+		return shares[i].Category < shares[j].Category
+	})
+	return shares, total
+}
+
+// Global served-category counter
+var categoryCounts = newCategoryCounter()
+
+// handleCategoryStats serves GET /stats/categories?window=1h, the categories served in the
+// trailing window (1m to 24h, default 1h) with their counts and percentages, from in-memory
+// counters so clients needn't query Prometheus
+func (s *Server) handleCategoryStats(c *gin.Context) {
+	window := time.Hour
+	if raw := c.Query("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < time.Minute || d > maxCategoryWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("window must be a duration between 1m and %s", maxCategoryWindow)})
+			return
+		}
+		window = d.Truncate(time.Minute)
+	}
+	now := time.Now().UTC()
+	shares, total := categoryCounts.Snapshot(window, now)
+	c.JSON(http.StatusOK, gin.H{
+		"window":     window.String(),
+		"from":       now.Add(-window),
+		"to":         now,
+		"total":      total,
+		"categories": shares,
+	})
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Changeset statuses. A pending changeset becomes applied when a second admin approves it,
+// or scheduled when they approve it to activate later.
+const (
+	ChangesetPending   = "pending"
+	ChangesetScheduled = "scheduled"
+	ChangesetApplied   = "applied"
+	ChangesetRejected  = "rejected"
+	ChangesetCancelled = "cancelled"
+	ChangesetFailed    = "failed"
+)
+
+// Changeset workflow errors
+var (
+	errChangesetNotFound = errors.New("changeset not found")
+	errChangesetClosed   = errors.New("changeset is no longer pending")
+	errSelfApproval      = errors.New("a changeset must be approved by a different admin than its author")
+	errAnonymousApproval = errors.New("approving a changeset needs an authenticated admin identity; set ADMIN_API_KEYS")
+	errStaleChangeset    = errors.New("the active rules changed since this changeset was proposed; propose it again against the current version")
+	errNotScheduled      = errors.New("changeset is not scheduled")
+)
+
+// ChangesetComment is a note left on a changeset during review
+type ChangesetComment struct {
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RuleChangeset is a proposed replacement for the active rule set. It only takes effect once
+// approved by an admin other than its author, and only if the rules it was proposed against
+// are still active.
+type RuleChangeset struct {
+	ID          string             `json:"id"`
+	BaseVersion string             `json:"base_version"`
+	Version     string             `json:"version"`
+	Rules       []Rule             `json:"rules"`
+	Status      string             `json:"status"`
+	Author      string             `json:"author"`
+	Reviewer    string             `json:"reviewer,omitempty"`
+	Comments    []ChangesetComment `json:"comments"`
+	Warnings    []string           `json:"warnings,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	ReviewedAt  *time.Time         `json:"reviewed_at,omitempty"`
+	ActivateAt  *time.Time         `json:"activate_at,omitempty"`
+	ActivatedAt *time.Time         `json:"activated_at,omitempty"`
+	Failure     string             `json:"failure,omitempty"`
+}
+
+// ruleSet returns the rule set the changeset proposes
+func (cs RuleChangeset) ruleSet() *RuleSet {
+	rs := &RuleSet{Version: cs.Version, Rules: make([]Rule, len(cs.Rules))}
+	for i, rule := range cs.Rules {
+		rule.Keywords = append([]string(nil), rule.Keywords...)
+		rule.Fields = append([]string(nil), rule.Fields...)
+		rs.Rules[i] = rule
+	}
+	return rs
+}
+
+// RuleChange is a rule whose definition differs between two rule sets
+type RuleChange struct {
+	Name   string `json:"name"`
+	Before Rule   `json:"before"`
+	After  Rule   `json:"after"`
+}
+
+// RuleSetDiff describes how one rule set differs from another, matching rules by name
+type RuleSetDiff struct {
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Added     []Rule       `json:"added"`
+	Removed   []Rule       `json:"removed"`
+	Changed   []RuleChange `json:"changed"`
+	Reordered bool         `json:"reordered"`
+}
+
+// ruleKey names a rule for diffing, falling back to its position when it has no name
+func ruleKey(rule Rule, i int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+// diffRuleSets compares two rule sets rule by rule
+func diffRuleSets(from, to *RuleSet) RuleSetDiff {
+	diff := RuleSetDiff{From: from.Version, To: to.Version, Added: []Rule{}, Removed: []Rule{}, Changed: []RuleChange{}}
+	before := map[string]Rule{}
+	for i, rule := range from.Rules {
+		before[ruleKey(rule, i)] = rule
+	}
+	after := map[string]bool{}
+	var kept []string
+	for i, rule := range to.Rules {
+		key := ruleKey(rule, i)
+		after[key] = true
+		old, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, rule)
+			continue
+		case !reflect.DeepEqual(old, rule):
+			diff.Changed = append(diff.Changed, RuleChange{Name: key, Before: old, After: rule})
+		}
+		kept = append(kept, key)
+	}
+	i := 0
+	for j, rule := range from.Rules {
+		key := ruleKey(rule, j)
+		if !after[key] {
+			diff.Removed = append(diff.Removed, rule)
+			continue
+		}
+		if kept[i] != key {
+			diff.Reordered = true
+		}
+		i++
+	}
+	return diff
+}
+
+// changesetStore keeps rule changesets in memory in the order they were proposed
+type changesetStore struct {
+	mu         sync.Mutex
+	changesets []*RuleChangeset
+}
+
+// find returns a changeset by ID. Callers must hold the lock.
+func (s *changesetStore) find(id string) (*RuleChangeset, error) {
+	for _, cs := range s.changesets {
+		if cs.ID == id {
+			return cs, nil
+		}
+	}
+	return nil, errChangesetNotFound
+}
+
+// Create records a new pending changeset against srv's active rules
+func (s *changesetStore) Create(srv *Server, cs RuleChangeset) RuleChangeset {
+	cs.ID = newID("cs_")
+	cs.BaseVersion = srv.activeRules().Version
+	cs.Status = ChangesetPending
+	cs.CreatedAt = time.Now().UTC()
+	if cs.Comments == nil {
+		cs.Comments = []ChangesetComment{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changesets = append(s.changesets, &cs)
+	return cs
+}
+
+// Get returns a changeset by ID
+func (s *changesetStore) Get(id string) (RuleChangeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, err := s.find(id)
+	if err != nil {
+		return RuleChangeset{}, err
+	}
+	return *cs, nil
+}
+
+// List returns the changesets with a status, or all of them when status is empty, newest first
+func (s *changesetStore) List(status string) []RuleChangeset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []RuleChangeset{}
+	for _, cs := range s.changesets {
+		if status == "" || cs.Status == status {
+			list = append(list, *cs)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Applied returns the applied changeset that proposed a rule set version
+func (s *changesetStore) Applied(version string) (RuleChangeset, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cs := range s.changesets {
+		if cs.Status == ChangesetApplied && cs.Version == version {
+			return *cs, true
+		}
+	}
+	return RuleChangeset{}, false
+}
+
+// Comment adds a review comment to a changeset
+func (s *changesetStore) Comment(id, author, body string) (RuleChangeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, err := s.find(id)
+	if err != nil {
+		return RuleChangeset{}, err
+	}
+	cs.Comments = append(cs.Comments, ChangesetComment{Author: author, Body: body, CreatedAt: time.Now().UTC()})
+	return *cs, nil
+}
+
+// Approve activates a pending changeset's rules on srv, on behalf of a reviewer other than its
+// author. With a future activateAt the changeset is scheduled instead, and the scheduler activates it
+// then. Only one changeset can be scheduled at a time, since a second one would be stale by
+// the time it came due.
+func (s *changesetStore) Approve(srv *Server, id, reviewer string, activateAt time.Time) (RuleChangeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, err := s.find(id)
+	switch {
+	case err != nil:
+		return RuleChangeset{}, err
+	case cs.Status != ChangesetPending:
+		return RuleChangeset{}, errChangesetClosed
+	case reviewer == "anonymous":
+		return RuleChangeset{}, errAnonymousApproval
+	case reviewer == cs.Author:
+		return RuleChangeset{}, errSelfApproval
+	case cs.BaseVersion != srv.activeRules().Version:
+		return RuleChangeset{}, errStaleChangeset
+	}
+	if activateAt.IsZero() {
+		cs.activate(srv, reviewer)
+		return *cs, nil
+	}
+	for _, other := range s.changesets {
+		if other.Status == ChangesetScheduled {
+			return RuleChangeset{}, fmt.Errorf("changeset %s is already scheduled to activate at %s; cancel it first", other.ID, other.ActivateAt.Format(time.RFC3339))
+		}
+	}
+	activateAt = activateAt.UTC()
+	cs.close(ChangesetScheduled, reviewer)
+	cs.ActivateAt = &activateAt
+	return *cs, nil
+}
+
+// activate makes the changeset's rules srv's active rule set. Callers must hold the store lock.
+func (cs *RuleChangeset) activate(srv *Server, reviewer string) {
+	srv.setActiveRules(cs.ruleSet())
+	if cs.ReviewedAt == nil {
+		cs.close(ChangesetApplied, reviewer)
+	}
+	now := time.Now().UTC()
+	cs.Status = ChangesetApplied
+	cs.ActivatedAt = &now
+}
+
+// ActivateDue activates the scheduled changesets due by now on srv, returning them. A changeset
+// whose base rules are no longer active fails rather than overwriting the newer rules.
+func (s *changesetStore) ActivateDue(srv *Server, now time.Time) []RuleChangeset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []RuleChangeset
+	for _, cs := range s.changesets {
+		if cs.Status != ChangesetScheduled || cs.ActivateAt.After(now) {
+			continue
+		}
+		if cs.BaseVersion != srv.activeRules().Version {
+			cs.Status = ChangesetFailed
+			cs.Failure = errStaleChangeset.Error()
+		} else {
+			cs.activate(srv, cs.Reviewer)
+		}
+		due = append(due, *cs)
+	}
+	return due
+}
+
+// Cancel stops a scheduled changeset from activating
+func (s *changesetStore) Cancel(id string) (RuleChangeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, err := s.find(id)
+	if err != nil {
+		return RuleChangeset{}, err
+	}
+	if cs.Status != ChangesetScheduled {
+		return RuleChangeset{}, errNotScheduled
+	}
+	cs.Status = ChangesetCancelled
+	return *cs, nil
+}
+
+// Reject closes a pending changeset without applying it. Authors may withdraw their own.
+func (s *changesetStore) Reject(id, reviewer string) (RuleChangeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, err := s.find(id)
+	if err != nil {
+		return RuleChangeset{}, err
+	}
+	if cs.Status != ChangesetPending {
+		return RuleChangeset{}, errChangesetClosed
+	}
+	cs.close(ChangesetRejected, reviewer)
+	return *cs, nil
+}
+
+// close records the outcome of a review
+func (cs *RuleChangeset) close(status, reviewer string) {
+	now := time.Now().UTC()
+	cs.Status = status
+	cs.Reviewer = reviewer
+	cs.ReviewedAt = &now
+}
+
+// Global rule changesets
+var changesets = &changesetStore{}
+
+// changesetStatus maps changeset workflow errors to HTTP statuses
+func changesetStatus(err error) int {
+	switch {
+	case errors.Is(err, errChangesetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errSelfApproval), errors.Is(err, errAnonymousApproval):
+		return http.StatusForbidden
+	}
+	return http.StatusConflict
+}
+
+// handleCreateChangeset serves POST /admin/rules/changesets, proposing a new rule set for review
+func (s *Server) handleCreateChangeset(c *gin.Context) {
+	var req struct {
+		Version string `json:"version" binding:"required"`
+		Rules   []Rule `json:"rules" binding:"required,min=1,dive"`
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	current := s.activeRules()
+	if ruleHistory.Known(req.Version) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version " + req.Version + " is already in use; pinned requests rely on versions being unique"})
+		return
+	}
+
+	proposed := &RuleSet{Version: req.Version, Rules: req.Rules}
+	for i := range proposed.Rules {
+		proposed.Rules[i].normalize()
+	}
+	report := validateRuleSet(proposed)
+	if len(report.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rule set is invalid", "errors": report.Errors, "warnings": report.Warnings})
+		return
+	}
+
+	author := callerName(c)
+	cs := RuleChangeset{Version: req.Version, Rules: proposed.Rules, Author: author, Warnings: report.Warnings}
+	if req.Comment != "" {
+		cs.Comments = []ChangesetComment{{Author: author, Body: req.Comment, CreatedAt: time.Now().UTC()}}
+	}
+	cs = changesets.Create(s, cs)
+	s.recordAudit(author, "rules.changeset.create", cs.ID, map[string]interface{}{
+		"version":      cs.Version,
+		"base_version": cs.BaseVersion,
+	})
+	c.JSON(http.StatusCreated, gin.H{"changeset": cs, "diff": diffRuleSets(current, cs.ruleSet())})
+}
+
+// handleListChangesets serves GET /admin/rules/changesets, optionally filtered with ?status=
+func (s *Server) handleListChangesets(c *gin.Context) {
+	list := changesets.List(c.Query("status"))
+	c.JSON(http.StatusOK, gin.H{"count": len(list), "changesets": list})
+}
+
+// handleGetChangeset serves GET /admin/rules/changesets/:id with a diff against the active rules
+func (s *Server) handleGetChangeset(c *gin.Context) {
+	cs, err := changesets.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(changesetStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changeset": cs, "diff": diffRuleSets(s.activeRules(), cs.ruleSet())})
+}
+
+// handleCommentChangeset serves POST /admin/rules/changesets/:id/comments
+func (s *Server) handleCommentChangeset(c *gin.Context) {
+	var req struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	author := callerName(c)
+	cs, err := changesets.Comment(c.Param("id"), author, req.Body)
+	if err != nil {
+		c.JSON(changesetStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.recordAudit(author, "rules.changeset.comment", cs.ID, nil)
+	c.JSON(http.StatusOK, cs)
+}
+
+// handleApproveChangeset serves POST /admin/rules/changesets/:id/approve, activating its rules
+// now or, given an activate_at in the body, scheduling them
+func (s *Server) handleApproveChangeset(c *gin.Context) {
+	var req struct {
+		ActivateAt *time.Time `json:"activate_at"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var activateAt time.Time
+	if req.ActivateAt != nil {
+		if !req.ActivateAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "activate_at must be in the future"})
+			return
+		}
+		activateAt = *req.ActivateAt
+	}
+
+	reviewer := callerName(c)
+	cs, err := changesets.Approve(s, c.Param("id"), reviewer, activateAt)
+	if err != nil {
+		s.recordAudit(reviewer, "rules.changeset.approve_denied", c.Param("id"), map[string]interface{}{"reason": err.Error()})
+		c.JSON(changesetStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	details := map[string]interface{}{
+		"version":      cs.Version,
+		"base_version": cs.BaseVersion,
+		"author":       cs.Author,
+	}
+	if cs.ActivateAt != nil {
+		details["activate_at"] = cs.ActivateAt
+		s.recordAudit(reviewer, "rules.changeset.schedule", cs.ID, details)
+	} else {
+		s.recordAudit(reviewer, "rules.changeset.approve", cs.ID, details)
+	}
+	c.JSON(http.StatusOK, cs)
+}
+
+// handleCancelChangeset serves POST /admin/rules/changesets/:id/cancel, stopping a scheduled
+// activation
+func (s *Server) handleCancelChangeset(c *gin.Context) {
+	cs, err := changesets.Cancel(c.Param("id"))
+	if err != nil {
+		c.JSON(changesetStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.recordAudit(callerName(c), "rules.changeset.cancel", cs.ID, map[string]interface{}{"activate_at": cs.ActivateAt})
+	c.JSON(http.StatusOK, cs)
+}
+
+// startRuleScheduler activates scheduled changesets as they come due
+func (s *Server) startRuleScheduler(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			for _, cs := range changesets.ActivateDue(s, time.Now()) {
+				if cs.Status == ChangesetFailed {
+					s.logger.Error("Scheduled rule set activation failed", map[string]interface{}{
+						"event_type":    "rules_activation",
+						"error_type":    "stale_changeset",
+						"error_message": cs.ID + ": " + cs.Failure,
+					})
+					s.recordAudit("scheduler", "rules.changeset.activation_failed", cs.ID, map[string]interface{}{"reason": cs.Failure})
+					continue
+				}
+				s.recordAudit("scheduler", "rules.changeset.activate", cs.ID, map[string]interface{}{"version": cs.Version})
+			}
+		}
+	}()
+}
+
+// handleRejectChangeset serves POST /admin/rules/changesets/:id/reject
+func (s *Server) handleRejectChangeset(c *gin.Context) {
+	reviewer := callerName(c)
+	cs, err := changesets.Reject(c.Param("id"), reviewer)
+	if err != nil {
+		c.JSON(changesetStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.recordAudit(reviewer, "rules.changeset.reject", cs.ID, nil)
+	c.JSON(http.StatusOK, cs)
+}
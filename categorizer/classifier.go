@@ -0,0 +1,61 @@
+package main
+
+import "sync"
+
+// Classifier runs transactions through a categorization pipeline against its active rule
+// set. Either can be swapped while requests are being classified.
+type Classifier struct {
+	mu       sync.RWMutex
+	rules    *RuleSet
+	pipeline *Pipeline
+	metrics  *Metrics
+}
+
+// NewClassifier creates a classifier evaluating rs through p, recording stage latencies and
+// fuzzy matches in m
+func NewClassifier(rs *RuleSet, p *Pipeline, m *Metrics) *Classifier {
+	return &Classifier{rules: rs, pipeline: p, metrics: m}
+}
+
+// Rules returns the active rule set
+func (cl *Classifier) Rules() *RuleSet {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.rules
+}
+
+// SwapRules replaces the active rule set, returning the one it replaced. Callers must not
+// modify rs afterwards.
+func (cl *Classifier) SwapRules(rs *RuleSet) *RuleSet {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	previous := cl.rules
+	cl.rules = rs
+	return previous
+}
+
+// SetPipeline replaces the pipeline
+func (cl *Classifier) SetPipeline(p *Pipeline) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.pipeline = p
+}
+
+// Run passes a classification through the pipeline
+func (cl *Classifier) Run(c *Classification) {
+	cl.mu.RLock()
+	p := cl.pipeline
+	cl.mu.RUnlock()
+	p.Run(c, cl.metrics)
+}
+
+// Classify runs a categorization request, including its MCC and the user and tenant whose
+// overrides apply, through the pipeline against a rule set
+func (cl *Classifier) Classify(req TransactionRequest, rs *RuleSet) Classification {
+	c := newClassification(req, rs)
+	cl.Run(&c)
+	if c.Fuzzy {
+		cl.metrics.recordFuzzyMatch(c.Category)
+	}
+	return c
+}
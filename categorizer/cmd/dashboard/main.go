@@ -0,0 +1,360 @@
+// Command dashboard is a terminal dashboard for a running categorizer: live request rates,
+// the category distribution, recently unclassified merchants and the state of the rules,
+// for local development and demos without Grafana.
+//
+//	go run ./cmd/dashboard -url http://localhost:9000
+//
+// Unclassified merchants are tailed from every tenant's events through the admin events API,
+// which needs an admin API key when the service requires one; set ADMIN_API_KEY.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// ANSI escapes for drawing
+const (
+	clearScreen = "\033[H\033[2J"
+	hideCursor  = "\033[?25l"
+	showCursor  = "\033[?25h"
+	bold        = "\033[1m"
+	dim         = "\033[2m"
+	red         = "\033[31m"
+	green       = "\033[32m"
+	yellow      = "\033[33m"
+	reset       = "\033[0m"
+)
+
+// unclassifiedCategory is the category the pipeline falls back to
+const unclassifiedCategory = "Other"
+
+// maxUnclassified bounds the unclassified merchants listed
+const maxUnclassified = 10
+
+// dashboard polls the service and keeps what it needs between polls to compute rates
+type dashboard struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+
+	lastPoll     time.Time
+	lastRequests map[string]float64
+	// eventSeq is the last event read, once tailing has started from the newest event
+	eventSeq     int64
+	tailing      bool
+	unclassified []unclassifiedMerchant
+}
+
+// unclassifiedMerchant is a transaction that fell through to the fallback category
+type unclassifiedMerchant struct {
+	merchant string
+	amount   float64
+	at       time.Time
+}
+
+// snapshot is one poll's worth of numbers to draw
+type snapshot struct {
+	version        string
+	rulesetVersion string
+	startedAt      time.Time
+	rulesReloaded  time.Time
+	requestRates   map[string]float64
+	categories     map[string]float64
+	errors         []string
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:9000", "base URL of the running service")
+	interval := flag.Duration("interval", 2*time.Second, "refresh interval")
+	flag.Parse()
+
+	d := &dashboard{
+		baseURL: strings.TrimRight(*url, "/"),
+		apiKey:  os.Getenv("ADMIN_API_KEY"),
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	fmt.Print(hideCursor)
+	defer fmt.Print(showCursor)
+
+	tick := time.NewTicker(*interval)
+	defer tick.Stop()
+	for {
+		d.draw(d.poll())
+		select {
+		case <-stop:
+			fmt.Println()
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// request fetches a path from the service, whatever the response's status
+func (d *dashboard) request(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, d.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+d.apiKey)
+	}
+	return d.client.Do(req)
+}
+
+// get fetches a path from the service, failing on anything but success
+func (d *dashboard) get(path string) (*http.Response, error) {
+	resp, err := d.request(path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// poll gathers a snapshot, noting rather than failing on what couldn't be read
+func (d *dashboard) poll() snapshot {
+	s := snapshot{requestRates: map[string]float64{}, categories: map[string]float64{}}
+	now := time.Now()
+
+	if err := d.pollMetrics(&s, now); err != nil {
+		s.errors = append(s.errors, err.Error())
+	}
+	if err := d.pollEvents(); err != nil {
+		s.errors = append(s.errors, err.Error())
+	}
+	d.lastPoll = now
+	return s
+}
+
+// pollMetrics reads request rates, categories and rule state from /metrics
+func (d *dashboard) pollMetrics(s *snapshot, now time.Time) error {
+	resp, err := d.get("/metrics")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return fmt.Errorf("parse /metrics: %w", err)
+	}
+
+	requests := map[string]float64{}
+	for _, m := range metricsOf(families, "http_requests_total") {
+		class := label(m, "status_code")
+		if class != "" {
+			class = class[:1] + "xx"
+		}
+		requests[class] += m.GetCounter().GetValue()
+	}
+	if d.lastRequests != nil {
+		elapsed := now.Sub(d.lastPoll).Seconds()
+		for class, total := range requests {
+			// A drop means the service restarted; show nothing for this poll
+			if delta := total - d.lastRequests[class]; delta >= 0 && elapsed > 0 {
+				s.requestRates[class] = delta / elapsed
+			}
+		}
+	}
+	d.lastRequests = requests
+
+	for _, m := range metricsOf(families, "categorization_requests_total") {
+		s.categories[label(m, "category")] += m.GetCounter().GetValue()
+	}
+	for _, m := range metricsOf(families, "build_info") {
+		s.version, s.rulesetVersion = label(m, "version"), label(m, "ruleset_version")
+	}
+	for _, m := range metricsOf(families, "service_start_time_seconds") {
+		s.startedAt = time.Unix(int64(m.GetGauge().GetValue()), 0)
+	}
+	for _, m := range metricsOf(families, "rules_last_reload_timestamp_seconds") {
+		s.rulesReloaded = time.Unix(int64(m.GetGauge().GetValue()), 0)
+	}
+	return nil
+}
+
+// metricsOf returns a family's metrics, or none when the service doesn't export it
+func metricsOf(families map[string]*dto.MetricFamily, name string) []*dto.Metric {
+	if mf, ok := families[name]; ok {
+		return mf.Metric
+	}
+	return nil
+}
+
+// label returns a metric's label value
+func label(m *dto.Metric, name string) string {
+	for _, pair := range m.Label {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
+
+// pollEvents tails categorized transactions from the event log, keeping the latest ones that
+// fell through to the fallback category. Tailing starts from the newest event rather than
+// replaying what the service still holds, and skips ahead to the oldest event held when the
+// dashboard falls too far behind.
+func (d *dashboard) pollEvents() error {
+	limit := 1000
+	if !d.tailing {
+		limit = 1
+	}
+	path := fmt.Sprintf("/admin/events?type=transaction.categorized&after_seq=%d&limit=%d", d.eventSeq, limit)
+	resp, err := d.request(path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		var gone struct {
+			OldestSeq int64 `json:"oldest_seq"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&gone); err != nil {
+			return fmt.Errorf("decode /admin/events: %w", err)
+		}
+		d.eventSeq = gone.OldestSeq - 1
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	var page struct {
+		Events []struct {
+			Data struct {
+				Transaction struct {
+					Merchant  string    `json:"merchant"`
+					Amount    float64   `json:"amount"`
+					Category  string    `json:"category"`
+					CreatedAt time.Time `json:"created_at"`
+				} `json:"transaction"`
+			} `json:"data"`
+		} `json:"events"`
+		NextSeq   int64 `json:"next_seq"`
+		LatestSeq int64 `json:"latest_seq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return fmt.Errorf("decode /admin/events: %w", err)
+	}
+	// Start from the newest event, and again if the service restarted with fewer events
+	if !d.tailing || page.LatestSeq < d.eventSeq {
+		d.eventSeq, d.tailing = page.LatestSeq, true
+		return nil
+	}
+	for _, event := range page.Events {
+		tx := event.Data.Transaction
+		if tx.Category == unclassifiedCategory {
+			d.unclassified = append(d.unclassified, unclassifiedMerchant{merchant: tx.Merchant, amount: tx.Amount, at: tx.CreatedAt})
+		}
+	}
+	if len(d.unclassified) > maxUnclassified {
+		d.unclassified = d.unclassified[len(d.unclassified)-maxUnclassified:]
+	}
+	if page.NextSeq > d.eventSeq {
+		d.eventSeq = page.NextSeq
+	}
+	return nil
+}
+
+// draw redraws the whole screen from a snapshot
+func (d *dashboard) draw(s snapshot) {
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	fmt.Fprintf(&b, "%scategorizer%s %s  %s%s%s\n", bold, reset, s.version, dim, d.baseURL, reset)
+	if !s.startedAt.IsZero() {
+		fmt.Fprintf(&b, "up %s", time.Since(s.startedAt).Round(time.Second))
+	}
+	fmt.Fprintf(&b, "   updated %s\n\n", time.Now().Format("15:04:05"))
+
+	b.WriteString(bold + "Rules" + reset + "\n")
+	fmt.Fprintf(&b, "  ruleset   %s\n", s.rulesetVersion)
+	if !s.rulesReloaded.IsZero() {
+		fmt.Fprintf(&b, "  reloaded  %s ago (%s)\n", time.Since(s.rulesReloaded).Round(time.Second), s.rulesReloaded.Format("15:04:05"))
+	}
+	b.WriteString("\n")
+
+	b.WriteString(bold + "Requests/s" + reset + "\n")
+	total := 0.0
+	for _, class := range sortedKeys(s.requestRates) {
+		total += s.requestRates[class]
+	}
+	fmt.Fprintf(&b, "  total %6.1f", total)
+	for _, class := range sortedKeys(s.requestRates) {
+		colour := green
+		switch {
+		case strings.HasPrefix(class, "5"):
+			colour = red
+		case strings.HasPrefix(class, "4"):
+			colour = yellow
+		}
+		fmt.Fprintf(&b, "   %s%s %.1f%s", colour, class, s.requestRates[class], reset)
+	}
+	b.WriteString("\n\n")
+
+	b.WriteString(bold + "Categories" + reset + "\n")
+	drawBars(&b, s.categories)
+	b.WriteString("\n")
+
+	b.WriteString(bold + "Recently unclassified" + reset + "\n")
+	if len(d.unclassified) == 0 {
+		b.WriteString(dim + "  none yet" + reset + "\n")
+	}
+	for i := len(d.unclassified) - 1; i >= 0; i-- {
+		u := d.unclassified[i]
+		fmt.Fprintf(&b, "  %s  %-32.32s %9.2f\n", u.at.Local().Format("15:04:05"), u.merchant, u.amount)
+	}
+
+	for _, err := range s.errors {
+		fmt.Fprintf(&b, "\n%s%s%s", red, err, reset)
+	}
+	b.WriteString("\n" + dim + "ctrl-c to quit" + reset + "\n")
+	fmt.Print(b.String())
+}
+
+// drawBars draws counts as horizontal bars, largest first
+func drawBars(b *strings.Builder, counts map[string]float64) {
+	const width = 40
+	names := sortedKeys(counts)
+	sort.SliceStable(names, func(i, j int) bool { return counts[names[i]] > counts[names[j]] })
+	total, largest := 0.0, 0.0
+	for _, n := range counts {
+		total += n
+		largest = max(largest, n)
+	}
+	if total == 0 {
+		b.WriteString(dim + "  no categorizations yet" + reset + "\n")
+		return
+	}
+	for _, name := range names {
+		bar := int(counts[name] / largest * width)
+		fmt.Fprintf(b, "  %-18.18s %s%s %6.0f %5.1f%%\n", name, strings.Repeat("█", bar), strings.Repeat(" ", width-bar), counts[name], counts[name]/total*100)
+	}
+}
+
+// sortedKeys returns a map's keys in order
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CategoryUncategorized is assigned when the rules' best category falls short of the tenant's
+// minimum confidence
+const CategoryUncategorized = "Uncategorized"
+
+// classificationConfidence is how sure the rules are of the category they decided: the top
+// candidate's share of the candidates' scores, discounted when it was only found by fuzzy
+// matching
+func classificationConfidence(cl *Classification) float64 {
+	if len(cl.Candidates) == 0 {
+		return 1
+	}
+	confidence := cl.Candidates[0].Score
+	if cl.Fuzzy {
+		confidence *= fuzzyCandidateWeight
+	}
+	return confidence
+}
+
+// maxAlternatives bounds the alternative categories in a response
+const maxAlternatives = 3
+
+// responseConfidence is how sure the pipeline is of the category it assigned, from 0 to 1.
+// Overrides, pinned merchants, policies and fixed rules such as credits are certain; the rules
+// and the fallback classifier are as sure as their scores, and feedback votes as their
+// majority; and the Other default, assigned
+// because nothing matched, has no confidence at all.
+func responseConfidence(cl Classification) float64 {
+	switch cl.DecidedBy {
+	case "rules":
+		if len(cl.Candidates) > 0 {
+			return math.Round(classificationConfidence(&cl)*1000) / 1000
+		}
+	case "ml", "ml_fallback":
+		if scorer, ok := fallbackClassifier.(FallbackScorer); ok {
+			for _, cs := range scorer.Scores(&cl) {
+				if cs.Category == cl.Category {
+					return cs.Score
+				}
+			}
+			return 0
+		}
+	case "feedback":
+		return cl.Feedback.Share()
+	case "post_process":
+		return 0
+	}
+	return 1
+}
+
+// alternativeCategories ranks the other categories the pipeline considered, best first: the
+// rules' candidates, or the fallback classifier's scores when it decided
+func alternativeCategories(cl Classification) []CategoryScore {
+	scores := cl.Candidates
+	if cl.DecidedBy == "ml" || cl.DecidedBy == "ml_fallback" {
+		scores = nil
+		if scorer, ok := fallbackClassifier.(FallbackScorer); ok {
+			scores = scorer.Scores(&cl)
+		}
+	}
+	var alternatives []CategoryScore
+	for _, cs := range scores {
+		if cs.Category != cl.Category {
+			alternatives = append(alternatives, cs)
+		}
+	}
+	sort.SliceStable(alternatives, func(i, j int) bool { return alternatives[i].Score > alternatives[j].Score })
+	if len(alternatives) > maxAlternatives {
+		alternatives = alternatives[:maxAlternatives]
+	}
+	return alternatives
+}
+
+// confidenceThresholdStore keeps each tenant's minimum confidence for auto-assigning a category.
+// Tenants without one accept whatever the rules decide.
+type confidenceThresholdStore struct {
+	mu         sync.RWMutex
+	thresholds map[string]float64
+}
+
+// newConfidenceThresholdStore creates an empty store
+func newConfidenceThresholdStore() *confidenceThresholdStore {
+	return &confidenceThresholdStore{thresholds: map[string]float64{}}
+}
+
+// Set replaces a tenant's threshold
+func (s *confidenceThresholdStore) Set(tenantID string, threshold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.thresholds[tenantID] = threshold
+}
+
+// Get returns a tenant's threshold
+func (s *confidenceThresholdStore) Get(tenantID string) (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	threshold, ok := s.thresholds[tenantID]
+	return threshold, ok
+}
+
+// Delete removes a tenant's threshold, reporting whether it had one
+func (s *confidenceThresholdStore) Delete(tenantID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.thresholds[tenantID]; !ok {
+		return false
+	}
+	delete(s.thresholds, tenantID)
+	return true
+}
+
+// Snapshot returns a copy of every tenant's threshold
+func (s *confidenceThresholdStore) Snapshot() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string]float64, len(s.thresholds))
+	for tenantID, threshold := range s.thresholds {
+		snapshot[tenantID] = threshold
+	}
+	return snapshot
+}
+
+// Restore replaces every tenant's threshold
+func (s *confidenceThresholdStore) Restore(snapshot map[string]float64) {
+	thresholds := make(map[string]float64, len(snapshot))
+	for tenantID, threshold := range snapshot {
+		thresholds[tenantID] = threshold
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.thresholds = thresholds
+}
+
+// Global tenant confidence thresholds
+var confidenceThresholds = newConfidenceThresholdStore()
+
+// belowTenantConfidence reports whether a rules decision is less certain than its tenant requires
+func belowTenantConfidence(cl *Classification) bool {
+	if cl.TenantID == "" {
+		return false
+	}
+	threshold, ok := confidenceThresholds.Get(cl.TenantID)
+	return ok && classificationConfidence(cl) < threshold
+}
+
+// mlBelowTenantConfidence reports whether the fallback classifier is less sure of category
+// than the transaction's tenant requires, on top of the classifier's own ML_MIN_CONFIDENCE.
+// A classifier that doesn't score its categories can't show it meets a threshold.
+func mlBelowTenantConfidence(cl *Classification, category string) bool {
+	if cl.TenantID == "" {
+		return false
+	}
+	threshold, ok := confidenceThresholds.Get(cl.TenantID)
+	if !ok {
+		return false
+	}
+	scorer, ok := fallbackClassifier.(FallbackScorer)
+	if !ok {
+		return threshold > 0
+	}
+	for _, cs := range scorer.Scores(cl) {
+		if cs.Category == category {
+			return cs.Score < threshold
+		}
+	}
+	return true
+}
+
+// handlePutConfidenceThreshold serves PUT /tenants/:tenant_id/confidence-threshold
+func (s *Server) handlePutConfidenceThreshold(c *gin.Context) {
+	var body struct {
+		MinConfidence *float64 `json:"min_confidence" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *body.MinConfidence < 0 || *body.MinConfidence > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_confidence must be between 0 and 1"})
+		return
+	}
+	tenantID := c.Param("tenant_id")
+	confidenceThresholds.Set(tenantID, *body.MinConfidence)
+	c.JSON(http.StatusOK, gin.H{"tenant_id": tenantID, "min_confidence": *body.MinConfidence})
+}
+
+// handleGetConfidenceThreshold serves GET /tenants/:tenant_id/confidence-threshold
+func (s *Server) handleGetConfidenceThreshold(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	threshold, ok := confidenceThresholds.Get(tenantID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "confidence threshold not set"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant_id": tenantID, "min_confidence": threshold})
+}
+
+// handleDeleteConfidenceThreshold serves DELETE /tenants/:tenant_id/confidence-threshold
+func (s *Server) handleDeleteConfidenceThreshold(c *gin.Context) {
+	if !confidenceThresholds.Delete(c.Param("tenant_id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "confidence threshold not set"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds service settings read from the environment
+type Config struct {
+	AccountingCodesFile string
+	FuzzyMaxDistance    int
+	FuzzyMinLength      int
+	NoisePatternsFile   string
+	PipelineStages      []string
+	DedupeWindow        time.Duration
+	WebhookURLs         []string
+	RiskAlerts          bool
+	RoundUpMultiplier   float64
+	RoundUpPotID        string
+	MonzoAPIURL         string
+	MonzoAccessToken    string
+	MonzoAccountID      string
+	MonzoAuthURL        string
+	MonzoClientID       string
+	MonzoClientSecret   string
+	MonzoRedirectURL    string
+	MonzoWebhookURL     string
+	MonzoTokenFile      string
+	MonzoTokenRefresh   time.Duration
+	MonzoReauthInterval time.Duration
+	MonzoWebhookCheck   time.Duration
+	MonzoWriteback      bool
+	MonzoWritebackKey   string
+	MonzoWritebackEvery time.Duration
+	MonzoWritebackBatch int
+	MonzoReceipts       bool
+	MonzoRateLimit      float64
+	MonzoRateBurst      int
+	MonzoSyncInterval   time.Duration
+	BankProviders       []string
+	Environment         string
+	RulesFile           string
+	StickyConfirmations int
+	StickyGlobal        int
+	FeedbackMinVotes    int
+	Classifier          string
+	MLMinConfidence     float64
+	MLTrainInterval     time.Duration
+	IncomeRulesFile     string
+	IncomeRegularity    int
+	CarbonFactorsFile   string
+	CashbackOffersFile  string
+	TaxonomyFile        string
+	HomeCountry         string
+	AnalyticsMinCohort  int
+	AggregationInterval time.Duration
+	AggregationClip     float64
+	DPEpsilon           float64
+	DigestProvider      string
+	DigestFrom          string
+	DigestTemplatesDir  string
+	DigestCheckInterval time.Duration
+	GRPCAddr            string
+	SMTPAddr            string
+	SMTPUsername        string
+	SMTPPassword        string
+	BackupDir           string
+	Region              string
+	PeerURLs            []string
+	ReplicationInterval time.Duration
+	ReplicationToken    string
+	SummaryCacheTTL     time.Duration
+	ReadReplica         bool
+	ReplicaSyncInterval time.Duration
+	RollupInterval      time.Duration
+	TSDBExporter        string
+	TSDBURL             string
+	TSDBToken           string
+	TranslationProvider string
+	TranslationURL      string
+	TranslationAPIKey   string
+	TranslationTimeout  time.Duration
+	TenantMetrics       []string
+	MetricsExporter     string
+	StatsdAddr          string
+	StatsdPrefix        string
+	StatsdFlushInterval time.Duration
+	ReadinessInterval   time.Duration
+	HealthHistorySize   int
+	ErrorBufferSize     int
+	StartupWaitTimeout  time.Duration
+	APIKeys             []string
+	AdminAPIKeys        []string
+	InsecureNoAuth      bool
+	TrustedProxies      []string
+	RateLimitRPS        float64
+	RateLimitBurst      int
+	MaxBodyBytes        int
+	CORSOrigins         []string
+	SignConvention      string
+	RuleScheduleCheck   time.Duration
+	RulesetRetention    time.Duration
+	EventLogFile        string
+	EventLogSize        int
+	AdminCallLogFile    string
+}
+
+// loadConfig reads the service configuration from environment variables
+func loadConfig() Config {
+	environment := getEnv("ENVIRONMENT", EnvironmentProduction)
+	monzo := monzoEnvironments[environment]
+	return Config{
+		AccountingCodesFile: os.Getenv("ACCOUNTING_CODES_FILE"),
+		FuzzyMaxDistance:    getEnvInt("FUZZY_MATCH_MAX_DISTANCE", 0),
+		FuzzyMinLength:      getEnvInt("FUZZY_MATCH_MIN_LENGTH", 5),
+		NoisePatternsFile:   os.Getenv("NOISE_PATTERNS_FILE"),
+		PipelineStages:      getEnvList("PIPELINE_STAGES", defaultPipelineStages),
+		DedupeWindow:        getEnvDuration("DEDUPE_WINDOW", 2*time.Minute),
+		WebhookURLs:         getEnvList("WEBHOOK_URLS", nil),
+		RiskAlerts:          getEnvBool("RISK_ALERTS_ENABLED", false),
+		RoundUpMultiplier:   getEnvFloat("ROUNDUP_MULTIPLIER", 1),
+		RoundUpPotID:        os.Getenv("ROUNDUP_POT_ID"),
+		MonzoAPIURL:         getEnv("MONZO_API_URL", monzo.apiURL),
+		MonzoAccessToken:    os.Getenv("MONZO_ACCESS_TOKEN"),
+		MonzoAccountID:      os.Getenv("MONZO_ACCOUNT_ID"),
+		MonzoAuthURL:        getEnv("MONZO_AUTH_URL", monzo.authURL),
+		MonzoClientID:       os.Getenv("MONZO_CLIENT_ID"),
+		MonzoClientSecret:   os.Getenv("MONZO_CLIENT_SECRET"),
+		MonzoRedirectURL:    os.Getenv("MONZO_REDIRECT_URL"),
+		MonzoWebhookURL:     os.Getenv("MONZO_WEBHOOK_URL"),
+		MonzoTokenFile:      os.Getenv("MONZO_TOKEN_FILE"),
+		MonzoTokenRefresh:   getEnvDuration("MONZO_TOKEN_REFRESH_INTERVAL", time.Minute),
+		MonzoReauthInterval: getEnvDuration("MONZO_REAUTH_INTERVAL", 90*24*time.Hour),
+		MonzoWebhookCheck:   getEnvDuration("MONZO_WEBHOOK_RECONCILE_INTERVAL", 10*time.Minute),
+		MonzoWriteback:      getEnvBool("MONZO_WRITEBACK_ENABLED", false),
+		MonzoWritebackKey:   getEnv("MONZO_WRITEBACK_KEY", "category"),
+		MonzoWritebackEvery: getEnvDuration("MONZO_WRITEBACK_INTERVAL", 5*time.Second),
+		MonzoWritebackBatch: getEnvInt("MONZO_WRITEBACK_BATCH", 20),
+		MonzoReceipts:       getEnvBool("MONZO_RECEIPTS_ENABLED", false),
+		MonzoRateLimit:      getEnvFloat("MONZO_RATE_LIMIT_RPS", 5),
+		MonzoRateBurst:      getEnvInt("MONZO_RATE_LIMIT_BURST", 10),
+		MonzoSyncInterval:   getEnvDuration("MONZO_SYNC_INTERVAL", 15*time.Minute),
+		BankProviders:       getEnvList("BANK_PROVIDERS", []string{"monzo"}),
+		Environment:         environment,
+		RulesFile:           os.Getenv("RULES_FILE"),
+		StickyConfirmations: getEnvInt("STICKY_CONFIRMATIONS", 3),
+		StickyGlobal:        getEnvInt("STICKY_GLOBAL_CONFIRMATIONS", 25),
+		FeedbackMinVotes:    getEnvInt("FEEDBACK_MIN_VOTES", 3),
+		Classifier:          getEnv("CLASSIFIER", "keyword"),
+		MLMinConfidence:     getEnvFloat("ML_MIN_CONFIDENCE", 0.6),
+		MLTrainInterval:     getEnvDuration("ML_TRAIN_INTERVAL", 15*time.Minute),
+		IncomeRulesFile:     os.Getenv("INCOME_RULES_FILE"),
+		IncomeRegularity:    getEnvInt("INCOME_REGULAR_PAYMENTS", 2),
+		CarbonFactorsFile:   os.Getenv("CARBON_FACTORS_FILE"),
+		CashbackOffersFile:  os.Getenv("CASHBACK_OFFERS_FILE"),
+		TaxonomyFile:        os.Getenv("TAXONOMY_MAPPINGS_FILE"),
+		HomeCountry:         getEnv("HOME_COUNTRY", "GB"),
+		AnalyticsMinCohort:  getEnvInt("ANALYTICS_MIN_COHORT", 5),
+		AggregationInterval: getEnvDuration("AGGREGATION_INTERVAL", time.Hour),
+		AggregationClip:     getEnvFloat("AGGREGATION_CLIP", 2000),
+		DPEpsilon:           getEnvFloat("DP_EPSILON", 1),
+		DigestProvider:      getEnv("DIGEST_PROVIDER", "log"),
+		DigestFrom:          os.Getenv("DIGEST_FROM"),
+		DigestTemplatesDir:  os.Getenv("DIGEST_TEMPLATES_DIR"),
+		DigestCheckInterval: getEnvDuration("DIGEST_CHECK_INTERVAL", time.Hour),
+		GRPCAddr:            os.Getenv("GRPC_ADDR"),
+		SMTPAddr:            os.Getenv("SMTP_ADDR"),
+		SMTPUsername:        os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:        os.Getenv("SMTP_PASSWORD"),
+		BackupDir:           os.Getenv("BACKUP_DIR"),
+		Region:              getEnv("REGION", "local"),
+		PeerURLs:            getEnvList("PEER_URLS", nil),
+		ReplicationInterval: getEnvDuration("REPLICATION_INTERVAL", 5*time.Second),
+		ReplicationToken:    os.Getenv("REPLICATION_TOKEN"),
+		SummaryCacheTTL:     getEnvDuration("SUMMARY_CACHE_TTL", 30*time.Second),
+		ReadReplica:         getEnvBool("STORE_READ_REPLICA", false),
+		ReplicaSyncInterval: getEnvDuration("STORE_REPLICA_SYNC_INTERVAL", time.Second),
+		RollupInterval:      getEnvDuration("ROLLUP_INTERVAL", time.Minute),
+		TSDBExporter:        os.Getenv("TSDB_EXPORTER"),
+		TSDBURL:             os.Getenv("TSDB_URL"),
+		TSDBToken:           os.Getenv("TSDB_TOKEN"),
+		TranslationProvider: os.Getenv("TRANSLATION_PROVIDER"),
+		TranslationURL:      os.Getenv("TRANSLATION_URL"),
+		TranslationAPIKey:   os.Getenv("TRANSLATION_API_KEY"),
+		TranslationTimeout:  getEnvDuration("TRANSLATION_TIMEOUT", 2*time.Second),
+		TenantMetrics:       getEnvList("METRICS_TENANT_ALLOWLIST", nil),
+		MetricsExporter:     getEnv("METRICS_EXPORTER", "prometheus"),
+		StatsdAddr:          getEnv("STATSD_ADDR", "127.0.0.1:8125"),
+		StatsdPrefix:        getEnv("STATSD_PREFIX", "categorizer."),
+		StatsdFlushInterval: getEnvDuration("STATSD_FLUSH_INTERVAL", 10*time.Second),
+		ReadinessInterval:   getEnvDuration("READINESS_INTERVAL", 15*time.Second),
+		HealthHistorySize:   getEnvInt("HEALTH_HISTORY_SIZE", 100),
+		ErrorBufferSize:     getEnvInt("ERROR_BUFFER_SIZE", 100),
+		StartupWaitTimeout:  getEnvDuration("STARTUP_WAIT_TIMEOUT", time.Minute),
+		APIKeys:             getEnvList("API_KEYS", nil),
+		AdminAPIKeys:        getEnvList("ADMIN_API_KEYS", nil),
+		InsecureNoAuth:      getEnvBool("INSECURE_NO_AUTH", false),
+		TrustedProxies:      getEnvList("TRUSTED_PROXIES", nil),
+		RateLimitRPS:        getEnvFloat("RATE_LIMIT_RPS", 50),
+		RateLimitBurst:      getEnvInt("RATE_LIMIT_BURST", 100),
+		MaxBodyBytes:        getEnvInt("MAX_BODY_BYTES", 1<<20),
+		CORSOrigins:         getEnvList("CORS_ALLOWED_ORIGINS", nil),
+		SignConvention:      getEnv("AMOUNT_SIGN_CONVENTION", SignTyped),
+		RuleScheduleCheck:   getEnvDuration("RULE_SCHEDULE_INTERVAL", 30*time.Second),
+		RulesetRetention:    getEnvDuration("RULESET_RETENTION", 90*24*time.Hour),
+		EventLogFile:        os.Getenv("EVENT_LOG_FILE"),
+		EventLogSize:        getEnvInt("EVENT_LOG_SIZE", 10000),
+		AdminCallLogFile:    os.Getenv("ADMIN_CALL_LOG_FILE"),
+	}
+}
+
+// getEnv returns an environment variable, or fallback when unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt returns an integer environment variable, or fallback when unset or invalid
+func getEnvInt(key string, fallback int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		invalidEnv = append(invalidEnv, key+"="+raw)
+		return fallback
+	}
+	return value
+}
+
+// getEnvFloat returns a decimal environment variable, or fallback when unset or invalid
+func getEnvFloat(key string, fallback float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		invalidEnv = append(invalidEnv, key+"="+raw)
+		return fallback
+	}
+	return value
+}
+
+// getEnvBool returns a boolean environment variable, or fallback when unset or invalid
+func getEnvBool(key string, fallback bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		invalidEnv = append(invalidEnv, key+"="+raw)
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration returns a duration environment variable such as "90s", or fallback when unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		invalidEnv = append(invalidEnv, key+"="+raw)
+		return fallback
+	}
+	return value
+}
+
+// getEnvList returns a comma-separated environment variable as a list, or fallback when unset
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return strings.Split(value, ",")
+}
+
+// invalidEnv lists environment variables that were set but couldn't be parsed, and so were
+// replaced by their defaults
+var invalidEnv []string
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rule conflict types
+const (
+	ConflictShadowedKeyword = "shadowed_keyword"
+	ConflictUnreachableRule = "unreachable_rule"
+	ConflictAmbiguousSample = "ambiguous_sample"
+)
+
+// RuleConflict describes a keyword or rule that can never fire, or a sample descriptor
+// matched by rules for more than one category
+type RuleConflict struct {
+	Type       string   `json:"type"`
+	Rule       string   `json:"rule,omitempty"`
+	Keyword    string   `json:"keyword,omitempty"`
+	ShadowedBy string   `json:"shadowed_by,omitempty"`
+	Sample     string   `json:"sample,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Message    string   `json:"message"`
+}
+
+// covers reports whether rule a applies to every transaction rule b applies to, ignoring keywords
+func (a Rule) covers(b Rule) bool {
+	if a.MinAmount > b.MinAmount {
+		return false
+	}
+	if a.MaxAmount > 0 && (b.MaxAmount <= 0 || a.MaxAmount < b.MaxAmount) {
+		return false
+	}
+	if len(a.Fields) == 0 {
+		return true
+	}
+	if len(b.Fields) == 0 {
+		return a.matchesField(FieldMerchant) && a.matchesField(FieldDescription)
+	}
+	for _, field := range b.Fields {
+		if !a.matchesField(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// findShadowedKeywords reports keywords that always lose to an earlier rule: any text
+// containing them also contains a keyword of a rule evaluated first
+func findShadowedKeywords(rs *RuleSet) []RuleConflict {
+	conflicts := []RuleConflict{}
+	for j, later := range rs.Rules {
+		shadowed := 0
+		for _, keyword := range later.Keywords {
+			for i := 0; i < j; i++ {
+				earlier := rs.Rules[i]
+				if !earlier.covers(later) {
+					continue
+				}
+				blocker := ""
+				for _, k := range earlier.Keywords {
+					if k != "" && strings.Contains(keyword, k) {
+						blocker = k
+						break
+					}
+				}
+				if blocker == "" {
+					continue
+				}
+				shadowed++
+				conflicts = append(conflicts, RuleConflict{
+					Type:       ConflictShadowedKeyword,
+					Rule:       later.Name,
+					Keyword:    keyword,
+					ShadowedBy: earlier.Name,
+					Message:    fmt.Sprintf("keyword %q in rule %q can never fire: rule %q matches %q first", keyword, later.Name, earlier.Name, blocker),
+				})
+				break
+			}
+		}
+		if len(later.Keywords) > 0 && shadowed == len(later.Keywords) && len(later.Patterns) == 0 {
+			conflicts = append(conflicts, RuleConflict{
+				Type:    ConflictUnreachableRule,
+				Rule:    later.Name,
+				Message: fmt.Sprintf("rule %q can never fire: every keyword is shadowed by an earlier rule", later.Name),
+			})
+		}
+	}
+	return conflicts
+}
+
+// ruleSampleDescriptors returns representative merchant/description pairs used to probe rules
+func ruleSampleDescriptors() [][2]string {
+	samples := make([][2]string, 0, len(syntheticMerchants))
+	for _, m := range syntheticMerchants {
+		samples = append(samples, [2]string{m.merchant, m.description})
+	}
+	return samples
+}
+
+// findAmbiguousSamples reports sample descriptors that rules for several categories match,
+// where rule order alone decides the outcome
+func findAmbiguousSamples(rs *RuleSet, samples [][2]string) []RuleConflict {
+	conflicts := []RuleConflict{}
+	for _, sample := range samples {
+		merchantLower := strings.ToLower(sample[0])
+		descriptionLower := strings.ToLower(sample[1])
+
+		categories := []string{}
+		seen := map[string]bool{}
+		winner := ""
+		for _, rule := range rs.Rules {
+			amount := rule.MinAmount + 1
+			if rule.MaxAmount > 0 {
+				amount = min(amount, rule.MaxAmount)
+			}
+			if _, ok := rule.Match(merchantLower, descriptionLower, amount); !ok {
+				continue
+			}
+			if winner == "" {
+				winner = rule.Name
+			}
+			if !seen[rule.Category] {
+				seen[rule.Category] = true
+				categories = append(categories, rule.Category)
+			}
+		}
+		if len(categories) < 2 {
+			continue
+		}
+
+		descriptor := strings.TrimSpace(sample[0] + " " + sample[1])
+		conflicts = append(conflicts, RuleConflict{
+			Type:       ConflictAmbiguousSample,
+			Sample:     descriptor,
+			Categories: categories,
+			Rule:       winner,
+			Message:    fmt.Sprintf("%q matches rules for %s; rule %q wins by order", descriptor, strings.Join(categories, ", "), winner),
+		})
+	}
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].Sample < conflicts[j].Sample
+	})
+	return conflicts
+}
+
+// detectRuleConflicts runs every conflict check against a rule set
+func detectRuleConflicts(rs *RuleSet) []RuleConflict {
+	conflicts := findShadowedKeywords(rs)
+	return append(conflicts, findAmbiguousSamples(rs, ruleSampleDescriptors())...)
+}
+
+// logRuleConflicts warns about every conflict found in a freshly loaded rule set
+func (s *Server) logRuleConflicts(rs *RuleSet) {
+	for _, conflict := range detectRuleConflicts(rs) {
+		s.logger.Warn(conflict.Message, map[string]interface{}{
+			"error_type": conflict.Type,
+			"event_type": "rule_conflict",
+		})
+	}
+}
+
+func (s *Server) handleRuleConflicts(c *gin.Context) {
+	current := s.activeRules()
+	conflicts := detectRuleConflicts(current)
+	c.JSON(http.StatusOK, gin.H{
+		"ruleset_version": current.Version,
+		"count":           len(conflicts),
+		"conflicts":       conflicts,
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Decline reason categories
+const (
+	DeclineInsufficientFunds = "insufficient_funds"
+	DeclineCardBlocked       = "card_blocked"
+	DeclineSuspectedFraud    = "suspected_fraud"
+	DeclineAuthentication    = "authentication_failed"
+	DeclineLimitExceeded     = "limit_exceeded"
+	DeclineMerchantBlocked   = "merchant_blocked"
+	DeclineOther             = "other"
+)
+
+// declineReasonKeywords maps fragments of raw decline reasons to decline categories, checked in order
+var declineReasonKeywords = []struct {
+	keyword string
+	reason  string
+}{
+	{"insufficient", DeclineInsufficientFunds},
+	{"funds", DeclineInsufficientFunds},
+	{"fraud", DeclineSuspectedFraud},
+	{"suspicious", DeclineSuspectedFraud},
+	{"blocked_merchant", DeclineMerchantBlocked},
+	{"merchant", DeclineMerchantBlocked},
+	{"blocked", DeclineCardBlocked},
+	{"inactive", DeclineCardBlocked},
+	{"frozen", DeclineCardBlocked},
+	{"expired", DeclineCardBlocked},
+	{"pin", DeclineAuthentication},
+	{"cvc", DeclineAuthentication},
+	{"cvv", DeclineAuthentication},
+	{"3ds", DeclineAuthentication},
+	{"authentication", DeclineAuthentication},
+	{"limit", DeclineLimitExceeded},
+}
+
+// categorizeDeclineReason maps a raw decline reason such as "INSUFFICIENT_FUNDS" to a decline category
+func categorizeDeclineReason(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	lower := strings.ToLower(raw)
+	for _, k := range declineReasonKeywords {
+		if strings.Contains(lower, k.keyword) {
+			return k.reason
+		}
+	}
+	return DeclineOther
+}
+
+// DeclineCount is the number of declines for one merchant, category or reason
+type DeclineCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// declineTracker counts declined transactions per merchant, category and reason
+type declineTracker struct {
+	mu         sync.Mutex
+	total      int
+	byMerchant map[string]int
+	byCategory map[string]int
+	byReason   map[string]int
+}
+
+// newDeclineTracker creates an empty decline tracker
+func newDeclineTracker() *declineTracker {
+	return &declineTracker{
+		byMerchant: map[string]int{},
+		byCategory: map[string]int{},
+		byReason:   map[string]int{},
+	}
+}
+
+// Record counts a declined transaction, in m as well
+func (t *declineTracker) Record(m *Metrics, merchant, category, reason string) {
+	if reason == "" {
+		reason = DeclineOther
+	}
+	m.recordDecline(category, reason)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total++
+	t.byMerchant[resolveMerchant(normalizeDescriptor(merchant))]++
+	t.byCategory[category]++
+	t.byReason[reason]++
+}
+
+// rankCounts sorts counts descending and keeps the first limit entries
+func rankCounts(counts map[string]int, limit int) []DeclineCount {
+	ranked := make([]DeclineCount, 0, len(counts))
+	for name, count := range counts {
+		ranked = append(ranked, DeclineCount{Name: name, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// Snapshot returns decline totals with the top merchants
+func (t *declineTracker) Snapshot(merchantLimit int) gin.H {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return gin.H{
+		"total":      t.total,
+		"merchants":  rankCounts(t.byMerchant, merchantLimit),
+		"categories": rankCounts(t.byCategory, 0),
+		"reasons":    rankCounts(t.byReason, 0),
+	}
+}
+
+// Global decline counters
+var declines = newDeclineTracker()
+
+func (s *Server) handleDeclineStats(c *gin.Context) {
+	c.JSON(http.StatusOK, declines.Snapshot(50))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// demoStep is one scripted event of the demo: a transaction posted to the service, and
+// optionally a receipt itemizing it
+type demoStep struct {
+	merchant, description string
+	amount                float64
+	transactionType       string
+	status                string
+	declineReason         string
+	// settles is the index of an earlier pending step this step settles
+	settles int
+	receipt []ReceiptItem
+}
+
+// demoScript is the stream of events the demo replays, cycling through each demo user
+var demoScript = []demoStep{
+	{merchant: "Pret A Manger", description: "Lunch", amount: 6.45, transactionType: "debit", status: StatusPending, settles: -1},
+	{merchant: "Pret A Manger", description: "Lunch", amount: 6.45, transactionType: "debit", status: StatusSettled, settles: 0},
+	{merchant: "Tesco Stores", amount: 23.60, transactionType: "debit", status: StatusSettled, settles: -1, receipt: []ReceiptItem{
+		{Description: "Bread", Quantity: 1, Amount: 1.40},
+		{Description: "Red wine", Quantity: 2, Amount: 16.00},
+		{Description: "Shampoo", Quantity: 1, Amount: 6.20},
+	}},
+	{merchant: "Uber", description: "Trip", amount: 14.27, transactionType: "debit", status: StatusSettled, settles: -1},
+	{merchant: "Betfair", amount: 20, transactionType: "debit", status: StatusDeclined, declineReason: "INSUFFICIENT_FUNDS", settles: -1},
+	{merchant: "Netflix", description: "Subscription", amount: 10.99, transactionType: "debit", status: StatusSettled, settles: -1},
+	{merchant: "Employer Ltd", description: "Salary", amount: 2450, transactionType: "credit", status: StatusSettled, settles: -1},
+}
+
+// demoMode holds the demo's fake Monzo and the users connected to it
+type demoMode struct {
+	srv      *Server
+	monzo    *demoMonzo
+	users    []string
+	interval time.Duration
+	baseURL  string
+}
+
+// startDemo prepares the service to run as a self-contained demo: the Monzo API is replaced
+// by an in-process fake, file-backed stores are switched off so nothing outside the process
+// is touched, and demo users are connected with generated history to sync. Once the server
+// is up, a scripted stream of transactions is posted to it every interval.
+func (s *Server) startDemo(args []string) error {
+	fs := flag.NewFlagSet("--demo", flag.ContinueOnError)
+	users := fs.Int("users", 3, "number of demo users")
+	perUser := fs.Int("transactions", 60, "generated transactions per user")
+	days := fs.Int("days", 90, "days of generated history")
+	interval := fs.Duration("interval", 5*time.Second, "time between scripted events")
+	seed := fs.Int64("seed", 1, "seed for the generated history")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *users < 1 || *users > maxSeedUsers {
+		return fmt.Errorf("-users must be between 1 and %d", maxSeedUsers)
+	}
+	if *perUser < 0 || *perUser > maxSeedTransactionsPerUser {
+		return fmt.Errorf("-transactions must be between 0 and %d", maxSeedTransactionsPerUser)
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	fake := newDemoMonzo(s.logger)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	go http.Serve(listener, fake)
+	fakeURL := "http://" + listener.Addr().String()
+
+	s.config.MonzoAPIURL, s.config.MonzoAuthURL = fakeURL, fakeURL
+	s.config.MonzoClientID, s.config.MonzoClientSecret = "demo", "demo"
+	s.config.MonzoRedirectURL = "http://localhost:9000/connect/monzo/callback"
+	s.config.MonzoWebhookURL = ""
+	s.config.MonzoWriteback, s.config.MonzoReceipts = true, true
+	s.config.MonzoAccessToken, s.config.MonzoAccountID = "demo", "acc_demo_1"
+	s.config.RoundUpPotID = "pot_demo"
+	s.config.MonzoTokenFile, s.config.EventLogFile, s.config.AdminCallLogFile = "", "", ""
+	s.config.APIKeys, s.config.AdminAPIKeys, s.config.InsecureNoAuth = nil, nil, true
+	monzoTokens = newMonzoTokenManager(s.config, s.metrics, s.logger)
+
+	demo := &demoMode{srv: s, monzo: fake, interval: *interval, baseURL: "http://localhost:9000"}
+	rng := rand.New(rand.NewSource(*seed))
+	now := time.Now().UTC()
+	for i := 1; i <= *users; i++ {
+		userID := fmt.Sprintf("demo-user-%d", i)
+		accountID := fmt.Sprintf("acc_demo_%d", i)
+		fake.AddAccount(accountID, userID)
+
+		history := s.generateSyntheticHistory(rng, userID, *perUser, *days, now.Truncate(24*time.Hour))
+		sort.Slice(history, func(a, b int) bool { return history[a].CreatedAt.Before(history[b].CreatedAt) })
+		for n, tx := range history {
+			amount := toPence(tx.Amount)
+			if tx.TransactionType != "credit" {
+				amount = -amount
+			}
+			fake.AddTransaction(accountID, MonzoTransaction{
+				ID:          fmt.Sprintf("tx_demo_%d_%04d", i, n),
+				Created:     tx.CreatedAt,
+				Description: tx.Merchant,
+				Amount:      amount,
+				Currency:    "GBP",
+				Merchant:    &MonzoMerchant{Name: tx.Merchant},
+				Settled:     tx.CreatedAt.Format(time.RFC3339),
+			})
+		}
+
+		monzoTokens.Put(MonzoConnection{
+			ID:              fmt.Sprintf("conn_demo_%d", i),
+			UserID:          userID,
+			MonzoUserID:     "user_demo",
+			AccountID:       accountID,
+			AccountType:     defaultAccountType,
+			AccessToken:     "demo",
+			RefreshToken:    "demo",
+			ExpiresAt:       now.Add(6 * time.Hour),
+			AuthenticatedAt: now,
+		})
+		demo.users = append(demo.users, userID)
+	}
+
+	s.logger.Info(fmt.Sprintf("Demo mode: %d users connected to a fake Monzo at %s; scripted events every %s",
+		*users, fakeURL, *interval), map[string]interface{}{
+		"event_type": "demo_started",
+	})
+	go demo.run()
+	return nil
+}
+
+// run waits for the server, backfills every demo connection and then replays the script
+func (d *demoMode) run() {
+	for {
+		resp, err := http.Get(d.baseURL + "/health")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	for _, conn := range monzoTokens.List("") {
+		d.srv.startMonzoSync(conn, MonzoSyncRequest{Full: true}, "demo")
+	}
+
+	pending := map[int]string{}
+	for n := 0; ; n++ {
+		time.Sleep(d.interval)
+		step := demoScript[n%len(demoScript)]
+		userID := d.users[(n/len(demoScript))%len(d.users)]
+		accountID := "acc_demo_" + userID[len("demo-user-"):]
+
+		transactionID := fmt.Sprintf("tx_live_%d", n)
+		if step.settles >= 0 {
+			transactionID = pending[step.settles]
+		}
+		if step.status == StatusPending {
+			pending[n%len(demoScript)] = transactionID
+		}
+		if err := d.post(userID, accountID, transactionID, step); err != nil {
+			d.srv.logger.Warn(fmt.Sprintf("Demo event %d failed", n), map[string]interface{}{
+				"event_type":    "demo_event_failed",
+				"error_message": err.Error(),
+			})
+		}
+	}
+}
+
+// post sends one scripted step to the service as the bank would, and adds it to the fake
+// Monzo feed so syncs see it too
+func (d *demoMode) post(userID, accountID, transactionID string, step demoStep) error {
+	now := time.Now().UTC()
+	if step.status != StatusPending {
+		amount := toPence(step.amount)
+		if step.transactionType != "credit" {
+			amount = -amount
+		}
+		mt := MonzoTransaction{ID: transactionID, Created: now, Description: step.merchant, Amount: amount, Currency: "GBP",
+			Merchant: &MonzoMerchant{Name: step.merchant}, Settled: now.Format(time.RFC3339)}
+		if step.status == StatusDeclined {
+			mt.Settled, mt.DeclineReason = "", step.declineReason
+		}
+		d.monzo.AddTransaction(accountID, mt)
+	}
+
+	req := TransactionRequest{
+		Merchant:        step.merchant,
+		Description:     step.description,
+		Amount:          step.amount,
+		TransactionType: step.transactionType,
+		UserID:          userID,
+		CreatedAt:       now,
+		TransactionID:   transactionID,
+		Status:          step.status,
+		DeclineReason:   step.declineReason,
+	}
+	if err := d.send(http.MethodPost, "/categorize", req); err != nil {
+		return err
+	}
+	if len(step.receipt) > 0 {
+		return d.send(http.MethodPut, "/users/"+userID+"/transactions/"+transactionID+"/receipt", gin.H{"items": step.receipt})
+	}
+	return nil
+}
+
+// send makes a JSON request to the running service
+func (d *demoMode) send(method, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, d.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// demoMonzo is an in-memory stand-in for the Monzo API, serving just the endpoints the
+// service calls so the demo runs without a Monzo account. It accepts any access token.
+type demoMonzo struct {
+	mu           sync.Mutex
+	accounts     map[string]string // account ID to owning user ID
+	transactions map[string][]MonzoTransaction
+	webhooks     map[string]MonzoWebhook
+	receipts     map[string]monzoReceipt
+	pots         map[string]int64
+	logger       *StructuredLogger
+}
+
+// newDemoMonzo creates an empty fake Monzo that logs the writes it receives to logger
+func newDemoMonzo(logger *StructuredLogger) *demoMonzo {
+	return &demoMonzo{
+		logger:       logger,
+		accounts:     map[string]string{},
+		transactions: map[string][]MonzoTransaction{},
+		webhooks:     map[string]MonzoWebhook{},
+		receipts:     map[string]monzoReceipt{},
+		pots:         map[string]int64{},
+	}
+}
+
+// AddAccount opens an account for a user
+func (d *demoMonzo) AddAccount(accountID, userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.accounts[accountID] = userID
+}
+
+// AddTransaction appends a transaction to an account's feed
+func (d *demoMonzo) AddTransaction(accountID string, mt MonzoTransaction) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.transactions[accountID] = append(d.transactions[accountID], mt)
+}
+
+// reply writes a JSON response
+func (d *demoMonzo) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (d *demoMonzo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		d.reply(w, http.StatusBadRequest, map[string]string{"code": "bad_request", "message": err.Error()})
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case path == "/oauth2/token":
+		d.reply(w, http.StatusOK, monzoTokenResponse{AccessToken: newID("demo_access_"), RefreshToken: newID("demo_refresh_"), ExpiresIn: 6 * 60 * 60, UserID: "user_demo"})
+	case path == "/oauth2/logout":
+		d.reply(w, http.StatusOK, map[string]string{})
+	case path == "/ping/whoami":
+		d.reply(w, http.StatusOK, map[string]interface{}{"authenticated": true, "user_id": "user_demo"})
+	case path == "/accounts":
+		accounts := []MonzoAccount{}
+		for id := range d.accounts {
+			accounts = append(accounts, MonzoAccount{ID: id, Description: "Demo account", Type: defaultAccountType})
+		}
+		d.reply(w, http.StatusOK, map[string]interface{}{"accounts": accounts})
+	case path == "/transactions" && r.Method == http.MethodGet:
+		d.reply(w, http.StatusOK, map[string]interface{}{"transactions": d.listTransactions(r)})
+	case strings.HasPrefix(path, "/transactions/") && r.Method == http.MethodPatch:
+		d.annotate(w, r, strings.TrimPrefix(path, "/transactions/"))
+	case path == "/transaction-receipts" && r.Method == http.MethodPut:
+		var receipt monzoReceipt
+		if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+			d.reply(w, http.StatusBadRequest, map[string]string{"code": "bad_request", "message": err.Error()})
+			return
+		}
+		d.receipts[receipt.ExternalID] = receipt
+		d.logger.Info(fmt.Sprintf("Demo Monzo: receipt with %d items attached to %s", len(receipt.Items), receipt.TransactionID), map[string]interface{}{
+			"event_type": "demo_monzo",
+		})
+		d.reply(w, http.StatusOK, map[string]string{})
+	case path == "/transaction-receipts" && r.Method == http.MethodDelete:
+		delete(d.receipts, r.Form.Get("external_id"))
+		d.reply(w, http.StatusOK, map[string]string{})
+	case strings.HasPrefix(path, "/pots/") && strings.HasSuffix(path, "/deposit"):
+		potID := strings.TrimSuffix(strings.TrimPrefix(path, "/pots/"), "/deposit")
+		amount, _ := strconv.ParseInt(r.Form.Get("amount"), 10, 64)
+		d.pots[potID] += amount
+		d.logger.Info(fmt.Sprintf("Demo Monzo: %dp rounded up into pot %s, now %dp", amount, potID, d.pots[potID]), map[string]interface{}{
+			"event_type": "demo_monzo",
+		})
+		d.reply(w, http.StatusOK, map[string]interface{}{"id": potID, "balance": d.pots[potID]})
+	case path == "/webhooks" && r.Method == http.MethodGet:
+		webhooks := []MonzoWebhook{}
+		for _, webhook := range d.webhooks {
+			if webhook.AccountID == r.Form.Get("account_id") {
+				webhooks = append(webhooks, webhook)
+			}
+		}
+		d.reply(w, http.StatusOK, map[string]interface{}{"webhooks": webhooks})
+	case path == "/webhooks" && r.Method == http.MethodPost:
+		webhook := MonzoWebhook{ID: newID("webhook_"), AccountID: r.Form.Get("account_id"), URL: r.Form.Get("url")}
+		d.webhooks[webhook.ID] = webhook
+		d.reply(w, http.StatusOK, map[string]interface{}{"webhook": webhook})
+	case strings.HasPrefix(path, "/webhooks/") && r.Method == http.MethodDelete:
+		delete(d.webhooks, strings.TrimPrefix(path, "/webhooks/"))
+		d.reply(w, http.StatusOK, map[string]string{})
+	default:
+		d.reply(w, http.StatusNotFound, map[string]string{"code": "not_found", "message": r.Method + " " + path})
+	}
+}
+
+// listTransactions pages through an account's feed as Monzo does: since is a transaction ID
+// to continue after or a time, before a time. Callers must hold the lock.
+func (d *demoMonzo) listTransactions(r *http.Request) []MonzoTransaction {
+	feed := d.transactions[r.Form.Get("account_id")]
+	since, before := r.Form.Get("since"), r.Form.Get("before")
+	limit, err := strconv.Atoi(r.Form.Get("limit"))
+	if err != nil || limit < 1 || limit > monzoTransactionPage {
+		limit = monzoTransactionPage
+	}
+	sinceTime, _ := time.Parse(time.RFC3339, since)
+	beforeTime, _ := time.Parse(time.RFC3339, before)
+
+	start := 0
+	if strings.HasPrefix(since, "tx_") {
+		for i, mt := range feed {
+			if mt.ID == since {
+				start = i + 1
+			}
+		}
+	}
+	page := []MonzoTransaction{}
+	for _, mt := range feed[start:] {
+		if len(page) == limit {
+			break
+		}
+		if !sinceTime.IsZero() && mt.Created.Before(sinceTime) {
+			continue
+		}
+		if !beforeTime.IsZero() && !mt.Created.Before(beforeTime) {
+			continue
+		}
+		page = append(page, mt)
+	}
+	return page
+}
+
+// annotate records metadata set on a transaction. Callers must hold the lock.
+func (d *demoMonzo) annotate(w http.ResponseWriter, r *http.Request, transactionID string) {
+	for key, values := range r.PostForm {
+		if strings.HasPrefix(key, "metadata[") && len(values) > 0 {
+			d.logger.Info(fmt.Sprintf("Demo Monzo: %s on %s set to %q", key, transactionID, values[0]), map[string]interface{}{
+				"event_type": "demo_monzo",
+			})
+		}
+	}
+	d.reply(w, http.StatusOK, map[string]interface{}{"transaction": map[string]string{"id": transactionID}})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecation describes an endpoint or request field that callers should stop using. Surface
+// names what is deprecated, e.g. "GET /summary" or "POST /categorize field status", and a
+// Replacement starting with "/" is linked as the successor endpoint.
+type Deprecation struct {
+	Surface     string     `json:"surface"`
+	Since       time.Time  `json:"since"`
+	Sunset      *time.Time `json:"sunset,omitempty"`
+	Replacement string     `json:"replacement,omitempty"`
+	Link        string     `json:"link,omitempty"`
+}
+
+// CallerUsage counts one caller's use of a deprecated surface
+type CallerUsage struct {
+	Caller   string    `json:"caller"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// DeprecationUsage is a deprecated surface and who still uses it
+type DeprecationUsage struct {
+	Deprecation
+	Total   int           `json:"total"`
+	Callers []CallerUsage `json:"callers"`
+}
+
+// deprecationRegistry tracks the deprecated surfaces and their use per caller
+type deprecationRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*deprecationEntry
+}
+
+type deprecationEntry struct {
+	deprecation Deprecation
+	callers     map[string]*CallerUsage
+}
+
+// Register adds a deprecated surface so it is reported even before anyone uses it
+func (r *deprecationRegistry) Register(d Deprecation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[d.Surface]; !ok {
+		r.entries[d.Surface] = &deprecationEntry{deprecation: d, callers: map[string]*CallerUsage{}}
+	}
+}
+
+// Record counts a use of a deprecated surface by caller
+func (r *deprecationRegistry) Record(d Deprecation, caller string) {
+	r.Register(d)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.entries[d.Surface]
+	usage, ok := entry.callers[caller]
+	if !ok {
+		usage = &CallerUsage{Caller: caller}
+		entry.callers[caller] = usage
+	}
+	usage.Count++
+	usage.LastSeen = time.Now().UTC()
+}
+
+// Report lists every deprecated surface by name, callers with the most use first
+func (r *deprecationRegistry) Report() []DeprecationUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := make([]DeprecationUsage, 0, len(r.entries))
+	for _, entry := range r.entries {
+		usage := DeprecationUsage{Deprecation: entry.deprecation, Callers: []CallerUsage{}}
+		for _, caller := range entry.callers {
+			usage.Total += caller.Count
+			usage.Callers = append(usage.Callers, *caller)
+		}
+		sort.Slice(usage.Callers, func(i, j int) bool {
+			if usage.Callers[i].Count != usage.Callers[j].Count {
+				return usage.Callers[i].Count > usage.Callers[j].Count
+			}
+			return usage.Callers[i].Caller < usage.Callers[j].Caller
+		})
+		report = append(report, usage)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Surface < report[j].Surface
+	})
+	return report
+}
+
+// Global deprecation registry
+var deprecations = &deprecationRegistry{entries: map[string]*deprecationEntry{}}
+
+// markDeprecated sets the Deprecation, Sunset and Link headers on the response and counts
+// the use against the caller. Handlers call it when a request uses a deprecated field.
+func (s *Server) markDeprecated(c *gin.Context, d Deprecation) {
+	c.Header("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	if d.Sunset != nil {
+		c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		addLink(c, "<"+d.Link+`>; rel="deprecation"`)
+	}
+	if strings.HasPrefix(d.Replacement, "/") {
+		addLink(c, "<"+d.Replacement+`>; rel="successor-version"`)
+	}
+	caller := callerName(c)
+	deprecations.Record(d, caller)
+	s.metrics.recordDeprecatedUsage(d.Surface, caller)
+}
+
+// addLink adds a Link header value unless the response already has it
+func addLink(c *gin.Context, link string) {
+	for _, existing := range c.Writer.Header().Values("Link") {
+		if existing == link {
+			return
+		}
+	}
+	c.Writer.Header().Add("Link", link)
+}
+
+// deprecated marks every request to a route as using a deprecated endpoint
+func (s *Server) deprecated(d Deprecation) gin.HandlerFunc {
+	deprecations.Register(d)
+	return func(c *gin.Context) {
+		s.markDeprecated(c, d)
+		c.Next()
+	}
+}
+
+// handleDeprecations serves GET /admin/deprecations with each deprecated surface and the
+// callers still using it
+func (s *Server) handleDeprecations(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"deprecations": deprecations.Report()})
+}
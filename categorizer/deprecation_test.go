@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestDeprecatedRoute checks a route behind the deprecated middleware answers with the
+// Deprecation, Sunset and Link headers and counts the use against the caller
+func TestDeprecatedRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewMetrics(prometheus.NewRegistry(), "test", nil)
+	s := NewServer(Config{}, NewStructuredLogger("test", nil), m, newMemoryStore(0), NewClassifier(defaultRuleSet(), nil, m))
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	d := Deprecation{
+		Surface:     "GET /test/old",
+		Since:       since,
+		Sunset:      &sunset,
+		Replacement: "/test/new",
+		Link:        "https://example.com/deprecations/old",
+	}
+	r := gin.New()
+	r.GET("/test/old", s.deprecated(d), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test/old", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want the handler's 200", w.Code)
+	}
+	if got, want := w.Header().Get("Deprecation"), "@"+strconv.FormatInt(since.Unix(), 10); got != want {
+		t.Errorf("Deprecation %q, want %q", got, want)
+	}
+	if got, want := w.Header().Get("Sunset"), "Wed, 01 Jul 2026 00:00:00 GMT"; got != want {
+		t.Errorf("Sunset %q, want %q", got, want)
+	}
+	links := map[string]bool{}
+	for _, link := range w.Header().Values("Link") {
+		links[link] = true
+	}
+	for _, want := range []string{
+		`<https://example.com/deprecations/old>; rel="deprecation"`,
+		`</test/new>; rel="successor-version"`,
+	} {
+		if !links[want] {
+			t.Errorf("Link headers %v, missing %s", w.Header().Values("Link"), want)
+		}
+	}
+
+	for _, usage := range deprecations.Report() {
+		if usage.Surface != d.Surface {
+			continue
+		}
+		if usage.Total != 1 || len(usage.Callers) != 1 || usage.Callers[0].Caller != "anonymous" {
+			t.Errorf("usage %+v, want one use by anonymous", usage)
+		}
+		return
+	}
+	t.Errorf("%s is not in the deprecation report", d.Surface)
+}
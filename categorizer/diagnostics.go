@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedValue replaces secrets in the diagnostics bundle
+const redactedValue = "[redacted]"
+
+// secretFieldMarkers identify config fields whose values must never leave the process
+var secretFieldMarkers = []string{"Token", "Password", "Secret", "Key"}
+
+// redactedConfig returns the configuration with secret fields masked
+func redactedConfig(cfg Config) map[string]interface{} {
+	redacted := map[string]interface{}{}
+	value := reflect.ValueOf(cfg)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		v := value.Field(i).Interface()
+		for _, marker := range secretFieldMarkers {
+			if strings.Contains(field.Name, marker) && !value.Field(i).IsZero() {
+				v = redactedValue
+				break
+			}
+		}
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		redacted[field.Name] = v
+	}
+	return redacted
+}
+
+// ruleStats summarises the active ruleset
+func (s *Server) ruleStats() map[string]interface{} {
+	rules := s.activeRules()
+	categories := map[string]int{}
+	keywords := 0
+	for _, rule := range rules.Rules {
+		categories[rule.Category]++
+		keywords += len(rule.Keywords)
+	}
+	return map[string]interface{}{
+		"version":    rules.Version,
+		"rules":      len(rules.Rules),
+		"keywords":   keywords,
+		"categories": categories,
+		"conflicts":  detectRuleConflicts(rules),
+		"pipeline":   s.config.PipelineStages,
+	}
+}
+
+// runtimeStats reports process-level figures
+func (s *Server) runtimeStats() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return map[string]interface{}{
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_alloc":       mem.HeapAlloc,
+		"heap_objects":     mem.HeapObjects,
+		"gc_cycles":        mem.NumGC,
+		"users":            len(s.store.Users()),
+		"overrides":        len(overrides.List("", "")),
+		"aggregate_months": aggregates.Months(),
+	}
+}
+
+// diagnosticsBundle builds the files of a diagnostics bundle
+func (s *Server) diagnosticsBundle() (map[string][]byte, error) {
+	sections := map[string]interface{}{
+		"build.json":  s.currentBuildInfo(),
+		"config.json": redactedConfig(s.config),
+		"rules.json":  s.ruleStats(),
+		"caches.json": map[string]interface{}{
+			"summaries": summaries.Stats(),
+			"rollups":   rollups.Stats(),
+		},
+		"runtime.json":      s.runtimeStats(),
+		"dependencies.json": readiness.List(),
+		"errors.json":       s.logger.recent.List(),
+	}
+
+	files := map[string][]byte{}
+	for name, section := range sections {
+		data, err := json.MarshalIndent(section, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[name] = data
+	}
+
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return nil, fmt.Errorf("goroutines.txt: %w", err)
+	}
+	files["goroutines.txt"] = goroutines.Bytes()
+	return files, nil
+}
+
+// handleDiagnostics serves GET /admin/diagnostics as a zip to attach to incident tickets
+func (s *Server) handleDiagnostics(c *gin.Context) {
+	files, err := s.diagnosticsBundle()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.requestLogger(c).Info("Diagnostics bundle generated", map[string]interface{}{
+		"event_type": "diagnostics_generated",
+	})
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=diagnostics-%s.zip", now.Format("20060102T150405Z")))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
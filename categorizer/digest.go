@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Digest frequencies
+const (
+	DigestWeekly  = "weekly"
+	DigestMonthly = "monthly"
+)
+
+// DigestSubscription is a user's opt-in to email digests
+type DigestSubscription struct {
+	UserID     string     `json:"user_id"`
+	Email      string     `json:"email" binding:"required,email"`
+	Frequency  string     `json:"frequency" binding:"required,oneof=weekly monthly"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// nextSend returns when the subscription's next digest is due
+func (s DigestSubscription) nextSend() time.Time {
+	last := s.CreatedAt
+	if s.LastSentAt != nil {
+		last = *s.LastSentAt
+	}
+	if s.Frequency == DigestWeekly {
+		return last.AddDate(0, 0, 7)
+	}
+	return last.AddDate(0, 1, 0)
+}
+
+// digestRegistry keeps digest opt-ins in memory
+type digestRegistry struct {
+	mu            sync.Mutex
+	subscriptions map[string]*DigestSubscription
+}
+
+// Set opts a user in, keeping the last send time of an existing subscription
+func (r *digestRegistry) Set(sub DigestSubscription) DigestSubscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sub.CreatedAt = time.Now().UTC()
+	if existing, ok := r.subscriptions[sub.UserID]; ok {
+		sub.CreatedAt = existing.CreatedAt
+		sub.LastSentAt = existing.LastSentAt
+	}
+	r.subscriptions[sub.UserID] = &sub
+	return sub
+}
+
+// Get returns a user's subscription
+func (r *digestRegistry) Get(userID string) (DigestSubscription, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sub, ok := r.subscriptions[userID]
+	if !ok {
+		return DigestSubscription{}, false
+	}
+	return *sub, true
+}
+
+// Remove opts a user out, reporting whether they were subscribed
+func (r *digestRegistry) Remove(userID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.subscriptions[userID]
+	delete(r.subscriptions, userID)
+	return ok
+}
+
+// Due returns the subscriptions whose next digest is due at now
+func (r *digestRegistry) Due(now time.Time) []DigestSubscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []DigestSubscription
+	for _, sub := range r.subscriptions {
+		if !now.Before(sub.nextSend()) {
+			due = append(due, *sub)
+		}
+	}
+	return due
+}
+
+// MarkSent records when a user's digest was sent
+func (r *digestRegistry) MarkSent(userID string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sub, ok := r.subscriptions[userID]; ok {
+		sub.LastSentAt = &at
+	}
+}
+
+// Global digest opt-ins
+var digests = &digestRegistry{subscriptions: map[string]*DigestSubscription{}}
+
+// BudgetStatus compares a category's spending with a user's threshold notification rule
+type BudgetStatus struct {
+	Category string  `json:"category"`
+	Period   string  `json:"period"`
+	Limit    float64 `json:"limit"`
+	Spent    float64 `json:"spent"`
+	Over     bool    `json:"over"`
+}
+
+// Digest is the content of one digest email
+type Digest struct {
+	UserID           string         `json:"user_id"`
+	Frequency        string         `json:"frequency"`
+	From             time.Time      `json:"from"`
+	To               time.Time      `json:"to"`
+	Summary          Summary        `json:"summary"`
+	NewSubscriptions []Subscription `json:"new_subscriptions"`
+	Budgets          []BudgetStatus `json:"budgets"`
+}
+
+// buildDigest summarises the period before now: spending per category, subscriptions first
+// confirmed by a second charge in the period, and the status of the user's budgets
+func (s *Server) buildDigest(userID, frequency string, now time.Time) Digest {
+	from := now.AddDate(0, -1, 0)
+	if frequency == DigestWeekly {
+		from = now.AddDate(0, 0, -7)
+	}
+	digest := Digest{
+		UserID:           userID,
+		Frequency:        frequency,
+		From:             from,
+		To:               now,
+		Summary:          buildSummary(userID, s.store.ListTransactions(userID, from, now)),
+		NewSubscriptions: []Subscription{},
+		Budgets:          []BudgetStatus{},
+	}
+
+	for _, sub := range detectSubscriptions(s.store.ListTransactions(userID, now.AddDate(0, -3, 0), now)) {
+		if sub.Charges == 2 && !sub.LastCharge.Before(from) {
+			digest.NewSubscriptions = append(digest.NewSubscriptions, sub)
+		}
+	}
+
+	for _, rule := range notifications.List(userID) {
+		if rule.Type != NotifyCategoryThreshold {
+			continue
+		}
+		start := periodStart(rule.Period, now)
+		spent := rule.thresholdSpending(s, start)
+		digest.Budgets = append(digest.Budgets, BudgetStatus{
+			Category: rule.Category,
+			Period:   rule.Period,
+			Limit:    rule.Threshold,
+			Spent:    spent,
+			Over:     spent > rule.Threshold,
+		})
+	}
+	return digest
+}
+
+// Default digest templates, overridable with digest.txt.tmpl and digest.html.tmpl in DIGEST_TEMPLATES_DIR
+const (
+	defaultDigestSubject = `Your {{.Frequency}} spending digest`
+
+	defaultDigestText = `Your spending from {{.From.Format "2 Jan"}} to {{.To.Format "2 Jan 2006"}}
+
+Spent £{{printf "%.2f" .Summary.Spending}} · Received £{{printf "%.2f" .Summary.Income}}
+{{range .Summary.Categories}}
+  {{.Category}}: £{{printf "%.2f" .Total}} ({{.Count}})
+{{- end}}
+{{if .NewSubscriptions}}
+New subscriptions
+{{- range .NewSubscriptions}}
+  {{.Merchant}}: £{{printf "%.2f" .Amount}} a month
+{{- end}}
+{{end}}{{if .Budgets}}
+Budgets
+{{- range .Budgets}}
+  {{.Category}}: £{{printf "%.2f" .Spent}} of £{{printf "%.2f" .Limit}} this {{.Period}}{{if .Over}} (over){{end}}
+{{- end}}
+{{end}}`
+
+	defaultDigestHTML = `<h2>Your spending from {{.From.Format "2 Jan"}} to {{.To.Format "2 Jan 2006"}}</h2>
+<p>Spent £{{printf "%.2f" .Summary.Spending}} · Received £{{printf "%.2f" .Summary.Income}}</p>
+<table>{{range .Summary.Categories}}<tr><td>{{.Category}}</td><td>£{{printf "%.2f" .Total}}</td></tr>{{end}}</table>
+{{if .NewSubscriptions}}<h3>New subscriptions</h3><ul>{{range .NewSubscriptions}}<li>{{.Merchant}}: £{{printf "%.2f" .Amount}} a month</li>{{end}}</ul>{{end}}
+{{if .Budgets}}<h3>Budgets</h3><ul>{{range .Budgets}}<li>{{.Category}}: £{{printf "%.2f" .Spent}} of £{{printf "%.2f" .Limit}} this {{.Period}}{{if .Over}} <strong>over</strong>{{end}}</li>{{end}}</ul>{{end}}`
+)
+
+// Active digest templates
+var (
+	digestSubjectTemplate = template.Must(template.New("subject").Parse(defaultDigestSubject))
+	digestTextTemplate    = template.Must(template.New("digest.txt.tmpl").Parse(defaultDigestText))
+	digestHTMLTemplate    = htmltemplate.Must(htmltemplate.New("digest.html.tmpl").Parse(defaultDigestHTML))
+)
+
+// loadDigestTemplates replaces the default templates with any found in dir
+func loadDigestTemplates(dir string) error {
+	textPath := filepath.Join(dir, "digest.txt.tmpl")
+	if _, err := os.Stat(textPath); err == nil {
+		t, err := template.ParseFiles(textPath)
+		if err != nil {
+			return err
+		}
+		digestTextTemplate = t
+	}
+	htmlPath := filepath.Join(dir, "digest.html.tmpl")
+	if _, err := os.Stat(htmlPath); err == nil {
+		t, err := htmltemplate.ParseFiles(htmlPath)
+		if err != nil {
+			return err
+		}
+		digestHTMLTemplate = t
+	}
+	return nil
+}
+
+// EmailMessage is a multipart email with text and HTML bodies
+type EmailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// renderDigest renders a digest into an email for the given address
+func renderDigest(to string, digest Digest) (EmailMessage, error) {
+	var subject, text, html bytes.Buffer
+	if err := digestSubjectTemplate.Execute(&subject, digest); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := digestTextTemplate.Execute(&text, digest); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := digestHTMLTemplate.Execute(&html, digest); err != nil {
+		return EmailMessage{}, err
+	}
+	return EmailMessage{To: to, Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
+
+// EmailSender delivers email through a provider such as SMTP or SES
+type EmailSender interface {
+	Send(msg EmailMessage) error
+}
+
+// logSender logs emails instead of sending them, for local development
+type logSender struct {
+	logger *StructuredLogger
+}
+
+func (s logSender) Send(msg EmailMessage) error {
+	s.logger.Info("Email not sent: log provider", map[string]interface{}{
+		"event_type": "email_logged",
+	})
+	return nil
+}
+
+// smtpSender sends email through an SMTP relay
+type smtpSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// Ping checks the SMTP server accepts connections
+func (s smtpSender) Ping() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (s smtpSender) Send(msg EmailMessage) error {
+	boundary := newID("b_")
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", s.from, msg.To, msg.Subject)
+	fmt.Fprintf(&body, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	fmt.Fprintf(&body, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.Text)
+	fmt.Fprintf(&body, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+	fmt.Fprintf(&body, "--%s--\r\n", boundary)
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, body.Bytes())
+}
+
+// newEmailSender builds the email provider selected by DIGEST_PROVIDER, logging to logger
+// when that is the log provider
+func newEmailSender(cfg Config, logger *StructuredLogger) (EmailSender, error) {
+	switch cfg.DigestProvider {
+	case "", "log":
+		return logSender{logger: logger}, nil
+	case "smtp":
+		if cfg.SMTPAddr == "" || cfg.DigestFrom == "" {
+			return nil, errors.New("smtp digest provider requires SMTP_ADDR and DIGEST_FROM")
+		}
+		var auth smtp.Auth
+		if cfg.SMTPUsername != "" {
+			host := strings.Split(cfg.SMTPAddr, ":")[0]
+			auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+		}
+		return smtpSender{addr: cfg.SMTPAddr, from: cfg.DigestFrom, auth: auth}, nil
+	}
+	return nil, fmt.Errorf("unknown digest provider %q", cfg.DigestProvider)
+}
+
+// Email provider used for digests, set at startup
+var emailSender EmailSender
+
+// sendDigest builds, renders and sends one user's digest
+func (s *Server) sendDigest(sub DigestSubscription, now time.Time) error {
+	msg, err := renderDigest(sub.Email, s.buildDigest(sub.UserID, sub.Frequency, now))
+	if err == nil {
+		err = emailSender.Send(msg)
+	}
+	if err != nil {
+		s.metrics.recordDigestEmail(sub.Frequency, "error")
+		s.logger.Warn("Digest email failed", map[string]interface{}{
+			"error_message": err.Error(),
+			"event_type":    "digest_failed",
+		})
+		return err
+	}
+	s.metrics.recordDigestEmail(sub.Frequency, "sent")
+	digests.MarkSent(sub.UserID, now)
+	return nil
+}
+
+// startDigestWorker sends every due digest on each interval
+func (s *Server) startDigestWorker(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			now := time.Now().UTC()
+			for _, sub := range digests.Due(now) {
+				s.sendDigest(sub, now)
+			}
+		}
+	}()
+}
+
+func (s *Server) handleSetDigest(c *gin.Context) {
+	var sub DigestSubscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sub.UserID = c.Param("user_id")
+	c.JSON(http.StatusOK, digests.Set(sub))
+}
+
+func (s *Server) handleGetDigest(c *gin.Context) {
+	sub, ok := digests.Get(c.Param("user_id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user is not subscribed to digests"})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func (s *Server) handleDeleteDigest(c *gin.Context) {
+	if !digests.Remove(c.Param("user_id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user is not subscribed to digests"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleDigestPreview renders the digest a user would receive now without sending it
+func (s *Server) handleDigestPreview(c *gin.Context) {
+	userID := c.Param("user_id")
+	sub, ok := digests.Get(userID)
+	if !ok {
+		sub = DigestSubscription{UserID: userID, Frequency: c.DefaultQuery("frequency", DigestWeekly)}
+	}
+	msg, err := renderDigest(sub.Email, s.buildDigest(userID, sub.Frequency, time.Now().UTC()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("format") == "html" {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(msg.HTML))
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// charityKeywords are registered UK charities, giving platforms and generic donation terms
+var charityKeywords = []string{
+	"oxfam", "british red cross", "cancer research", "macmillan", "save the children", "unicef",
+	"rspca", "rspb", "nspcc", "barnardo", "british heart foundation", "marie curie", "age uk",
+	"dogs trust", "wateraid", "samaritans", "comic relief", "children in need", "amnesty",
+	"wwf", "justgiving", "cafdonate", "charity", "charitable", "donation",
+}
+
+// giftAidIneligibleKeywords mark charity payments that buy something (raffles, tickets, shop
+// purchases) and so can't carry Gift Aid
+var giftAidIneligibleKeywords = []string{"raffle", "lottery", "ticket", "shop", "membership", "event"}
+
+// Gift Aid rates: the charity reclaims basic rate tax on the gross gift, and higher and
+// additional rate donors claim the difference back themselves
+const (
+	giftAidBasicRate      = 0.20
+	giftAidHigherRate     = 0.40
+	giftAidAdditionalRate = 0.45
+)
+
+// CharityDonations totals a user's donations to one charity
+type CharityDonations struct {
+	Charity  string  `json:"charity"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+	Eligible float64 `json:"gift_aid_eligible"`
+}
+
+// GiftAidSummary totals a user's donations over a UK tax year
+type GiftAidSummary struct {
+	UserID               string             `json:"user_id"`
+	TaxYear              string             `json:"tax_year"`
+	From                 string             `json:"from"`
+	To                   string             `json:"to"`
+	Donations            int                `json:"donations"`
+	Total                float64            `json:"total"`
+	Eligible             float64            `json:"gift_aid_eligible"`
+	GrossEligible        float64            `json:"gross_eligible"`
+	CharityClaim         float64            `json:"charity_claim"`
+	HigherRateRelief     float64            `json:"higher_rate_relief"`
+	AdditionalRateRelief float64            `json:"additional_rate_relief"`
+	Charities            []CharityDonations `json:"charities"`
+}
+
+// taxYearBounds returns the UK tax year starting 6 April of the given year
+func taxYearBounds(startYear int) (time.Time, time.Time) {
+	from := time.Date(startYear, time.April, 6, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(1, 0, 0)
+}
+
+// currentTaxYear returns the starting year of the UK tax year containing t
+func currentTaxYear(t time.Time) int {
+	if t.Before(time.Date(t.Year(), time.April, 6, 0, 0, 0, 0, time.UTC)) {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
+
+// giftAidEligible reports whether a donation looks like a gift rather than a purchase
+func giftAidEligible(tx StoredTransaction) bool {
+	text := strings.ToLower(tx.Merchant + " " + tx.Description)
+	for _, keyword := range giftAidIneligibleKeywords {
+		if strings.Contains(text, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildGiftAidSummary totals settled donations per charity and the Gift Aid they attract
+func buildGiftAidSummary(userID string, startYear int, transactions []StoredTransaction) GiftAidSummary {
+	from, to := taxYearBounds(startYear)
+	summary := GiftAidSummary{
+		UserID:  userID,
+		TaxYear: fmt.Sprintf("%d-%02d", startYear, (startYear+1)%100),
+		From:    from.Format(dateLayout),
+		To:      to.AddDate(0, 0, -1).Format(dateLayout),
+	}
+
+	charities := map[string]*CharityDonations{}
+	for _, tx := range transactions {
+		if tx.Category != "Donations" || tx.Duplicate || tx.Status == StatusDeclined ||
+			strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		name := resolveMerchant(normalizeDescriptor(tx.Merchant))
+		charity, ok := charities[name]
+		if !ok {
+			charity = &CharityDonations{Charity: name}
+			charities[name] = charity
+		}
+		charity.Count++
+		charity.Total += tx.Amount
+		summary.Donations++
+		summary.Total += tx.Amount
+		if giftAidEligible(tx) {
+			charity.Eligible += tx.Amount
+			summary.Eligible += tx.Amount
+		}
+	}
+
+	gross := summary.Eligible / (1 - giftAidBasicRate)
+	summary.Total = roundPence(summary.Total)
+	summary.Eligible = roundPence(summary.Eligible)
+	summary.GrossEligible = roundPence(gross)
+	summary.CharityClaim = roundPence(gross * giftAidBasicRate)
+	summary.HigherRateRelief = roundPence(gross * (giftAidHigherRate - giftAidBasicRate))
+	summary.AdditionalRateRelief = roundPence(gross * (giftAidAdditionalRate - giftAidBasicRate))
+
+	summary.Charities = make([]CharityDonations, 0, len(charities))
+	for _, charity := range charities {
+		charity.Total = roundPence(charity.Total)
+		charity.Eligible = roundPence(charity.Eligible)
+		summary.Charities = append(summary.Charities, *charity)
+	}
+	sort.Slice(summary.Charities, func(i, j int) bool {
+		return summary.Charities[i].Total > summary.Charities[j].Total
+	})
+	return summary
+}
+
+// handleGiftAidSummary serves GET /summary/gift-aid?user_id=...&tax_year=2024, where
+// tax_year is the year the tax year starts in and defaults to the current one
+func (s *Server) handleGiftAidSummary(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	startYear := currentTaxYear(time.Now().UTC())
+	if raw := c.Query("tax_year"); raw != "" {
+		year, err := strconv.Atoi(raw)
+		if err != nil || year < 1990 || year > 2100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tax_year must be the year the tax year starts, e.g. 2024"})
+			return
+		}
+		startYear = year
+	}
+
+	from, to := taxYearBounds(startYear)
+	c.JSON(http.StatusOK, buildGiftAidSummary(userID, startYear, s.store.ListTransactions(userID, from, to)))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Environments the service's external APIs can point at. Each bank provider picks its base
+// URLs for the configured environment, unless they're set explicitly.
+const (
+	EnvironmentProduction = "production"
+	EnvironmentSandbox    = "sandbox"
+)
+
+// monzoHosts are Monzo's API and OAuth base URLs in one environment
+type monzoHosts struct {
+	apiURL  string
+	authURL string
+}
+
+// monzoEnvironments maps each environment to its Monzo base URLs
+var monzoEnvironments = map[string]monzoHosts{
+	EnvironmentProduction: {apiURL: "https://api.monzo.com", authURL: "https://auth.monzo.com"},
+	EnvironmentSandbox:    {apiURL: "https://api-sandbox.monzo.com", authURL: "https://auth-sandbox.monzo.com"},
+}
+
+// monzoEnvironmentOf returns the environment whose Monzo hosts a base URL points at, or ""
+// for a host that is neither, such as a local stub
+func monzoEnvironmentOf(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	for env, hosts := range monzoEnvironments {
+		for _, known := range []string{hosts.apiURL, hosts.authURL} {
+			if k, err := url.Parse(known); err == nil && k.Host == u.Host {
+				return env
+			}
+		}
+	}
+	return ""
+}
+
+// checkEnvironment checks ENVIRONMENT names a known environment and that explicitly set
+// Monzo URLs point at that environment's hosts, so a sandbox deployment can't talk to
+// production Monzo or the other way round
+func checkEnvironment(cfg Config) error {
+	if _, ok := monzoEnvironments[cfg.Environment]; !ok {
+		return fmt.Errorf("ENVIRONMENT must be %s or %s, got %q", EnvironmentProduction, EnvironmentSandbox, cfg.Environment)
+	}
+	for _, u := range []struct{ env, value string }{{"MONZO_API_URL", cfg.MonzoAPIURL}, {"MONZO_AUTH_URL", cfg.MonzoAuthURL}} {
+		if env := monzoEnvironmentOf(u.value); env != "" && env != cfg.Environment {
+			return fmt.Errorf("%s points at Monzo's %s environment but ENVIRONMENT is %s", u.env, env, cfg.Environment)
+		}
+	}
+	return nil
+}
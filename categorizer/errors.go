@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorEvent is a logged error kept in memory for triage, with repeats of the same error
+// folded into one event
+type ErrorEvent struct {
+	Level        LogLevel  `json:"level"`
+	Message      string    `json:"message"`
+	EventType    string    `json:"event_type,omitempty"`
+	ErrorType    string    `json:"error_type,omitempty"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+	Endpoint     string    `json:"endpoint,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Count        int       `json:"count"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// key identifies repeats of the same error
+func (e ErrorEvent) key() string {
+	return strings.Join([]string{string(e.Level), e.Message, e.EventType, e.ErrorType, e.ErrorMessage, e.Endpoint}, "\x00")
+}
+
+// isErrorEntry reports whether a log entry describes an error: anything logged at ERROR,
+// and warnings that carry an error message
+func isErrorEntry(entry LogEntry) bool {
+	return entry.Level == ERROR || entry.ErrorMessage != ""
+}
+
+// errorBuffer keeps the most recently seen distinct errors, evicting the one seen longest
+// ago once full
+type errorBuffer struct {
+	mu     sync.Mutex
+	size   int
+	events []*ErrorEvent
+	byKey  map[string]*ErrorEvent
+}
+
+// newErrorBuffer creates a buffer holding up to size distinct errors
+func newErrorBuffer(size int) *errorBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &errorBuffer{size: size, byKey: map[string]*ErrorEvent{}}
+}
+
+// Record adds an error log entry, counting it against an earlier event when it repeats one
+func (b *errorBuffer) Record(entry LogEntry) {
+	if !isErrorEntry(entry) {
+		return
+	}
+	seen, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		seen = time.Now().UTC()
+	}
+	event := ErrorEvent{
+		Level:        entry.Level,
+		Message:      entry.Message,
+		EventType:    entry.EventType,
+		ErrorType:    entry.ErrorType,
+		ErrorMessage: entry.ErrorMessage,
+		Endpoint:     entry.Endpoint,
+		RequestID:    entry.RequestID,
+		Count:        1,
+		FirstSeen:    seen,
+		LastSeen:     seen,
+	}
+	key := event.key()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if existing, ok := b.byKey[key]; ok {
+		existing.Count++
+		existing.LastSeen = seen
+		existing.RequestID = event.RequestID
+		b.remove(existing)
+		b.events = append(b.events, existing)
+		return
+	}
+	if len(b.events) == b.size {
+		oldest := b.events[0]
+		b.remove(oldest)
+		delete(b.byKey, oldest.key())
+	}
+	b.events = append(b.events, &event)
+	b.byKey[key] = &event
+}
+
+// remove drops an event from the recency order
+func (b *errorBuffer) remove(event *ErrorEvent) {
+	for i, e := range b.events {
+		if e == event {
+			b.events = append(b.events[:i], b.events[i+1:]...)
+			return
+		}
+	}
+}
+
+// List returns the buffered errors, most recently seen first
+func (b *errorBuffer) List() []ErrorEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]ErrorEvent, 0, len(b.events))
+	for i := len(b.events) - 1; i >= 0; i-- {
+		list = append(list, *b.events[i])
+	}
+	return list
+}
+
+// Clear drops every buffered error
+func (b *errorBuffer) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = nil
+	b.byKey = map[string]*ErrorEvent{}
+}
+
+// handleListErrors serves GET /admin/errors with the recent errors, most recently seen first,
+// optionally limited with ?limit=
+func (s *Server) handleListErrors(c *gin.Context) {
+	list := s.logger.recent.List()
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		if limit < len(list) {
+			list = list[:limit]
+		}
+	}
+	total := 0
+	for _, event := range list {
+		total += event.Count
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"capacity":    s.logger.recent.size,
+		"count":       len(list),
+		"occurrences": total,
+		"errors":      list,
+	})
+}
+
+// handleClearErrors serves DELETE /admin/errors, e.g. once an incident is resolved
+func (s *Server) handleClearErrors(c *gin.Context) {
+	s.logger.recent.Clear()
+	c.Status(http.StatusNoContent)
+}
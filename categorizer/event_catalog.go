@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// More event types. The catalog below lists every type the service emits.
+const (
+	EventTransactionCategorized = "transaction.categorized"
+	EventBudgetBreached         = "budget.breached"
+	EventRulesReloaded          = "rules.reloaded"
+)
+
+// EventType describes an emitted event type and the JSON schema of its data at its current
+// version. The version is bumped whenever a field is removed, renamed or changes meaning, so
+// consumers can tell payload shapes apart from event_version alone; adding a field doesn't
+// bump it.
+type EventType struct {
+	Type        string                 `json:"type"`
+	Version     int                    `json:"version"`
+	Description string                 `json:"description"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+// JSON schema helpers for the catalog
+func schemaObject(required []string, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "required": required, "properties": properties}
+}
+
+func schemaType(t string) map[string]interface{} {
+	return map[string]interface{}{"type": t}
+}
+
+func schemaTime() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "format": "date-time"}
+}
+
+func schemaArray(items map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+// transactionSchema describes a stored transaction
+var transactionSchema = schemaObject(
+	[]string{"id", "user_id", "merchant", "amount", "transaction_type", "category", "created_at", "status"},
+	map[string]interface{}{
+		"id":               schemaType("string"),
+		"user_id":          schemaType("string"),
+		"merchant":         schemaType("string"),
+		"description":      schemaType("string"),
+		"amount":           schemaType("number"),
+		"transaction_type": map[string]interface{}{"type": "string", "enum": []string{TypeDebit, TypeCredit}},
+		"mcc":              schemaType("string"),
+		"category":         schemaType("string"),
+		"created_at":       schemaTime(),
+		"transaction_id":   schemaType("string"),
+		"dedupe_hash":      schemaType("string"),
+		"duplicate":        schemaType("boolean"),
+		"duplicate_of":     schemaType("string"),
+		"status":           map[string]interface{}{"type": "string", "enum": []string{StatusPending, StatusSettled, StatusDeclined}},
+		"settled_at":       schemaTime(),
+		"decline_reason":   schemaType("string"),
+		"location":         schemaType("string"),
+		"country":          schemaType("string"),
+		"tenant_id":        schemaType("string"),
+		"references": schemaArray(schemaObject([]string{"id", "type", "value"}, map[string]interface{}{
+			"id":         schemaType("string"),
+			"type":       map[string]interface{}{"type": "string", "enum": []string{RefReceiptURL, RefInvoiceNumber, RefExpenseReport, RefOther}},
+			"value":      schemaType("string"),
+			"system":     schemaType("string"),
+			"created_by": schemaType("string"),
+			"created_at": schemaTime(),
+		})),
+		"receipt": schemaObject([]string{"external_id", "currency", "items", "updated_at"}, map[string]interface{}{
+			"external_id": schemaType("string"),
+			"currency":    schemaType("string"),
+			"items": schemaArray(schemaObject([]string{"description", "quantity", "amount", "category"}, map[string]interface{}{
+				"description": schemaType("string"),
+				"quantity":    schemaType("number"),
+				"unit":        schemaType("string"),
+				"amount":      schemaType("number"),
+				"tax":         schemaType("number"),
+				"category":    schemaType("string"),
+			})),
+			"updated_at": schemaTime(),
+			"pushed_at":  schemaTime(),
+			"push_error": schemaType("string"),
+		}),
+	},
+)
+
+// flaggedTransactionProperties are common to events about one incoming transaction
+func flaggedTransactionProperties(extra map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{
+		"user_id":        schemaType("string"),
+		"transaction_id": schemaType("string"),
+		"merchant":       schemaType("string"),
+		"amount":         schemaType("number"),
+		"category":       schemaType("string"),
+	}
+	for name, schema := range extra {
+		properties[name] = schema
+	}
+	return properties
+}
+
+// eventCatalog is every event type the service emits
+var eventCatalog = []EventType{
+	{
+		Type:        EventTransactionCategorized,
+		Version:     1,
+		Description: "A user's transaction was categorized and recorded in their history",
+		Schema:      schemaObject([]string{"transaction"}, map[string]interface{}{"transaction": transactionSchema}),
+	},
+	{
+		Type:        EventTransactionUpdated,
+		Version:     1,
+		Description: "A pending transaction settled or was declined, replacing its earlier record",
+		Schema: schemaObject([]string{"transaction", "previous"}, map[string]interface{}{
+			"transaction": transactionSchema,
+			"previous":    transactionSchema,
+		}),
+	},
+	{
+		Type:        EventTransactionBlocked,
+		Version:     1,
+		Description: "A transaction matched one of its user's self-exclusion blocks",
+		Schema: schemaObject([]string{"user_id", "merchant", "amount", "category", "block"}, flaggedTransactionProperties(map[string]interface{}{
+			"block": schemaObject([]string{"id", "user_id", "type", "value"}, map[string]interface{}{
+				"id":         schemaType("string"),
+				"user_id":    schemaType("string"),
+				"type":       map[string]interface{}{"type": "string", "enum": []string{"merchant", "category", "risk_flag"}},
+				"value":      schemaType("string"),
+				"created_at": schemaTime(),
+			}),
+		})),
+	},
+	{
+		Type:        EventTransactionRiskFlagged,
+		Version:     1,
+		Description: "A transaction raised vulnerable-customer risk flags (only with RISK_ALERTS)",
+		Schema: schemaObject([]string{"merchant", "amount", "category", "risk_flags"}, flaggedTransactionProperties(map[string]interface{}{
+			"risk_flags": schemaArray(schemaType("string")),
+			"status":     schemaType("string"),
+		})),
+	},
+	{
+		Type:        EventBudgetBreached,
+		Version:     1,
+		Description: "A user's spending in a category passed their threshold for the period",
+		Schema: schemaObject([]string{"user_id", "rule_id", "category", "period", "period_start", "threshold", "spent"}, map[string]interface{}{
+			"user_id":      schemaType("string"),
+			"rule_id":      schemaType("string"),
+			"category":     schemaType("string"),
+			"period":       map[string]interface{}{"type": "string", "enum": []string{"day", "week", "month"}},
+			"period_start": schemaTime(),
+			"threshold":    schemaType("number"),
+			"spent":        schemaType("number"),
+		}),
+	},
+	{
+		Type:        EventNotificationTriggered,
+		Version:     1,
+		Description: "A notification rule with the webhook channel fired",
+		Schema: schemaObject([]string{"id", "user_id", "rule_id", "title", "body", "created_at"}, map[string]interface{}{
+			"id":         schemaType("string"),
+			"user_id":    schemaType("string"),
+			"rule_id":    schemaType("string"),
+			"title":      schemaType("string"),
+			"body":       schemaType("string"),
+			"created_at": schemaTime(),
+		}),
+	},
+	{
+		Type:        EventRulesReloaded,
+		Version:     1,
+		Description: "A new categorization rule set became active",
+		Schema: schemaObject([]string{"version", "previous_version", "rules"}, map[string]interface{}{
+			"version":          schemaType("string"),
+			"previous_version": schemaType("string"),
+			"rules":            schemaType("integer"),
+		}),
+	},
+	{
+		Type:        EventJobCompleted,
+		Version:     1,
+		Description: "A tracked admin job finished, successfully or not",
+		Schema: schemaObject([]string{"job_id", "type", "status", "progress"}, map[string]interface{}{
+			"job_id":   schemaType("string"),
+			"type":     schemaType("string"),
+			"status":   map[string]interface{}{"type": "string", "enum": []string{JobCompleted, JobFailed}},
+			"progress": map[string]interface{}{"type": "object", "additionalProperties": schemaType("integer")},
+			"error":    schemaType("string"),
+		}),
+	},
+	{
+		Type:        EventWebhookTest,
+		Version:     1,
+		Description: "A test delivery requested for a webhook subscription",
+		Schema: schemaObject([]string{"subscription_id"}, map[string]interface{}{
+			"subscription_id": schemaType("string"),
+		}),
+	},
+}
+
+// eventVersion returns the current payload version of an event type
+func eventVersion(eventType string) int {
+	for _, et := range eventCatalog {
+		if et.Type == eventType {
+			return et.Version
+		}
+	}
+	return 0
+}
+
+// handleEventSchema serves GET /events/schema with the event catalog, or one type's entry
+// with ?type=
+func (s *Server) handleEventSchema(c *gin.Context) {
+	if eventType := c.Query("type"); eventType != "" {
+		for _, et := range eventCatalog {
+			if et.Type == eventType {
+				c.JSON(http.StatusOK, et)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown event type " + eventType})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"envelope": schemaObject([]string{"id", "type", "event_version", "created_at", "data"}, map[string]interface{}{
+			"id":            schemaType("string"),
+			"type":          schemaType("string"),
+			"event_version": schemaType("integer"),
+			"tenant_id":     schemaType("string"),
+			"created_at":    schemaTime(),
+			"data":          schemaType("object"),
+		}),
+		"events": eventCatalog,
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventLog is an append-only log of emitted events numbered by sequence, so consumers that
+// missed webhook deliveries can catch up in order. The most recent events are kept in memory
+// to serve reads; with a file configured every event is also appended to it as a JSON line
+// and reloaded on startup, so sequence numbers survive restarts.
+type eventLog struct {
+	mu  sync.RWMutex
+	seq int64
+	// events is a ring of the most recent events: count of them, the oldest at start
+	events []Event
+	start  int
+	count  int
+	file   *os.File
+}
+
+// newEventLog creates a log serving up to size recent events
+func newEventLog(size int) *eventLog {
+	if size < 1 {
+		size = 1
+	}
+	return &eventLog{events: make([]Event, size)}
+}
+
+// at returns the i-th oldest event held. Callers must hold the lock.
+func (l *eventLog) at(i int) Event {
+	return l.events[(l.start+i)%len(l.events)]
+}
+
+// Open reloads the events in a log file and appends new events to it
+func (l *eventLog) Open(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			f.Close()
+			return fmt.Errorf("parse %s line %d: %w", path, line, err)
+		}
+		l.keep(event)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	l.file = f
+	return nil
+}
+
+// keep adds an event to the in-memory window, overwriting the oldest once it is full.
+// Callers must hold the lock.
+func (l *eventLog) keep(event Event) {
+	if event.Sequence > l.seq {
+		l.seq = event.Sequence
+	}
+	if l.count < len(l.events) {
+		l.events[(l.start+l.count)%len(l.events)] = event
+		l.count++
+		return
+	}
+	l.events[l.start] = event
+	l.start = (l.start + 1) % len(l.events)
+}
+
+// Append numbers an event and records it, returning any error writing it to the file. The
+// event is kept in memory either way.
+func (l *eventLog) Append(event *Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event.Sequence = l.seq + 1
+	l.keep(*event)
+	if l.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err == nil {
+		_, err = l.file.Write(append(data, '\n'))
+	}
+	return err
+}
+
+// After returns up to limit events with a sequence above seq that match filter, and the
+// sequence to resume from: the last event returned once limit is reached, otherwise the last
+// event appended. It fails when events after seq have already been dropped from memory, since
+// the caller can't catch up from seq without a gap.
+func (l *eventLog) After(seq int64, limit int, filter func(Event) bool) ([]Event, int64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	list := []Event{}
+	if l.count > 0 && seq < l.at(0).Sequence-1 {
+		return list, seq, fmt.Errorf("events after %d are no longer held; the oldest is %d", seq, l.at(0).Sequence)
+	}
+	for i := 0; i < l.count; i++ {
+		event := l.at(i)
+		if event.Sequence <= seq || !filter(event) {
+			continue
+		}
+		list = append(list, event)
+		if len(list) == limit {
+			return list, event.Sequence, nil
+		}
+	}
+	if l.seq > seq {
+		seq = l.seq
+	}
+	return list, seq, nil
+}
+
+// Oldest returns the sequence of the oldest event held in memory
+func (l *eventLog) Oldest() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.count == 0 {
+		return 0
+	}
+	return l.at(0).Sequence
+}
+
+// Latest returns the sequence of the last event appended
+func (l *eventLog) Latest() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.seq
+}
+
+// Global event log, sized from the configuration at startup
+var eventJournal *eventLog
+
+// handleListEvents serves GET /events?tenant_id=&after_seq=, returning a tenant's events in
+// sequence order. A tenant-bound API key implies its tenant; other callers must name one.
+func (s *Server) handleListEvents(c *gin.Context) {
+	tenantID, err := scopeTenant(c, c.Query("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if tenantID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id is required"})
+		return
+	}
+	listEvents(c, tenantID)
+}
+
+// handleListAllEvents serves GET /admin/events?after_seq=, returning every tenant's events,
+// or one tenant's with ?tenant_id=
+func (s *Server) handleListAllEvents(c *gin.Context) {
+	listEvents(c, c.Query("tenant_id"))
+}
+
+// listEvents answers with the events after ?after_seq= in sequence order, for one tenant or
+// all of them when tenantID is empty, optionally filtered by ?type= and limited with ?limit=
+// (default 100, at most 1000). Consumers pass the next_seq of one response as the after_seq
+// of the next.
+func listEvents(c *gin.Context, tenantID string) {
+	var after int64
+	if raw := c.Query("after_seq"); raw != "" {
+		seq, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after_seq must be a non-negative integer"})
+			return
+		}
+		after = seq
+	}
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+	eventType := c.Query("type")
+
+	list, next, err := eventJournal.After(after, limit, func(event Event) bool {
+		return (eventType == "" || event.Type == eventType) && (tenantID == "" || event.TenantID == tenantID)
+	})
+	if err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error(), "oldest_seq": eventJournal.Oldest()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events":     list,
+		"count":      len(list),
+		"next_seq":   next,
+		"latest_seq": eventJournal.Latest(),
+	})
+}
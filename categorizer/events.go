@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Event types
+const (
+	EventTransactionUpdated = "transaction.updated"
+	EventWebhookTest        = "webhook.test"
+)
+
+// knownEventTypes are the event types webhook subscriptions can filter on
+func knownEventTypes() []string {
+	types := make([]string, 0, len(eventCatalog))
+	for _, et := range eventCatalog {
+		types = append(types, et.Type)
+	}
+	return types
+}
+
+// Event is a notification emitted when categorization state changes. Events raised for a
+// tenant's transaction carry its tenant ID and also go to that tenant's subscriptions.
+type Event struct {
+	ID        string      `json:"id"`
+	Sequence  int64       `json:"seq,omitempty"`
+	Type      string      `json:"type"`
+	Version   int         `json:"event_version"`
+	TenantID  string      `json:"tenant_id,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookClient delivers events to the webhook URLs the operator configured
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// emitEvent logs an event and delivers it in the background to every configured webhook URL
+// and every matching subscription of the tenant it was raised for
+func (s *Server) emitEvent(eventType, tenantID string, data interface{}) Event {
+	event := Event{
+		ID:        newID("evt_"),
+		Type:      eventType,
+		Version:   eventVersion(eventType),
+		TenantID:  tenantID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	if err := eventJournal.Append(&event); err != nil {
+		s.logger.Error("Failed to append to event log", map[string]interface{}{
+			"event_type":    "event_log",
+			"error_type":    event.Type,
+			"error_message": err.Error(),
+		})
+	}
+	s.metrics.recordEvent(eventType)
+	s.logger.Info("Event emitted", map[string]interface{}{
+		"event_type": eventType,
+	})
+
+	for _, url := range s.config.WebhookURLs {
+		go s.deliverEvent(webhookClient, url, "", event)
+	}
+	for _, sub := range webhooks.Matching(event) {
+		go webhooks.Deliver(s, sub, event)
+	}
+	return event
+}
+
+// deliverEvent POSTs an event to a webhook URL through client, signing it when a secret is
+// given, and logs failed deliveries
+func (s *Server) deliverEvent(client *http.Client, url, secret string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		s.logEventDeliveryFailure(url, event, err.Error())
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		s.logEventDeliveryFailure(url, event, err.Error())
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhook(secret, time.Now(), body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		s.metrics.recordEventDelivery(event.Type, "error")
+		s.logEventDeliveryFailure(url, event, err.Error())
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		s.metrics.recordEventDelivery(event.Type, "error")
+		s.logEventDeliveryFailure(url, event, resp.Status)
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	s.metrics.recordEventDelivery(event.Type, "success")
+	return nil
+}
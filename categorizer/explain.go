@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExplainResponse describes how the pipeline arrived at a category
+type ExplainResponse struct {
+	Category       string          `json:"category"`
+	Rule           string          `json:"rule,omitempty"`
+	Keyword        string          `json:"keyword,omitempty"`
+	Policy         *MerchantPolicy `json:"policy,omitempty"`
+	Override       *Override       `json:"override,omitempty"`
+	Sticky         *StickyPin      `json:"sticky,omitempty"`
+	Feedback       *FeedbackVote   `json:"feedback,omitempty"`
+	Fuzzy          bool            `json:"fuzzy"`
+	IncomeType     string          `json:"income_type,omitempty"`
+	InvestmentType string          `json:"investment_type,omitempty"`
+	BNPLProvider   string          `json:"bnpl_provider,omitempty"`
+	Language       string          `json:"language,omitempty"`
+	RuleScores     []RulePrecision `json:"rule_scores,omitempty"`
+	RulesetVersion string          `json:"ruleset_version"`
+	Stages         []StageTrace    `json:"stages"`
+	TotalUs        float64         `json:"total_duration_us"`
+}
+
+// handleExplain serves POST /categorize/explain
+func (s *Server) handleExplain(c *gin.Context) {
+	var req TransactionRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = s.normalizeTransaction(c, &req)
+	}
+	var rs *RuleSet
+	if err == nil {
+		rs, err = requestRuleSet(c, s.classifier)
+	}
+	if err != nil {
+		s.metrics.recordCategorizationError("bad_request")
+		s.requestLogger(c).logCategorizationError("bad_request", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cl := newClassification(req, rs)
+	cl.Explain = true
+	start := time.Now()
+	s.classifier.Run(&cl)
+	total := time.Since(start)
+
+	response := ExplainResponse{
+		Category:       cl.Category,
+		Keyword:        cl.Keyword,
+		Fuzzy:          cl.Fuzzy,
+		IncomeType:     cl.IncomeType,
+		InvestmentType: classificationInvestmentType(cl),
+		BNPLProvider:   cl.BNPLProvider,
+		Language:       cl.Language,
+		Policy:         cl.Policy,
+		Override:       cl.Override,
+		Sticky:         cl.Sticky,
+		Feedback:       cl.Feedback,
+		RulesetVersion: cl.RuleSet.Version,
+		Stages:         cl.Trace,
+		TotalUs:        float64(total.Nanoseconds()) / 1e3,
+	}
+	if cl.Rule != nil {
+		response.Rule = cl.Rule.Name
+	}
+	// Every matching rule's precision against feedback, in the order they were preferred
+	if len(cl.RuleHits) > 0 {
+		scores := rulePrecision.Scores(cl.RuleSet)
+		for _, hit := range cl.RuleHits {
+			response.RuleScores = append(response.RuleScores, scores[ruleID(*hit.Rule)])
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFeedbackBatch bounds the rows accepted in one batch
+const maxFeedbackBatch = 10000
+
+// Feedback row outcomes
+const (
+	FeedbackAccepted  = "accepted"
+	FeedbackUpdated   = "updated"
+	FeedbackDuplicate = "duplicate"
+	FeedbackInvalid   = "invalid"
+)
+
+// Feedback is a correction of a category the service assigned, collected by an integrator's app.
+// It identifies the transaction by ID, or by merchant when the integrator didn't keep one.
+// Corrections replicate between regions like overrides, the last write winning.
+type Feedback struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id,omitempty"`
+	TenantID      string    `json:"tenant_id,omitempty"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	Merchant      string    `json:"merchant,omitempty"`
+	Description   string    `json:"description,omitempty"`
+	Amount        float64   `json:"amount,omitempty"`
+	Predicted     string    `json:"predicted,omitempty"`
+	Category      string    `json:"category"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Region        string    `json:"region,omitempty"`
+}
+
+// newerThan reports whether f wins a last-writer-wins merge against other, breaking ties on
+// the timestamp as overrides do
+func (f Feedback) newerThan(other Feedback) bool {
+	if !f.UpdatedAt.Equal(other.UpdatedAt) {
+		return f.UpdatedAt.After(other.UpdatedAt)
+	}
+	return f.Region > other.Region
+}
+
+// key identifies the transaction a correction is about, so a resubmitted correction is a
+// duplicate and a changed one replaces the last
+func (f Feedback) key() string {
+	if f.TransactionID != "" {
+		return strings.Join([]string{f.TenantID, f.UserID, "tx", f.TransactionID}, "|")
+	}
+	return strings.Join([]string{f.TenantID, f.UserID, "merchant", resolveMerchant(normalizeDescriptor(f.Merchant)),
+		strings.ToLower(f.Description), strconv.FormatFloat(f.Amount, 'f', 2, 64)}, "|")
+}
+
+// validate fills in what the user's stored transaction knows and checks the correction's
+// fields. The category may be any name the correction's tenant uses; the base category it
+// maps onto is stored.
+func (f *Feedback) validate(srv *Server, base map[string]bool) error {
+	if f.TransactionID != "" && f.UserID != "" {
+		if tx, ok := srv.findStoredTransaction(f.UserID, f.TransactionID); ok {
+			if f.Merchant == "" {
+				f.Merchant, f.Description, f.Amount = tx.Merchant, tx.Description, tx.Amount
+			}
+			if f.Predicted == "" {
+				f.Predicted = tx.Category
+			}
+		}
+	}
+	switch {
+	case f.TransactionID == "" && f.Merchant == "":
+		return errors.New("transaction_id or merchant is required")
+	case f.Category == "":
+		return errors.New("category is required")
+	case !tenantTaxonomies.Categories(f.TenantID, base)[f.Category]:
+		return fmt.Errorf("unknown category %q", f.Category)
+	}
+	f.Category = tenantTaxonomies.Canonical(f.TenantID, f.Category)
+	return nil
+}
+
+// validateReplicated checks a correction written in another region, which has already mapped
+// its category onto a base one
+func (f Feedback) validateReplicated(base map[string]bool) error {
+	switch {
+	case f.TransactionID == "" && f.Merchant == "":
+		return errors.New("transaction_id or merchant is required")
+	case !base[f.Category]:
+		return fmt.Errorf("unknown category %q", f.Category)
+	case f.UpdatedAt.IsZero():
+		return errors.New("updated_at is required")
+	}
+	return nil
+}
+
+// findStoredTransaction looks up a user's stored transaction by its ID or the bank's
+func (s *Server) findStoredTransaction(userID, id string) (StoredTransaction, bool) {
+	for _, tx := range s.store.ListTransactions(userID, time.Time{}, time.Time{}) {
+		if tx.ID == id || tx.TransactionID == id {
+			return tx, true
+		}
+	}
+	return StoredTransaction{}, false
+}
+
+// feedbackStore keeps category corrections in memory, one per transaction, with a log of local
+// writes for replication to peer regions
+type feedbackStore struct {
+	mu       sync.RWMutex
+	feedback map[string]Feedback
+	changes  []Feedback
+	// changesBase is the sequence number of changes[0]
+	changesBase int
+}
+
+// newFeedbackStore creates an empty store
+func newFeedbackStore() *feedbackStore {
+	return &feedbackStore{feedback: map[string]Feedback{}}
+}
+
+// Put stores a correction as a local write in region, returning the stored copy and whether it
+// was new, replaced an earlier correction or repeated one
+func (s *feedbackStore) Put(f Feedback, region string) (Feedback, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := f.key()
+	existing, ok := s.feedback[key]
+	if ok && existing.Category == f.Category {
+		return existing, FeedbackDuplicate
+	}
+	f.ID = newID("fb_")
+	f.CreatedAt = time.Now().UTC()
+	f.UpdatedAt = clock.Now()
+	f.Region = region
+	s.feedback[key] = f
+	s.changes = append(s.changes, f)
+	if ok {
+		return f, FeedbackUpdated
+	}
+	return f, FeedbackAccepted
+}
+
+// Merge applies a correction replicated from another region if it wins over the stored one,
+// reporting whether it was applied
+func (s *feedbackStore) Merge(f Feedback) bool {
+	clock.Observe(f.UpdatedAt)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.feedback[f.key()]; ok && !f.newerThan(existing) {
+		return false
+	}
+	s.feedback[f.key()] = f
+	return true
+}
+
+// ChangesSince returns local writes from sequence number seq onwards and the next sequence number
+func (s *feedbackStore) ChangesSince(seq int) ([]Feedback, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if seq < s.changesBase {
+		seq = s.changesBase
+	}
+	return append([]Feedback{}, s.changes[seq-s.changesBase:]...), s.changesBase + len(s.changes)
+}
+
+// TrimChanges drops local writes before seq once every peer has them
+func (s *feedbackStore) TrimChanges(seq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := seq - s.changesBase; n > 0 && n <= len(s.changes) {
+		s.changes = append([]Feedback{}, s.changes[n:]...)
+		s.changesBase = seq
+	}
+}
+
+// Lookup returns the stored correction for a key
+func (s *feedbackStore) Lookup(key string) (Feedback, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.feedback[key]
+	return f, ok
+}
+
+// List returns corrections, newest first, optionally for one user or tenant
+func (s *feedbackStore) List(userID, tenantID string) []Feedback {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Feedback, 0, len(s.feedback))
+	for _, f := range s.feedback {
+		if (userID == "" || f.UserID == userID) && (tenantID == "" || f.TenantID == tenantID) {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// Global category corrections
+var feedback = newFeedbackStore()
+
+// observeFeedback brings what is learned from corrections up to date with a stored one
+func (s *Server) observeFeedback(f Feedback) {
+	feedbackVotes.Record(f)
+	rulePrecision.Record(f)
+	s.observeStickiness(f)
+}
+
+// feedbackCSVColumns are the columns a feedback CSV may have, in any order; a header row
+// naming them is required
+var feedbackCSVColumns = map[string]func(*Feedback, string) error{
+	"user_id":        func(f *Feedback, v string) error { f.UserID = v; return nil },
+	"tenant_id":      func(f *Feedback, v string) error { f.TenantID = v; return nil },
+	"transaction_id": func(f *Feedback, v string) error { f.TransactionID = v; return nil },
+	"merchant":       func(f *Feedback, v string) error { f.Merchant = v; return nil },
+	"description":    func(f *Feedback, v string) error { f.Description = v; return nil },
+	"predicted":      func(f *Feedback, v string) error { f.Predicted = v; return nil },
+	"category":       func(f *Feedback, v string) error { f.Category = v; return nil },
+	"amount": func(f *Feedback, v string) error {
+		if v == "" {
+			return nil
+		}
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("amount %q is not a number", v)
+		}
+		f.Amount = amount
+		return nil
+	},
+}
+
+// parseFeedbackCSV reads corrections from CSV with a header row. Rows that can't be parsed are
+// returned as errors by row number rather than failing the whole file.
+func parseFeedbackCSV(r io.Reader) ([]Feedback, map[int]error, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("empty CSV")
+	}
+	header := records[0]
+	for _, column := range header {
+		if _, ok := feedbackCSVColumns[strings.TrimSpace(column)]; !ok {
+			return nil, nil, fmt.Errorf("unknown CSV column %q", column)
+		}
+	}
+
+	list := make([]Feedback, 0, len(records)-1)
+	rowErrors := map[int]error{}
+	for i, record := range records[1:] {
+		var f Feedback
+		if len(record) != len(header) {
+			rowErrors[i+1] = fmt.Errorf("row has %d fields, want %d", len(record), len(header))
+		}
+		for n := 0; n < len(record) && n < len(header); n++ {
+			if err := feedbackCSVColumns[strings.TrimSpace(header[n])](&f, strings.TrimSpace(record[n])); err != nil && rowErrors[i+1] == nil {
+				rowErrors[i+1] = err
+			}
+		}
+		list = append(list, f)
+	}
+	return list, rowErrors, nil
+}
+
+// FeedbackRowResult reports what happened to one row of a batch
+type FeedbackRowResult struct {
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// FeedbackBatchResult reports what a batch did, or would do in a dry run
+type FeedbackBatchResult struct {
+	DryRun    bool                `json:"dry_run"`
+	Total     int                 `json:"total"`
+	Accepted  int                 `json:"accepted"`
+	Updated   int                 `json:"updated"`
+	Duplicate int                 `json:"duplicate"`
+	Invalid   int                 `json:"invalid"`
+	Rows      []FeedbackRowResult `json:"rows"`
+}
+
+// ingestFeedback validates and stores each correction independently, skipping invalid rows and
+// repeats within the batch or of stored corrections. rowErrors are parse errors by row number.
+func (s *Server) ingestFeedback(list []Feedback, rowErrors map[int]error, dryRun bool) FeedbackBatchResult {
+	result := FeedbackBatchResult{DryRun: dryRun, Total: len(list), Rows: make([]FeedbackRowResult, 0, len(list))}
+	categories := s.knownCategories()
+	seen := map[string]string{}
+	for i := range list {
+		row := FeedbackRowResult{Row: i + 1}
+		err := rowErrors[i+1]
+		if err == nil {
+			err = list[i].validate(s, categories)
+		}
+		switch {
+		case err != nil:
+			row.Status, row.Error = FeedbackInvalid, err.Error()
+		case seen[list[i].key()] == list[i].Category:
+			row.Status = FeedbackDuplicate
+		case dryRun:
+			existing, ok := feedback.Lookup(list[i].key())
+			switch {
+			case seen[list[i].key()] != "" || ok && existing.Category != list[i].Category:
+				row.Status = FeedbackUpdated
+			case ok:
+				row.Status = FeedbackDuplicate
+			default:
+				row.Status = FeedbackAccepted
+			}
+		default:
+			var stored Feedback
+			stored, row.Status = feedback.Put(list[i], s.config.Region)
+			row.ID = stored.ID
+			if row.Status != FeedbackDuplicate {
+				s.observeFeedback(stored)
+			}
+		}
+		if err == nil {
+			seen[list[i].key()] = list[i].Category
+		}
+
+		switch row.Status {
+		case FeedbackAccepted:
+			result.Accepted++
+		case FeedbackUpdated:
+			result.Updated++
+		case FeedbackDuplicate:
+			result.Duplicate++
+		case FeedbackInvalid:
+			result.Invalid++
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result
+}
+
+// handleFeedback serves POST /feedback, one correction. A new correction is created; one that
+// repeats or replaces an earlier correction of the same transaction is reported as such.
+func (s *Server) handleFeedback(c *gin.Context) {
+	var f Feedback
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID, err := scopeTenant(c, f.TenantID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	f.TenantID = tenantID
+	if err := f.validate(s, s.knownCategories()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stored, status := feedback.Put(f, s.config.Region)
+	if status != FeedbackDuplicate {
+		s.observeFeedback(stored)
+	}
+	code := http.StatusOK
+	if status == FeedbackAccepted {
+		code = http.StatusCreated
+	}
+	c.JSON(code, gin.H{"status": status, "feedback": stored})
+}
+
+// handleFeedbackBatch serves POST /feedback/batch?format=json|csv&dry_run=true. JSON bodies are
+// an array of corrections; CSV bodies have a header row naming the columns. Each row is
+// accepted or rejected on its own and reported in the result.
+func (s *Server) handleFeedbackBatch(c *gin.Context) {
+	var list []Feedback
+	var rowErrors map[int]error
+	format := c.DefaultQuery("format", "json")
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		format = "csv"
+	}
+	switch strings.ToLower(format) {
+	case "json":
+		if err := json.NewDecoder(c.Request.Body).Decode(&list); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case "csv":
+		parsed, errs, err := parseFeedbackCSV(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		list, rowErrors = parsed, errs
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+	if len(list) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "batch is empty"})
+		return
+	}
+	if len(list) > maxFeedbackBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("batch has %d rows, at most %d are allowed", len(list), maxFeedbackBatch)})
+		return
+	}
+
+	for i := range list {
+		tenantID, err := scopeTenant(c, list[i].TenantID)
+		if err != nil {
+			if rowErrors == nil {
+				rowErrors = map[int]error{}
+			}
+			rowErrors[i+1] = err
+		}
+		list[i].TenantID = tenantID
+	}
+
+	c.JSON(http.StatusOK, s.ingestFeedback(list, rowErrors, c.Query("dry_run") == "true"))
+}
+
+// handleListFeedback serves GET /feedback?user_id=&tenant_id=&limit= (default 100)
+func (s *Server) handleListFeedback(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	tenantID, _ := scopeTenant(c, c.Query("tenant_id"))
+	list := feedback.List(c.Query("user_id"), tenantID)
+	total := len(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":    total,
+		"count":    len(list),
+		"feedback": list,
+	})
+}
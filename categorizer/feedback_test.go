@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFeedbackMerge checks a replicated correction replaces the stored one only when it was
+// written later, or at the same time in a higher region, and that local writes are logged
+// for replication
+func TestFeedbackMerge(t *testing.T) {
+	store := newFeedbackStore()
+	local, _ := store.Put(Feedback{UserID: "u1", TransactionID: "tx1", Category: "Groceries"}, "eu")
+	if changes, next := store.ChangesSince(0); len(changes) != 1 || next != 1 {
+		t.Fatalf("changes %v next %d, want the one local write", changes, next)
+	}
+
+	older := local
+	older.Category, older.Region, older.UpdatedAt = "Shopping", "us", local.UpdatedAt.Add(-time.Second)
+	if store.Merge(older) {
+		t.Errorf("merged a correction written before the stored one")
+	}
+
+	tied := local
+	tied.Category, tied.Region = "Eating Out", "us"
+	if !store.Merge(tied) {
+		t.Errorf("a tie from a higher region did not win")
+	}
+	if got, _ := store.Lookup(local.key()); got.Category != "Eating Out" {
+		t.Errorf("category %q after the tie, want Eating Out", got.Category)
+	}
+
+	if later := clock.Now(); !later.After(tied.UpdatedAt) {
+		t.Errorf("clock at %s did not move past the merged write at %s", later, tied.UpdatedAt)
+	}
+
+	store.TrimChanges(1)
+	if changes, next := store.ChangesSince(0); len(changes) != 0 || next != 1 {
+		t.Errorf("after trim: changes %v next %d, want none and 1", changes, next)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// FeedbackVote is the category a strict majority of the corrections for a merchant give it
+type FeedbackVote struct {
+	Scope    string `json:"scope"`
+	ScopeID  string `json:"scope_id,omitempty"`
+	Merchant string `json:"merchant"`
+	Category string `json:"category"`
+	// Votes is how many of the Total votes for the merchant gave the category: the user's
+	// corrections in a user's vote, and users in a tenant or global one
+	Votes int `json:"votes"`
+	Total int `json:"total"`
+}
+
+// Share returns the fraction of the merchant's corrections that voted for the category
+func (v FeedbackVote) Share() float64 {
+	return math.Round(float64(v.Votes)/float64(v.Total)*1000) / 1000
+}
+
+// feedbackTally counts corrections per category for one merchant in one scope
+type feedbackTally map[string]int
+
+// majority returns the category more than half of the tally votes for
+func (t feedbackTally) majority() (category string, votes, total int) {
+	categories := make([]string, 0, len(t))
+	for c, n := range t {
+		categories = append(categories, c)
+		total += n
+	}
+	sort.Strings(categories)
+	for _, c := range categories {
+		if t[c]*2 > total {
+			return c, t[c], total
+		}
+	}
+	return "", 0, total
+}
+
+// feedbackVoter tallies the stored corrections per merchant for each user, each tenant and
+// across everyone, updated as each correction is stored. A user's tally counts all of their
+// corrections; the tenant and global tallies count each user once, voting for their own
+// majority, so one user correcting many transactions can't outvote everyone else.
+type feedbackVoter struct {
+	mu      sync.RWMutex
+	tallies map[string]feedbackTally
+	// recorded is each stored correction as it was counted, by feedback key, so a
+	// replacement takes back exactly what the correction it replaces added
+	recorded map[string]recordedVote
+	// cast is the shared vote each user holds for a merchant, by user tally key
+	cast map[string]recordedVote
+}
+
+// recordedVote is a user's vote for a canonical merchant's category within a tenant
+type recordedVote struct {
+	userID, tenantID, merchant, category string
+}
+
+// newFeedbackVoter creates a voter with no corrections counted
+func newFeedbackVoter() *feedbackVoter {
+	return &feedbackVoter{
+		tallies:  map[string]feedbackTally{},
+		recorded: map[string]recordedVote{},
+		cast:     map[string]recordedVote{},
+	}
+}
+
+// voteKey identifies a merchant's tally within a scope
+func voteKey(scope, scopeID, merchant string) string {
+	return scope + "|" + scopeID + "|" + merchant
+}
+
+// Record counts a stored correction, replacing any earlier correction of the same
+// transaction. Corrections without a user or a merchant aren't counted.
+func (v *feedbackVoter) Record(f Feedback) {
+	vote := recordedVote{userID: f.UserID, tenantID: f.TenantID, merchant: resolveMerchant(normalizeDescriptor(f.Merchant)), category: f.Category}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if previous, ok := v.recorded[f.key()]; ok {
+		delete(v.recorded, f.key())
+		v.count(previous, -1)
+	}
+	if vote.userID == "" || vote.merchant == "" {
+		return
+	}
+	v.recorded[f.key()] = vote
+	v.count(vote, 1)
+}
+
+// count adds n of a vote to its user's tally, then moves the user's shared vote if their
+// majority changed. Callers must hold the lock.
+func (v *feedbackVoter) count(vote recordedVote, n int) {
+	userKey := voteKey(ScopeUser, vote.userID, vote.merchant)
+	v.add(userKey, vote.category, n)
+
+	held, ok := v.cast[userKey]
+	want := vote
+	if n < 0 && ok {
+		want.tenantID = held.tenantID
+	}
+	want.category, _, _ = v.tallies[userKey].majority()
+	if ok && held == want {
+		return
+	}
+	if ok {
+		v.share(held, -1)
+		delete(v.cast, userKey)
+	}
+	if want.category != "" {
+		v.share(want, 1)
+		v.cast[userKey] = want
+	}
+}
+
+// share adds n of a user's vote to the tenant and global tallies. Callers must hold the lock.
+func (v *feedbackVoter) share(vote recordedVote, n int) {
+	v.add(voteKey(ScopeGlobal, "", vote.merchant), vote.category, n)
+	if vote.tenantID != "" {
+		v.add(voteKey(ScopeTenant, vote.tenantID, vote.merchant), vote.category, n)
+	}
+}
+
+// add changes a tally's count for a category, forgetting counts and tallies that reach zero.
+// Callers must hold the lock.
+func (v *feedbackVoter) add(key, category string, n int) {
+	tally := v.tallies[key]
+	if tally == nil {
+		tally = feedbackTally{}
+		v.tallies[key] = tally
+	}
+	tally[category] += n
+	if tally[category] <= 0 {
+		delete(tally, category)
+	}
+	if len(tally) == 0 {
+		delete(v.tallies, key)
+	}
+}
+
+// Vote returns the majority category for a canonical merchant: from the user's own
+// corrections when most of them agree, otherwise from the tenant's and then everyone's once
+// minVotes users have voted. 0 turns the tenant and global votes off.
+func (v *feedbackVoter) Vote(userID, tenantID, merchant string, minVotes int) (FeedbackVote, bool) {
+	if merchant == "" {
+		return FeedbackVote{}, false
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	scopes := []struct {
+		scope, id string
+		minVotes  int
+	}{
+		{ScopeUser, userID, 1},
+		{ScopeTenant, tenantID, minVotes},
+		{ScopeGlobal, "", minVotes},
+	}
+	for _, s := range scopes {
+		if s.scope != ScopeGlobal && s.id == "" || s.minVotes <= 0 {
+			continue
+		}
+		tally, ok := v.tallies[voteKey(s.scope, s.id, merchant)]
+		if !ok {
+			continue
+		}
+		category, votes, total := tally.majority()
+		if category == "" || total < s.minVotes {
+			continue
+		}
+		return FeedbackVote{Scope: s.scope, ScopeID: s.id, Merchant: merchant, Category: category, Votes: votes, Total: total}, true
+	}
+	return FeedbackVote{}, false
+}
+
+// Global feedback votes
+var feedbackVotes = newFeedbackVoter()
+
+// feedbackStage categorizes a merchant as the corrections reported through /feedback vote,
+// ahead of the keyword rules, so the service learns from the mistakes users correct
+type feedbackStage struct {
+	s *Server
+}
+
+func (feedbackStage) Name() string { return "feedback" }
+
+func (feedbackStage) Backend() string { return "feedback" }
+
+func (st feedbackStage) Process(cl *Classification) {
+	if cl.Decided {
+		return
+	}
+	if vote, ok := feedbackVotes.Vote(cl.UserID, cl.TenantID, cl.NormalizedMerchant, st.s.config.FeedbackMinVotes); ok {
+		cl.Feedback = &vote
+		cl.decide(vote.Category)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+// Categories for bank charges and zero-amount card checks
+const (
+	CategoryFees             = "Fees"
+	CategoryCardVerification = "Card Verification"
+)
+
+// Fee types reported alongside the Fees category
+const (
+	FeeOverdraft   = "overdraft"
+	FeeFX          = "fx"
+	FeeLate        = "late"
+	FeeReturned    = "returned_payment"
+	FeeCashAdvance = "cash_advance"
+	FeeAccount     = "account"
+)
+
+// feeKeywords are bank and card charge descriptors and the type of fee each indicates, in
+// match order. Bare "fee" is left out since it would match "coffee".
+var feeKeywords = []struct {
+	keyword string
+	feeType string
+}{
+	{"unarranged overdraft", FeeOverdraft},
+	{"overdraft fee", FeeOverdraft},
+	{"overdraft interest", FeeOverdraft},
+	{"overdraft charge", FeeOverdraft},
+	{"non-sterling transaction fee", FeeFX},
+	{"non-sterling fee", FeeFX},
+	{"foreign transaction fee", FeeFX},
+	{"currency conversion fee", FeeFX},
+	{"fx fee", FeeFX},
+	{"late payment fee", FeeLate},
+	{"late fee", FeeLate},
+	{"returned payment fee", FeeReturned},
+	{"unpaid item fee", FeeReturned},
+	{"cash advance fee", FeeCashAdvance},
+	{"atm fee", FeeCashAdvance},
+	{"monthly account fee", FeeAccount},
+	{"card replacement fee", FeeAccount},
+}
+
+// cardVerificationKeywords describe small charges made only to check a card is valid
+var cardVerificationKeywords = []string{"card verification", "account verification", "verification charge"}
+
+// feeRuleKeywords lists the fee descriptors for the built-in Fees rule
+func feeRuleKeywords() []string {
+	keywords := make([]string, 0, len(feeKeywords))
+	for _, f := range feeKeywords {
+		keywords = append(keywords, f.keyword)
+	}
+	return keywords
+}
+
+// feeTypeFor returns the type of fee a Fees rule keyword indicates
+func feeTypeFor(keyword string) string {
+	for _, f := range feeKeywords {
+		if f.keyword == keyword {
+			return f.feeType
+		}
+	}
+	return ""
+}
+
+// classificationFeeType returns the fee type of a transaction categorized as Fees
+func classificationFeeType(cl Classification) string {
+	if cl.Category != CategoryFees {
+		return ""
+	}
+	return feeTypeFor(cl.Keyword)
+}
+
+// isChargeCategory reports whether a category is a bank charge or card check rather than a
+// purchase, so purchase offers like cashback don't apply
+func isChargeCategory(category string) bool {
+	return category == CategoryFees || category == CategoryCardVerification
+}
+
+// zeroAmountStage categorizes zero-amount transactions, which are card authorizations and
+// account verification checks rather than spending, before overrides or rules see them
+type zeroAmountStage struct{}
+
+func (zeroAmountStage) Name() string { return "zero_amount" }
+
+func (zeroAmountStage) Backend() string { return "verification" }
+
+func (zeroAmountStage) Process(cl *Classification) {
+	if cl.Decided || cl.Amount != 0 {
+		return
+	}
+	cl.decide(CategoryCardVerification)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// FuzzyOptions controls edit-distance tolerant keyword matching
+type FuzzyOptions struct {
+	MaxDistance int
+	MinLength   int
+}
+
+// Enabled reports whether fuzzy matching is switched on
+func (o FuzzyOptions) Enabled() bool {
+	return o.MaxDistance > 0
+}
+
+// tokenize splits lowercased text into letter/digit runs
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// MatchFuzzy returns the first single-word keyword of the rule within the allowed edit
+// distance of a token in the lowercased merchant or description
+func (r Rule) MatchFuzzy(merchantLower, descriptionLower string, amount float64, opts FuzzyOptions) (string, bool) {
+	if !r.matchesAmount(amount) {
+		return "", false
+	}
+
+	var tokens []string
+	if r.matchesField(FieldMerchant) {
+		tokens = append(tokens, tokenize(merchantLower)...)
+	}
+	if r.matchesField(FieldDescription) {
+		tokens = append(tokens, tokenize(descriptionLower)...)
+	}
+
+	for _, keyword := range r.Keywords {
+		if len([]rune(keyword)) < opts.MinLength || strings.ContainsRune(keyword, ' ') {
+			continue
+		}
+		for _, token := range tokens {
+			if len([]rune(token)) < opts.MinLength {
+				continue
+			}
+			if levenshtein(token, keyword) <= opts.MaxDistance {
+				return keyword, true
+			}
+		}
+	}
+	return "", false
+}
+
+// MatchFuzzyAll returns every rule with a keyword within edit distance of the transaction
+func (rs *RuleSet) MatchFuzzyAll(merchant, description string, amount float64, opts FuzzyOptions) []RuleHit {
+	merchantLower := strings.ToLower(merchant)
+	descriptionLower := strings.ToLower(description)
+	var hits []RuleHit
+	for i := range rs.Rules {
+		if keyword, ok := rs.Rules[i].MatchFuzzy(merchantLower, descriptionLower, amount, opts); ok {
+			hits = append(hits, RuleHit{Rule: &rs.Rules[i], Keyword: keyword})
+		}
+	}
+	return hits
+}
+
+// Active fuzzy matching settings, set from the configuration at startup and off unless
+// configured
+var fuzzyMatching FuzzyOptions
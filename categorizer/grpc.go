@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The gRPC API defined in proto/categorizer.proto. It is served without generated code: the
+// handler speaks unary gRPC over cleartext HTTP/2 and encodes the messages with protowire,
+// as the remote-write exporter does.
+
+// grpcServicePrefix is the path prefix of the Categorizer service's methods
+const grpcServicePrefix = "/categorizer.v1.Categorizer/"
+
+// maxGRPCMessage bounds a request message, as gRPC servers do by default
+const maxGRPCMessage = 4 << 20
+
+// gRPC status codes the handler returns
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// Timeouts for the gRPC server's connections. Calls are unary with small messages, so a
+// client that is slow to send or read one is cut off rather than left holding a connection.
+const (
+	grpcReadHeaderTimeout = 10 * time.Second
+	grpcReadTimeout       = 30 * time.Second
+	grpcWriteTimeout      = 30 * time.Second
+	grpcIdleTimeout       = 2 * time.Minute
+)
+
+// grpcError is an RPC failure with its gRPC status code
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string { return e.message }
+
+// grpcErrorf returns a gRPC error with a formatted message
+func grpcErrorf(code int, format string, args ...interface{}) *grpcError {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// GRPCHandler returns the gRPC API, accepting HTTP/2 without TLS as internal callers send
+// it. Calls go through the request logger, metrics and API group middleware, so they are rate
+// limited, size limited, authenticated and scoped to the caller's tenant as REST requests
+// are. The rate limit is a separate allowance from the REST API's.
+func (s *Server) GRPCHandler() http.Handler {
+	r := s.engine()
+	rpcs := r.Group("", append([]gin.HandlerFunc{grpcStatus()}, s.apiMiddleware()...)...)
+	rpcs.POST(grpcServicePrefix+":method", s.serveGRPC)
+	r.NoRoute(func(c *gin.Context) {
+		writeGRPCError(c, grpcErrorf(grpcUnimplemented, "unknown method %s", c.Request.URL.Path))
+	})
+	return h2c.NewHandler(onlyGRPC(r), &http2.Server{})
+}
+
+// RunGRPC serves the gRPC API on addr until it fails
+func (s *Server) RunGRPC(addr string) error {
+	s.logger.Info("gRPC server started and listening", map[string]interface{}{
+		"port":       strings.TrimPrefix(addr, ":"),
+		"event_type": "server_ready",
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.GRPCHandler(),
+		ReadHeaderTimeout: grpcReadHeaderTimeout,
+		ReadTimeout:       grpcReadTimeout,
+		WriteTimeout:      grpcWriteTimeout,
+		IdleTimeout:       grpcIdleTimeout,
+	}
+	return server.ListenAndServe()
+}
+
+// onlyGRPC refuses requests that aren't gRPC calls before they reach next
+func onlyGRPC(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "this port serves gRPC only", http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcStatus makes the JSON errors the API middleware rejects calls with into gRPC statuses
+func grpcStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = grpcStatusWriter{c.Writer}
+		c.Next()
+	}
+}
+
+// grpcStatusWriter replaces the body of a rejected call with a gRPC status carrying its
+// error. The HTTP status is kept, for the metrics and for clients that go by it.
+type grpcStatusWriter struct {
+	gin.ResponseWriter
+}
+
+func (w grpcStatusWriter) Write(body []byte) (int, error) {
+	status := w.Status()
+	if status == http.StatusOK {
+		return w.ResponseWriter.Write(body)
+	}
+	var rejection struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &rejection)
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcCodeForHTTP(status)))
+	w.Header().Set("Grpc-Message", grpcPercentEncode(rejection.Error))
+	w.WriteHeaderNow()
+	return len(body), nil
+}
+
+// grpcCodeForHTTP returns the gRPC status code for a middleware rejection's HTTP status
+func grpcCodeForHTTP(status int) int {
+	switch status {
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusRequestEntityTooLarge:
+		return grpcResourceExhausted
+	case http.StatusTooManyRequests:
+		return grpcUnavailable
+	}
+	return grpcUnknown
+}
+
+// serveGRPC runs the method a call names once the middleware has admitted it
+func (s *Server) serveGRPC(c *gin.Context) {
+	method := c.Param("method")
+	s.addLogFields(c, map[string]interface{}{"rpc": method})
+
+	response, err := s.callGRPC(c, method)
+	if err != nil {
+		writeGRPCError(c, err)
+		return
+	}
+	c.Header("Content-Type", "application/grpc")
+	c.Header("Trailer", "Grpc-Status, Grpc-Message")
+	c.Status(http.StatusOK)
+	c.Writer.Write(grpcFrame(response))
+	c.Writer.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+}
+
+// writeGRPCError answers a call with a trailers-only response: the status goes in the
+// headers with no message
+func writeGRPCError(c *gin.Context, err error) {
+	status, ok := err.(*grpcError)
+	if !ok {
+		status = &grpcError{code: grpcInternal, message: err.Error()}
+	}
+	c.Header("Content-Type", "application/grpc")
+	c.Header("Grpc-Status", strconv.Itoa(status.code))
+	c.Header("Grpc-Message", grpcPercentEncode(status.message))
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+}
+
+// callGRPC runs the method a call names, returning the encoded response
+func (s *Server) callGRPC(c *gin.Context, method string) ([]byte, error) {
+	message, err := readGRPCMessage(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := requestRuleSet(c, s.classifier)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%s", err)
+	}
+
+	switch method {
+	case "Categorize":
+		return s.grpcCategorize(c, message, rs)
+	case "CategorizeBatch":
+		return s.grpcCategorizeBatch(c, message, rs)
+	}
+	return nil, grpcErrorf(grpcUnimplemented, "unknown method %s", c.Request.URL.Path)
+}
+
+// grpcCategorize serves Categorize
+func (s *Server) grpcCategorize(c *gin.Context, message []byte, rs *RuleSet) ([]byte, error) {
+	start := time.Now()
+	var transaction []byte
+	var opts categorizeOptions
+	err := protoFields(message, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			transaction, err = f.bytes()
+		case 2:
+			opts, err = decodeCategorizeOptions(f)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%s", err)
+	}
+	req, err := s.grpcTransactionRequest(c, transaction)
+	if errors.Is(err, errTenantForbidden) {
+		return nil, grpcErrorf(grpcPermissionDenied, "%s", err)
+	}
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%s", err)
+	}
+	response := s.categorize(c.Request.Context(), req, rs, opts, start)
+	return encodeCategoryResponse(response), nil
+}
+
+// grpcCategorizeBatch serves CategorizeBatch
+func (s *Server) grpcCategorizeBatch(c *gin.Context, message []byte, rs *RuleSet) ([]byte, error) {
+	var transactions [][]byte
+	var opts categorizeOptions
+	err := protoFields(message, func(f protoField) error {
+		switch f.num {
+		case 1:
+			transaction, err := f.bytes()
+			transactions = append(transactions, transaction)
+			return err
+		case 2:
+			var err error
+			opts, err = decodeCategorizeOptions(f)
+			return err
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return nil, grpcErrorf(grpcInvalidArgument, "%s", err)
+	case len(transactions) == 0:
+		return nil, grpcErrorf(grpcInvalidArgument, "batch is empty")
+	case len(transactions) > maxCategorizeBatch:
+		return nil, grpcErrorf(grpcInvalidArgument, "batch has %d transactions, at most %d are allowed", len(transactions), maxCategorizeBatch)
+	}
+
+	var out []byte
+	failed := 0
+	for i, transaction := range transactions {
+		start := time.Now()
+		var result []byte
+		result = protowire.AppendTag(result, 1, protowire.VarintType)
+		result = protowire.AppendVarint(result, uint64(i))
+		req, err := s.grpcTransactionRequest(c, transaction)
+		if err != nil {
+			result = appendProtoString(result, 2, err.Error())
+			failed++
+		} else {
+			response := s.categorize(c.Request.Context(), req, rs, opts, start)
+			result = appendProtoMessage(result, 3, encodeCategoryResponse(response))
+		}
+		out = appendProtoMessage(out, 1, result)
+	}
+	out = appendProtoVarint(out, 2, uint64(len(transactions)-failed))
+	out = appendProtoVarint(out, 3, uint64(failed))
+	return out, nil
+}
+
+// grpcTransactionRequest decodes and validates a Transaction message as the REST API validates
+// its JSON body, scoping it to the caller's tenant and recording and logging the
+// transactions it rejects
+func (s *Server) grpcTransactionRequest(c *gin.Context, message []byte) (TransactionRequest, error) {
+	req, err := decodeTransaction(message)
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&req)
+	}
+	if err == nil {
+		err = s.normalizeTransaction(c, &req)
+	}
+	if err != nil {
+		s.metrics.recordCategorizationError("bad_request")
+		s.requestLogger(c).logCategorizationError("bad_request", err.Error())
+	}
+	return req, err
+}
+
+// readGRPCMessage reads the single length-prefixed message of a unary request
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, grpcReadError(err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxGRPCMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message is %d bytes, at most %d are allowed", length, maxGRPCMessage)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, grpcReadError(err)
+	}
+	return message, nil
+}
+
+// grpcReadError reports a failure to read a request message, which is the body size limit
+// when the middleware's limit cut it off
+func grpcReadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return grpcErrorf(grpcResourceExhausted, "request body exceeds %d bytes", tooLarge.Limit)
+	}
+	return grpcErrorf(grpcInvalidArgument, "reading message: %s", err)
+}
+
+// grpcFrame prefixes an uncompressed message with its length
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcPercentEncode encodes a status message for the grpc-message header, which allows
+// printable ASCII other than "%"
+func grpcPercentEncode(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// protoField is one field of an encoded protobuf message
+type protoField struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value []byte
+}
+
+// protoFields calls visit for each field of an encoded message in order
+func protoFields(message []byte, visit func(protoField) error) error {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		n = protowire.ConsumeFieldValue(num, typ, message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := visit(protoField{num: num, typ: typ, value: message[:n]}); err != nil {
+			return err
+		}
+		message = message[n:]
+	}
+	return nil
+}
+
+// wireTypeError reports a field sent with a different wire type than the schema's
+func (f protoField) wireTypeError() error {
+	return fmt.Errorf("field %d has wire type %d", f.num, f.typ)
+}
+
+// bytes returns a length-delimited field's contents: a string, bytes or a message
+func (f protoField) bytes() ([]byte, error) {
+	if f.typ != protowire.BytesType {
+		return nil, f.wireTypeError()
+	}
+	b, _ := protowire.ConsumeBytes(f.value)
+	return b, nil
+}
+
+// string returns a string field
+func (f protoField) string() (string, error) {
+	b, err := f.bytes()
+	return string(b), err
+}
+
+// double returns a double field
+func (f protoField) double() (float64, error) {
+	if f.typ != protowire.Fixed64Type {
+		return 0, f.wireTypeError()
+	}
+	v, _ := protowire.ConsumeFixed64(f.value)
+	return math.Float64frombits(v), nil
+}
+
+// int32 returns an int32 field
+func (f protoField) int32() (int32, error) {
+	if f.typ != protowire.VarintType {
+		return 0, f.wireTypeError()
+	}
+	v, _ := protowire.ConsumeVarint(f.value)
+	return int32(v), nil
+}
+
+// decodeTransaction decodes a Transaction message
+func decodeTransaction(message []byte) (TransactionRequest, error) {
+	var req TransactionRequest
+	strings := map[protowire.Number]*string{
+		1: &req.Merchant, 2: &req.Description, 4: &req.TransactionType, 5: &req.UserID,
+		6: &req.TenantID, 7: &req.TransactionID, 8: &req.MCC, 10: &req.Status,
+		11: &req.DeclineReason, 12: &req.Country, 13: &req.Location, 14: &req.SignConvention,
+		15: &req.DedupeHash,
+	}
+	err := protoFields(message, func(f protoField) (err error) {
+		switch f.num {
+		case 3:
+			req.Amount, err = f.double()
+		case 9:
+			var createdAt string
+			if createdAt, err = f.string(); err == nil && createdAt != "" {
+				if req.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+					err = fmt.Errorf("created_at must be an RFC 3339 time: %w", err)
+				}
+			}
+		default:
+			if field, ok := strings[f.num]; ok {
+				*field, err = f.string()
+			}
+		}
+		return err
+	})
+	return req, err
+}
+
+// decodeCategorizeOptions decodes an Options field
+func decodeCategorizeOptions(f protoField) (categorizeOptions, error) {
+	opts := categorizeOptions{Include: map[string]bool{}}
+	message, err := f.bytes()
+	if err != nil {
+		return opts, err
+	}
+	err = protoFields(message, func(f protoField) error {
+		switch f.num {
+		case 1:
+			top, err := f.int32()
+			if err == nil && top < 0 {
+				err = fmt.Errorf("top must not be negative")
+			}
+			opts.Top = int(top)
+			return err
+		case 2:
+			include, err := f.string()
+			opts.Include[include] = true
+			return err
+		}
+		return nil
+	})
+	return opts, err
+}
+
+// encodeCategoryResponse encodes a CategorizeResponse message
+func encodeCategoryResponse(r CategoryResponse) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, r.Category)
+	b = appendProtoDouble(b, 2, r.Confidence)
+	for _, cs := range r.Alternatives {
+		b = appendProtoMessage(b, 3, encodeCategoryScore(cs))
+	}
+	for _, cs := range r.Candidates {
+		b = appendProtoMessage(b, 4, encodeCategoryScore(cs))
+	}
+	b = appendProtoBool(b, 5, r.ReviewRequired)
+	b = appendProtoString(b, 6, r.FeeType)
+	b = appendProtoString(b, 7, r.IncomeType)
+	b = appendProtoString(b, 8, r.InvestmentType)
+	for _, flag := range r.RiskFlags {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendString(b, flag)
+	}
+	b = appendProtoBool(b, 10, r.Duplicate)
+	b = appendProtoString(b, 11, r.DuplicateOf)
+	b = appendProtoBool(b, 12, r.Updated)
+	b = appendProtoString(b, 13, r.DeclineReason)
+	b = appendProtoBool(b, 14, r.Blocked)
+	b = appendProtoString(b, 15, r.BlockID)
+	b = appendProtoDouble(b, 16, r.RoundUp)
+	b = appendProtoString(b, 17, r.BNPLProvider)
+	b = appendProtoString(b, 18, r.CanonicalCategory)
+	return b
+}
+
+// encodeCategoryScore encodes a CategoryScore message
+func encodeCategoryScore(cs CategoryScore) []byte {
+	b := appendProtoString(nil, 1, cs.Category)
+	return appendProtoDouble(b, 2, cs.Score)
+}
+
+// The appendProto helpers encode a field, leaving out proto3 default values
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendProtoVarint(b, num, 1)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
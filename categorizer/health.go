@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pinger is a dependency that can report whether it is reachable
+type Pinger interface {
+	Ping() error
+}
+
+// DependencyCheck is one readiness check against a dependency. Required dependencies are
+// waited for at startup; peers aren't, since they may be waiting for this instance too.
+type DependencyCheck struct {
+	Name     string
+	Ping     func() error
+	Required bool
+}
+
+// dependencyChecks returns a check for every external dependency the service is configured
+// to use
+func (s *Server) dependencyChecks() []DependencyCheck {
+	var checks []DependencyCheck
+	if p, ok := potDepositor.(Pinger); ok {
+		checks = append(checks, DependencyCheck{Name: "monzo", Ping: p.Ping, Required: true})
+	}
+	if p, ok := emailSender.(Pinger); ok {
+		checks = append(checks, DependencyCheck{Name: "smtp", Ping: p.Ping, Required: true})
+	}
+	for _, peer := range s.config.PeerURLs {
+		checks = append(checks, DependencyCheck{Name: "peer:" + peer, Ping: peerPinger(peer)})
+	}
+	return checks
+}
+
+// healthClient probes dependencies over HTTP
+var healthClient = &http.Client{Timeout: 5 * time.Second}
+
+// peerPinger checks a replication peer's health endpoint
+func peerPinger(peer string) func() error {
+	return func() error {
+		resp, err := healthClient.Get(strings.TrimRight(peer, "/") + "/health")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// CheckResult is the outcome of one dependency check
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadinessResult is the outcome of one readiness run over every dependency
+type ReadinessResult struct {
+	CheckedAt time.Time     `json:"checked_at"`
+	Ready     bool          `json:"ready"`
+	Failures  int           `json:"failures"`
+	LatencyMs float64       `json:"latency_ms"`
+	Checks    []CheckResult `json:"checks"`
+	Startup   *StartupState `json:"startup,omitempty"`
+}
+
+// runReadinessChecks checks every dependency concurrently
+func runReadinessChecks(checks []DependencyCheck) ReadinessResult {
+	start := time.Now()
+	result := ReadinessResult{CheckedAt: start.UTC(), Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check DependencyCheck) {
+			defer wg.Done()
+			checkStart := time.Now()
+			err := check.Ping()
+			result.Checks[i] = CheckResult{
+				Name:      check.Name,
+				Healthy:   err == nil,
+				LatencyMs: float64(time.Since(checkStart).Microseconds()) / 1e3,
+			}
+			if err != nil {
+				result.Checks[i].Error = err.Error()
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, check := range result.Checks {
+		if !check.Healthy {
+			result.Failures++
+		}
+	}
+	result.Ready = result.Failures == 0
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1e3
+	return result
+}
+
+// healthHistory keeps the most recent readiness results in a fixed-size ring
+type healthHistory struct {
+	mu      sync.Mutex
+	results []ReadinessResult
+	next    int
+	full    bool
+}
+
+// newHealthHistory creates a history holding up to size results
+func newHealthHistory(size int) *healthHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &healthHistory{results: make([]ReadinessResult, size)}
+}
+
+// Add records a result, overwriting the oldest once the ring is full
+func (h *healthHistory) Add(result ReadinessResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.results[h.next] = result
+	h.next = (h.next + 1) % len(h.results)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// List returns the recorded results, newest first
+func (h *healthHistory) List() []ReadinessResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count := h.next
+	if h.full {
+		count = len(h.results)
+	}
+	list := make([]ReadinessResult, 0, count)
+	for i := 1; i <= count; i++ {
+		list = append(list, h.results[(h.next-i+len(h.results))%len(h.results)])
+	}
+	return list
+}
+
+// Latest returns the most recent result, if any
+func (h *healthHistory) Latest() (ReadinessResult, bool) {
+	list := h.List()
+	if len(list) == 0 {
+		return ReadinessResult{}, false
+	}
+	return list[0], true
+}
+
+// Global readiness history, sized from the configuration at startup
+var readiness *healthHistory
+
+// checkReadiness runs the readiness checks and records the result
+func (s *Server) checkReadiness() ReadinessResult {
+	result := runReadinessChecks(s.dependencyChecks())
+	if state := startup.State(); state.Phase != startupReady {
+		result.Startup = &state
+		result.Ready = false
+	}
+	readiness.Add(result)
+	if previous := readiness.List(); len(previous) > 1 && previous[1].Ready != result.Ready {
+		message := "Service became ready"
+		if !result.Ready {
+			message = "Service became not ready"
+		}
+		s.logger.Warn(message, map[string]interface{}{
+			"event_type": "readiness_changed",
+		})
+	}
+	return result
+}
+
+// startReadinessChecks checks dependencies now and then on every interval
+func (s *Server) startReadinessChecks(interval time.Duration) {
+	go func() {
+		s.checkReadiness()
+		for range time.Tick(interval) {
+			s.checkReadiness()
+		}
+	}()
+}
+
+// handleReady serves GET /healthz/ready from the latest readiness result, answering 503
+// while a dependency is failing
+func (s *Server) handleReady(c *gin.Context) {
+	result, ok := readiness.Latest()
+	if !ok {
+		result = s.checkReadiness()
+	}
+	status := http.StatusOK
+	if !result.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, result)
+}
+
+// handleHealthHistory serves GET /healthz/history with the recent readiness results, newest first
+func (s *Server) handleHealthHistory(c *gin.Context) {
+	history := readiness.List()
+	c.JSON(http.StatusOK, gin.H{
+		"interval": s.config.ReadinessInterval.String(),
+		"count":    len(history),
+		"results":  history,
+	})
+}
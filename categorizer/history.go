@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTimeParam parses a date (YYYY-MM-DD) or RFC3339 timestamp. Dates used as the end
+// of a range cover the whole day.
+func parseTimeParam(value string, endOfRange bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected YYYY-MM-DD or RFC3339", value)
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseTimeRange reads the from/to query parameters of a request
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, error) {
+	from, err := parseTimeParam(c.Query("from"), false)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseTimeParam(c.Query("to"), true)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
+// handleHistory serves GET /history
+func (s *Server) handleHistory(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	view, err := requestView(c, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	transactions := s.store.ListTransactions(userID, from, to)
+	if view != nil {
+		transactions = view.Filter(transactions)
+	}
+	if c.Query("exclude_declined") == "true" {
+		transactions = withoutDeclined(transactions)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      userID,
+		"count":        len(transactions),
+		"transactions": transactions,
+	})
+}
+
+// withoutDeclined filters declined transactions out of a history
+func withoutDeclined(transactions []StoredTransaction) []StoredTransaction {
+	kept := make([]StoredTransaction, 0, len(transactions))
+	for _, tx := range transactions {
+		if tx.Status != StatusDeclined {
+			kept = append(kept, tx)
+		}
+	}
+	return kept
+}
+
+// recordHistory stores a categorized transaction in its user's history. Settlements and
+// declines of a pending transaction update it in place and emit an update event; repeated
+// submissions are flagged as duplicates on the response.
+func (s *Server) recordHistory(req TransactionRequest, category, decidedBy string, response *CategoryResponse) {
+	tx := StoredTransaction{
+		UserID:          req.UserID,
+		Merchant:        req.Merchant,
+		Description:     req.Description,
+		Amount:          req.Amount,
+		TransactionType: req.TransactionType,
+		MCC:             req.MCC,
+		Category:        category,
+		DecidedBy:       decidedBy,
+		CreatedAt:       req.CreatedAt,
+		TransactionID:   req.TransactionID,
+		DedupeHash:      req.DedupeHash,
+		Status:          req.Status,
+		Location:        req.Location,
+		Country:         req.Country,
+		TenantID:        req.TenantID,
+		BNPLProvider:    response.BNPLProvider,
+	}
+	if tx.Status == "" {
+		tx.Status = StatusSettled
+	}
+	if response.CanonicalCategory != "" {
+		tx.TenantCategory = response.Category
+	}
+	if tx.Status == StatusDeclined {
+		tx.DeclineReason = categorizeDeclineReason(req.DeclineReason)
+	}
+
+	if updated, previous, ok := s.store.ResolvePending(tx); ok {
+		response.Updated = true
+		s.depositRoundUp(updated)
+		s.queueWriteBack(updated)
+		s.emitEvent(EventTransactionUpdated, req.TenantID, gin.H{
+			"transaction": updated,
+			"previous":    previous,
+		})
+		return
+	}
+
+	stored := s.store.AddTransaction(tx)
+	if stored.Duplicate {
+		s.metrics.recordDuplicate()
+		response.Duplicate = true
+		response.DuplicateOf = stored.DuplicateOf
+		return
+	}
+	s.depositRoundUp(stored)
+	s.queueWriteBack(stored)
+	s.emitEvent(EventTransactionCategorized, req.TenantID, gin.H{"transaction": stored})
+}
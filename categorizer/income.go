@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	CategoryIncome    = "Income"
+	CategoryTransfers = "Transfers"
+)
+
+// Income types, the sub-taxonomy of Income. IncomeTransfer is money moved between the user's
+// own accounts and is categorized as Transfers rather than Income.
+const (
+	IncomeSalary   = "salary"
+	IncomeBenefits = "benefits"
+	IncomeInterest = "interest"
+	IncomeRefund   = "refund"
+	IncomeOther    = "other"
+	IncomeTransfer = "transfer"
+)
+
+// incomeTypes are the types an income rule may assign
+var incomeTypes = map[string]bool{
+	IncomeSalary: true, IncomeBenefits: true, IncomeInterest: true, IncomeRefund: true, IncomeOther: true, IncomeTransfer: true,
+}
+
+// IncomeRule types a credit whose counterparty (the merchant) or reference (the description)
+// matches one of its patterns. Employers are counterparties of a salary rule.
+type IncomeRule struct {
+	Type           string   `json:"type"`
+	Counterparties []string `json:"counterparties,omitempty"`
+	References     []string `json:"references,omitempty"`
+
+	counterparties []*regexp.Regexp
+	references     []*regexp.Regexp
+}
+
+// compile checks the rule and compiles its patterns, case-insensitively
+func (r *IncomeRule) compile() error {
+	if !incomeTypes[r.Type] {
+		return fmt.Errorf("unknown income type %q", r.Type)
+	}
+	if len(r.Counterparties) == 0 && len(r.References) == 0 {
+		return fmt.Errorf("%s rule needs counterparties or references", r.Type)
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		compiled := make([]*regexp.Regexp, 0, len(patterns))
+		for _, pattern := range patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %w", r.Type, pattern, err)
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+	var err error
+	if r.counterparties, err = compile(r.Counterparties); err != nil {
+		return err
+	}
+	r.references, err = compile(r.References)
+	return err
+}
+
+// Matches reports whether a normalized counterparty or reference matches the rule
+func (r IncomeRule) Matches(counterparty, reference string) bool {
+	for _, re := range r.counterparties {
+		if re.MatchString(counterparty) {
+			return true
+		}
+	}
+	for _, re := range r.references {
+		if re.MatchString(reference) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultIncomeRules are tried in order, so a refund from HMRC is a refund rather than benefits
+var defaultIncomeRules = []IncomeRule{
+	{Type: IncomeTransfer, References: []string{`\b(transfer from|tfr from|from savings|from isa|pot withdrawal|own account)\b`}},
+	{Type: IncomeRefund, References: []string{`\b(refund|reversal|chargeback|cashback|returned payment)\b`}},
+	{Type: IncomeSalary, References: []string{`\b(payroll|salary|wages|sal|pay ?slip|bacs pay)\b`}},
+	{Type: IncomeBenefits, Counterparties: []string{`\b(dwp|dwp uc|child benefit|tax credits?)\b`},
+		References: []string{`\b(universal credit|child benefit|pip|jsa|esa|tax credits?|state pension|pension credit)\b`}},
+	{Type: IncomeInterest, References: []string{`\b(interest|int paid|gross int|dividend)\b`}},
+}
+
+// incomeRules are the active income rules
+var incomeRules = mustCompileIncomeRules(defaultIncomeRules)
+
+// mustCompileIncomeRules compiles the built-in rules, which are known to be valid
+func mustCompileIncomeRules(rules []IncomeRule) []IncomeRule {
+	compiled := append([]IncomeRule{}, rules...)
+	for i := range compiled {
+		if err := compiled[i].compile(); err != nil {
+			panic(err)
+		}
+	}
+	return compiled
+}
+
+// loadIncomeRules replaces the income rules with a JSON array read from path
+func loadIncomeRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var rules []IncomeRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return fmt.Errorf("income rule %d: %w", i, err)
+		}
+	}
+
+	incomeRules = rules
+	return nil
+}
+
+// Payment intervals regular income arrives at: weekly, fortnightly, four-weekly and monthly
+var regularIncomeIntervals = [][2]time.Duration{
+	{6 * 24 * time.Hour, 8 * 24 * time.Hour},
+	{13 * 24 * time.Hour, 15 * 24 * time.Hour},
+	{26 * 24 * time.Hour, 35 * 24 * time.Hour},
+}
+
+// regularIncomeTolerance is how far a regular payment's amount may drift from the latest one
+const regularIncomeTolerance = 0.25
+
+// isRegularIncome reports whether a credit paid at the given time continues a regular run of
+// similar payments from the same counterparty in the user's history, the shape of a salary
+// without a payroll reference. It takes config.IncomeRegularity earlier payments; 0 turns the
+// check off.
+func (s *Server) isRegularIncome(cl *Classification, at time.Time) bool {
+	needed := s.config.IncomeRegularity
+	if needed <= 0 || cl.UserID == "" || cl.NormalizedMerchant == "" {
+		return false
+	}
+	var previous []StoredTransaction
+	for _, tx := range s.store.ListTransactions(cl.UserID, at.AddDate(0, 0, -35*(needed+1)), at) {
+		if strings.ToLower(tx.TransactionType) == "credit" && resolveMerchant(normalizeDescriptor(tx.Merchant)) == cl.NormalizedMerchant {
+			previous = append(previous, tx)
+		}
+	}
+	if len(previous) < needed {
+		return false
+	}
+	previous = previous[len(previous)-needed:]
+
+	regular := func(gap time.Duration) bool {
+		for _, interval := range regularIncomeIntervals {
+			if gap >= interval[0] && gap <= interval[1] {
+				return true
+			}
+		}
+		return false
+	}
+	later, amount := at, math.Abs(cl.Amount)
+	for i := len(previous) - 1; i >= 0; i-- {
+		tx := previous[i]
+		if !regular(later.Sub(tx.CreatedAt)) || math.Abs(math.Abs(tx.Amount)-amount) > amount*regularIncomeTolerance {
+			return false
+		}
+		later = tx.CreatedAt
+	}
+	return true
+}
+
+// incomeType types a credit by the income rules, then by the regularity of the counterparty's
+// payments, defaulting to other income
+func (s *Server) incomeType(cl *Classification, at time.Time) string {
+	for _, rule := range incomeRules {
+		if rule.Matches(cl.NormalizedMerchant, cl.NormalizedDescription) {
+			return rule.Type
+		}
+	}
+	if s.isRegularIncome(cl, at) {
+		return IncomeSalary
+	}
+	return IncomeOther
+}
+
+// incomeStage categorizes credits, which the keyword rules can't tell apart: transfers between
+// the user's own accounts go to Transfers, and everything else is Income typed as salary,
+// benefits, interest, refunds or other income
+type incomeStage struct {
+	s *Server
+}
+
+func (incomeStage) Name() string { return "income" }
+
+func (incomeStage) Backend() string { return "income" }
+
+func (st incomeStage) Process(cl *Classification) {
+	if cl.Decided || strings.ToLower(cl.TransactionType) != "credit" {
+		return
+	}
+	at := cl.CreatedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	cl.IncomeType = st.s.incomeType(cl, at)
+	if cl.IncomeType == IncomeTransfer {
+		cl.decide(CategoryTransfers)
+		return
+	}
+	cl.decide(CategoryIncome)
+}
+
+// classificationIncomeType returns the income type of a transaction categorized as Income
+func classificationIncomeType(cl Classification) string {
+	if cl.Category != CategoryIncome {
+		return ""
+	}
+	return cl.IncomeType
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+)
+
+// CategoryInvestments is money moved into or out of investments and pensions, and the returns
+// they pay. It is saving rather than spending or earning.
+const CategoryInvestments = "Investments"
+
+// Investment types. InvestmentInterest marks interest credits, which stay categorized as
+// Income but are a return on savings all the same.
+const (
+	InvestmentBrokerage = "brokerage"
+	InvestmentPension   = "pension"
+	InvestmentDividend  = "dividend"
+	InvestmentInterest  = "interest"
+)
+
+// investmentKeywords are the investment platforms and pension providers the built-in
+// Investments rule matches, with the type of flow each indicates
+var investmentKeywords = []struct {
+	keyword        string
+	investmentType string
+}{
+	{"vanguard", InvestmentBrokerage},
+	{"hargreaves lansdown", InvestmentBrokerage},
+	{"aj bell", InvestmentBrokerage},
+	{"interactive investor", InvestmentBrokerage},
+	{"trading 212", InvestmentBrokerage},
+	{"freetrade", InvestmentBrokerage},
+	{"nutmeg", InvestmentBrokerage},
+	{"moneybox", InvestmentBrokerage},
+	{"wealthify", InvestmentBrokerage},
+	{"etoro", InvestmentBrokerage},
+	{"fidelity", InvestmentBrokerage},
+	{"charles stanley", InvestmentBrokerage},
+	{"stocks and shares isa", InvestmentBrokerage},
+	{"pensionbee", InvestmentPension},
+	{"nest pension", InvestmentPension},
+	{"sipp", InvestmentPension},
+	{"pension contribution", InvestmentPension},
+	{"pension", InvestmentPension},
+}
+
+// investmentRuleKeywords lists the keywords for the built-in Investments rule
+func investmentRuleKeywords() []string {
+	keywords := make([]string, 0, len(investmentKeywords))
+	for _, k := range investmentKeywords {
+		keywords = append(keywords, k.keyword)
+	}
+	return keywords
+}
+
+// investmentTypeFor returns the type of flow an Investments rule keyword indicates
+func investmentTypeFor(keyword string) string {
+	for _, k := range investmentKeywords {
+		if k.keyword == keyword {
+			return k.investmentType
+		}
+	}
+	return ""
+}
+
+// investmentReturnKeywords mark credits that are returns on investments. Interest on savings
+// is income, which the income stage types as interest.
+var investmentReturnKeywords = []struct {
+	keyword        string
+	investmentType string
+}{
+	{"dividend", InvestmentDividend},
+	{"dividends", InvestmentDividend},
+	{"div payment", InvestmentDividend},
+}
+
+// containsTokens reports whether text contains phrase as whole tokens, so a keyword never
+// matches inside a longer word
+func containsTokens(text, phrase string) bool {
+	return strings.Contains(" "+strings.Join(tokenize(text), " ")+" ", " "+strings.Join(tokenize(phrase), " ")+" ")
+}
+
+// creditInvestmentType returns the investment type of a credit: a dividend, or a withdrawal
+// from an investment platform. Pension providers paying out and interest are income, so
+// they are left to the income stage.
+func creditInvestmentType(merchant, description string) string {
+	for _, k := range investmentReturnKeywords {
+		if containsTokens(description, k.keyword) || containsTokens(merchant, k.keyword) {
+			return k.investmentType
+		}
+	}
+	for _, k := range investmentKeywords {
+		if k.investmentType == InvestmentBrokerage && containsTokens(merchant, k.keyword) {
+			return InvestmentBrokerage
+		}
+	}
+	return ""
+}
+
+// investmentStage categorizes investment credits as Investments before the income stage
+// would count them as income. Payments into investments and pensions are debits, which the
+// built-in Investments rule matches.
+type investmentStage struct{}
+
+func (investmentStage) Name() string { return "investments" }
+
+func (investmentStage) Backend() string { return "investments" }
+
+func (investmentStage) Process(cl *Classification) {
+	if cl.Decided || strings.ToLower(cl.TransactionType) != "credit" {
+		return
+	}
+	if investmentType := creditInvestmentType(cl.NormalizedMerchant, cl.NormalizedDescription); investmentType != "" {
+		cl.InvestmentType = investmentType
+		cl.decide(CategoryInvestments)
+	}
+}
+
+// classificationInvestmentType returns the investment type of a transaction categorized as
+// Investments, or of interest the income stage typed
+func classificationInvestmentType(cl Classification) string {
+	if classificationIncomeType(cl) == IncomeInterest {
+		return InvestmentInterest
+	}
+	if cl.Category != CategoryInvestments {
+		return ""
+	}
+	if cl.InvestmentType != "" {
+		return cl.InvestmentType
+	}
+	return investmentTypeFor(cl.Keyword)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// EventJobCompleted is emitted when a tracked job finishes, successfully or not
+const EventJobCompleted = "job.completed"
+
+// Job is a long-running admin operation run in the background and tracked until it finishes.
+// Progress holds counters the job updates as it goes and is its result once finished.
+type Job struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Status     string         `json:"status"`
+	Params     interface{}    `json:"params"`
+	Progress   map[string]int `json:"progress"`
+	Error      string         `json:"error,omitempty"`
+	CreatedBy  string         `json:"created_by"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// jobRegistry tracks background jobs in memory
+type jobRegistry struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// Start runs fn in the background as a new job of srv. fn reports progress through add and
+// its error, if any, fails the job.
+func (r *jobRegistry) Start(srv *Server, jobType, createdBy string, params interface{}, fn func(add func(counter string, n int)) error) Job {
+	job := &Job{
+		ID:        newID("job_"),
+		Type:      jobType,
+		Status:    JobRunning,
+		Params:    params,
+		Progress:  map[string]int{},
+		CreatedBy: createdBy,
+		StartedAt: time.Now().UTC(),
+	}
+	r.mu.Lock()
+	r.jobs[job.ID] = job
+	started := r.copy(job)
+	r.mu.Unlock()
+
+	add := func(counter string, n int) {
+		r.mu.Lock()
+		job.Progress[counter] += n
+		r.mu.Unlock()
+	}
+	go func() {
+		err := fn(add)
+		now := time.Now().UTC()
+		r.mu.Lock()
+		job.FinishedAt = &now
+		job.Status = JobCompleted
+		if err != nil {
+			job.Status, job.Error = JobFailed, err.Error()
+		}
+		finished := r.copy(job)
+		r.mu.Unlock()
+
+		if err != nil {
+			srv.logger.Error("Job failed", map[string]interface{}{
+				"event_type":    "job_failed",
+				"error_type":    finished.Type,
+				"error_message": err.Error(),
+			})
+		}
+		srv.emitEvent(EventJobCompleted, "", gin.H{
+			"job_id":   finished.ID,
+			"type":     finished.Type,
+			"status":   finished.Status,
+			"progress": finished.Progress,
+			"error":    finished.Error,
+		})
+	}()
+	return started
+}
+
+// copy returns a snapshot of a job. Callers must hold the lock.
+func (r *jobRegistry) copy(job *Job) Job {
+	snapshot := *job
+	snapshot.Progress = make(map[string]int, len(job.Progress))
+	for counter, n := range job.Progress {
+		snapshot.Progress[counter] = n
+	}
+	return snapshot
+}
+
+// Get returns a job by ID
+func (r *jobRegistry) Get(id string) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return r.copy(job), true
+}
+
+// List returns every job, most recently started first
+func (r *jobRegistry) List() []Job {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		list = append(list, r.copy(job))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+	return list
+}
+
+// Global job registry
+var jobs = &jobRegistry{jobs: map[string]*Job{}}
+
+// handleListJobs serves GET /admin/jobs
+func (s *Server) handleListJobs(c *gin.Context) {
+	list := jobs.List()
+	c.JSON(http.StatusOK, gin.H{"count": len(list), "jobs": list})
+}
+
+// handleGetJob serves GET /admin/jobs/:id
+func (s *Server) handleGetJob(c *gin.Context) {
+	job, ok := jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
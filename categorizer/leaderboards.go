@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LeaderboardEntry is one ranked merchant or category
+type LeaderboardEntry struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Total float64 `json:"total"`
+	Share float64 `json:"share"`
+}
+
+// Leaderboard ranks a user's or tenant's spending over a period
+type Leaderboard struct {
+	UserID   string             `json:"user_id,omitempty"`
+	TenantID string             `json:"tenant_id,omitempty"`
+	Category string             `json:"category,omitempty"`
+	Period   string             `json:"period"`
+	From     *time.Time         `json:"from,omitempty"`
+	To       *time.Time         `json:"to,omitempty"`
+	Sort     string             `json:"sort"`
+	Total    float64            `json:"total"`
+	Entries  []LeaderboardEntry `json:"entries"`
+}
+
+// merchantSpending totals settled and pending debits per normalized merchant, optionally
+// within one category
+func merchantSpending(transactions []StoredTransaction, category string) map[string]*MerchantRollup {
+	merchants := map[string]*MerchantRollup{}
+	for _, tx := range transactions {
+		if tx.Duplicate || tx.Status == StatusDeclined || strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		if category != "" && tx.Category != category {
+			continue
+		}
+		merchant := resolveMerchant(normalizeDescriptor(tx.Merchant))
+		if merchants[merchant] == nil {
+			merchants[merchant] = &MerchantRollup{}
+		}
+		merchants[merchant].Debits++
+		merchants[merchant].Spending += tx.Amount
+	}
+	return merchants
+}
+
+// tenantTransactions returns every user's transactions within [from, to) recorded for a tenant
+func (s *Server) tenantTransactions(tenantID string, from, to time.Time) map[string][]StoredTransaction {
+	byUser := map[string][]StoredTransaction{}
+	for _, userID := range s.store.Users() {
+		for _, tx := range s.store.ListTransactions(userID, from, to) {
+			if tx.TenantID == tenantID {
+				byUser[userID] = append(byUser[userID], tx)
+			}
+		}
+	}
+	return byUser
+}
+
+// leaderboardRange returns the range of the current day, week, month or year, or an
+// unbounded range for "all"
+func leaderboardRange(period string, now time.Time) (time.Time, time.Time, error) {
+	switch period {
+	case "day", "week", "month":
+		start := periodStart(period, now)
+		return start, periodEnd(period, start), nil
+	case "year":
+		start := time.Date(now.UTC().Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), nil
+	case "all":
+		return time.Time{}, time.Time{}, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("period must be one of day, week, month, year or all")
+}
+
+// rankLeaderboard orders entries by total spend or count, computes each one's share of the
+// ordering measure and keeps the first limit
+func rankLeaderboard(board *Leaderboard, entries []LeaderboardEntry, limit int) {
+	total, count := 0.0, 0
+	for i := range entries {
+		entries[i].Total = roundPence(entries[i].Total)
+		total += entries[i].Total
+		count += entries[i].Count
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if board.Sort == "count" && a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	for i := range entries {
+		switch {
+		case board.Sort == "count" && count > 0:
+			entries[i].Share = float64(entries[i].Count) / float64(count)
+		case board.Sort != "count" && total > 0:
+			entries[i].Share = entries[i].Total / total
+		}
+		entries[i].Share = math.Round(entries[i].Share*1000) / 1000
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	board.Total = roundPence(total)
+	board.Entries = entries
+}
+
+// leaderboardRequest parses the parameters shared by the leaderboard endpoints: one of
+// ?user_id= or ?tenant_id=, ?period= (default month), ?sort=spend|count and ?limit=
+func leaderboardRequest(c *gin.Context) (*Leaderboard, time.Time, time.Time, int, error) {
+	board := &Leaderboard{
+		UserID:   c.Query("user_id"),
+		TenantID: c.Query("tenant_id"),
+		Period:   c.DefaultQuery("period", "month"),
+		Sort:     c.DefaultQuery("sort", "spend"),
+		Entries:  []LeaderboardEntry{},
+	}
+	if (board.UserID == "") == (board.TenantID == "") {
+		return nil, time.Time{}, time.Time{}, 0, fmt.Errorf("exactly one of user_id or tenant_id is required")
+	}
+	if board.Sort != "spend" && board.Sort != "count" {
+		return nil, time.Time{}, time.Time{}, 0, fmt.Errorf("sort must be spend or count")
+	}
+	limit := 10
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			return nil, time.Time{}, time.Time{}, 0, fmt.Errorf("limit must be between 1 and 100")
+		}
+		limit = n
+	}
+	from, to, err := leaderboardRange(board.Period, time.Now())
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, 0, err
+	}
+	if !from.IsZero() {
+		board.From, board.To = &from, &to
+	}
+	return board, from, to, limit, nil
+}
+
+// handleTopMerchants serves GET /analytics/top-merchants, ranking merchants by spend or
+// count, optionally within ?category=. A user's ranking comes from daily rollups; a tenant's
+// is totalled from the raw history of its users.
+func (s *Server) handleTopMerchants(c *gin.Context) {
+	board, from, to, limit, err := leaderboardRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	board.Category = c.Query("category")
+
+	var merchants map[string]*MerchantRollup
+	if board.UserID != "" {
+		merchants = s.loadMerchantSpending(board.UserID, board.Category, from, to)
+	} else {
+		s.metrics.recordRollupRead("scan")
+		merchants = map[string]*MerchantRollup{}
+		for _, transactions := range s.tenantTransactions(board.TenantID, from, to) {
+			for merchant, m := range merchantSpending(transactions, board.Category) {
+				if merchants[merchant] == nil {
+					merchants[merchant] = &MerchantRollup{}
+				}
+				merchants[merchant].Debits += m.Debits
+				merchants[merchant].Spending += m.Spending
+			}
+		}
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(merchants))
+	for merchant, m := range merchants {
+		entries = append(entries, LeaderboardEntry{Name: merchant, Count: m.Debits, Total: m.Spending})
+	}
+	rankLeaderboard(board, entries, limit)
+	c.JSON(http.StatusOK, board)
+}
+
+// handleTopCategories serves GET /analytics/top-categories, ranking spending categories by
+// spend or count the way the summary totals them
+func (s *Server) handleTopCategories(c *gin.Context) {
+	board, from, to, limit, err := leaderboardRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals := map[string]*LeaderboardEntry{}
+	add := func(categories []CategorySummary) {
+		for _, category := range categories {
+			if totals[category.Category] == nil {
+				totals[category.Category] = &LeaderboardEntry{Name: category.Category}
+			}
+			totals[category.Category].Count += category.Count
+			totals[category.Category].Total += category.Total
+		}
+	}
+	if board.UserID != "" {
+		add(s.loadSummary(board.UserID, from, to).Categories)
+	} else {
+		s.metrics.recordRollupRead("scan")
+		for userID, transactions := range s.tenantTransactions(board.TenantID, from, to) {
+			add(buildSummary(userID, transactions).Categories)
+		}
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(totals))
+	for _, entry := range totals {
+		entries = append(entries, *entry)
+	}
+	rankLeaderboard(board, entries, limit)
+	c.JSON(http.StatusOK, board)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CategoryDebtRepayments is money paid back to lenders: credit card bills and loan repayments.
+// The spending happened when the card was used or the loan was taken out.
+const CategoryDebtRepayments = "Debt Repayments"
+
+// Kinds of debt a repayment pays off
+const (
+	LoanCreditCard = "credit_card"
+	LoanPersonal   = "loan"
+)
+
+// lenderKeywords are the lenders and repayment wording the built-in Debt Repayments rule
+// matches. Generic wording has no lender, so the repayment is tracked under its merchant.
+var lenderKeywords = []struct {
+	keyword  string
+	lender   string
+	loanType string
+}{
+	{"barclaycard", "Barclaycard", LoanCreditCard},
+	{"american express", "American Express", LoanCreditCard},
+	{"amex", "American Express", LoanCreditCard},
+	{"capital one", "Capital One", LoanCreditCard},
+	{"mbna", "MBNA", LoanCreditCard},
+	{"vanquis", "Vanquis", LoanCreditCard},
+	{"aqua card", "Aqua", LoanCreditCard},
+	{"newday", "NewDay", LoanCreditCard},
+	{"jaja", "Jaja", LoanCreditCard},
+	{"zopa", "Zopa", LoanPersonal},
+	{"novuna", "Novuna", LoanPersonal},
+	{"lendable", "Lendable", LoanPersonal},
+	{"motonovo", "MotoNovo", LoanPersonal},
+	{"black horse", "Black Horse", LoanPersonal},
+	{"student loans company", "Student Loans Company", LoanPersonal},
+	{"credit card payment", "", LoanCreditCard},
+	{"credit card repayment", "", LoanCreditCard},
+	{"loan repayment", "", LoanPersonal},
+	{"loan payment", "", LoanPersonal},
+	{"car finance", "", LoanPersonal},
+}
+
+// loanRuleKeywords lists the keywords for the built-in Debt Repayments rule
+func loanRuleKeywords() []string {
+	keywords := make([]string, 0, len(lenderKeywords))
+	for _, k := range lenderKeywords {
+		keywords = append(keywords, k.keyword)
+	}
+	return keywords
+}
+
+// repaymentLender returns the lender a repayment goes to and the kind of debt it pays off.
+// Named lenders win over generic wording; otherwise the merchant is the lender.
+func repaymentLender(tx StoredTransaction) (lender, loanType string) {
+	merchant := resolveMerchant(normalizeDescriptor(tx.Merchant))
+	description := normalizeDescriptor(tx.Description)
+	for _, k := range lenderKeywords {
+		if !strings.Contains(merchant, k.keyword) && !strings.Contains(description, k.keyword) {
+			continue
+		}
+		if k.lender != "" {
+			return k.lender, k.loanType
+		}
+		if loanType == "" {
+			loanType = k.loanType
+		}
+	}
+	if loanType == "" {
+		loanType = LoanPersonal
+	}
+	return merchant, loanType
+}
+
+// isRepayment reports whether a stored transaction paid money back to a lender
+func isRepayment(tx StoredTransaction) bool {
+	return tx.Category == CategoryDebtRepayments && !tx.Duplicate && tx.Status != StatusDeclined &&
+		strings.ToLower(tx.TransactionType) != TypeCredit
+}
+
+// lenderKey compares lender names the way users type them. Names mentioning a known lender,
+// by any of its keywords, compare as that lender, so "Amex" is "American Express".
+func lenderKey(lender string) string {
+	key := strings.ToLower(strings.TrimSpace(lender))
+	for _, k := range lenderKeywords {
+		if k.lender != "" && (containsTokens(key, k.keyword) || containsTokens(key, k.lender)) {
+			return strings.ToLower(k.lender)
+		}
+	}
+	return key
+}
+
+// LoanTerms are what a user tells us about a debt: what they owed on a date and the interest
+// it charges, from which its balance is estimated as repayments arrive
+type LoanTerms struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id" binding:"required"`
+	Lender         string    `json:"lender" binding:"required"`
+	Type           string    `json:"type,omitempty" binding:"omitempty,oneof=credit_card loan"`
+	Balance        float64   `json:"balance" binding:"required,gt=0"`
+	AsOf           time.Time `json:"as_of" binding:"required"`
+	APR            float64   `json:"apr" binding:"gte=0,lte=100"`
+	MonthlyPayment float64   `json:"monthly_payment,omitempty" binding:"gte=0"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// loanBook keeps each user's loan terms in memory, one set per lender
+type loanBook struct {
+	mu    sync.RWMutex
+	terms map[string][]LoanTerms
+}
+
+// newLoanBook creates an empty book
+func newLoanBook() *loanBook {
+	return &loanBook{terms: map[string][]LoanTerms{}}
+}
+
+// Put stores terms, replacing the user's earlier terms for the same lender
+func (b *loanBook) Put(t LoanTerms) LoanTerms {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t.ID = newID("loan_")
+	t.CreatedAt = time.Now().UTC()
+	list := b.terms[t.UserID][:0:0]
+	for _, existing := range b.terms[t.UserID] {
+		if lenderKey(existing.Lender) != lenderKey(t.Lender) {
+			list = append(list, existing)
+		}
+	}
+	b.terms[t.UserID] = append(list, t)
+	return t
+}
+
+// Remove deletes terms by ID
+func (b *loanBook) Remove(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for userID, list := range b.terms {
+		for i, t := range list {
+			if t.ID == id {
+				b.terms[userID] = append(list[:i:i], list[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Terms returns a user's loan terms
+func (b *loanBook) Terms(userID string) []LoanTerms {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]LoanTerms{}, b.terms[userID]...)
+}
+
+// Snapshot returns a copy of every user's loan terms
+func (b *loanBook) Snapshot() map[string][]LoanTerms {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	snapshot := make(map[string][]LoanTerms, len(b.terms))
+	for userID, list := range b.terms {
+		snapshot[userID] = append([]LoanTerms{}, list...)
+	}
+	return snapshot
+}
+
+// Restore replaces every user's loan terms
+func (b *loanBook) Restore(terms map[string][]LoanTerms) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if terms == nil {
+		terms = map[string][]LoanTerms{}
+	}
+	b.terms = terms
+}
+
+// Global loan terms
+var loans = newLoanBook()
+
+// maxProjectedRepayments bounds the repayments projected for a loan
+const maxProjectedRepayments = 12
+
+// maxPayoffMonths bounds how far ahead a payoff date is looked for
+const maxPayoffMonths = 600
+
+// ProjectedRepayment is an expected future repayment and the balance it leaves
+type ProjectedRepayment struct {
+	Date    time.Time `json:"date"`
+	Amount  float64   `json:"amount"`
+	Balance float64   `json:"balance"`
+}
+
+// LoanStatus tracks the repayments a user has made to one lender and, when they have given
+// the loan's terms, what they are estimated to still owe
+type LoanStatus struct {
+	Lender           string     `json:"lender"`
+	Type             string     `json:"type"`
+	Repayments       int        `json:"repayments"`
+	TotalRepaid      float64    `json:"total_repaid"`
+	AverageRepayment float64    `json:"average_repayment"`
+	FirstRepayment   *time.Time `json:"first_repayment,omitempty"`
+	LastRepayment    *time.Time `json:"last_repayment,omitempty"`
+
+	Terms            *LoanTerms           `json:"terms,omitempty"`
+	EstimatedBalance *float64             `json:"estimated_balance,omitempty"`
+	InterestAccrued  float64              `json:"interest_accrued,omitempty"`
+	Projected        []ProjectedRepayment `json:"projected,omitempty"`
+	PaidOffBy        *time.Time           `json:"paid_off_by,omitempty"`
+
+	repayments []StoredTransaction
+}
+
+// accrueInterest adds simple daily interest at the APR between two times
+func accrueInterest(balance, apr float64, from, to time.Time) float64 {
+	if !to.After(from) {
+		return 0
+	}
+	return balance * apr / 100 * to.Sub(from).Hours() / 24 / 365
+}
+
+// estimate works the loan's balance forward from its terms through the repayments made since,
+// then projects the repayments still to come at the agreed monthly payment, or the average
+// repayment when none was given
+func (s *LoanStatus) estimate(now time.Time) {
+	t := s.Terms
+	balance, at := t.Balance, t.AsOf
+	for _, tx := range s.repayments {
+		if tx.CreatedAt.Before(t.AsOf) || tx.CreatedAt.After(now) {
+			continue
+		}
+		interest := accrueInterest(balance, t.APR, at, tx.CreatedAt)
+		s.InterestAccrued += interest
+		balance = math.Max(balance+interest-tx.Amount, 0)
+		at = tx.CreatedAt
+	}
+	interest := accrueInterest(balance, t.APR, at, now)
+	s.InterestAccrued = roundPence(s.InterestAccrued + interest)
+	balance = roundPence(balance + interest)
+	estimated := balance
+	s.EstimatedBalance = &estimated
+
+	payment := t.MonthlyPayment
+	if payment == 0 {
+		payment = s.AverageRepayment
+	}
+	if balance == 0 || payment == 0 {
+		return
+	}
+	next := now
+	if s.LastRepayment != nil && s.LastRepayment.After(t.AsOf) {
+		next = *s.LastRepayment
+	}
+	at = now
+	for month := 0; month < maxPayoffMonths && balance > 0; month++ {
+		next = next.AddDate(0, 1, 0)
+		interest := accrueInterest(balance, t.APR, at, next)
+		if interest >= payment {
+			// The payment doesn't cover the interest, so the loan is never paid off
+			return
+		}
+		amount := math.Min(payment, balance+interest)
+		balance = roundPence(balance + interest - amount)
+		at = next
+		if len(s.Projected) < maxProjectedRepayments {
+			s.Projected = append(s.Projected, ProjectedRepayment{Date: next, Amount: roundPence(amount), Balance: balance})
+		}
+	}
+	if balance == 0 {
+		paidOff := at
+		s.PaidOffBy = &paidOff
+	}
+}
+
+// buildLoanStatuses groups a user's repayments by lender and estimates the balance of every
+// lender they have given terms for, including lenders they haven't repaid yet
+func buildLoanStatuses(transactions []StoredTransaction, terms []LoanTerms, now time.Time) []LoanStatus {
+	statuses := map[string]*LoanStatus{}
+	for _, tx := range transactions {
+		if !isRepayment(tx) {
+			continue
+		}
+		lender, loanType := repaymentLender(tx)
+		status := statuses[lenderKey(lender)]
+		if status == nil {
+			status = &LoanStatus{Lender: lender, Type: loanType}
+			statuses[lenderKey(lender)] = status
+		}
+		status.repayments = append(status.repayments, tx)
+	}
+	for i := range terms {
+		t := terms[i]
+		status := statuses[lenderKey(t.Lender)]
+		if status == nil {
+			status = &LoanStatus{Lender: t.Lender, Type: LoanPersonal}
+			statuses[lenderKey(t.Lender)] = status
+		}
+		if t.Type != "" {
+			status.Type = t.Type
+		}
+		status.Terms = &t
+	}
+
+	list := make([]LoanStatus, 0, len(statuses))
+	for _, status := range statuses {
+		sort.Slice(status.repayments, func(i, j int) bool {
+			return status.repayments[i].CreatedAt.Before(status.repayments[j].CreatedAt)
+		})
+		for _, tx := range status.repayments {
+			status.Repayments++
+			status.TotalRepaid += tx.Amount
+		}
+		if status.Repayments > 0 {
+			first, last := status.repayments[0].CreatedAt, status.repayments[status.Repayments-1].CreatedAt
+			status.FirstRepayment, status.LastRepayment = &first, &last
+			status.AverageRepayment = roundPence(status.TotalRepaid / float64(status.Repayments))
+			status.TotalRepaid = roundPence(status.TotalRepaid)
+		}
+		if status.Terms != nil {
+			status.estimate(now)
+		}
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Lender < list[j].Lender
+	})
+	return list
+}
+
+// handleCreateLoanTerms serves POST /loan-terms, replacing the user's terms for the lender
+func (s *Server) handleCreateLoanTerms(c *gin.Context) {
+	var terms LoanTerms
+	if err := c.ShouldBindJSON(&terms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, loans.Put(terms))
+}
+
+// handleDeleteLoanTerms serves DELETE /loan-terms/:id
+func (s *Server) handleDeleteLoanTerms(c *gin.Context) {
+	if !loans.Remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "loan terms not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleLoans serves GET /users/:user_id/loans, the user's repayments per lender with
+// estimated balances and upcoming repayments for the loans they have given terms for
+func (s *Server) handleLoans(c *gin.Context) {
+	userID := c.Param("user_id")
+	statuses := buildLoanStatuses(s.store.ListTransactions(userID, time.Time{}, time.Time{}), loans.Terms(userID), time.Now().UTC())
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"loans":   statuses,
+	})
+}
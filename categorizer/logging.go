@@ -18,43 +18,73 @@ const (
 
 // LogEntry represents a structured log entry
 type LogEntry struct {
-	Timestamp   string      `json:"timestamp"`
-	Level       LogLevel    `json:"level"`
-	Service     string      `json:"service"`
-	Version     string      `json:"version"`
-	Message     string      `json:"message"`
-	Merchant    string      `json:"merchant,omitempty"`
-	Category    string      `json:"category,omitempty"`
-	Amount      float64     `json:"amount,omitempty"`
-	Duration    string      `json:"duration_ms,omitempty"`
-	StatusCode  string      `json:"status_code,omitempty"`
-	Method      string      `json:"method,omitempty"`
-	Endpoint    string      `json:"endpoint,omitempty"`
-	EventType   string      `json:"event_type,omitempty"`
-	ErrorType   string      `json:"error_type,omitempty"`
-	RequestID   string      `json:"request_id,omitempty"`
+	Timestamp    string     `json:"timestamp"`
+	Level        LogLevel   `json:"level"`
+	Service      string     `json:"service"`
+	Version      string     `json:"version"`
+	Environment  string     `json:"environment,omitempty"`
+	Message      string     `json:"message"`
+	Merchant     string     `json:"merchant,omitempty"`
+	Category     string     `json:"category,omitempty"`
+	Amount       float64    `json:"amount,omitempty"`
+	Duration     string     `json:"duration_ms,omitempty"`
+	StatusCode   string     `json:"status_code,omitempty"`
+	Method       string     `json:"method,omitempty"`
+	Endpoint     string     `json:"endpoint,omitempty"`
+	EventType    string     `json:"event_type,omitempty"`
+	ErrorType    string     `json:"error_type,omitempty"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	RequestID    string     `json:"request_id,omitempty"`
+	TenantID     string     `json:"tenant_id,omitempty"`
+	Route        string     `json:"route,omitempty"`
+	Build        *BuildInfo `json:"build,omitempty"`
 }
 
 // StructuredLogger provides structured JSON logging
 type StructuredLogger struct {
-	logger *log.Logger
+	logger      *log.Logger
+	environment string
+	// recent buffers the warnings and errors logged, when set
+	recent *errorBuffer
+	// fields are added to every entry, under the fields of each call
+	fields map[string]interface{}
 }
 
-// NewStructuredLogger creates a new structured logger
-func NewStructuredLogger() *StructuredLogger {
+// NewStructuredLogger creates a new structured logger that labels entries with environment
+// and keeps its warnings and errors in recent
+func NewStructuredLogger(environment string, recent *errorBuffer) *StructuredLogger {
 	return &StructuredLogger{
-		logger: log.New(os.Stdout, "", 0),
+		logger:      log.New(os.Stdout, "", 0),
+		environment: environment,
+		recent:      recent,
 	}
 }
 
+// With returns a logger that adds fields to every entry it logs
+func (sl *StructuredLogger) With(fields map[string]interface{}) *StructuredLogger {
+	merged := make(map[string]interface{}, len(sl.fields)+len(fields))
+	for key, value := range sl.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &StructuredLogger{logger: sl.logger, environment: sl.environment, recent: sl.recent, fields: merged}
+}
+
 // logEntry logs a structured entry
 func (sl *StructuredLogger) logEntry(level LogLevel, message string, fields map[string]interface{}) {
+	if len(sl.fields) > 0 {
+		fields = sl.With(fields).fields
+	}
+
 	entry := LogEntry{
-		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
-		Level:     level,
-		Service:   "categorizer",
-		Version:   "1.0.0",
-		Message:   message,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       level,
+		Service:     "categorizer",
+		Version:     version,
+		Environment: sl.environment,
+		Message:     message,
 	}
 
 	// Populate fields from map
@@ -85,9 +115,21 @@ func (sl *StructuredLogger) logEntry(level LogLevel, message string, fields map[
 	if errorType, ok := fields["error_type"].(string); ok {
 		entry.ErrorType = errorType
 	}
+	if errorMessage, ok := fields["error_message"].(string); ok {
+		entry.ErrorMessage = errorMessage
+	}
 	if requestID, ok := fields["request_id"].(string); ok {
 		entry.RequestID = requestID
 	}
+	if tenantID, ok := fields["tenant_id"].(string); ok {
+		entry.TenantID = tenantID
+	}
+	if route, ok := fields["route"].(string); ok {
+		entry.Route = route
+	}
+	if build, ok := fields["build"].(BuildInfo); ok {
+		entry.Build = &build
+	}
 
 	jsonData, err := json.Marshal(entry)
 	if err != nil {
@@ -96,6 +138,9 @@ func (sl *StructuredLogger) logEntry(level LogLevel, message string, fields map[
 	}
 
 	sl.logger.Println(string(jsonData))
+	if sl.recent != nil {
+		sl.recent.Record(entry)
+	}
 }
 
 // Info logs an info level message
@@ -113,36 +158,33 @@ func (sl *StructuredLogger) Error(message string, fields map[string]interface{})
 	sl.logEntry(ERROR, message, fields)
 }
 
-// Global structured logger instance
-var structuredLogger = NewStructuredLogger()
-
 // Helper functions for common log patterns
-func logCategorizationRequest(merchant, category string, amount float64, duration time.Duration, success bool) {
+func (sl *StructuredLogger) logCategorizationRequest(merchant, category string, amount float64, duration time.Duration, success bool) {
 	level := INFO
 	message := "Categorization request completed"
-	
+
 	if !success {
 		level = WARN
 		message = "Categorization request failed"
 	}
 
 	fields := map[string]interface{}{
-		"merchant":    merchant,
-		"category":    category,
-		"amount":      amount,
-		"duration":    duration,
-		"event_type":  "categorization_request",
-		"success":     success,
+		"merchant":   merchant,
+		"category":   category,
+		"amount":     amount,
+		"duration":   duration,
+		"event_type": "categorization_request",
+		"success":    success,
 	}
 
 	if level == INFO {
-		structuredLogger.Info(message, fields)
+		sl.Info(message, fields)
 	} else {
-		structuredLogger.Warn(message, fields)
+		sl.Warn(message, fields)
 	}
 }
 
-func logHTTPRequest(method, endpoint, statusCode string, duration time.Duration) {
+func (sl *StructuredLogger) logHTTPRequest(method, endpoint, statusCode string, duration time.Duration) {
 	level := INFO
 	if statusCode[0] >= '4' {
 		level = WARN
@@ -158,23 +200,41 @@ func logHTTPRequest(method, endpoint, statusCode string, duration time.Duration)
 	}
 
 	if level == INFO {
-		structuredLogger.Info(message, fields)
+		sl.Info(message, fields)
 	} else {
-		structuredLogger.Warn(message, fields)
+		sl.Warn(message, fields)
 	}
 }
 
-func logServiceStartup(port string) {
-	structuredLogger.Info("Service started", map[string]interface{}{
+func (s *Server) logServiceStartup(port string) {
+	s.logger.Info("Service started", map[string]interface{}{
 		"port":       port,
 		"event_type": "service_startup",
+		"build":      s.currentBuildInfo(),
 	})
 }
 
-func logCategorizationError(errorType, errorMessage string) {
-	structuredLogger.Error("Categorization error occurred", map[string]interface{}{
+func (sl *StructuredLogger) logCategorizationError(errorType, errorMessage string) {
+	sl.Error("Categorization error occurred", map[string]interface{}{
 		"error_type":    errorType,
 		"error_message": errorMessage,
 		"event_type":    "categorization_error",
 	})
-}
\ No newline at end of file
+}
+
+func (s *Server) logStartupError(component string, err error) {
+	s.logger.Error("Service startup failed", map[string]interface{}{
+		"error_type":    component,
+		"error_message": err.Error(),
+		"event_type":    "startup_error",
+	})
+}
+
+func (s *Server) logEventDeliveryFailure(url string, event Event, errorMessage string) {
+	s.logger.Warn("Event delivery failed", map[string]interface{}{
+		"endpoint":      url,
+		"error_type":    event.Type,
+		"error_message": errorMessage,
+		"event_type":    "event_delivery_failed",
+	})
+}
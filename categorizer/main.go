@@ -1,164 +1,432 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type TransactionRequest struct {
-	Merchant        string  `json:"merchant" binding:"required"`
-	Amount          float64 `json:"amount" binding:"required"`
-	Description     string  `json:"description"`
-	TransactionType string  `json:"transaction_type" binding:"required"`
+	Merchant        string    `json:"merchant" binding:"required"`
+	Amount          float64   `json:"amount"`
+	Description     string    `json:"description"`
+	TransactionType string    `json:"transaction_type"`
+	UserID          string    `json:"user_id"`
+	CreatedAt       time.Time `json:"created_at"`
+	TransactionID   string    `json:"transaction_id"`
+	DedupeHash      string    `json:"dedupe_hash"`
+	Status          string    `json:"status" binding:"omitempty,oneof=pending settled declined"`
+	DeclineReason   string    `json:"decline_reason"`
+	MCC             string    `json:"mcc"`
+	Country         string    `json:"country"`
+	Location        string    `json:"location"`
+	TenantID        string    `json:"tenant_id"`
+	SignConvention  string    `json:"sign_convention" binding:"omitempty,oneof=typed signed"`
 }
 
 type CategoryResponse struct {
-	Category string `json:"category"`
+	Category          string                  `json:"category"`
+	CanonicalCategory string                  `json:"canonical_category,omitempty"`
+	Confidence        float64                 `json:"confidence"`
+	Alternatives      []CategoryScore         `json:"alternatives,omitempty"`
+	Candidates        []CategoryScore         `json:"candidates,omitempty"`
+	Tax               *TaxInfo                `json:"tax,omitempty"`
+	Duplicate         bool                    `json:"duplicate,omitempty"`
+	DuplicateOf       string                  `json:"duplicate_of,omitempty"`
+	Updated           bool                    `json:"updated,omitempty"`
+	DeclineReason     string                  `json:"decline_reason,omitempty"`
+	RiskFlags         []string                `json:"risk_flags,omitempty"`
+	Blocked           bool                    `json:"blocked,omitempty"`
+	BlockID           string                  `json:"block_id,omitempty"`
+	RoundUp           float64                 `json:"round_up,omitempty"`
+	Carbon            *CarbonEstimate         `json:"carbon,omitempty"`
+	Cashback          *CashbackAnnotation     `json:"cashback,omitempty"`
+	FeeType           string                  `json:"fee_type,omitempty"`
+	IncomeType        string                  `json:"income_type,omitempty"`
+	InvestmentType    string                  `json:"investment_type,omitempty"`
+	BNPLProvider      string                  `json:"bnpl_provider,omitempty"`
+	ReviewRequired    bool                    `json:"review_required,omitempty"`
+	Taxonomies        map[string]TaxonomyCode `json:"taxonomies,omitempty"`
+	Suggestions       []Suggestion            `json:"suggestions,omitempty"`
 }
 
-func categorizeTransaction(merchant, description string, amount float64, transactionType string) string {
-	merchantLower := strings.ToLower(merchant)
-	descriptionLower := strings.ToLower(description)
-	transactionTypeLower := strings.ToLower(transactionType)
+func (s *Server) categorizeTransaction(merchant, description string, amount float64, transactionType string) string {
+	return s.classifyTransaction(merchant, description, amount, transactionType).Category
+}
 
-	if transactionTypeLower == "credit" {
-		return "Income"
-	}
+// classifyTransaction runs a transaction through the server's classifier against its active rules
+func (s *Server) classifyTransaction(merchant, description string, amount float64, transactionType string) Classification {
+	return s.classifier.Classify(TransactionRequest{
+		Merchant:        merchant,
+		Description:     description,
+		Amount:          amount,
+		TransactionType: transactionType,
+	}, s.classifier.Rules())
+}
 
-	incomeKeywords := []string{"salary", "deposit", "income", "gift"}
-	for _, keyword := range incomeKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Income"
+// categorizeOptions are the optional parts of a categorization response a caller asked for:
+// the top candidates and fields such as tax or taxonomy
+type categorizeOptions struct {
+	Top     int
+	Include map[string]bool
+}
+
+// parseIncludes reads a comma-separated list of optional fields
+func parseIncludes(list string) map[string]bool {
+	include := map[string]bool{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			include[value] = true
 		}
 	}
+	return include
+}
 
-	// Transport
-	transportKeywords := []string{"uber", "lyft", "taxi", "transport", "tfl", "bus", "train", "metro", "subway"}
-	for _, keyword := range transportKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Transport"
-		}
+// categorizeParams parses the ?top= and ?include= options of a categorize request
+func categorizeParams(c *gin.Context) (categorizeOptions, error) {
+	top, err := topParam(c)
+	if err != nil {
+		return categorizeOptions{}, err
 	}
+	return categorizeOptions{Top: top, Include: parseIncludes(c.Query("include"))}, nil
+}
+
+// handleCategorize serves POST /categorize
+func (s *Server) handleCategorize(c *gin.Context) {
+	start := time.Now()
 
-	// Food & Drink
-	foodKeywords := []string{"starbucks", "costa", "cafe", "restaurant", "mcdonalds", "kfc", "pizza", "food", "coffee", "tea"}
-	for _, keyword := range foodKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Food & Drink"
+	var req TransactionRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = s.normalizeTransaction(c, &req)
+	}
+	var rs *RuleSet
+	if err == nil {
+		rs, err = requestRuleSet(c, s.classifier)
+	}
+	if err != nil {
+		s.metrics.recordCategorizationError("bad_request")
+		s.requestLogger(c).logCategorizationError("bad_request", err.Error())
+		status := http.StatusBadRequest
+		if errors.Is(err, errTenantForbidden) {
+			status = http.StatusForbidden
 		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TenantID != "" {
+		s.addLogFields(c, map[string]interface{}{"tenant_id": req.TenantID})
 	}
 
-	// Shopping
-	shoppingKeywords := []string{"amazon", "ebay", "shop", "store", "retail", "market", "mall", "clothing", "fashion"}
-	for _, keyword := range shoppingKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Shopping"
-		}
+	opts, err := categorizeParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Groceries
-	groceryKeywords := []string{"tesco", "sainsbury", "asda", "morrisons", "waitrose", "aldi", "lidl", "grocery", "supermarket"}
-	for _, keyword := range groceryKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Groceries"
-		}
+	response := s.categorize(c.Request.Context(), req, rs, opts, start)
+	c.JSON(http.StatusOK, response)
+}
+
+// topParam parses the ?top= number of candidates to return, 0 when not asked for
+func topParam(c *gin.Context) (int, error) {
+	value := c.Query("top")
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New("top must be a positive integer")
 	}
+	return n, nil
+}
 
-	// Entertainment
-	entertainmentKeywords := []string{"cinema", "movie", "netflix", "spotify", "apple music", "game", "entertainment", "theatre"}
-	for _, keyword := range entertainmentKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Entertainment"
-		}
+// categorize classifies one validated request against rs, records it and builds its response,
+// including the optional fields opts asks for. The REST and gRPC APIs share it.
+func (s *Server) categorize(ctx context.Context, req TransactionRequest, rs *RuleSet, opts categorizeOptions, start time.Time) CategoryResponse {
+	cl := s.classifier.Classify(req, rs)
+	// A tenant's own category counts as the base category it maps onto everywhere but the
+	// response, so history, metrics and global analytics stay on the canonical taxonomy
+	category := tenantTaxonomies.Canonical(req.TenantID, cl.Category)
+	duration := time.Since(start)
+
+	// Record metrics
+	s.metrics.recordCategorizationRequest(category, "success", req.TenantID)
+	categoryCounts.Record(category, start)
+	s.metrics.recordCategorizationDuration(category, cl.Backend, cl.DecidedBy, req.TenantID, duration)
+
+	// Log categorization request
+	s.LoggerFromContext(ctx).logCategorizationRequest(req.Merchant, category, req.Amount, duration, true)
+
+	response := CategoryResponse{
+		Category:       tenantTaxonomies.Display(req.TenantID, cl.Category),
+		Confidence:     responseConfidence(cl),
+		Alternatives:   tenantCategoryScores(req.TenantID, alternativeCategories(cl)),
+		Carbon:         cl.Carbon,
+		Cashback:       cashbackFor(req.Merchant, category, req.Amount, req.TransactionType),
+		FeeType:        classificationFeeType(cl),
+		IncomeType:     classificationIncomeType(cl),
+		InvestmentType: classificationInvestmentType(cl),
+		BNPLProvider:   cl.BNPLProvider,
+		ReviewRequired: cl.Policy != nil && cl.Policy.Action == PolicyReview,
+		Suggestions:    suggestCategories(cl),
+	}
+	if response.Category != category {
+		response.CanonicalCategory = category
 	}
 
-	// Bills & Utilities
-	billsKeywords := []string{"electric", "gas", "water", "internet", "phone", "insurance", "council tax", "utility", "energy"}
-	for _, keyword := range billsKeywords {
-		if strings.Contains(merchantLower, keyword) || strings.Contains(descriptionLower, keyword) {
-			return "Bills & Utilities"
-		}
+	// Compliance flags for vulnerable-customer tooling
+	if flags := riskFlagsFor(req.Merchant, req.Description, req.Amount); len(flags) > 0 {
+		response.RiskFlags = flags
+		s.flagRiskyTransaction(req, category, flags)
 	}
 
-	// ATM/Cash
-	if strings.Contains(merchantLower, "atm") || strings.Contains(merchantLower, "cash") {
-		return "ATM"
+	// Advisory flag for users' self-exclusion blocks
+	if req.UserID != "" {
+		s.checkBlocks(req, category, &response)
 	}
 
-	// Large amounts might be rent/salary
-	if amount > 700 {
-		if strings.Contains(descriptionLower, "salary") || strings.Contains(descriptionLower, "wages") {
-			return "Income"
-		}
-		if strings.Contains(descriptionLower, "rent") || strings.Contains(descriptionLower, "mortgage") {
-			return "Housing"
+	// Declines are tracked separately for fraud-adjacent monitoring
+	if req.Status == StatusDeclined {
+		response.DeclineReason = categorizeDeclineReason(req.DeclineReason)
+		declines.Record(s.metrics, req.Merchant, category, response.DeclineReason)
+	}
+
+	// Keep history for identified users
+	if req.UserID != "" {
+		s.recordHistory(req, category, cl.DecidedBy, &response)
+		if !response.Duplicate && !response.Updated {
+			notifications.Evaluate(s, req, category)
 		}
 	}
+	if opts.Top > 0 {
+		response.Candidates = tenantCategoryScores(req.TenantID, topCandidates(cl, opts.Top))
+	}
+	if opts.Include["tax"] {
+		tax := taxInfoFor(category, req.Merchant, req.Description, req.Amount)
+		response.Tax = &tax
+	}
+	if opts.Include["taxonomy"] {
+		response.Taxonomies = taxonomyCodesFor(category)
+	}
+	if opts.Include["round_up"] {
+		response.RoundUp = s.roundUpFor(req.Amount, req.TransactionType)
+	}
 
-	return "Other"
+	return response
 }
 
-func handleCategorize(c *gin.Context) {
-	start := time.Now()
+func main() {
+	if len(os.Args) > 1 && os.Args[1] != "--demo" {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
-	var req TransactionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		recordCategorizationError("bad_request")
-		logCategorizationError("bad_request", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	cfg := loadConfig()
+	m := NewMetrics(prometheus.NewRegistry(), cfg.Environment, cfg.TenantMetrics)
+	logger := NewStructuredLogger(cfg.Environment, newErrorBuffer(cfg.ErrorBufferSize))
+	var history Store = newMemoryStore(cfg.DedupeWindow)
+	if cfg.ReadReplica {
+		history = newReplicatedStore(newMemoryStore(cfg.DedupeWindow), newMemoryStore(cfg.DedupeWindow), m)
 	}
+	s := NewServer(cfg, logger, m, history, NewClassifier(defaultRuleSet(), nil, m))
+	s.classifier.SetPipeline(defaultPipeline(s))
 
-	category := categorizeTransaction(req.Merchant, req.Description, req.Amount, req.TransactionType)
-	duration := time.Since(start)
+	// Stores sized or set up from the configuration are created before anything can use them
+	eventJournal = newEventLog(cfg.EventLogSize)
+	readiness = newHealthHistory(cfg.HealthHistorySize)
+	summaries = newSummaryCache(cfg.SummaryCacheTTL)
+	ruleHistory = newRuleSetHistory(cfg.RulesetRetention, s.classifier.Rules())
+	monzoTokens = newMonzoTokenManager(cfg, m, logger)
+	monzoBudget = newMonzoRateBudget(cfg.MonzoRateLimit, cfg.MonzoRateBurst, m)
+	emailSender = logSender{logger: logger}
+	fuzzyMatching = FuzzyOptions{MaxDistance: cfg.FuzzyMaxDistance, MinLength: cfg.FuzzyMinLength}
 
-	// Record metrics
-	recordCategorizationRequest(category, "success")
-	recordCategorizationDuration(category, duration)
+	if len(os.Args) > 1 {
+		if err := s.startDemo(os.Args[2:]); err != nil {
+			s.logStartupError("demo", err)
+			os.Exit(1)
+		}
+	}
 
-	// Log categorization request
-	logCategorizationRequest(req.Merchant, category, req.Amount, duration, true)
+	s.metrics.recordServiceStart()
 
-	response := CategoryResponse{
-		Category: category,
+	if err := checkEnvironment(s.config); err != nil {
+		s.logStartupError("environment", err)
+		os.Exit(1)
 	}
 
-	c.JSON(http.StatusOK, response)
-}
+	// The event journal is opened before anything that emits events, so they are numbered
+	// after the events already in the file
+	if s.config.EventLogFile != "" {
+		if err := eventJournal.Open(s.config.EventLogFile); err != nil {
+			s.logStartupError("event_log", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.RulesFile != "" {
+		if err := s.loadRules(s.config.RulesFile); err != nil {
+			s.logStartupError("rules", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.AccountingCodesFile != "" {
+		if err := loadChartOfAccounts(s.config.AccountingCodesFile); err != nil {
+			s.logStartupError("chart_of_accounts", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.CarbonFactorsFile != "" {
+		if err := loadCarbonFactors(s.config.CarbonFactorsFile); err != nil {
+			s.logStartupError("carbon_factors", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.IncomeRulesFile != "" {
+		if err := loadIncomeRules(s.config.IncomeRulesFile); err != nil {
+			s.logStartupError("income_rules", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.CashbackOffersFile != "" {
+		if err := loadCashbackOffers(s.config.CashbackOffersFile); err != nil {
+			s.logStartupError("cashback_offers", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.AdminCallLogFile != "" {
+		if err := adminCalls.Open(s.config.AdminCallLogFile, s.logger); err != nil {
+			s.logStartupError("admin_call_log", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.MonzoTokenFile != "" {
+		if err := monzoTokens.Open(s.config.MonzoTokenFile); err != nil {
+			s.logStartupError("monzo_connections", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.TaxonomyFile != "" {
+		if err := loadTaxonomies(s.config.TaxonomyFile); err != nil {
+			s.logStartupError("taxonomy_mappings", err)
+			os.Exit(1)
+		}
+	}
+	if s.config.NoisePatternsFile != "" {
+		if err := loadNoisePatterns(s.config.NoisePatternsFile); err != nil {
+			s.logStartupError("noise_patterns", err)
+			os.Exit(1)
+		}
+	}
+	configured, err := newPipeline(s.config.PipelineStages, s)
+	if err != nil {
+		s.logStartupError("pipeline", err)
+		os.Exit(1)
+	}
+	s.classifier.SetPipeline(configured)
+	providers, err := newBankProviders(s.config.BankProviders, s.config, s.metrics)
+	if err != nil {
+		s.logStartupError("bank_providers", err)
+		os.Exit(1)
+	}
+	bankProviders = providers
+	if len(s.config.PeerURLs) > 0 && s.config.ReplicationToken == "" {
+		s.logStartupError("replication", errors.New("PEER_URLS requires REPLICATION_TOKEN"))
+		os.Exit(1)
+	}
+	if s.config.RoundUpPotID != "" {
+		if s.config.MonzoAccessToken == "" || s.config.MonzoAccountID == "" {
+			s.logStartupError("roundups", errors.New("ROUNDUP_POT_ID requires MONZO_ACCESS_TOKEN and MONZO_ACCOUNT_ID"))
+			os.Exit(1)
+		}
+		potDepositor = newMonzoClient(s.config, s.metrics)
+	}
 
+	if s.config.DigestTemplatesDir != "" {
+		if err := loadDigestTemplates(s.config.DigestTemplatesDir); err != nil {
+			s.logStartupError("digest_templates", err)
+			os.Exit(1)
+		}
+	}
+	sender, err := newEmailSender(s.config, s.logger)
+	if err != nil {
+		s.logStartupError("digest_provider", err)
+		os.Exit(1)
+	}
+	emailSender = sender
 
-func main() {
-	// Log service startup
-	logServiceStartup("9000")
+	exporter, err := newTSDBExporter(s.config)
+	if err != nil {
+		s.logStartupError("tsdb_exporter", err)
+		os.Exit(1)
+	}
+	tsdbExporter = exporter
 
-	r := gin.Default()
+	translationProvider, err := newTranslator(s.config)
+	if err != nil {
+		s.logStartupError("translation_provider", err)
+		os.Exit(1)
+	}
+	translator = translationProvider
 
-	// Add metrics middleware
-	r.Use(MetricsMiddleware())
+	mlClassifier, err := newCategorizer(s.config)
+	if err != nil {
+		s.logStartupError("classifier", err)
+		os.Exit(1)
+	}
+	fallbackClassifier = mlClassifier
 
-	// Prometheus metrics endpoint
-	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	metricsExporter, err := newMetricsExporter(s.config)
+	if err != nil {
+		s.logStartupError("metrics_exporter", err)
+		os.Exit(1)
+	}
+	statsd = metricsExporter
 
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "healthy",
-			"service": "categorizer",
-			"version": "1.0.0",
-		})
-	})
+	// Log service startup once the rules and model are loaded, so the build details and
+	// load times describe what is actually serving
+	s.logServiceStartup("9000")
+	s.metrics.recordBuildInfo(s.currentBuildInfo())
+	s.metrics.recordRulesLoaded()
+	// Trainable classifiers record their model's load when they train
+	if _, trainable := fallbackClassifier.(TrainableClassifier); fallbackClassifier != nil && !trainable {
+		s.metrics.recordModelLoaded()
+	}
 
-	// Categorization endpoint
-	r.POST("/categorize", handleCategorize)
+	s.logRuleConflicts(s.activeRules())
+	s.startAggregationJob(s.config.AggregationInterval)
+	s.startDigestWorker(s.config.DigestCheckInterval)
+	s.startReplication()
+	s.startReplicaSync(s.config.ReplicaSyncInterval)
+	s.startRollupJob(s.config.RollupInterval)
+	s.startModelTraining(s.config.MLTrainInterval)
+	s.startRuleScheduler(s.config.RuleScheduleCheck)
+	s.startRulesReloadSignal()
+	s.startTokenRefresh(s.config.MonzoTokenRefresh)
+	s.startWebhookReconciliation(s.config.MonzoWebhookCheck)
+	s.startWriteBacks(s.config.MonzoWritebackEvery, s.config.MonzoWritebackBatch)
+	s.startMonzoSyncs(s.config.MonzoSyncInterval)
+	s.startStatsdFlush(s.config.StatsdFlushInterval)
+	s.startReadinessChecks(s.config.ReadinessInterval)
+	s.startDependencyWait(s.config.StartupWaitTimeout)
 
 	// Start server
-	structuredLogger.Info("Server started and listening", map[string]interface{}{
-		"port":       "9000",
-		"event_type": "server_ready",
-	})
-	r.Run(":9000")
+	if s.config.GRPCAddr != "" {
+		go func() {
+			if err := s.RunGRPC(s.config.GRPCAddr); err != nil {
+				s.logStartupError("grpc_server", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	if err := s.Run(":9000"); err != nil {
+		s.logStartupError("http_server", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Merchant policy actions
+const (
+	PolicyPin    = "pin"
+	PolicyReview = "review"
+)
+
+// CategoryNeedsReview is assigned to transactions a tenant wants categorized by hand
+const CategoryNeedsReview = "Needs Review"
+
+// MerchantPolicy is a tenant's rule for one merchant, enforced ahead of every override and
+// keyword rule: pin always maps the merchant to Category, and review never categorizes it
+// automatically so it goes to manual review.
+type MerchantPolicy struct {
+	TenantID  string    `json:"tenant_id"`
+	Merchant  string    `json:"merchant" binding:"required"`
+	Action    string    `json:"action" binding:"required,oneof=pin review"`
+	Category  string    `json:"category,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// validate canonicalises the merchant and category and checks the policy's fields. A pin may
+// name any category the tenant uses; the base category it maps onto is stored.
+func (p *MerchantPolicy) validate(base map[string]bool) error {
+	categories := tenantTaxonomies.Categories(p.TenantID, base)
+	p.Merchant = resolveMerchant(normalizeDescriptor(p.Merchant))
+	switch {
+	case p.TenantID == "":
+		return errors.New("tenant_id is required")
+	case p.Merchant == "":
+		return errors.New("merchant is required")
+	case p.Action == PolicyPin && !categories[p.Category]:
+		return fmt.Errorf("pin needs a known category, got %q", p.Category)
+	case p.Action == PolicyReview && p.Category != "":
+		return errors.New("review policies don't take a category")
+	}
+	p.Category = tenantTaxonomies.Canonical(p.TenantID, p.Category)
+	return nil
+}
+
+// merchantPolicyStore keeps each tenant's merchant policies in memory, keyed by canonical merchant
+type merchantPolicyStore struct {
+	mu       sync.RWMutex
+	policies map[string]map[string]MerchantPolicy
+}
+
+// newMerchantPolicyStore creates an empty store
+func newMerchantPolicyStore() *merchantPolicyStore {
+	return &merchantPolicyStore{policies: map[string]map[string]MerchantPolicy{}}
+}
+
+// Put creates or replaces the tenant's policy for the merchant
+func (s *merchantPolicyStore) Put(p MerchantPolicy) MerchantPolicy {
+	p.CreatedAt = time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policies[p.TenantID] == nil {
+		s.policies[p.TenantID] = map[string]MerchantPolicy{}
+	}
+	s.policies[p.TenantID][p.Merchant] = p
+	return p
+}
+
+// Delete removes the tenant's policy for a merchant, reporting whether it existed
+func (s *merchantPolicyStore) Delete(tenantID, merchant string) bool {
+	merchant = resolveMerchant(normalizeDescriptor(merchant))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.policies[tenantID][merchant]; !ok {
+		return false
+	}
+	delete(s.policies[tenantID], merchant)
+	return true
+}
+
+// List returns a tenant's policies sorted by merchant
+func (s *merchantPolicyStore) List(tenantID string) []MerchantPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]MerchantPolicy, 0, len(s.policies[tenantID]))
+	for _, p := range s.policies[tenantID] {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Merchant < list[j].Merchant
+	})
+	return list
+}
+
+// Lookup returns the tenant's policy for a canonical merchant
+func (s *merchantPolicyStore) Lookup(tenantID, merchant string) (MerchantPolicy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.policies[tenantID][merchant]
+	return p, ok
+}
+
+// Snapshot returns a copy of every tenant's policies
+func (s *merchantPolicyStore) Snapshot() map[string][]MerchantPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string][]MerchantPolicy, len(s.policies))
+	for tenantID, policies := range s.policies {
+		for _, p := range policies {
+			snapshot[tenantID] = append(snapshot[tenantID], p)
+		}
+	}
+	return snapshot
+}
+
+// Restore replaces every tenant's policies
+func (s *merchantPolicyStore) Restore(snapshot map[string][]MerchantPolicy) {
+	policies := make(map[string]map[string]MerchantPolicy, len(snapshot))
+	for tenantID, list := range snapshot {
+		policies[tenantID] = make(map[string]MerchantPolicy, len(list))
+		for _, p := range list {
+			policies[tenantID][p.Merchant] = p
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+}
+
+// Global tenant merchant policies
+var merchantPolicies = newMerchantPolicyStore()
+
+// merchantPolicyStage enforces the tenant's merchant policy before any other stage can decide
+type merchantPolicyStage struct{}
+
+func (merchantPolicyStage) Name() string { return "merchant_policy" }
+
+func (merchantPolicyStage) Backend() string { return "policy" }
+
+func (merchantPolicyStage) Process(cl *Classification) {
+	if cl.Decided || cl.TenantID == "" {
+		return
+	}
+	p, ok := merchantPolicies.Lookup(cl.TenantID, cl.NormalizedMerchant)
+	if !ok {
+		return
+	}
+	cl.Policy = &p
+	if p.Action == PolicyReview {
+		cl.decide(CategoryNeedsReview)
+		return
+	}
+	cl.decide(p.Category)
+}
+
+// handlePutMerchantPolicy serves PUT /tenants/:tenant_id/merchant-policies
+func (s *Server) handlePutMerchantPolicy(c *gin.Context) {
+	var p MerchantPolicy
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.TenantID = c.Param("tenant_id")
+	if err := p.validate(s.knownCategories()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, merchantPolicies.Put(p))
+}
+
+// handleListMerchantPolicies serves GET /tenants/:tenant_id/merchant-policies
+func (s *Server) handleListMerchantPolicies(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	list := merchantPolicies.List(tenantID)
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id": tenantID,
+		"count":     len(list),
+		"policies":  list,
+	})
+}
+
+// handleDeleteMerchantPolicy serves DELETE /tenants/:tenant_id/merchant-policies?merchant=
+func (s *Server) handleDeleteMerchantPolicy(c *gin.Context) {
+	if !merchantPolicies.Delete(c.Param("tenant_id"), c.Query("merchant")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "merchant policy not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
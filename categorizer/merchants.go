@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MerchantCategoryShare is how many of a merchant's transactions landed in one category
+type MerchantCategoryShare struct {
+	Category string  `json:"category"`
+	Count    int     `json:"count"`
+	Share    float64 `json:"share"`
+}
+
+// MerchantStats describes how a normalized merchant appears across stored history, to judge
+// whether a rule for it is worth adding
+type MerchantStats struct {
+	Merchant      string                  `json:"merchant"`
+	Count         int                     `json:"count"`
+	Users         int                     `json:"users"`
+	AverageAmount float64                 `json:"average_amount"`
+	TotalAmount   float64                 `json:"total_amount"`
+	Categories    []MerchantCategoryShare `json:"categories"`
+	Descriptors   []DeclineCount          `json:"descriptors"`
+	FirstSeen     *time.Time              `json:"first_seen,omitempty"`
+	LastSeen      *time.Time              `json:"last_seen,omitempty"`
+}
+
+// merchantStats aggregates every user's non-duplicate, non-declined transactions whose
+// descriptor normalizes to merchant
+func (s *Server) merchantStats(merchant string) MerchantStats {
+	stats := MerchantStats{Merchant: merchant, Categories: []MerchantCategoryShare{}}
+	categories := map[string]int{}
+	descriptors := map[string]int{}
+
+	for _, userID := range s.store.Users() {
+		seen := false
+		for _, tx := range s.store.ListTransactions(userID, time.Time{}, time.Time{}) {
+			if tx.Duplicate || tx.Status == StatusDeclined || resolveMerchant(normalizeDescriptor(tx.Merchant)) != merchant {
+				continue
+			}
+			seen = true
+			stats.Count++
+			stats.TotalAmount += tx.Amount
+			categories[tx.Category]++
+			descriptors[tx.Merchant]++
+			at := tx.CreatedAt
+			if stats.FirstSeen == nil || at.Before(*stats.FirstSeen) {
+				stats.FirstSeen = &at
+			}
+			if stats.LastSeen == nil || at.After(*stats.LastSeen) {
+				stats.LastSeen = &at
+			}
+		}
+		if seen {
+			stats.Users++
+		}
+	}
+	if stats.Count == 0 {
+		return stats
+	}
+
+	stats.TotalAmount = math.Round(stats.TotalAmount*100) / 100
+	stats.AverageAmount = math.Round(stats.TotalAmount/float64(stats.Count)*100) / 100
+	for _, c := range rankCounts(categories, 0) {
+		stats.Categories = append(stats.Categories, MerchantCategoryShare{
+			Category: c.Name,
+			Count:    c.Count,
+			Share:    math.Round(float64(c.Count)/float64(stats.Count)*1000) / 1000,
+		})
+	}
+	stats.Descriptors = rankCounts(descriptors, 10)
+	return stats
+}
+
+// handleMerchantStats serves GET /merchants/:name/stats. The name may be a raw descriptor;
+// it is normalized the same way incoming transactions are.
+func (s *Server) handleMerchantStats(c *gin.Context) {
+	merchant := resolveMerchant(normalizeDescriptor(c.Param("name")))
+	if merchant == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "merchant name is empty once normalized"})
+		return
+	}
+	stats := s.merchantStats(merchant)
+	if stats.Count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no transactions for merchant " + merchant})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
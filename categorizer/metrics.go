@@ -1,86 +1,625 @@
 package main
 
 import (
+	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// Prometheus metrics
-var (
-	categorizationRequestsTotal = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "categorization_requests_total",
-			Help: "Total number of categorization requests",
-		},
-		[]string{"category", "status"},
-	)
-
-	categorizationErrorsTotal = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "categorization_errors_total",
-			Help: "Total number of categorization errors",
-		},
-		[]string{"error_type"},
-	)
-
-	categorizationDuration = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "categorization_duration_seconds",
-			Help:    "Categorization request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"category"},
-	)
-
-	httpRequestsTotal = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method", "endpoint", "status_code"},
-	)
-
-	httpRequestDuration = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"method", "endpoint"},
-	)
-)
+// Metrics holds the service's Prometheus collectors, registered on a registry of their own
+// rather than the global default, so several instances can live in one process
+type Metrics struct {
+	registry *prometheus.Registry
+	// environment labels the service's own metrics, and the observations sent to DogStatsD
+	environment string
+	// tenants are the tenants allowed their own tenant label value
+	tenants map[string]bool
+
+	categorizationRequestsTotal *prometheus.CounterVec
+	categorizationErrorsTotal   *prometheus.CounterVec
+	categorizationDuration      *prometheus.HistogramVec
+	stageDuration               *prometheus.HistogramVec
+	fuzzyMatchesTotal           *prometheus.CounterVec
+	duplicatesTotal             prometheus.Counter
+	declinedTransactionsTotal   *prometheus.CounterVec
+	riskFlagsTotal              *prometheus.CounterVec
+	blockedTransactionsTotal    *prometheus.CounterVec
+	roundUpDepositsTotal        *prometheus.CounterVec
+	notificationFiringsTotal    *prometheus.CounterVec
+	digestEmailsTotal           *prometheus.CounterVec
+	replicationPushesTotal      *prometheus.CounterVec
+	replicationMergesTotal      *prometheus.CounterVec
+	summaryCacheRequestsTotal   *prometheus.CounterVec
+	rollupReadsTotal            *prometheus.CounterVec
+	storeQueriesTotal           *prometheus.CounterVec
+	storeReplicaLag             prometheus.Gauge
+	tsdbExportsTotal            *prometheus.CounterVec
+	translationsTotal           *prometheus.CounterVec
+	buildInfo                   *prometheus.GaugeVec
+	serviceStartTime            prometheus.Gauge
+	rulesLastReload             prometheus.Gauge
+	modelLastLoad               prometheus.Gauge
+	monzoLastSync               prometheus.Gauge
+	monzoTokenRefreshesTotal    *prometheus.CounterVec
+	monzoWebhookChangesTotal    *prometheus.CounterVec
+	monzoWriteBacksTotal        *prometheus.CounterVec
+	monzoReceiptsTotal          *prometheus.CounterVec
+	monzoQueueDepth             *prometheus.GaugeVec
+	monzoThrottledTotal         *prometheus.CounterVec
+	monzoSyncedTotal            *prometheus.CounterVec
+	rejectedRequestsTotal       *prometheus.CounterVec
+	deprecatedUsageTotal        *prometheus.CounterVec
+	eventsEmittedTotal          *prometheus.CounterVec
+	eventDeliveriesTotal        *prometheus.CounterVec
+	httpRequestsTotal           *prometheus.CounterVec
+	httpRequestDuration         *prometheus.HistogramVec
+}
+
+// NewMetrics registers the service's collectors, along with the Go runtime and process
+// collectors, on reg. The service's own metrics are labelled with environment, the one its
+// external APIs point at, and only the tenants listed get their own tenant label value.
+// Each registry can only be given to one Metrics.
+func NewMetrics(reg *prometheus.Registry, environment string, tenants []string) *Metrics {
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	factory := promauto.With(prometheus.WrapRegistererWith(prometheus.Labels{"environment": environment}, reg))
+	allowed := map[string]bool{}
+	for _, tenant := range tenants {
+		allowed[tenant] = true
+	}
+	return &Metrics{
+		registry:    reg,
+		environment: environment,
+		tenants:     allowed,
+
+		categorizationRequestsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "categorization_requests_total",
+				Help: "Total number of categorization requests",
+			},
+			[]string{"category", "status", "tenant"},
+		),
+
+		categorizationErrorsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "categorization_errors_total",
+				Help: "Total number of categorization errors",
+			},
+			[]string{"error_type"},
+		),
+
+		categorizationDuration: factory.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "categorization_duration_seconds",
+				Help:    "Categorization request duration in seconds by category and the backend and stage that decided it",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"category", "backend", "stage", "tenant"},
+		),
+
+		stageDuration: factory.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "categorization_stage_duration_seconds",
+				Help:    "Categorization pipeline stage duration in seconds",
+				Buckets: []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01},
+			},
+			[]string{"stage"},
+		),
+
+		fuzzyMatchesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "categorization_fuzzy_matches_total",
+				Help: "Total number of categorizations decided by edit-distance tolerant matching",
+			},
+			[]string{"category"},
+		),
+
+		duplicatesTotal: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "categorization_duplicates_total",
+				Help: "Total number of resubmitted transactions flagged as duplicates",
+			},
+		),
+
+		declinedTransactionsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "declined_transactions_total",
+				Help: "Total number of declined transactions categorized",
+			},
+			[]string{"category", "reason"},
+		),
+
+		riskFlagsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "risk_flags_total",
+				Help: "Total number of high-risk merchant flags raised",
+			},
+			[]string{"flag"},
+		),
+
+		blockedTransactionsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "blocked_transactions_total",
+				Help: "Total number of transactions matching a user's self-exclusion block",
+			},
+			[]string{"block_type"},
+		),
+
+		roundUpDepositsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "roundup_deposits_total",
+				Help: "Total number of round-up pot deposits by outcome",
+			},
+			[]string{"status"},
+		),
+
+		notificationFiringsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "notification_rule_firings_total",
+				Help: "Total number of notification rule firings by rule type",
+			},
+			[]string{"rule_type"},
+		),
+
+		digestEmailsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "digest_emails_total",
+				Help: "Total number of digest emails by frequency and outcome",
+			},
+			[]string{"frequency", "status"},
+		),
+
+		replicationPushesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "replication_pushes_total",
+				Help: "Total number of override replication pushes by peer and outcome",
+			},
+			[]string{"peer", "status"},
+		),
+
+		replicationMergesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "replication_merges_total",
+				Help: "Total number of replicated writes by record (override or feedback) and merge result: applied, stale or rejected",
+			},
+			[]string{"record", "result"},
+		),
+
+		summaryCacheRequestsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "summary_cache_requests_total",
+				Help: "Total number of summary cache lookups by result",
+			},
+			[]string{"result"},
+		),
+
+		rollupReadsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rollup_reads_total",
+				Help: "Total number of summary and trend reads by source (rollup or scan of raw history)",
+			},
+			[]string{"source"},
+		),
+
+		storeQueriesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "store_queries_total",
+				Help: "Total number of history store queries by target (primary or replica) and operation",
+			},
+			[]string{"target", "operation"},
+		),
+
+		storeReplicaLag: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "store_replica_lag_seconds",
+				Help: "Age of the oldest history write not yet copied to the read replica",
+			},
+		),
+
+		tsdbExportsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tsdb_exports_total",
+				Help: "Total number of business-metric pushes to the TSDB by outcome",
+			},
+			[]string{"status"},
+		),
+
+		translationsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "description_translations_total",
+				Help: "Total number of description translations by detected language and outcome (cached, translated, failed or skipped)",
+			},
+			[]string{"language", "result"},
+		),
+
+		buildInfo: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "build_info",
+				Help: "Build and data versions of the running service; always 1",
+			},
+			[]string{"version", "git_sha", "build_time", "go_version", "ruleset_version", "model_version"},
+		),
+
+		serviceStartTime: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "service_start_time_seconds",
+				Help: "Unix time the service started",
+			},
+		),
+
+		rulesLastReload: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "rules_last_reload_timestamp_seconds",
+				Help: "Unix time the active ruleset was last loaded successfully",
+			},
+		),
+
+		modelLastLoad: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "model_last_load_timestamp_seconds",
+				Help: "Unix time the fallback classifier model was last loaded; 0 if none is loaded",
+			},
+		),
+
+		monzoLastSync: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "monzo_last_sync_timestamp_seconds",
+				Help: "Unix time of the last successful Monzo API call; 0 if there has been none",
+			},
+		),
+
+		monzoTokenRefreshesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "monzo_token_refreshes_total",
+				Help: "Total number of Monzo access token refreshes by result",
+			},
+			[]string{"result"},
+		),
+
+		monzoWebhookChangesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "monzo_webhook_reconciliations_total",
+				Help: "Total number of Monzo webhooks registered or removed to fix drift, and of failed reconciliations",
+			},
+			[]string{"result"},
+		),
+
+		monzoWriteBacksTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "monzo_writebacks_total",
+				Help: "Total number of category write-backs to Monzo transactions by result",
+			},
+			[]string{"result"},
+		),
+
+		monzoReceiptsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "monzo_receipt_pushes_total",
+				Help: "Total number of itemized receipts pushed to Monzo by result",
+			},
+			[]string{"result"},
+		),
+
+		monzoQueueDepth: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "monzo_rate_limit_queue_depth",
+				Help: "Monzo API calls waiting on the rate limit budget by priority",
+			},
+			[]string{"priority"},
+		),
+
+		monzoThrottledTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "monzo_rate_limit_throttled_total",
+				Help: "Total number of Monzo API calls held back by reason: budget (client-side) or rate_limited (a 429 from Monzo)",
+			},
+			[]string{"reason"},
+		),
+
+		monzoSyncedTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "monzo_synced_transactions_total",
+				Help: "Total number of transactions read by Monzo syncs by result: imported, settled or skipped",
+			},
+			[]string{"result"},
+		),
+
+		rejectedRequestsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_rejected_requests_total",
+				Help: "Total number of requests rejected by a route group's middleware",
+			},
+			[]string{"group", "reason"},
+		),
+
+		deprecatedUsageTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "deprecated_usage_total",
+				Help: "Total number of requests using a deprecated endpoint or field, by caller",
+			},
+			[]string{"surface", "caller"},
+		),
+
+		eventsEmittedTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "events_emitted_total",
+				Help: "Total number of events emitted",
+			},
+			[]string{"type"},
+		),
+
+		eventDeliveriesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "event_deliveries_total",
+				Help: "Total number of webhook event deliveries",
+			},
+			[]string{"type", "status"},
+		),
+
+		httpRequestsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Total number of HTTP requests",
+			},
+			[]string{"method", "endpoint", "status_code"},
+		),
+
+		httpRequestDuration: factory.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "HTTP request duration in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"method", "endpoint"},
+		),
+	}
+}
+
+// Registry returns the registry the collectors are registered on, to gather or serve them
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
 
 // Helper functions for recording metrics
-func recordCategorizationRequest(category, status string) {
-	categorizationRequestsTotal.WithLabelValues(category, status).Inc()
+func (m *Metrics) recordCategorizationRequest(category, status, tenantID string) {
+	m.categorizationRequestsTotal.WithLabelValues(categoryLabels.Value(category), status, m.tenantLabel(tenantID)).Inc()
+}
+
+func (m *Metrics) recordCategorizationError(errorType string) {
+	m.categorizationErrorsTotal.WithLabelValues(errorType).Inc()
+}
+
+func (m *Metrics) recordCategorizationDuration(category, backend, stage, tenantID string, duration time.Duration) {
+	category, tenant := categoryLabels.Value(category), m.tenantLabel(tenantID)
+	m.categorizationDuration.WithLabelValues(category, backend, stage, tenant).Observe(duration.Seconds())
+	m.observeStatsd("categorization_duration_seconds", duration.Seconds(),
+		map[string]string{"category": category, "backend": backend, "stage": stage, "tenant": tenant})
+}
+
+func (m *Metrics) recordStageDuration(stage string, duration time.Duration) {
+	m.stageDuration.WithLabelValues(stage).Observe(duration.Seconds())
+	m.observeStatsd("categorization_stage_duration_seconds", duration.Seconds(), map[string]string{"stage": stage})
+}
+
+func (m *Metrics) recordFuzzyMatch(category string) {
+	m.fuzzyMatchesTotal.WithLabelValues(categoryLabels.Value(category)).Inc()
+}
+
+func (m *Metrics) recordDuplicate() {
+	m.duplicatesTotal.Inc()
+}
+
+func (m *Metrics) recordDecline(category, reason string) {
+	m.declinedTransactionsTotal.WithLabelValues(categoryLabels.Value(category), reason).Inc()
+}
+
+func (m *Metrics) recordRiskFlag(flag string) {
+	m.riskFlagsTotal.WithLabelValues(flag).Inc()
+}
+
+func (m *Metrics) recordBlockedTransaction(blockType string) {
+	m.blockedTransactionsTotal.WithLabelValues(blockType).Inc()
+}
+
+func (m *Metrics) recordRoundUpDeposit(status string) {
+	m.roundUpDepositsTotal.WithLabelValues(status).Inc()
+}
+
+func (m *Metrics) recordNotificationFired(ruleType string) {
+	m.notificationFiringsTotal.WithLabelValues(ruleType).Inc()
+}
+
+func (m *Metrics) recordDigestEmail(frequency, status string) {
+	m.digestEmailsTotal.WithLabelValues(frequency, status).Inc()
+}
+
+func (m *Metrics) recordReplicationPush(peer, status string) {
+	m.replicationPushesTotal.WithLabelValues(peer, status).Inc()
+}
+
+func (m *Metrics) recordReplicationMerge(record, result string) {
+	m.replicationMergesTotal.WithLabelValues(record, result).Inc()
+}
+
+func (m *Metrics) recordSummaryCache(result string) {
+	m.summaryCacheRequestsTotal.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) recordRollupRead(source string) {
+	m.rollupReadsTotal.WithLabelValues(source).Inc()
+}
+
+func (m *Metrics) recordStoreQuery(target, operation string) {
+	m.storeQueriesTotal.WithLabelValues(target, operation).Inc()
+}
+
+func (m *Metrics) setStoreReplicaLag(lag time.Duration) {
+	m.storeReplicaLag.Set(lag.Seconds())
+}
+
+func (m *Metrics) recordTSDBExport(status string) {
+	m.tsdbExportsTotal.WithLabelValues(status).Inc()
+}
+
+func (m *Metrics) recordTranslation(language, result string) {
+	m.translationsTotal.WithLabelValues(language, result).Inc()
+}
+
+func (m *Metrics) recordBuildInfo(info BuildInfo) {
+	m.buildInfo.Reset()
+	m.buildInfo.WithLabelValues(info.Version, info.GitSHA, info.BuildTime, info.GoVersion, info.RulesetVersion, info.ModelVersion).Set(1)
+}
+
+func (m *Metrics) recordServiceStart() {
+	m.serviceStartTime.SetToCurrentTime()
+}
+
+func (m *Metrics) recordRulesLoaded() {
+	m.rulesLastReload.SetToCurrentTime()
+}
+
+func (m *Metrics) recordModelLoaded() {
+	m.modelLastLoad.SetToCurrentTime()
+}
+
+func (m *Metrics) recordMonzoSync() {
+	m.monzoLastSync.SetToCurrentTime()
+}
+
+func (m *Metrics) recordMonzoTokenRefresh(result string) {
+	m.monzoTokenRefreshesTotal.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) recordWebhookReconciliation(result string, n int) {
+	m.monzoWebhookChangesTotal.WithLabelValues(result).Add(float64(n))
 }
 
-func recordCategorizationError(errorType string) {
-	categorizationErrorsTotal.WithLabelValues(errorType).Inc()
+func (m *Metrics) recordMonzoWriteBack(result string) {
+	m.monzoWriteBacksTotal.WithLabelValues(result).Inc()
 }
 
-func recordCategorizationDuration(category string, duration time.Duration) {
-	categorizationDuration.WithLabelValues(category).Observe(duration.Seconds())
+func (m *Metrics) recordMonzoReceipt(result string) {
+	m.monzoReceiptsTotal.WithLabelValues(result).Inc()
 }
 
-func recordHTTPRequest(method, endpoint, statusCode string) {
-	httpRequestsTotal.WithLabelValues(method, endpoint, statusCode).Inc()
+func (m *Metrics) addMonzoQueueDepth(priority string, delta int) {
+	m.monzoQueueDepth.WithLabelValues(priority).Add(float64(delta))
 }
 
-func recordHTTPDuration(method, endpoint string, duration time.Duration) {
-	httpRequestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
+func (m *Metrics) recordMonzoThrottled(reason string) {
+	m.monzoThrottledTotal.WithLabelValues(reason).Inc()
 }
 
-// Middleware to track HTTP metrics
-func MetricsMiddleware() gin.HandlerFunc {
+func (m *Metrics) recordMonzoSyncedTransaction(result string) {
+	m.monzoSyncedTotal.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) recordRejectedRequest(group, reason string) {
+	m.rejectedRequestsTotal.WithLabelValues(group, reason).Inc()
+}
+
+func (m *Metrics) recordDeprecatedUsage(surface, caller string) {
+	m.deprecatedUsageTotal.WithLabelValues(surface, caller).Inc()
+}
+
+func (m *Metrics) recordEvent(eventType string) {
+	m.eventsEmittedTotal.WithLabelValues(eventType).Inc()
+}
+
+func (m *Metrics) recordEventDelivery(eventType, status string) {
+	m.eventDeliveriesTotal.WithLabelValues(eventType, status).Inc()
+}
+
+func (m *Metrics) recordHTTPRequest(method, endpoint, statusCode string) {
+	m.httpRequestsTotal.WithLabelValues(method, endpoint, statusCode).Inc()
+}
+
+func (m *Metrics) recordHTTPDuration(method, endpoint string, duration time.Duration) {
+	m.httpRequestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
+	m.observeStatsd("http_request_duration_seconds", duration.Seconds(), map[string]string{"method": method, "endpoint": endpoint})
+}
+
+// otherLabel replaces label values that would add unbounded series
+const otherLabel = "other"
+
+// labelGuard caps the distinct values a label can take; once max values have been seen,
+// new ones are reported as "other"
+type labelGuard struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]bool
+}
+
+// newLabelGuard creates a guard allowing up to max distinct values
+func newLabelGuard(max int) *labelGuard {
+	return &labelGuard{max: max, seen: map[string]bool{}}
+}
+
+// Value returns value if it is already known or there is room for it, and "other" otherwise
+func (g *labelGuard) Value(value string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen[value] {
+		return value
+	}
+	if len(g.seen) >= g.max {
+		return otherLabel
+	}
+	g.seen[value] = true
+	return value
+}
+
+// Label guards. Endpoints are route templates and categories come from the ruleset, so
+// both are bounded in practice; the guards stop a bug or a bad ruleset from exploding
+// series counts.
+var (
+	endpointLabels = newLabelGuard(200)
+	categoryLabels = newLabelGuard(100)
+)
+
+// tenantLabel returns the tenant label for a request: the tenant ID if it is on
+// METRICS_TENANT_ALLOWLIST, "other" for any other tenant, and empty (no label) when the
+// request has no tenant or the allowlist is unset
+func (m *Metrics) tenantLabel(tenantID string) string {
+	if tenantID == "" || len(m.tenants) == 0 {
+		return ""
+	}
+	if m.tenants[tenantID] {
+		return tenantID
+	}
+	return otherLabel
+}
+
+// httpMethods are the methods reported as-is; anything else is "other"
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// httpLabels returns the method and endpoint labels for a request. The endpoint is gin's
+// route template (e.g. /users/:user_id/blocks/:id), never the raw path, and requests that
+// matched no route are "other".
+func httpLabels(c *gin.Context) (string, string) {
+	method := c.Request.Method
+	if !httpMethods[method] {
+		method = otherLabel
+	}
+	endpoint := c.FullPath()
+	if endpoint == "" {
+		endpoint = otherLabel
+	}
+	return method, endpointLabels.Value(endpoint)
+}
+
+// MetricsMiddleware tracks HTTP metrics in the server's registry
+func (s *Server) MetricsMiddleware() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		start := time.Now()
-		path := c.Request.URL.Path
-		method := c.Request.Method
 
 		c.Next()
 
@@ -88,10 +627,11 @@ func MetricsMiddleware() gin.HandlerFunc {
 		statusCode := strconv.Itoa(c.Writer.Status())
 
 		// Record metrics
-		recordHTTPRequest(method, path, statusCode)
-		recordHTTPDuration(method, path, duration)
-		
+		method, endpoint := httpLabels(c)
+		s.metrics.recordHTTPRequest(method, endpoint, statusCode)
+		s.metrics.recordHTTPDuration(method, endpoint, duration)
+
 		// Log HTTP request
-		logHTTPRequest(method, path, statusCode, duration)
+		s.requestLogger(c).logHTTPRequest(c.Request.Method, c.Request.URL.Path, statusCode, duration)
 	})
-}
\ No newline at end of file
+}
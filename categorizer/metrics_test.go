@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestLabelGuard checks a guard keeps the values it has seen and reports new ones as "other"
+// once full
+func TestLabelGuard(t *testing.T) {
+	g := newLabelGuard(2)
+	for _, tt := range []struct{ value, want string }{
+		{"a", "a"},
+		{"b", "b"},
+		{"c", otherLabel},
+		{"a", "a"},
+		{"b", "b"},
+		{"d", otherLabel},
+	} {
+		if got := g.Value(tt.value); got != tt.want {
+			t.Errorf("Value(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestHTTPLabels checks requests are labelled by route template, with unmatched routes and
+// non-standard methods reported as "other"
+func TestHTTPLabels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var method, endpoint string
+	record := func(c *gin.Context) { method, endpoint = httpLabels(c) }
+	r := gin.New()
+	r.GET("/users/:user_id/blocks", record)
+	r.Handle("PROPFIND", "/users/:user_id/blocks", record)
+	r.NoRoute(record)
+
+	tests := []struct {
+		method, path          string
+		wantMethod, wantRoute string
+	}{
+		{http.MethodGet, "/users/u1/blocks", http.MethodGet, "/users/:user_id/blocks"},
+		{http.MethodGet, "/users/u2/blocks", http.MethodGet, "/users/:user_id/blocks"},
+		{"PROPFIND", "/users/u1/blocks", otherLabel, "/users/:user_id/blocks"},
+		{http.MethodGet, "/no/such/route", http.MethodGet, otherLabel},
+	}
+	for _, tt := range tests {
+		method, endpoint = "", ""
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if method != tt.wantMethod || endpoint != tt.wantRoute {
+			t.Errorf("%s %s labelled %s %s, want %s %s", tt.method, tt.path, method, endpoint, tt.wantMethod, tt.wantRoute)
+		}
+	}
+}
+
+// TestServerMetricsRegistry checks each server records requests in the registry it was given
+// and nowhere else
+func TestServerMetricsRegistry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newServer := func() (*Server, *prometheus.Registry) {
+		reg := prometheus.NewRegistry()
+		m := NewMetrics(reg, "test", nil)
+		s := NewServer(Config{}, NewStructuredLogger("test", nil), m, newMemoryStore(0), NewClassifier(defaultRuleSet(), nil, m))
+		return s, reg
+	}
+	requests := func(reg *prometheus.Registry) float64 {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := 0.0
+		for _, mf := range families {
+			if mf.GetName() == "http_requests_total" {
+				for _, m := range mf.Metric {
+					total += m.GetCounter().GetValue()
+				}
+			}
+		}
+		return total
+	}
+
+	served, servedReg := newServer()
+	_, idleReg := newServer()
+	served.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if got := requests(servedReg); got != 1 {
+		t.Errorf("serving server recorded %v requests, want 1", got)
+	}
+	if got := requests(idleReg); got != 0 {
+		t.Errorf("idle server recorded %v requests, want 0", got)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callerKey and tenantKey are the gin context keys holding the name of the authenticated
+// caller and the tenant its key is bound to
+const (
+	callerKey = "caller"
+	tenantKey = "tenant"
+)
+
+// errTenantForbidden is returned when a caller bound to one tenant asks about another
+var errTenantForbidden = errors.New("API key is not allowed to act for this tenant")
+
+// apiKey is a configured API key, the caller it identifies and, when bound to one, the only
+// tenant it may act for
+type apiKey struct {
+	caller   string
+	key      string
+	tenantID string
+}
+
+// parseAPIKeys reads API keys configured as "name:key" or "name:key:tenant_id", or as a bare
+// key which is then named by its position
+func parseAPIKeys(entries []string) []apiKey {
+	keys := make([]apiKey, 0, len(entries))
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		k := apiKey{caller: fmt.Sprintf("key%d", i+1), key: entry}
+		if len(parts) > 1 {
+			k.caller, k.key = parts[0], parts[1]
+		}
+		if len(parts) > 2 {
+			k.tenantID = parts[2]
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// requestAPIKey returns the key a request presents as a bearer token or X-API-Key header
+func requestAPIKey(c *gin.Context) string {
+	if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return c.GetHeader("X-API-Key")
+}
+
+// requireAPIKey rejects requests without one of the keys, recording the caller and its tenant
+// on those it lets through. A tenant-bound key is also rejected when the route's tenant_id,
+// in the path or the query, is another tenant's.
+func (s *Server) requireAPIKey(group string, keys []apiKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k, ok := matchAPIKey(requestAPIKey(c), keys)
+		if !ok {
+			s.metrics.recordRejectedRequest(group, "unauthorized")
+			c.Header("WWW-Authenticate", `Bearer realm="categorizer"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid API key"})
+			return
+		}
+		c.Set(callerKey, k.caller)
+		if k.tenantID != "" {
+			c.Set(tenantKey, k.tenantID)
+			for _, tenantID := range []string{c.Param("tenant_id"), c.Query("tenant_id")} {
+				if tenantID != "" && tenantID != k.tenantID {
+					s.metrics.recordRejectedRequest(group, "forbidden")
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errTenantForbidden.Error()})
+					return
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// requireConfiguredKeys rejects every request to a group whose keys aren't configured, so
+// forgetting them locks the group rather than opening it
+func (s *Server) requireConfiguredKeys(group, env string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.metrics.recordRejectedRequest(group, "unauthorized")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": env + " is not configured"})
+	}
+}
+
+// matchAPIKey returns the key a presented key matches
+func matchAPIKey(presented string, keys []apiKey) (apiKey, bool) {
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(k.key)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// callerName returns the authenticated caller, or "anonymous" when auth is disabled
+func callerName(c *gin.Context) string {
+	if caller := c.GetString(callerKey); caller != "" {
+		return caller
+	}
+	return "anonymous"
+}
+
+// scopeTenant checks a tenant ID from a request body against the tenant the caller's key is
+// bound to, filling it in when the request left it out. Unbound callers may use any tenant.
+func scopeTenant(c *gin.Context, tenantID string) (string, error) {
+	bound := c.GetString(tenantKey)
+	switch {
+	case bound == "" || tenantID == bound:
+		return tenantID, nil
+	case tenantID == "":
+		return bound, nil
+	}
+	return "", errTenantForbidden
+}
+
+// tokenBucket is one client's rate limit allowance
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client IP
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// maxRateLimitClients bounds the buckets kept before idle ones are swept
+const maxRateLimitClients = 10000
+
+// newRateLimiter allows rate requests a second per client, with bursts up to burst
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+}
+
+// Allow takes a token for client, or reports how long until one is available
+func (l *rateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.buckets) >= maxRateLimitClients {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets idle long enough to have refilled, which behave as new ones would
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimit answers 429 once a client IP exceeds the limiter; a nil limiter allows everything
+func (s *Server) rateLimit(group string, limiter *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+		if ok, wait := limiter.Allow(c.ClientIP()); !ok {
+			s.metrics.recordRejectedRequest(group, "rate_limited")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// maxBodySize rejects request bodies larger than limit bytes
+func (s *Server) maxBodySize(group string, limit int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > int64(limit) {
+			s.metrics.recordRejectedRequest(group, "body_too_large")
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body exceeds %d bytes", limit)})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(limit))
+		c.Next()
+	}
+}
+
+// cors allows browsers on the configured origins, or any origin for "*", to call the API,
+// answering preflight requests itself
+func cors(origins []string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[strings.TrimSpace(origin)] = true
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			c.Next()
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Vary", "Origin")
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
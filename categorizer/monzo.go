@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// monzoClient calls the Monzo API on behalf of a single account. Its calls draw on the rate
+// limit budget shared by every client of the same access token, at its priority.
+type monzoClient struct {
+	baseURL   string
+	token     func() (string, error)
+	accountID string
+	budget    string
+	priority  monzoPriority
+	http      *http.Client
+	metrics   *Metrics
+}
+
+// newMonzoClient creates a Monzo API client for the account and access token in the
+// service configuration, recording its calls in m
+func newMonzoClient(cfg Config, m *Metrics) *monzoClient {
+	return &monzoClient{
+		baseURL:   strings.TrimRight(cfg.MonzoAPIURL, "/"),
+		token:     func() (string, error) { return cfg.MonzoAccessToken, nil },
+		accountID: cfg.MonzoAccountID,
+		budget:    "config",
+		priority:  PriorityFollowUp,
+		http:      &http.Client{Timeout: 10 * time.Second},
+		metrics:   m,
+	}
+}
+
+// newConnectionClient creates a Monzo API client for a connected account, taking access
+// tokens from the token manager so they are refreshed as they expire
+func newConnectionClient(cfg Config, metrics *Metrics, conn MonzoConnection) *monzoClient {
+	m := newMonzoClient(cfg, metrics)
+	m.token = func() (string, error) { return monzoTokens.AccessToken(conn.ID) }
+	m.accountID = conn.AccountID
+	m.budget = conn.ID
+	return m
+}
+
+// withPriority returns a copy of the client making its calls at priority p
+func (m *monzoClient) withPriority(p monzoPriority) *monzoClient {
+	copied := *m
+	copied.priority = p
+	return &copied
+}
+
+// monzoAPIError is a non-2xx response from the Monzo API
+type monzoAPIError struct {
+	method, path string
+	status       int
+	body         string
+	// retryAfter is how long a rate-limited (429) caller was asked to wait, if it said
+	retryAfter time.Duration
+}
+
+func (e *monzoAPIError) Error() string {
+	return fmt.Sprintf("monzo %s %s: %d %s: %s", e.method, e.path, e.status, http.StatusText(e.status), e.body)
+}
+
+// do sends a request, form-encoded in the body or, for GET and DELETE, the query string,
+// and decodes the response into out when it isn't nil. Any non-2xx response is a
+// *monzoAPIError.
+func (m *monzoClient) do(method, path string, form url.Values, out interface{}) error {
+	target, body := m.baseURL+path, strings.NewReader(form.Encode())
+	if method == http.MethodGet || method == http.MethodDelete {
+		if len(form) > 0 {
+			target += "?" + form.Encode()
+		}
+		body = strings.NewReader("")
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return m.send(req, path, out)
+}
+
+// doJSON sends a request with a JSON body, for the endpoints that take one
+func (m *monzoClient) doJSON(method, path string, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, m.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return m.send(req, path, out)
+}
+
+// send authorizes and sends a request once the rate limit budget allows, decoding the
+// response into out when it isn't nil. A rate-limited request pauses the budget for Monzo's
+// Retry-After and, unless it's background work that its queue will retry, is tried again up
+// to maxRateLimitRetries times.
+func (m *monzoClient) send(req *http.Request, path string, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		monzoBudget.Wait(m.budget, m.priority)
+		err := m.sendOnce(req, path, out)
+		var apiErr *monzoAPIError
+		if !errors.As(err, &apiErr) || apiErr.status != http.StatusTooManyRequests {
+			return err
+		}
+		m.metrics.recordMonzoThrottled("rate_limited")
+		wait := apiErr.retryAfter
+		if wait <= 0 {
+			wait = defaultRetryAfter
+		}
+		monzoBudget.Pause(m.budget, wait)
+		if m.priority == PriorityBackground || attempt == maxRateLimitRetries || req.GetBody == nil {
+			return err
+		}
+		if req.Body, err = req.GetBody(); err != nil {
+			return err
+		}
+	}
+}
+
+// sendOnce authorizes and sends a request
+func (m *monzoClient) sendOnce(req *http.Request, path string, out interface{}) error {
+	token, err := m.token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	method := req.Method
+
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		apiErr := &monzoAPIError{method: method, path: path, status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			apiErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode monzo %s %s: %w", method, path, err)
+		}
+	}
+	m.metrics.recordMonzoSync()
+	return nil
+}
+
+// Ping checks the access token against the whoami endpoint
+func (m *monzoClient) Ping() error {
+	token, err := m.token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, m.baseURL+"/ping/whoami", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("monzo whoami: %s", resp.Status)
+	}
+	return nil
+}
+
+// DepositToPot moves an amount in pounds from the account into a pot. The dedupe ID makes
+// retries of the same deposit safe.
+func (m *monzoClient) DepositToPot(potID, dedupeID string, amount float64) error {
+	form := url.Values{
+		"source_account_id": {m.accountID},
+		"amount":            {strconv.FormatInt(int64(math.Round(amount*100)), 10)},
+		"dedupe_id":         {dedupeID},
+	}
+	return m.do(http.MethodPut, "/pots/"+url.PathEscape(potID)+"/deposit", form, nil)
+}
+
+// AnnotateTransaction sets a metadata key on a transaction. The notes key sets the notes
+// shown on the transaction in the Monzo app.
+func (m *monzoClient) AnnotateTransaction(transactionID, key, value string) error {
+	form := url.Values{"metadata[" + key + "]": {value}}
+	return m.do(http.MethodPatch, "/transactions/"+url.PathEscape(transactionID), form, nil)
+}
+
+// monzoReceipt is an itemized receipt attached to a transaction in the Monzo app. Amounts
+// are in minor units. Monzo keys receipts by external ID, so sending one again replaces it.
+type monzoReceipt struct {
+	TransactionID string             `json:"transaction_id"`
+	ExternalID    string             `json:"external_id"`
+	Total         int64              `json:"total"`
+	Currency      string             `json:"currency"`
+	Items         []monzoReceiptItem `json:"items"`
+}
+
+// monzoReceiptItem is a line on a Monzo receipt
+type monzoReceiptItem struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit,omitempty"`
+	Amount      int64   `json:"amount"`
+	Currency    string  `json:"currency"`
+	Tax         int64   `json:"tax,omitempty"`
+}
+
+// PutReceipt creates or replaces a transaction's receipt
+func (m *monzoClient) PutReceipt(receipt monzoReceipt) error {
+	return m.doJSON(http.MethodPut, "/transaction-receipts", receipt, nil)
+}
+
+// DeleteReceipt removes the receipt with an external ID
+func (m *monzoClient) DeleteReceipt(externalID string) error {
+	return m.do(http.MethodDelete, "/transaction-receipts", url.Values{"external_id": {externalID}}, nil)
+}
+
+// MonzoAccount is an account the authorized Monzo user holds
+type MonzoAccount struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Closed      bool   `json:"closed"`
+}
+
+// Accounts lists the user's accounts of a type, such as uk_retail, or of any type when
+// accountType is empty
+func (m *monzoClient) Accounts(accountType string) ([]MonzoAccount, error) {
+	form := url.Values{}
+	if accountType != "" {
+		form.Set("account_type", accountType)
+	}
+	var resp struct {
+		Accounts []MonzoAccount `json:"accounts"`
+	}
+	err := m.do(http.MethodGet, "/accounts", form, &resp)
+	return resp.Accounts, err
+}
+
+// MonzoWebhook is a URL Monzo posts the account's transaction events to
+type MonzoWebhook struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+	URL       string `json:"url"`
+}
+
+// Webhooks lists the webhooks registered on the account
+func (m *monzoClient) Webhooks() ([]MonzoWebhook, error) {
+	var resp struct {
+		Webhooks []MonzoWebhook `json:"webhooks"`
+	}
+	err := m.do(http.MethodGet, "/webhooks", url.Values{"account_id": {m.accountID}}, &resp)
+	return resp.Webhooks, err
+}
+
+// RegisterWebhook asks Monzo to post the account's transaction events to webhookURL
+func (m *monzoClient) RegisterWebhook(webhookURL string) (MonzoWebhook, error) {
+	var resp struct {
+		Webhook MonzoWebhook `json:"webhook"`
+	}
+	err := m.do(http.MethodPost, "/webhooks", url.Values{"account_id": {m.accountID}, "url": {webhookURL}}, &resp)
+	return resp.Webhook, err
+}
+
+// DeleteWebhook removes a webhook
+func (m *monzoClient) DeleteWebhook(id string) error {
+	return m.do(http.MethodDelete, "/webhooks/"+url.PathEscape(id), nil, nil)
+}
+
+// Logout revokes the client's access and refresh tokens
+func (m *monzoClient) Logout() error {
+	return m.do(http.MethodPost, "/oauth2/logout", nil, nil)
+}
+
+// MonzoMerchant is the merchant a transaction was made with, when Monzo recognised one
+type MonzoMerchant struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+// MonzoTransaction is a transaction on a Monzo account. Amounts are in minor units, negative
+// for money out, and Settled is empty while the transaction is pending.
+type MonzoTransaction struct {
+	ID            string         `json:"id"`
+	Created       time.Time      `json:"created"`
+	Description   string         `json:"description"`
+	Amount        int64          `json:"amount"`
+	Currency      string         `json:"currency"`
+	Merchant      *MonzoMerchant `json:"merchant"`
+	Settled       string         `json:"settled"`
+	DeclineReason string         `json:"decline_reason"`
+}
+
+// monzoTransactionPage is the most transactions Monzo returns in one page
+const monzoTransactionPage = 100
+
+// Transactions lists a page of the account's transactions, oldest first, with merchants
+// expanded. since is a transaction ID to continue after or an RFC 3339 time, and before an
+// RFC 3339 time; either may be empty to leave that end open.
+func (m *monzoClient) Transactions(since, before string, limit int) ([]MonzoTransaction, error) {
+	form := url.Values{
+		"account_id": {m.accountID},
+		"expand[]":   {"merchant"},
+		"limit":      {strconv.Itoa(limit)},
+	}
+	if since != "" {
+		form.Set("since", since)
+	}
+	if before != "" {
+		form.Set("before", before)
+	}
+	var resp struct {
+		Transactions []MonzoTransaction `json:"transactions"`
+	}
+	err := m.do(http.MethodGet, "/transactions", form, &resp)
+	return resp.Transactions, err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAccountType is the kind of Monzo account connected unless another is asked for
+const defaultAccountType = "uk_retail"
+
+// oauthSessionCookie holds the nonce binding an authorization to the browser that started it
+const oauthSessionCookie = "monzo_oauth_session"
+
+// finishConnection picks the account a connection is for and registers the Monzo webhook
+// on it. Monzo refuses API calls until the user approves access in their app, so this is
+// retried until it succeeds.
+func (s *Server) finishConnection(conn MonzoConnection) (MonzoConnection, error) {
+	client := newConnectionClient(s.config, s.metrics, conn)
+	if conn.AccountID == "" {
+		accounts, err := client.Accounts(conn.AccountType)
+		if err != nil {
+			return conn, err
+		}
+		for _, account := range accounts {
+			if !account.Closed {
+				conn.AccountID = account.ID
+				break
+			}
+		}
+		if conn.AccountID == "" {
+			return conn, fmt.Errorf("no open %s account", conn.AccountType)
+		}
+		client.accountID = conn.AccountID
+	}
+	if conn.WebhookID == "" && s.config.MonzoWebhookURL != "" {
+		provider, err := bankProvider("monzo")
+		if err != nil {
+			return conn, err
+		}
+		webhookID, err := provider.Subscribe(conn.account(), s.config.MonzoWebhookURL)
+		if err != nil {
+			return conn, err
+		}
+		conn.WebhookID = webhookID
+	}
+	return conn, nil
+}
+
+// finishPendingConnections retries finishing connections awaiting the user's approval
+func (s *Server) finishPendingConnections() {
+	for _, conn := range monzoTokens.List("") {
+		if conn.AccountID != "" && (conn.WebhookID != "" || s.config.MonzoWebhookURL == "") {
+			continue
+		}
+		finished, err := s.finishConnection(conn)
+		current, ok := monzoTokens.Get(conn.ID)
+		if !ok {
+			continue
+		}
+		lastError := ""
+		if err != nil {
+			lastError = err.Error()
+		}
+		if current.AccountID != finished.AccountID || current.WebhookID != finished.WebhookID || current.LastError != lastError {
+			current.AccountID, current.WebhookID, current.LastError = finished.AccountID, finished.WebhookID, lastError
+			monzoTokens.Put(current)
+		}
+	}
+}
+
+// handleConnectMonzo serves GET /connect/monzo?user_id=, redirecting the user to Monzo to
+// authorize access to their account, of ?account_type= (default uk_retail), or to
+// re-authorize ?connection_id=. Monzo sends them back to the callback, which only accepts
+// the browser that was sent.
+func (s *Server) handleConnectMonzo(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	pending := oauthState{UserID: userID, AccountType: c.DefaultQuery("account_type", defaultAccountType)}
+	if id := c.Query("connection_id"); id != "" {
+		conn, ok := monzoTokens.Get(id)
+		if !ok || conn.UserID != userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+			return
+		}
+		pending = oauthState{UserID: userID, AccountType: conn.AccountType, ConnectionID: conn.ID}
+	}
+	target, session, err := monzoTokens.AuthorizeURL(pending)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthSessionCookie, session, int(oauthStateTTL.Seconds()), "/connect/monzo", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, target)
+}
+
+// handleMonzoCallback serves GET /connect/monzo/callback, where Monzo returns the user with
+// an authorization code. The code is exchanged for tokens, saved as a new connection or onto
+// the connection being re-authorized, and the webhook registered once Monzo allows it.
+func (s *Server) handleMonzoCallback(c *gin.Context) {
+	session, _ := c.Cookie(oauthSessionCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthSessionCookie, "", -1, "/connect/monzo", "", c.Request.TLS != nil, true)
+	pending, ok := monzoTokens.ConsumeState(c.Query("state"), session)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown or expired state"})
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "monzo authorization failed: " + reason})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	tokens, err := monzoTokens.Exchange(code)
+	if err != nil {
+		s.requestLogger(c).Warn("Monzo authorization code exchange failed", map[string]interface{}{
+			"event_type":    "monzo_connection",
+			"user_id":       pending.UserID,
+			"error_message": err.Error(),
+		})
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	conn := MonzoConnection{ID: newID("conn_"), UserID: pending.UserID, AccountType: pending.AccountType}
+	if pending.ConnectionID != "" {
+		existing, ok := monzoTokens.Get(pending.ConnectionID)
+		if !ok || existing.UserID != pending.UserID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+			return
+		}
+		// Re-authorizing must not hand the connection to a different Monzo account
+		if existing.MonzoUserID != "" && existing.MonzoUserID != tokens.UserID {
+			c.JSON(http.StatusConflict, gin.H{"error": "authorized Monzo account does not match the connection"})
+			return
+		}
+		conn = existing
+	}
+	conn.MonzoUserID = tokens.UserID
+	conn.AccessToken = tokens.AccessToken
+	conn.RefreshToken = tokens.RefreshToken
+	conn.ExpiresAt = now.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	conn.AuthenticatedAt = now
+	conn.RefreshedAt = nil
+	conn.NeedsReauth = false
+	conn.LastError = ""
+	monzoTokens.Put(conn)
+
+	status := http.StatusCreated
+	finished, err := s.finishConnection(conn)
+	if err != nil {
+		// Most often the user hasn't yet approved access in the Monzo app
+		status = http.StatusAccepted
+		finished.LastError = err.Error()
+	}
+	monzoTokens.Put(finished)
+	if err == nil {
+		// Monzo only allows the full history to be read shortly after authentication
+		s.startMonzoSync(finished, MonzoSyncRequest{Full: true}, finished.UserID)
+	}
+	s.requestLogger(c).Info("Monzo connection authorized", map[string]interface{}{
+		"event_type":    "monzo_connection",
+		"connection_id": finished.ID,
+		"user_id":       finished.UserID,
+	})
+	c.JSON(status, monzoTokens.Status(finished, now))
+}
+
+// handleDeleteConnection serves DELETE /connections/:id?user_id=, deregistering the
+// connection's webhooks from Monzo and revoking its tokens before forgetting it. When Monzo
+// can't be reached the connection is kept, unless ?force=true.
+func (s *Server) handleDeleteConnection(c *gin.Context) {
+	conn, ok := monzoTokens.Get(c.Param("id"))
+	if !ok || conn.UserID != c.Query("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+	if err := s.disconnectMonzo(conn); err != nil && c.Query("force") != "true" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	monzoTokens.Remove(conn.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// disconnectMonzo removes the webhooks a connection registered and revokes its tokens. A
+// connection that must be re-authorized can't make either call, and is left to expire.
+func (s *Server) disconnectMonzo(conn MonzoConnection) error {
+	if conn.AccountID == "" {
+		return nil
+	}
+	client := newConnectionClient(s.config, s.metrics, conn).withPriority(PriorityInteractive)
+	webhooks, err := client.Webhooks()
+	if errors.Is(err, errReauthRequired) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if webhook.ID == conn.WebhookID || webhook.URL == s.config.MonzoWebhookURL {
+			if err := client.DeleteWebhook(webhook.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return client.Logout()
+}
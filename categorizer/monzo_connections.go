@@ -0,0 +1,585 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Monzo connection statuses
+const (
+	ConnectionActive         = "active"
+	ConnectionPending        = "pending_approval"
+	ConnectionExpired        = "expired"
+	ConnectionReauthDue      = "reauth_due"
+	ConnectionReauthRequired = "reauth_required"
+)
+
+const (
+	// monzoFullHistoryWindow is how long after a user authenticates that their full
+	// transaction history can be read; afterwards only the last monzoRecentHistory is
+	monzoFullHistoryWindow = 5 * time.Minute
+	monzoRecentHistory     = 90 * 24 * time.Hour
+
+	// tokenRefreshMargin is how long before expiry an access token is refreshed
+	tokenRefreshMargin = 5 * time.Minute
+	// reauthWarning is how long before Strong Customer Authentication lapses that a
+	// connection is reported as due for re-authentication
+	reauthWarning = 7 * 24 * time.Hour
+	// oauthStateTTL bounds how long a user has to complete an authorization
+	oauthStateTTL = 10 * time.Minute
+)
+
+// errReauthRequired is returned for connections whose user must authorize again
+var errReauthRequired = errors.New("monzo connection must be re-authorized")
+
+// MonzoConnection is a Monzo account a user has connected, with the OAuth tokens used to call
+// the API on their behalf. Tokens are persisted but never served.
+type MonzoConnection struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	MonzoUserID     string     `json:"monzo_user_id"`
+	AccountID       string     `json:"account_id"`
+	AccountType     string     `json:"account_type,omitempty"`
+	Environment     string     `json:"environment,omitempty"`
+	WebhookID       string     `json:"webhook_id,omitempty"`
+	AccessToken     string     `json:"access_token"`
+	RefreshToken    string     `json:"refresh_token,omitempty"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	AuthenticatedAt time.Time  `json:"authenticated_at"`
+	RefreshedAt     *time.Time `json:"refreshed_at,omitempty"`
+	NeedsReauth     bool       `json:"needs_reauth,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	// SyncCursor is the last Monzo transaction ID synced from the account, which the next
+	// incremental sync continues after
+	SyncCursor string     `json:"sync_cursor,omitempty"`
+	SyncedAt   *time.Time `json:"synced_at,omitempty"`
+}
+
+// account returns the bank account the connection reaches
+func (c MonzoConnection) account() BankAccount {
+	return BankAccount{Provider: "monzo", ConnectionID: c.ID, UserID: c.UserID, AccountID: c.AccountID}
+}
+
+// status reports where a connection stands at now
+func (conn MonzoConnection) status(now time.Time, reauthInterval time.Duration) string {
+	reauthDue := conn.AuthenticatedAt.Add(reauthInterval)
+	switch {
+	case conn.NeedsReauth || !now.Before(reauthDue):
+		return ConnectionReauthRequired
+	case conn.AccountID == "":
+		return ConnectionPending
+	case now.After(reauthDue.Add(-reauthWarning)):
+		return ConnectionReauthDue
+	case !now.Before(conn.ExpiresAt) && conn.RefreshToken == "":
+		return ConnectionReauthRequired
+	case !now.Before(conn.ExpiresAt):
+		return ConnectionExpired
+	}
+	return ConnectionActive
+}
+
+// ConnectionStatus is a connection as served by the API
+type ConnectionStatus struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	AccountID       string     `json:"account_id,omitempty"`
+	Status          string     `json:"status"`
+	Webhook         bool       `json:"webhook_registered"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	AuthenticatedAt time.Time  `json:"authenticated_at"`
+	ReauthDueAt     time.Time  `json:"reauth_due_at"`
+	RefreshedAt     *time.Time `json:"refreshed_at,omitempty"`
+	HistoryFrom     *time.Time `json:"history_from,omitempty"`
+	SyncCursor      string     `json:"sync_cursor,omitempty"`
+	SyncedAt        *time.Time `json:"synced_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	ReauthURL       string     `json:"reauth_url,omitempty"`
+}
+
+// monzoTokenResponse is the body of a Monzo OAuth token response
+type monzoTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	UserID       string `json:"user_id"`
+}
+
+// tokenRejectedError is a token request Monzo refused, as opposed to one that failed to reach it
+type tokenRejectedError struct {
+	status string
+	body   string
+}
+
+func (e *tokenRejectedError) Error() string {
+	return fmt.Sprintf("monzo token request rejected: %s: %s", e.status, e.body)
+}
+
+// oauthState is an authorization a user has been sent to Monzo to complete: a new
+// connection of an account type, or the re-authorization of an existing connection. Session
+// is the nonce held in the cookie of the browser that started it.
+type oauthState struct {
+	UserID       string
+	AccountType  string
+	ConnectionID string
+	Session      string
+	Expires      time.Time
+}
+
+// monzoTokenManager keeps the connected Monzo accounts and their OAuth tokens, refreshing
+// access tokens before they expire and tracking when each user must authenticate again.
+// With a file configured the connections are saved to it on every change and reloaded on
+// startup, so refresh tokens survive restarts.
+type monzoTokenManager struct {
+	mu             sync.Mutex
+	connections    map[string]*MonzoConnection
+	states         map[string]oauthState
+	path           string
+	environment    string
+	apiURL         string
+	authURL        string
+	clientID       string
+	clientSecret   string
+	redirectURL    string
+	reauthInterval time.Duration
+	http           *http.Client
+	metrics        *Metrics
+	logger         *StructuredLogger
+	// refreshing serializes refreshes per connection: Monzo refresh tokens are single use
+	refreshing map[string]*sync.Mutex
+}
+
+// newMonzoTokenManager creates a token manager for the OAuth client in the configuration,
+// recording refreshes in m and logging failures to logger
+func newMonzoTokenManager(cfg Config, m *Metrics, logger *StructuredLogger) *monzoTokenManager {
+	return &monzoTokenManager{
+		connections:    map[string]*MonzoConnection{},
+		refreshing:     map[string]*sync.Mutex{},
+		states:         map[string]oauthState{},
+		environment:    cfg.Environment,
+		apiURL:         strings.TrimRight(cfg.MonzoAPIURL, "/"),
+		authURL:        cfg.MonzoAuthURL,
+		clientID:       cfg.MonzoClientID,
+		clientSecret:   cfg.MonzoClientSecret,
+		redirectURL:    cfg.MonzoRedirectURL,
+		reauthInterval: cfg.MonzoReauthInterval,
+		http:           &http.Client{Timeout: 10 * time.Second},
+		metrics:        m,
+		logger:         logger,
+	}
+}
+
+// Open loads the connections saved in a file, if it exists, and saves changes to it
+func (tm *monzoTokenManager) Open(path string) error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*MonzoConnection
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, conn := range list {
+		// Connections saved before environments were recorded were all production ones
+		env := conn.Environment
+		if env == "" {
+			env = EnvironmentProduction
+		}
+		if env != tm.environment {
+			return fmt.Errorf("%s holds %s connection %s but the service runs against %s; sandbox and production tokens can't share a file", path, env, conn.ID, tm.environment)
+		}
+		tm.connections[conn.ID] = conn
+	}
+	return nil
+}
+
+// save writes the connections to the file, if any. Callers must hold the lock.
+func (tm *monzoTokenManager) save() {
+	if tm.path == "" {
+		return
+	}
+	list := make([]*MonzoConnection, 0, len(tm.connections))
+	for _, conn := range tm.connections {
+		list = append(list, conn)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		// Write to a temporary file first so a crash never loses the refresh tokens
+		tmp := tm.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, tm.path)
+		}
+	}
+	if err != nil {
+		tm.logger.Error("Failed to save Monzo connections", map[string]interface{}{
+			"event_type":    "monzo_connections",
+			"error_type":    "save_failed",
+			"error_message": err.Error(),
+		})
+	}
+}
+
+// Put adds or replaces a connection
+func (tm *monzoTokenManager) Put(conn MonzoConnection) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	conn.Environment = tm.environment
+	tm.connections[conn.ID] = &conn
+	tm.save()
+}
+
+// Get returns a connection
+func (tm *monzoTokenManager) Get(id string) (MonzoConnection, bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	conn, ok := tm.connections[id]
+	if !ok {
+		return MonzoConnection{}, false
+	}
+	return *conn, true
+}
+
+// List returns a user's connections, or every connection when userID is empty
+func (tm *monzoTokenManager) List(userID string) []MonzoConnection {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	list := []MonzoConnection{}
+	for _, conn := range tm.connections {
+		if userID == "" || conn.UserID == userID {
+			list = append(list, *conn)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// ForAccount returns the connection a transaction's account was synced through, when it is
+// a Monzo account and the connection can still call Monzo
+func (tm *monzoTokenManager) ForAccount(account *BankAccount) (MonzoConnection, bool) {
+	if account == nil || account.Provider != "monzo" {
+		return MonzoConnection{}, false
+	}
+	conn, ok := tm.Get(account.ConnectionID)
+	if !ok || conn.AccountID != account.AccountID {
+		return MonzoConnection{}, false
+	}
+	switch conn.status(time.Now(), tm.reauthInterval) {
+	case ConnectionActive, ConnectionExpired, ConnectionReauthDue:
+		return conn, true
+	}
+	return MonzoConnection{}, false
+}
+
+// SetSyncCursor records how far a connection's transactions have been synced, saving it
+// straight away so a sync interrupted by a crash resumes from there
+func (tm *monzoTokenManager) SetSyncCursor(id, cursor string, at time.Time) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	conn, ok := tm.connections[id]
+	if !ok {
+		return false
+	}
+	conn.SyncCursor, conn.SyncedAt = cursor, &at
+	tm.save()
+	return true
+}
+
+// Remove deletes a connection, reporting whether it existed
+func (tm *monzoTokenManager) Remove(id string) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if _, ok := tm.connections[id]; !ok {
+		return false
+	}
+	delete(tm.connections, id)
+	delete(tm.refreshing, id)
+	tm.save()
+	return true
+}
+
+// AccessToken returns a current access token for a connection, refreshing it first when it
+// is about to expire
+func (tm *monzoTokenManager) AccessToken(id string) (string, error) {
+	tm.mu.Lock()
+	conn, ok := tm.connections[id]
+	if !ok {
+		tm.mu.Unlock()
+		return "", fmt.Errorf("monzo connection %s not found", id)
+	}
+	status := conn.status(time.Now(), tm.reauthInterval)
+	refresh := time.Until(conn.ExpiresAt) < tokenRefreshMargin && conn.RefreshToken != ""
+	token := conn.AccessToken
+	tm.mu.Unlock()
+
+	if status == ConnectionReauthRequired {
+		return "", errReauthRequired
+	}
+	if !refresh {
+		return token, nil
+	}
+	if err := tm.Refresh(id); err != nil {
+		return "", err
+	}
+	refreshed, _ := tm.Get(id)
+	return refreshed.AccessToken, nil
+}
+
+// refreshLock returns the lock serializing a connection's refreshes
+func (tm *monzoTokenManager) refreshLock(id string) *sync.Mutex {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	lock, ok := tm.refreshing[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		tm.refreshing[id] = lock
+	}
+	return lock
+}
+
+// Refresh exchanges a connection's refresh token for new tokens, when it is about to expire.
+// Refreshes of one connection run one at a time, and one that waited on another finds the
+// token fresh and returns, so a single-use refresh token is never spent twice. A refresh
+// Monzo rejects marks the connection for re-authorization; other failures are left to be
+// retried.
+func (tm *monzoTokenManager) Refresh(id string) error {
+	lock := tm.refreshLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	conn, ok := tm.Get(id)
+	if !ok {
+		return fmt.Errorf("monzo connection %s not found", id)
+	}
+	if conn.NeedsReauth || conn.RefreshToken == "" {
+		return errReauthRequired
+	}
+	if time.Until(conn.ExpiresAt) >= tokenRefreshMargin {
+		return nil
+	}
+	tokens, err := tm.requestTokens(url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {tm.clientID},
+		"client_secret": {tm.clientSecret},
+		"refresh_token": {conn.RefreshToken},
+	})
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	current, ok := tm.connections[id]
+	if !ok {
+		return fmt.Errorf("monzo connection %s not found", id)
+	}
+	var rejected *tokenRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		tm.metrics.recordMonzoTokenRefresh("rejected")
+		current.NeedsReauth = true
+		current.LastError = err.Error()
+	case err != nil:
+		tm.metrics.recordMonzoTokenRefresh("failed")
+		current.LastError = err.Error()
+	default:
+		tm.metrics.recordMonzoTokenRefresh("refreshed")
+		current.AccessToken = tokens.AccessToken
+		if tokens.RefreshToken != "" {
+			current.RefreshToken = tokens.RefreshToken
+		}
+		now := time.Now().UTC()
+		current.ExpiresAt = now.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+		current.RefreshedAt = &now
+		current.LastError = ""
+	}
+	tm.save()
+	if err != nil {
+		tm.logger.Warn("Monzo token refresh failed", map[string]interface{}{
+			"event_type":    "monzo_token_refresh",
+			"connection_id": id,
+			"error_message": err.Error(),
+		})
+		if rejected != nil {
+			return errReauthRequired
+		}
+	}
+	return err
+}
+
+// requestTokens posts to Monzo's token endpoint
+func (tm *monzoTokenManager) requestTokens(form url.Values) (monzoTokenResponse, error) {
+	var tokens monzoTokenResponse
+	resp, err := tm.http.PostForm(tm.apiURL+"/oauth2/token", form)
+	if err != nil {
+		return tokens, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return tokens, fmt.Errorf("monzo token request: %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return tokens, &tokenRejectedError{status: resp.Status, body: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return tokens, fmt.Errorf("decode monzo token response: %w", err)
+	}
+	tm.metrics.recordMonzoSync()
+	return tokens, nil
+}
+
+// RefreshDue refreshes every access token about to expire whose connection can still be
+// refreshed
+func (tm *monzoTokenManager) RefreshDue() {
+	now := time.Now()
+	for _, conn := range tm.List("") {
+		if conn.status(now, tm.reauthInterval) == ConnectionReauthRequired || conn.ExpiresAt.Sub(now) >= tokenRefreshMargin {
+			continue
+		}
+		tm.Refresh(conn.ID)
+	}
+}
+
+// randomToken returns 16 random bytes, hex encoded
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AuthorizeURL returns the Monzo URL a user visits to complete an authorization, and the
+// session nonce the browser must present when Monzo sends it back
+func (tm *monzoTokenManager) AuthorizeURL(pending oauthState) (string, string, error) {
+	if tm.clientID == "" || tm.redirectURL == "" {
+		return "", "", errors.New("MONZO_CLIENT_ID and MONZO_REDIRECT_URL are not configured")
+	}
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	session, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	tm.mu.Lock()
+	now := time.Now()
+	for key, pending := range tm.states {
+		if now.After(pending.Expires) {
+			delete(tm.states, key)
+		}
+	}
+	pending.Session = session
+	pending.Expires = now.Add(oauthStateTTL)
+	tm.states[state] = pending
+	tm.mu.Unlock()
+
+	query := url.Values{
+		"client_id":     {tm.clientID},
+		"redirect_uri":  {tm.redirectURL},
+		"response_type": {"code"},
+		"state":         {state},
+	}
+	return strings.TrimRight(tm.authURL, "/") + "/?" + query.Encode(), session, nil
+}
+
+// ConsumeState returns the authorization a state was issued for, which can only be done once
+// and only by the browser session that started it
+func (tm *monzoTokenManager) ConsumeState(state, session string) (oauthState, bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	pending, ok := tm.states[state]
+	delete(tm.states, state)
+	if !ok || time.Now().After(pending.Expires) ||
+		subtle.ConstantTimeCompare([]byte(pending.Session), []byte(session)) != 1 {
+		return oauthState{}, false
+	}
+	return pending, true
+}
+
+// Exchange trades an authorization code for tokens
+func (tm *monzoTokenManager) Exchange(code string) (monzoTokenResponse, error) {
+	return tm.requestTokens(url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {tm.clientID},
+		"client_secret": {tm.clientSecret},
+		"redirect_uri":  {tm.redirectURL},
+		"code":          {code},
+	})
+}
+
+// Status describes a connection, with a re-authorization URL once one is due
+func (tm *monzoTokenManager) Status(conn MonzoConnection, now time.Time) ConnectionStatus {
+	status := ConnectionStatus{
+		ID:              conn.ID,
+		UserID:          conn.UserID,
+		AccountID:       conn.AccountID,
+		Status:          conn.status(now, tm.reauthInterval),
+		Webhook:         conn.WebhookID != "",
+		ExpiresAt:       conn.ExpiresAt,
+		AuthenticatedAt: conn.AuthenticatedAt,
+		ReauthDueAt:     conn.AuthenticatedAt.Add(tm.reauthInterval),
+		RefreshedAt:     conn.RefreshedAt,
+		SyncCursor:      conn.SyncCursor,
+		SyncedAt:        conn.SyncedAt,
+		LastError:       conn.LastError,
+	}
+	if now.After(conn.AuthenticatedAt.Add(monzoFullHistoryWindow)) {
+		from := now.Add(-monzoRecentHistory).UTC()
+		status.HistoryFrom = &from
+	}
+	if status.Status == ConnectionReauthDue || status.Status == ConnectionReauthRequired {
+		status.ReauthURL = "/connect/monzo?" + url.Values{"user_id": {conn.UserID}, "connection_id": {conn.ID}}.Encode()
+	}
+	return status
+}
+
+// Global Monzo token manager, set up from the configuration at startup
+var monzoTokens *monzoTokenManager
+
+// startTokenRefresh refreshes expiring Monzo access tokens every interval, and finishes
+// connections the user has since approved
+func (s *Server) startTokenRefresh(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			monzoTokens.RefreshDue()
+			s.finishPendingConnections()
+		}
+	}()
+}
+
+// handleListConnections serves GET /connections?user_id=, listing a user's Monzo connections
+// with their token status. Connections that need the user to authenticate again carry a
+// reauth_url to send them to; history_from is set once only recent history can be read.
+func (s *Server) handleListConnections(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	now := time.Now().UTC()
+	list := []ConnectionStatus{}
+	for _, conn := range monzoTokens.List(userID) {
+		list = append(list, monzoTokens.Status(conn, now))
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "count": len(list), "connections": list})
+}
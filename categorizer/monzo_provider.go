@@ -0,0 +1,75 @@
+package main
+
+import "fmt"
+
+// monzoProvider is the BankProvider for Monzo accounts connected over OAuth
+type monzoProvider struct {
+	cfg     Config
+	metrics *Metrics
+}
+
+func (monzoProvider) Name() string { return "monzo" }
+
+// client returns a Monzo client for an account's connection, at priority p
+func (mp monzoProvider) client(account BankAccount, p monzoPriority) (*monzoClient, error) {
+	conn, ok := monzoTokens.Get(account.ConnectionID)
+	if !ok {
+		return nil, fmt.Errorf("monzo connection %s not found", account.ConnectionID)
+	}
+	client := newConnectionClient(mp.cfg, mp.metrics, conn).withPriority(p)
+	if account.AccountID != "" {
+		client.accountID = account.AccountID
+	}
+	return client, nil
+}
+
+func (mp monzoProvider) ListTransactions(account BankAccount, since, before string, limit int) ([]BankTransaction, error) {
+	client, err := mp.client(account, PriorityBackground)
+	if err != nil {
+		return nil, err
+	}
+	page, err := client.Transactions(since, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]BankTransaction, 0, len(page))
+	for _, mt := range page {
+		bt := BankTransaction{
+			ID:            mt.ID,
+			CreatedAt:     mt.Created,
+			Description:   mt.Description,
+			Amount:        float64(mt.Amount) / 100,
+			Currency:      mt.Currency,
+			Status:        StatusSettled,
+			DeclineReason: mt.DeclineReason,
+		}
+		if mt.Merchant != nil {
+			bt.Merchant = mt.Merchant.Name
+		}
+		switch {
+		case mt.DeclineReason != "":
+			bt.Status = StatusDeclined
+		case mt.Settled == "":
+			bt.Status = StatusPending
+		}
+		list = append(list, bt)
+	}
+	return list, nil
+}
+
+func (mp monzoProvider) Subscribe(account BankAccount, url string) (string, error) {
+	client, err := mp.client(account, PriorityFollowUp)
+	if err != nil {
+		return "", err
+	}
+	webhook, err := client.RegisterWebhook(url)
+	return webhook.ID, err
+}
+
+func (mp monzoProvider) WriteAnnotation(account BankAccount, transactionID, key, value string) error {
+	client, err := mp.client(account, PriorityBackground)
+	if err != nil {
+		return err
+	}
+	return client.AnnotateTransaction(transactionID, key, value)
+}
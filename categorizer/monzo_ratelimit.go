@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateLimitRetries bounds how often a request Monzo rate limits is sent again
+const maxRateLimitRetries = 2
+
+// monzoPriority orders calls waiting on the same Monzo rate limit budget
+type monzoPriority int
+
+const (
+	// PriorityInteractive is for calls a user is waiting on, such as connecting an account
+	PriorityInteractive monzoPriority = iota
+	// PriorityFollowUp is for work following a webhook, such as pushing a receipt
+	PriorityFollowUp
+	// PriorityBackground is for bulk work, such as write-backs, reconciliation and backfills
+	PriorityBackground
+)
+
+// monzoPriorities names each priority, for metric labels
+var monzoPriorities = []string{"interactive", "follow_up", "background"}
+
+func (p monzoPriority) String() string {
+	return monzoPriorities[p]
+}
+
+// monzoRateBudget shares Monzo's rate limit between every client of an access token: a
+// token bucket per budget key, which waiting calls take from in priority order. A key Monzo
+// has rate limited is paused for everyone until its Retry-After passes.
+type monzoRateBudget struct {
+	limiter     *rateLimiter
+	mu          sync.Mutex
+	waiting     map[string]*[3]int
+	pausedUntil map[string]time.Time
+	metrics     *Metrics
+}
+
+// newMonzoRateBudget allows rate calls a second per key, with bursts up to burst, recording
+// throttling and queue depth in m. A rate of zero or less leaves calls unlimited, though
+// still paused after a 429.
+func newMonzoRateBudget(rate float64, burst int, m *Metrics) *monzoRateBudget {
+	b := &monzoRateBudget{waiting: map[string]*[3]int{}, pausedUntil: map[string]time.Time{}, metrics: m}
+	if rate > 0 {
+		b.limiter = newRateLimiter(rate, burst)
+	}
+	return b
+}
+
+// Wait blocks until a call at priority p may be made against key
+func (b *monzoRateBudget) Wait(key string, p monzoPriority) {
+	b.enqueue(key, p, 1)
+	defer b.enqueue(key, p, -1)
+
+	throttled := false
+	for {
+		wait := b.next(key, p)
+		if wait <= 0 {
+			return
+		}
+		if !throttled {
+			throttled = true
+			b.metrics.recordMonzoThrottled("budget")
+		}
+		time.Sleep(wait)
+	}
+}
+
+// next takes a token for a call at priority p, or reports how long to wait before trying again
+func (b *monzoRateBudget) next(key string, p monzoPriority) time.Duration {
+	b.mu.Lock()
+	if until := b.pausedUntil[key]; time.Now().Before(until) {
+		b.mu.Unlock()
+		return time.Until(until)
+	}
+	delete(b.pausedUntil, key)
+	for higher := monzoPriority(0); higher < p; higher++ {
+		if b.waiting[key][higher] > 0 {
+			b.mu.Unlock()
+			return 50 * time.Millisecond
+		}
+	}
+	b.mu.Unlock()
+
+	if b.limiter == nil {
+		return 0
+	}
+	if ok, wait := b.limiter.Allow(key); !ok {
+		return wait
+	}
+	return 0
+}
+
+// enqueue counts a call at priority p joining (delta 1) or leaving (-1) key's queue
+func (b *monzoRateBudget) enqueue(key string, p monzoPriority, delta int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts, ok := b.waiting[key]
+	if !ok {
+		counts = &[3]int{}
+		b.waiting[key] = counts
+	}
+	counts[p] += delta
+	if *counts == [3]int{} {
+		delete(b.waiting, key)
+	}
+	b.metrics.addMonzoQueueDepth(p.String(), delta)
+}
+
+// Pause holds every call against key for d, as Monzo asked after rate limiting it
+func (b *monzoRateBudget) Pause(key string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := time.Now().Add(d); until.After(b.pausedUntil[key]) {
+		b.pausedUntil[key] = until
+	}
+}
+
+// Global Monzo rate limit budget, set up from the configuration at startup
+var monzoBudget *monzoRateBudget
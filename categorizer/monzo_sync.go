@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errSyncRunning is returned when a connection is already being synced
+var errSyncRunning = errors.New("a sync is already running for this connection")
+
+// MonzoSyncRequest selects the transactions a sync fetches. An incremental sync continues
+// after the connection's cursor, or starts from the oldest history Monzo allows when it has
+// none. A full sync starts from Since instead, or the oldest history allowed, and re-reads
+// transactions already synced. Before bounds either.
+type MonzoSyncRequest struct {
+	Full   bool       `json:"full,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	Before *time.Time `json:"before,omitempty"`
+}
+
+// monzoSyncs tracks the connections being synced, so each only has one sync at a time
+var monzoSyncs = struct {
+	mu      sync.Mutex
+	running map[string]bool
+}{running: map[string]bool{}}
+
+// monzoPendingHold bounds how long a pending transaction holds the sync cursor back. One
+// pending for longer stops holding it, and is left to webhooks or a full sync to settle.
+const monzoPendingHold = 14 * 24 * time.Hour
+
+// syncMonzoTransactions pages through a connection's transactions, oldest first,
+// categorizing and storing each one not seen before and settling those seen while pending.
+// The cursor is saved after every page, so a sync interrupted by a crash or an error resumes
+// from the last page stored. It never moves past a transaction still pending, so the next
+// sync reads it again and settles it. Synced history doesn't trigger round-ups,
+// notifications or events, though its categories are written back to Monzo when that's
+// enabled.
+func (s *Server) syncMonzoTransactions(conn MonzoConnection, req MonzoSyncRequest, add func(string, int)) error {
+	monzoSyncs.mu.Lock()
+	if monzoSyncs.running[conn.ID] {
+		monzoSyncs.mu.Unlock()
+		return errSyncRunning
+	}
+	monzoSyncs.running[conn.ID] = true
+	monzoSyncs.mu.Unlock()
+	defer func() {
+		monzoSyncs.mu.Lock()
+		delete(monzoSyncs.running, conn.ID)
+		monzoSyncs.mu.Unlock()
+	}()
+
+	// Monzo refuses to list further back than the history it allows
+	since := ""
+	from := monzoTokens.Status(conn, time.Now().UTC()).HistoryFrom
+	if req.Full && req.Since != nil && (from == nil || req.Since.After(*from)) {
+		from = req.Since
+	}
+	if from != nil {
+		since = from.UTC().Format(time.RFC3339)
+	}
+	if !req.Full && conn.SyncCursor != "" {
+		since = conn.SyncCursor
+	}
+	before := ""
+	if req.Before != nil {
+		before = req.Before.UTC().Format(time.RFC3339)
+	}
+	// A bounded sync stops short of the newest transactions, so it only sets the cursor on a
+	// connection that has none; moving an existing one back would re-read what follows it
+	saveCursor := req.Before == nil || conn.SyncCursor == ""
+
+	provider, err := bankProvider("monzo")
+	if err != nil {
+		return err
+	}
+	account := conn.account()
+	held := false
+	for {
+		page, err := provider.ListTransactions(account, since, before, monzoTransactionPage)
+		if err != nil {
+			return err
+		}
+		rs := s.activeRules()
+		cursor := ""
+		for _, bt := range page {
+			result := s.ingestBankTransaction(account, bt, rs)
+			add(result, 1)
+			s.metrics.recordMonzoSyncedTransaction(result)
+			if bt.Status == StatusPending && time.Since(bt.CreatedAt) < monzoPendingHold {
+				held = true
+			}
+			if !held {
+				cursor = bt.ID
+			}
+		}
+		add("pages", 1)
+		if len(page) > 0 {
+			since = page[len(page)-1].ID
+		}
+		if saveCursor && cursor != "" {
+			monzoTokens.SetSyncCursor(conn.ID, cursor, time.Now().UTC())
+		}
+		if len(page) < monzoTransactionPage {
+			return nil
+		}
+	}
+}
+
+// startMonzoSync starts a sync of a connection as a tracked job
+func (s *Server) startMonzoSync(conn MonzoConnection, req MonzoSyncRequest, createdBy string) Job {
+	jobType := "monzo.sync"
+	if req.Full {
+		jobType = "monzo.backfill"
+	}
+	params := gin.H{"connection_id": conn.ID, "request": req}
+	return jobs.Start(s, jobType, createdBy, params, func(add func(string, int)) error {
+		return s.syncMonzoTransactions(conn, req, add)
+	})
+}
+
+// syncAllConnections runs an incremental sync of every connection that can call Monzo
+func (s *Server) syncAllConnections() {
+	now := time.Now()
+	for _, conn := range monzoTokens.List("") {
+		switch conn.status(now, monzoTokens.reauthInterval) {
+		case ConnectionPending, ConnectionReauthRequired:
+			continue
+		}
+		if conn.AccountID == "" {
+			continue
+		}
+		err := s.syncMonzoTransactions(conn, MonzoSyncRequest{}, func(string, int) {})
+		if err != nil && !errors.Is(err, errSyncRunning) {
+			s.logger.Warn("Monzo sync failed", map[string]interface{}{
+				"event_type":    "monzo_sync_failed",
+				"connection_id": conn.ID,
+				"error_message": err.Error(),
+			})
+		}
+	}
+}
+
+// startMonzoSyncs syncs new transactions from every connection every interval
+func (s *Server) startMonzoSyncs(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.syncAllConnections()
+		}
+	}()
+}
+
+// handleSyncConnection serves POST /admin/connections/:id/sync, starting a job that syncs
+// the connection's Monzo transactions. The body, optional, is a MonzoSyncRequest; without
+// one only transactions after the connection's cursor are fetched.
+func (s *Server) handleSyncConnection(c *gin.Context) {
+	conn, ok := monzoTokens.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+	if conn.AccountID == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "the connection has no account yet; the user may still need to approve access"})
+		return
+	}
+	var req MonzoSyncRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Since != nil && req.Before != nil && !req.Since.Before(*req.Before) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be earlier than before"})
+		return
+	}
+	monzoSyncs.mu.Lock()
+	running := monzoSyncs.running[conn.ID]
+	monzoSyncs.mu.Unlock()
+	if running {
+		c.JSON(http.StatusConflict, gin.H{"error": errSyncRunning.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, s.startMonzoSync(conn, req, callerName(c)))
+}
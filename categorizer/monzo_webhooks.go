@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookReconciliation is what reconciling one connection's Monzo webhooks changed
+type WebhookReconciliation struct {
+	ConnectionID string   `json:"connection_id"`
+	AccountID    string   `json:"account_id"`
+	Registered   []string `json:"registered,omitempty"`
+	Removed      []string `json:"removed,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// reconcileWebhooks brings the webhooks Monzo holds for a connection's account in line with
+// MONZO_WEBHOOK_URL, removing any left pointing elsewhere, e.g. after a hostname change, and
+// registering it when missing. Monzo only lists the webhooks our client registered. Callers
+// must not reconcile without MONZO_WEBHOOK_URL, which would remove every webhook.
+func (s *Server) reconcileWebhooks(conn MonzoConnection) WebhookReconciliation {
+	result := WebhookReconciliation{ConnectionID: conn.ID, AccountID: conn.AccountID}
+	client := newConnectionClient(s.config, s.metrics, conn).withPriority(PriorityBackground)
+	webhooks, err := client.Webhooks()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	webhookID := ""
+	for _, webhook := range webhooks {
+		if webhook.URL == s.config.MonzoWebhookURL && webhookID == "" {
+			webhookID = webhook.ID
+			continue
+		}
+		if err := client.DeleteWebhook(webhook.ID); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Removed = append(result.Removed, webhook.URL)
+	}
+	if webhookID == "" {
+		webhook, err := client.RegisterWebhook(s.config.MonzoWebhookURL)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		webhookID = webhook.ID
+		result.Registered = append(result.Registered, webhook.URL)
+	}
+
+	if current, ok := monzoTokens.Get(conn.ID); ok && current.WebhookID != webhookID {
+		current.WebhookID = webhookID
+		monzoTokens.Put(current)
+	}
+	return result
+}
+
+// reconcileAllWebhooks reconciles the webhooks of every connection that can call Monzo,
+// recording and logging any drift fixed. Nothing is reconciled while MONZO_WEBHOOK_URL is
+// unset: without a URL there is no telling our webhooks from stale ones.
+func (s *Server) reconcileAllWebhooks() []WebhookReconciliation {
+	results := []WebhookReconciliation{}
+	if s.config.MonzoWebhookURL == "" {
+		return results
+	}
+	now := time.Now()
+	for _, conn := range monzoTokens.List("") {
+		switch conn.status(now, monzoTokens.reauthInterval) {
+		case ConnectionPending, ConnectionReauthRequired:
+			continue
+		}
+		result := s.reconcileWebhooks(conn)
+		results = append(results, result)
+
+		if result.Error != "" {
+			s.metrics.recordWebhookReconciliation("failed", 1)
+			s.logger.Warn("Monzo webhook reconciliation failed", map[string]interface{}{
+				"event_type":    "monzo_webhook_reconciliation",
+				"connection_id": conn.ID,
+				"error_message": result.Error,
+			})
+			continue
+		}
+		s.metrics.recordWebhookReconciliation("registered", len(result.Registered))
+		s.metrics.recordWebhookReconciliation("removed", len(result.Removed))
+		if len(result.Registered) > 0 || len(result.Removed) > 0 {
+			s.logger.Info("Monzo webhook drift fixed", map[string]interface{}{
+				"event_type":    "monzo_webhook_reconciliation",
+				"connection_id": conn.ID,
+				"registered":    result.Registered,
+				"removed":       result.Removed,
+			})
+		}
+	}
+	return results
+}
+
+// startWebhookReconciliation reconciles Monzo webhooks on start and then every interval,
+// when MONZO_WEBHOOK_URL is set
+func (s *Server) startWebhookReconciliation(interval time.Duration) {
+	if s.config.MonzoWebhookURL == "" {
+		return
+	}
+	go func() {
+		s.reconcileAllWebhooks()
+		for range time.Tick(interval) {
+			s.reconcileAllWebhooks()
+		}
+	}()
+}
+
+// handleReconcileWebhooks serves POST /admin/connections/webhooks/reconcile, reconciling
+// every connection's Monzo webhooks now rather than at the next interval
+func (s *Server) handleReconcileWebhooks(c *gin.Context) {
+	results := s.reconcileAllWebhooks()
+	c.JSON(http.StatusOK, gin.H{"count": len(results), "connections": results})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxWriteBackAttempts bounds how often a failing write-back is retried
+	maxWriteBackAttempts = 3
+	// defaultRetryAfter is how long a connection is paused when Monzo rate limits it
+	// without saying for how long
+	defaultRetryAfter = time.Minute
+)
+
+// writeBack is a category waiting to be written onto a bank transaction
+type writeBack struct {
+	account       BankAccount
+	transactionID string
+	category      string
+	attempts      int
+}
+
+// writeBackQueue holds categories to write back to Monzo, sent in rate-limited batches. A
+// transaction recategorized before its write-back is sent is only written once, with the
+// latest category, and connections Monzo rate limits are paused until it allows them again.
+type writeBackQueue struct {
+	mu          sync.Mutex
+	pending     map[string]*writeBack
+	order       []string
+	pausedUntil map[string]time.Time
+}
+
+// newWriteBackQueue creates an empty write-back queue
+func newWriteBackQueue() *writeBackQueue {
+	return &writeBackQueue{pending: map[string]*writeBack{}, pausedUntil: map[string]time.Time{}}
+}
+
+// Add queues a category for a transaction, replacing any not yet sent
+func (q *writeBackQueue) Add(account BankAccount, transactionID, category string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := account.ConnectionID + "/" + transactionID
+	if wb, ok := q.pending[key]; ok {
+		wb.category, wb.attempts = category, 0
+		return
+	}
+	q.pending[key] = &writeBack{account: account, transactionID: transactionID, category: category}
+	q.order = append(q.order, key)
+}
+
+// take removes up to limit write-backs whose connections aren't paused, oldest first
+func (q *writeBackQueue) take(limit int, now time.Time) []writeBack {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	batch := []writeBack{}
+	kept := q.order[:0]
+	for _, key := range q.order {
+		wb := q.pending[key]
+		if len(batch) == limit || now.Before(q.pausedUntil[wb.account.ConnectionID]) {
+			kept = append(kept, key)
+			continue
+		}
+		batch = append(batch, *wb)
+		delete(q.pending, key)
+	}
+	q.order = kept
+	return batch
+}
+
+// retry puts a write-back back on the queue unless a newer category has replaced it,
+// pausing its connection until until when that is set
+func (q *writeBackQueue) retry(wb writeBack, until time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if until.After(q.pausedUntil[wb.account.ConnectionID]) {
+		q.pausedUntil[wb.account.ConnectionID] = until
+	}
+	key := wb.account.ConnectionID + "/" + wb.transactionID
+	if _, ok := q.pending[key]; ok {
+		return
+	}
+	q.pending[key] = &wb
+	q.order = append(q.order, key)
+}
+
+// Flush sends up to limit write-backs with srv's annotation key, recording the outcomes in
+// its metrics. Rate-limited ones wait for Monzo's Retry-After and other failures are retried
+// on later flushes, up to maxWriteBackAttempts.
+func (q *writeBackQueue) Flush(srv *Server, limit int) {
+	now := time.Now()
+	throttled := map[string]bool{}
+	for _, wb := range q.take(limit, now) {
+		// The rest of a batch waits too once its connection is rate limited
+		if throttled[wb.account.ConnectionID] {
+			q.retry(wb, time.Time{})
+			continue
+		}
+		provider, err := bankProvider(wb.account.Provider)
+		if err == nil {
+			err = provider.WriteAnnotation(wb.account, wb.transactionID, srv.config.MonzoWritebackKey, wb.category)
+		}
+		var apiErr *monzoAPIError
+		switch {
+		case err == nil:
+			srv.metrics.recordMonzoWriteBack("written")
+			continue
+		case errors.As(err, &apiErr) && apiErr.status == http.StatusTooManyRequests:
+			srv.metrics.recordMonzoWriteBack("throttled")
+			throttled[wb.account.ConnectionID] = true
+			wait := apiErr.retryAfter
+			if wait <= 0 {
+				wait = defaultRetryAfter
+			}
+			q.retry(wb, now.Add(wait))
+			continue
+		}
+
+		wb.attempts++
+		if wb.attempts < maxWriteBackAttempts && !errors.Is(err, errReauthRequired) {
+			srv.metrics.recordMonzoWriteBack("retried")
+			q.retry(wb, time.Time{})
+			continue
+		}
+		srv.metrics.recordMonzoWriteBack("failed")
+		srv.logger.Warn(fmt.Sprintf("Monzo write-back of transaction %s failed", wb.transactionID), map[string]interface{}{
+			"event_type":    "monzo_writeback_failed",
+			"category":      wb.category,
+			"error_message": err.Error(),
+		})
+	}
+}
+
+// Global Monzo write-back queue
+var writeBacks = newWriteBackQueue()
+
+// queueWriteBack queues a stored transaction's category for writing back to Monzo, when
+// write-back is enabled and the transaction was synced through a connected Monzo account
+func (s *Server) queueWriteBack(tx StoredTransaction) {
+	if !s.config.MonzoWriteback || tx.Duplicate {
+		return
+	}
+	if conn, ok := monzoTokens.ForAccount(tx.Account); ok {
+		writeBacks.Add(conn.account(), tx.TransactionID, tx.Category)
+	}
+}
+
+// startWriteBacks sends queued write-backs in batches of up to batch every interval
+func (s *Server) startWriteBacks(interval time.Duration, batch int) {
+	if !s.config.MonzoWriteback {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			writeBacks.Flush(s, batch)
+		}
+	}()
+}
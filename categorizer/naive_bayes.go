@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCategorizer builds the classifier CLASSIFIER selects. The keyword engine is the
+// default and needs no classifier.
+func newCategorizer(cfg Config) (Categorizer, error) {
+	switch cfg.Classifier {
+	case "", "keyword":
+		return nil, nil
+	case "naive_bayes":
+		return &naiveBayesClassifier{minConfidence: cfg.MLMinConfidence}, nil
+	}
+	return nil, fmt.Errorf("unknown classifier %q", cfg.Classifier)
+}
+
+// TrainableClassifier is a classifier that learns from a server's own data
+type TrainableClassifier interface {
+	Train(srv *Server) (ModelStats, error)
+}
+
+// ModelStats describes a trained model
+type ModelStats struct {
+	Version    string    `json:"version"`
+	Examples   int       `json:"examples"`
+	Feedback   int       `json:"feedback"`
+	Categories int       `json:"categories"`
+	Terms      int       `json:"terms"`
+	TrainedAt  time.Time `json:"trained_at"`
+}
+
+// errTooFewExamples is returned when there isn't enough data to train on
+var errTooFewExamples = errors.New("not enough categorized transactions to train on")
+
+// minTrainingExamples is how many examples a model needs before it is trusted with anything
+const minTrainingExamples = 20
+
+// feedbackWeight is how many history examples a correction counts as. Corrections are what
+// users said; history is mostly what the rules already decided.
+const feedbackWeight = 3
+
+// untrainableCategories are categories that say nothing about what a transaction is
+var untrainableCategories = map[string]bool{
+	"Other": true, CategoryUncategorized: true, CategoryNeedsReview: true,
+}
+
+// trainingExample is one labelled transaction
+type trainingExample struct {
+	terms    map[string]float64
+	category string
+	weight   float64
+}
+
+// modelTerms counts the terms the model sees in a transaction: the canonical merchant as a
+// whole, its words and the description's words, and the direction money moved
+func modelTerms(merchant, description, transactionType string) map[string]float64 {
+	terms := map[string]float64{}
+	if merchant != "" {
+		terms["merchant:"+merchant]++
+	}
+	for _, word := range strings.Fields(merchant) {
+		terms["m:"+word]++
+	}
+	for _, word := range strings.Fields(description) {
+		terms["d:"+word]++
+	}
+	if strings.ToLower(transactionType) == TypeCredit {
+		terms["type:credit"]++
+	}
+	return terms
+}
+
+// trainingStages are the pipeline stages whose categories the model learns from: the rules,
+// and what users and admins said. Categories the model decided itself, or that came from
+// history too old to say who decided them, would only teach it its own mistakes.
+var trainingStages = map[string]bool{
+	"rules": true, "overrides": true, "merchant_policy": true, "sticky": true, "feedback": true,
+}
+
+// trainingExamples collects labelled transactions: every user's history the model may learn
+// from, with the corrected category standing in wherever feedback corrected it, and
+// corrections of transactions that aren't in history
+func (s *Server) trainingExamples() ([]trainingExample, int) {
+	history := map[string][]StoredTransaction{}
+	transactionTypes := map[string]string{}
+	for _, userID := range s.store.Users() {
+		history[userID] = s.store.ListTransactions(userID, time.Time{}, time.Time{})
+		for _, tx := range history[userID] {
+			transactionTypes[userID+"|"+tx.ID] = tx.TransactionType
+			if tx.TransactionID != "" {
+				transactionTypes[userID+"|"+tx.TransactionID] = tx.TransactionType
+			}
+		}
+	}
+
+	corrected := map[string]bool{}
+	var examples []trainingExample
+	for _, f := range feedback.List("", "") {
+		if f.Merchant == "" {
+			continue
+		}
+		transactionType := ""
+		if f.TransactionID != "" {
+			corrected[f.UserID+"|"+f.TransactionID] = true
+			transactionType = transactionTypes[f.UserID+"|"+f.TransactionID]
+		}
+		examples = append(examples, trainingExample{
+			terms:    modelTerms(resolveMerchant(normalizeDescriptor(f.Merchant)), normalizeDescriptor(f.Description), transactionType),
+			category: f.Category,
+			weight:   feedbackWeight,
+		})
+	}
+	fromFeedback := len(examples)
+
+	for userID, transactions := range history {
+		for _, tx := range transactions {
+			if tx.Duplicate || untrainableCategories[tx.Category] || !trainingStages[tx.DecidedBy] ||
+				corrected[userID+"|"+tx.ID] || corrected[userID+"|"+tx.TransactionID] {
+				continue
+			}
+			examples = append(examples, trainingExample{
+				terms:    modelTerms(resolveMerchant(normalizeDescriptor(tx.Merchant)), normalizeDescriptor(tx.Description), tx.TransactionType),
+				category: tx.Category,
+				weight:   1,
+			})
+		}
+	}
+	return examples, fromFeedback
+}
+
+// naiveBayesModel is a multinomial naive Bayes model over TF-IDF weighted terms
+type naiveBayesModel struct {
+	stats ModelStats
+	// idf is each known term's inverse document frequency
+	idf map[string]float64
+	// logPrior and logLikelihood are log P(category) and log P(term | category); unseen is
+	// log P(term | category) for a known term never seen with the category
+	logPrior      map[string]float64
+	logLikelihood map[string]map[string]float64
+	unseen        map[string]float64
+}
+
+// tfidf weights a transaction's known terms by sublinear term frequency and inverse document
+// frequency, normalized to unit length so long descriptions don't outweigh short ones
+func (m *naiveBayesModel) tfidf(terms map[string]float64) map[string]float64 {
+	weights := make(map[string]float64, len(terms))
+	norm := 0.0
+	for term, tf := range terms {
+		idf, ok := m.idf[term]
+		if !ok {
+			continue
+		}
+		w := (1 + math.Log(tf)) * idf
+		weights[term] = w
+		norm += w * w
+	}
+	norm = math.Sqrt(norm)
+	for term := range weights {
+		weights[term] /= norm
+	}
+	return weights
+}
+
+// trainNaiveBayes fits a model to weighted examples with Laplace smoothing
+func trainNaiveBayes(examples []trainingExample) *naiveBayesModel {
+	m := &naiveBayesModel{
+		idf:           map[string]float64{},
+		logPrior:      map[string]float64{},
+		logLikelihood: map[string]map[string]float64{},
+		unseen:        map[string]float64{},
+	}
+	documents := map[string]float64{}
+	for _, ex := range examples {
+		for term := range ex.terms {
+			documents[term]++
+		}
+	}
+	for term, df := range documents {
+		m.idf[term] = math.Log(float64(len(examples))/df) + 1
+	}
+
+	classWeight := map[string]float64{}
+	termWeight := map[string]map[string]float64{}
+	totalWeight := map[string]float64{}
+	examplesWeight := 0.0
+	for _, ex := range examples {
+		classWeight[ex.category] += ex.weight
+		examplesWeight += ex.weight
+		if termWeight[ex.category] == nil {
+			termWeight[ex.category] = map[string]float64{}
+		}
+		for term, w := range m.tfidf(ex.terms) {
+			termWeight[ex.category][term] += w * ex.weight
+			totalWeight[ex.category] += w * ex.weight
+		}
+	}
+	vocabulary := float64(len(m.idf))
+	for category, weight := range classWeight {
+		m.logPrior[category] = math.Log(weight / examplesWeight)
+		denominator := totalWeight[category] + vocabulary
+		m.unseen[category] = math.Log(1 / denominator)
+		m.logLikelihood[category] = make(map[string]float64, len(termWeight[category]))
+		for term, w := range termWeight[category] {
+			m.logLikelihood[category][term] = math.Log((w + 1) / denominator)
+		}
+	}
+	return m
+}
+
+// Scores returns the posterior probability of every category, best first, or nothing when
+// the transaction has no term the model knows
+func (m *naiveBayesModel) Scores(terms map[string]float64) []CategoryScore {
+	weights := m.tfidf(terms)
+	if len(weights) == 0 {
+		return nil
+	}
+	logPosterior := make(map[string]float64, len(m.logPrior))
+	best := math.Inf(-1)
+	for category, prior := range m.logPrior {
+		score := prior
+		for term, w := range weights {
+			likelihood, ok := m.logLikelihood[category][term]
+			if !ok {
+				likelihood = m.unseen[category]
+			}
+			score += w * likelihood
+		}
+		logPosterior[category] = score
+		best = math.Max(best, score)
+	}
+	total := 0.0
+	for _, score := range logPosterior {
+		total += math.Exp(score - best)
+	}
+	scores := make([]CategoryScore, 0, len(logPosterior))
+	for category, score := range logPosterior {
+		scores = append(scores, CategoryScore{Category: category, Score: math.Round(math.Exp(score-best)/total*1000) / 1000})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Category < scores[j].Category
+	})
+	return scores
+}
+
+// naiveBayesClassifier categorizes transactions with a naive Bayes model trained on the
+// service's rule-categorized history and feedback. It decides only when its best category is at
+// least minConfidence likely, leaving everything else to the keyword rules.
+type naiveBayesClassifier struct {
+	mu            sync.RWMutex
+	model         *naiveBayesModel
+	minConfidence float64
+}
+
+// Train refits the model to srv's current history and feedback. The previous model stays in
+// use when there is too little to train on.
+func (c *naiveBayesClassifier) Train(srv *Server) (ModelStats, error) {
+	examples, fromFeedback := srv.trainingExamples()
+	categories := map[string]bool{}
+	for _, ex := range examples {
+		categories[ex.category] = true
+	}
+	if len(examples) < minTrainingExamples || len(categories) < 2 {
+		return ModelStats{Examples: len(examples), Feedback: fromFeedback, Categories: len(categories)}, errTooFewExamples
+	}
+
+	model := trainNaiveBayes(examples)
+	now := time.Now().UTC()
+	model.stats = ModelStats{
+		Version:    "naive-bayes-" + now.Format("20060102T150405Z"),
+		Examples:   len(examples),
+		Feedback:   fromFeedback,
+		Categories: len(categories),
+		Terms:      len(model.idf),
+		TrainedAt:  now,
+	}
+	c.mu.Lock()
+	c.model = model
+	c.mu.Unlock()
+	srv.metrics.recordModelLoaded()
+	srv.metrics.recordBuildInfo(srv.currentBuildInfo())
+	return model.stats, nil
+}
+
+// current returns the model in use, nil before the first successful training
+func (c *naiveBayesClassifier) current() *naiveBayesModel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+func (c *naiveBayesClassifier) Scores(cl *Classification) []CategoryScore {
+	model := c.current()
+	if model == nil {
+		return nil
+	}
+	return model.Scores(modelTerms(cl.NormalizedMerchant, cl.NormalizedDescription, cl.TransactionType))
+}
+
+func (c *naiveBayesClassifier) Classify(cl *Classification) (string, bool) {
+	scores := c.Scores(cl)
+	if len(scores) == 0 || scores[0].Score < c.minConfidence {
+		return "", false
+	}
+	return scores[0].Category, true
+}
+
+func (c *naiveBayesClassifier) ModelVersion() string {
+	if model := c.current(); model != nil {
+		return model.stats.Version
+	}
+	return "untrained"
+}
+
+// trainClassifier retrains the classifier when it learns, logging failures other than a lack
+// of data
+func (s *Server) trainClassifier() {
+	trainable, ok := fallbackClassifier.(TrainableClassifier)
+	if !ok {
+		return
+	}
+	stats, err := trainable.Train(s)
+	switch {
+	case errors.Is(err, errTooFewExamples):
+	case err != nil:
+		s.logger.Error("Model training failed", map[string]interface{}{
+			"event_type":    "model_training",
+			"error_type":    "training_failed",
+			"error_message": err.Error(),
+		})
+	default:
+		s.logger.Info("Model trained", map[string]interface{}{
+			"event_type":    "model_trained",
+			"model_version": stats.Version,
+			"examples":      stats.Examples,
+		})
+	}
+}
+
+// startModelTraining trains the classifier now and then on every interval, so it
+// keeps learning from new history and feedback
+func (s *Server) startModelTraining(interval time.Duration) {
+	if _, ok := fallbackClassifier.(TrainableClassifier); !ok {
+		return
+	}
+	go func() {
+		s.trainClassifier()
+		for range time.Tick(interval) {
+			s.trainClassifier()
+		}
+	}()
+}
+
+// handleTrainModel serves POST /admin/model/train, retraining the classifier now
+func (s *Server) handleTrainModel(c *gin.Context) {
+	trainable, ok := fallbackClassifier.(TrainableClassifier)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "the configured classifier has no model to train"})
+		return
+	}
+	stats, err := trainable.Train(s)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "stats": stats})
+		return
+	}
+	s.recordAudit(callerName(c), "model.train", stats.Version, map[string]interface{}{
+		"examples": stats.Examples,
+		"feedback": stats.Feedback,
+	})
+	c.JSON(http.StatusOK, stats)
+}
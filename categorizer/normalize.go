@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultNoisePatterns match card-network noise in raw bank descriptors. They are applied
+// in order to the lowercased descriptor, after numeric tokens are dropped, and each match is
+// replaced by a space.
+var defaultNoisePatterns = []string{
+	// Payment method prefixes
+	`\b(pos|contactless|card payment|card purchase|purchase|visa|mastercard|apple pay|google pay)\b`,
+	// Processor separators such as "UBER *TRIP" or "SQ *CAFE"
+	`\*`,
+	// Trailing city and country suffixes
+	`\s(london|manchester|birmingham|leeds|glasgow|edinburgh|bristol|liverpool|cardiff|belfast)(\s+(gb|gbr|uk))?\s*$`,
+	`\s(gb|gbr|uk)\s*$`,
+}
+
+// compileNoisePatterns compiles noise patterns, reporting the first invalid one
+func compileNoisePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid noise pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// loadNoisePatterns replaces the noise patterns with a JSON array of regexes read from path
+func loadNoisePatterns(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var patterns []string
+	if err := json.Unmarshal(data, &patterns); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	compiled, err := compileNoisePatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	noisePatterns = compiled
+	return nil
+}
+
+// symbolWords are the emoji merchants put in descriptors that say what they sell, mapped to
+// the keyword the rules know them by
+var symbolWords = map[rune]string{
+	'☕': "coffee",
+	'🍵': "tea",
+	'🍕': "pizza",
+	'🍔': "food",
+	'🍟': "food",
+	'🛒': "grocery",
+	'🚕': "taxi",
+	'🚖': "taxi",
+	'🚌': "bus",
+	'🚆': "train",
+	'🚇': "subway",
+	'🎬': "cinema",
+	'🎮': "game",
+	'🎭': "theatre",
+}
+
+// foldSymbols rewrites the emoji and symbols in a descriptor so it tokenizes as plain text.
+// Stylized letters such as full-width or mathematical bold are folded to their plain form,
+// known emoji become their keyword and every other run of symbols becomes a space, so
+// "☕️ 𝐂𝐎𝐒𝐓𝐀™" reads "coffee costa".
+func foldSymbols(descriptor string) string {
+	var b strings.Builder
+	b.Grow(len(descriptor))
+	space := false
+	// Symbols go before NFKC folding, which would otherwise spell out "™" as "TM"
+	for _, r := range descriptor {
+		if word, ok := symbolWords[r]; ok {
+			b.WriteString(" " + word + " ")
+			space = true
+			continue
+		}
+		if unicode.In(r, unicode.So, unicode.Sk, unicode.Cs, unicode.Co, unicode.Me, unicode.Cf, unicode.Variation_Selector) {
+			if !space {
+				b.WriteByte(' ')
+				space = true
+			}
+			continue
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	return norm.NFKC.String(b.String())
+}
+
+var (
+	// Dates such as 2024-03-12, 12/03, 12-03-24 or 12mar24
+	dateToken = regexp.MustCompile(`^(\d{4}[/.-]\d{1,2}[/.-]\d{1,2}|\d{1,2}[/.-]\d{1,2}([/.-]\d{2,4})?|\d{1,2}(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)\d{0,4})$`)
+	// Times such as 14:32 or 14:32:05
+	timeToken = regexp.MustCompile(`^\d{1,2}:\d{2}(:\d{2})?$`)
+	// Store and terminal numbers such as 3021, #0456 or no.12
+	storeNumberToken = regexp.MustCompile(`^(#\d+|no\.?\d+|\d{3,})$`)
+	// Store numbers glued to the merchant, such as tesco3021 or costa#0456
+	gluedStoreNumber = regexp.MustCompile(`^(\pL{3,})#?\d{3,}$`)
+)
+
+// numberedNames are merchant names whose numbers are part of the name, so they are kept whole
+var numberedNames = map[string]bool{"trading 212": true, "trading212": true, "microsoft 365": true}
+
+// referenceMarkers introduce a payment reference, in the same token after a colon or in the
+// next token
+var referenceMarkers = map[string]bool{"ref": true, "reference": true, "txn": true, "trn": true, "auth": true}
+
+// isNumericNoise reports whether a token is a date, time, store number or payment reference
+// rather than part of the merchant's name. Short numbers such as the 3 in "3 store" or the
+// 24 in "24 hour fitness", and names with digits in them such as "123reg", are kept.
+func isNumericNoise(token string) bool {
+	if dateToken.MatchString(token) || timeToken.MatchString(token) || storeNumberToken.MatchString(token) {
+		return true
+	}
+	// References such as 2k4lj83h5 are mostly digits with letters mixed in, which names aren't
+	letters, digits := 0, 0
+	for _, r := range token {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r):
+			digits++
+		default:
+			return false
+		}
+	}
+	return letters+digits >= 6 && letters >= 2 && digits > letters
+}
+
+// hasDigit reports whether a token contains a digit
+func hasDigit(token string) bool {
+	return strings.IndexFunc(token, unicode.IsDigit) >= 0
+}
+
+// stripNumericTokens drops the dates, times, store numbers and payment references from a
+// lowercased descriptor, so they neither match keywords by accident nor keep the same
+// merchant from resolving to one name. Tokens are split on spaces and "*" processor separators.
+// A descriptor is never stripped to nothing: its last token is kept when nothing else is.
+func stripNumericTokens(descriptor string) string {
+	tokens := strings.FieldsFunc(descriptor, func(r rune) bool { return unicode.IsSpace(r) || r == '*' })
+	kept := tokens[:0]
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if marker, value, ok := strings.Cut(token, ":"); ok && referenceMarkers[marker] && hasDigit(value) {
+			continue
+		}
+		if referenceMarkers[strings.TrimRight(token, ":#.")] && i+1 < len(tokens) && hasDigit(tokens[i+1]) {
+			i++
+			continue
+		}
+		if !hasDigit(token) || numberedNames[token] ||
+			len(kept) > 0 && numberedNames[kept[len(kept)-1]+" "+token] {
+			kept = append(kept, token)
+			continue
+		}
+		if m := gluedStoreNumber.FindStringSubmatch(token); m != nil {
+			kept = append(kept, m[1])
+			continue
+		}
+		if !isNumericNoise(token) || len(kept) == 0 && i == len(tokens)-1 {
+			kept = append(kept, token)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// normalizeDescriptor lowercases a raw descriptor, folds its emoji and symbols and strips
+// numeric and other noise tokens
+func normalizeDescriptor(descriptor string) string {
+	normalized := stripNumericTokens(strings.ToLower(foldSymbols(descriptor)))
+	for _, re := range noisePatterns {
+		normalized = re.ReplaceAllString(normalized, " ")
+	}
+	return strings.Join(strings.Fields(normalized), " ")
+}
+
+// Active noise patterns
+var noisePatterns, _ = compileNoisePatterns(defaultNoisePatterns)
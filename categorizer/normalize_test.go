@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+// TestNormalizeDescriptor runs normalizeDescriptor over raw descriptors as banks and card
+// networks send them
+func TestNormalizeDescriptor(t *testing.T) {
+	tests := []struct {
+		descriptor string
+		want       string
+	}{
+		// Payment method prefixes and processor separators
+		{"CARD PAYMENT TO TESCO STORES", "to tesco stores"},
+		{"CONTACTLESS PRET A MANGER", "pret a manger"},
+		{"UBER *TRIP", "uber trip"},
+		{"SQ *CAFE NERO", "sq cafe nero"},
+		{"APPLE PAY STARBUCKS", "starbucks"},
+		{"VISA AMAZON MARKETPLACE", "amazon marketplace"},
+
+		// Store numbers, loose and glued to the merchant
+		{"TESCO STORES 3021", "tesco stores"},
+		{"TESCO3021", "tesco"},
+		{"COSTA#0456", "costa"},
+		{"SAINSBURYS #0456", "sainsburys"},
+		{"BOOTS NO.12", "boots"},
+
+		// Dates, times and payment references
+		{"AMAZON 2024-03-12", "amazon"},
+		{"DELIVEROO 12/03", "deliveroo"},
+		{"NETFLIX 12MAR24", "netflix"},
+		{"TFL TRAVEL 14:32", "tfl travel"},
+		{"PAYPAL REF:8839201", "paypal"},
+		{"PAYPAL REF 8839201", "paypal"},
+		{"STRIPE TXN 2K4LJ83H5", "stripe"},
+		{"ACME LTD 2K4LJ83H5", "acme ltd"},
+
+		// Numbers that are part of the name
+		{"TRADING 212", "trading 212"},
+		{"TRADING212", "trading212"},
+		{"MICROSOFT 365", "microsoft 365"},
+		{"3 STORE", "3 store"},
+		{"24 HOUR FITNESS", "24 hour fitness"},
+		{"123REG", "123reg"},
+		{"7ELEVEN", "7eleven"},
+
+		// Trailing city and country suffixes
+		{"GREGGS LONDON GB", "greggs"},
+		{"WAITROSE MANCHESTER", "waitrose"},
+		{"ARGOS UK", "argos"},
+
+		// Emoji, symbols and stylized letters
+		{"☕️ 𝐂𝐎𝐒𝐓𝐀™", "coffee costa"},
+		{"🍕 PIZZA EXPRESS", "pizza pizza express"},
+		{"ＴＥＳＣＯ", "tesco"},
+		{"NANDO'S ★★★", "nando's"},
+
+		// A descriptor is never stripped to nothing
+		{"1234", "1234"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeDescriptor(tt.descriptor); got != tt.want {
+			t.Errorf("normalizeDescriptor(%q) = %q, want %q", tt.descriptor, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Notification rule types
+const (
+	NotifyCategoryThreshold = "category_threshold"
+	NotifyTransaction       = "transaction"
+)
+
+// Notification channels
+const (
+	ChannelWebhook = "webhook"
+	ChannelFeed    = "feed"
+)
+
+// EventNotificationTriggered is emitted when a notification rule with the webhook channel fires
+const EventNotificationTriggered = "notification.triggered"
+
+// maxFeedItems caps each user's notification feed, dropping the oldest items
+const maxFeedItems = 100
+
+// NotificationRule alerts a user when their spending in a category passes a threshold over a
+// period, or when a single transaction matches every condition set on the rule. A rule with a
+// saved view only considers transactions within it.
+type NotificationRule struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Name        string     `json:"name,omitempty"`
+	Type        string     `json:"type" binding:"required,oneof=category_threshold transaction"`
+	Category    string     `json:"category,omitempty"`
+	Merchant    string     `json:"merchant,omitempty"`
+	Threshold   float64    `json:"threshold,omitempty"`
+	Period      string     `json:"period,omitempty" binding:"omitempty,oneof=day week month"`
+	MinAmount   float64    `json:"min_amount,omitempty"`
+	Abroad      bool       `json:"abroad,omitempty"`
+	ViewID      string     `json:"view_id,omitempty"`
+	Channels    []string   `json:"channels,omitempty" binding:"omitempty,dive,oneof=webhook feed"`
+	Fired       int        `json:"fired"`
+	LastFiredAt *time.Time `json:"last_fired_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// lastPeriod is the period a threshold rule last fired in, so it fires once per period
+	lastPeriod string
+}
+
+// validate checks the fields each rule type needs and fills in defaults
+func (r *NotificationRule) validate() error {
+	switch r.Type {
+	case NotifyCategoryThreshold:
+		if r.Category == "" || r.Threshold <= 0 {
+			return errors.New("category_threshold rules need a category and a positive threshold")
+		}
+		if r.Period == "" {
+			r.Period = "month"
+		}
+	case NotifyTransaction:
+		if r.Category == "" && r.Merchant == "" && r.MinAmount <= 0 && !r.Abroad && r.ViewID == "" {
+			return errors.New("transaction rules need at least one of category, merchant, min_amount, abroad or view_id")
+		}
+	}
+	if len(r.Channels) == 0 {
+		r.Channels = []string{ChannelWebhook, ChannelFeed}
+	}
+	r.Merchant = strings.ToLower(r.Merchant)
+	return nil
+}
+
+// matchesTransaction reports whether a transaction meets every condition of a transaction
+// rule, counting it as abroad when it is outside homeCountry
+func (r NotificationRule) matchesTransaction(req TransactionRequest, category, homeCountry string) bool {
+	if r.Category != "" && !strings.EqualFold(r.Category, category) {
+		return false
+	}
+	if r.Merchant != "" && !strings.Contains(resolveMerchant(normalizeDescriptor(req.Merchant)), r.Merchant) {
+		return false
+	}
+	if r.MinAmount > 0 && req.Amount < r.MinAmount {
+		return false
+	}
+	if r.Abroad && (req.Country == "" || strings.EqualFold(req.Country, homeCountry)) {
+		return false
+	}
+	return r.inView(req.Merchant, category, req.Amount)
+}
+
+// inView reports whether a transaction falls within the rule's view. A rule whose view no
+// longer exists matches nothing.
+func (r NotificationRule) inView(merchant, category string, amount float64) bool {
+	if r.ViewID == "" {
+		return true
+	}
+	view, err := views.Get(r.UserID, r.ViewID)
+	return err == nil && view.Matches(merchant, category, amount)
+}
+
+// periodStart returns the start of the day, ISO week or month containing t
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "day":
+		return day
+	case "week":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// periodEnd returns the end of the period starting at start
+func periodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case "day":
+		return start.AddDate(0, 0, 1)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// categorySpending totals a user's settled and pending debits in a category over a range,
+// counting only transactions within view when one is given
+func (s *Server) categorySpending(userID, category string, view *SavedView, from, to time.Time) float64 {
+	total := 0.0
+	for _, tx := range s.store.ListTransactions(userID, from, to) {
+		if tx.Duplicate || tx.Status == StatusDeclined || strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		if view != nil && !view.Matches(tx.Merchant, tx.Category, tx.Amount) {
+			continue
+		}
+		if strings.EqualFold(tx.Category, category) {
+			total += tx.Amount
+		}
+	}
+	return roundPence(total)
+}
+
+// thresholdSpending totals what counts towards a threshold rule in srv's history over the
+// period starting at start
+func (r NotificationRule) thresholdSpending(srv *Server, start time.Time) float64 {
+	var view *SavedView
+	if r.ViewID != "" {
+		v, err := views.Get(r.UserID, r.ViewID)
+		if err != nil {
+			return 0
+		}
+		view = &v
+	}
+	return srv.categorySpending(r.UserID, r.Category, view, start, periodEnd(r.Period, start))
+}
+
+// FeedItem is a notification shown in a user's in-app feed
+type FeedItem struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	RuleID    string    `json:"rule_id"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// notifier keeps users' notification rules and feeds in memory
+type notifier struct {
+	mu    sync.Mutex
+	rules map[string][]*NotificationRule
+	feeds map[string][]FeedItem
+}
+
+// newNotifier creates a notifier with no rules
+func newNotifier() *notifier {
+	return &notifier{
+		rules: map[string][]*NotificationRule{},
+		feeds: map[string][]FeedItem{},
+	}
+}
+
+// Add registers a notification rule for its user
+func (n *notifier) Add(rule NotificationRule) NotificationRule {
+	rule.ID = newID("ntf_")
+	rule.CreatedAt = time.Now().UTC()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rules[rule.UserID] = append(n.rules[rule.UserID], &rule)
+	return rule
+}
+
+// List returns a user's notification rules
+func (n *notifier) List(userID string) []NotificationRule {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	rules := make([]NotificationRule, 0, len(n.rules[userID]))
+	for _, rule := range n.rules[userID] {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// Remove deletes a user's notification rule, reporting whether it existed
+func (n *notifier) Remove(userID, id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	rules := n.rules[userID]
+	for i, rule := range rules {
+		if rule.ID == id {
+			n.rules[userID] = append(rules[:i:i], rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns every user's notification rules
+func (n *notifier) Snapshot() map[string][]NotificationRule {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	snapshot := make(map[string][]NotificationRule, len(n.rules))
+	for userID, rules := range n.rules {
+		for _, rule := range rules {
+			snapshot[userID] = append(snapshot[userID], *rule)
+		}
+	}
+	return snapshot
+}
+
+// Restore replaces every user's notification rules
+func (n *notifier) Restore(rules map[string][]NotificationRule) {
+	restored := make(map[string][]*NotificationRule, len(rules))
+	for userID, list := range rules {
+		for i := range list {
+			restored[userID] = append(restored[userID], &list[i])
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rules = restored
+}
+
+// Feed returns a user's notification feed, newest first
+func (n *notifier) Feed(userID string) []FeedItem {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	items := n.feeds[userID]
+	feed := make([]FeedItem, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		feed = append(feed, items[i])
+	}
+	return feed
+}
+
+// Evaluate checks a user's rules against a transaction newly recorded by srv and fires every
+// match
+func (n *notifier) Evaluate(srv *Server, req TransactionRequest, category string) {
+	if req.Status == StatusDeclined || strings.ToLower(req.TransactionType) == "credit" {
+		return
+	}
+	at := req.CreatedAt
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, rule := range n.rules[req.UserID] {
+		switch rule.Type {
+		case NotifyTransaction:
+			if rule.matchesTransaction(req, category, srv.config.HomeCountry) {
+				n.fire(srv, rule, req.TenantID, "Transaction alert", fmt.Sprintf("£%.2f at %s (%s)", req.Amount, req.Merchant, category))
+			}
+		case NotifyCategoryThreshold:
+			if !strings.EqualFold(rule.Category, category) || !rule.inView(req.Merchant, category, req.Amount) {
+				continue
+			}
+			start := periodStart(rule.Period, at)
+			key := start.Format(dateLayout)
+			if rule.lastPeriod == key {
+				continue
+			}
+			spent := rule.thresholdSpending(srv, start)
+			if spent > rule.Threshold {
+				rule.lastPeriod = key
+				srv.emitEvent(EventBudgetBreached, req.TenantID, gin.H{
+					"user_id":      req.UserID,
+					"rule_id":      rule.ID,
+					"category":     rule.Category,
+					"period":       rule.Period,
+					"period_start": start,
+					"threshold":    rule.Threshold,
+					"spent":        spent,
+				})
+				n.fire(srv, rule, req.TenantID, rule.Category+" limit passed",
+					fmt.Sprintf("You've spent £%.2f on %s this %s, over your £%.2f limit", spent, rule.Category, rule.Period, rule.Threshold))
+			}
+		}
+	}
+}
+
+// fire delivers a rule's notification on its channels; callers hold n.mu
+func (n *notifier) fire(srv *Server, rule *NotificationRule, tenantID, title, body string) {
+	now := time.Now().UTC()
+	rule.Fired++
+	rule.LastFiredAt = &now
+	srv.metrics.recordNotificationFired(rule.Type)
+
+	item := FeedItem{
+		ID:        newID("feed_"),
+		UserID:    rule.UserID,
+		RuleID:    rule.ID,
+		Title:     title,
+		Body:      body,
+		CreatedAt: now,
+	}
+	if containsString(rule.Channels, ChannelFeed) {
+		feed := append(n.feeds[rule.UserID], item)
+		if len(feed) > maxFeedItems {
+			feed = feed[len(feed)-maxFeedItems:]
+		}
+		n.feeds[rule.UserID] = feed
+	}
+	if containsString(rule.Channels, ChannelWebhook) {
+		srv.emitEvent(EventNotificationTriggered, tenantID, item)
+	}
+}
+
+// Global notification rules and feeds
+var notifications = newNotifier()
+
+func (s *Server) handleCreateNotificationRule(c *gin.Context) {
+	var rule NotificationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := rule.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.UserID = c.Param("user_id")
+	if rule.ViewID != "" {
+		if _, err := views.Get(rule.UserID, rule.ViewID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "view_id: " + err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusCreated, notifications.Add(rule))
+}
+
+func (s *Server) handleListNotificationRules(c *gin.Context) {
+	userID := c.Param("user_id")
+	rules := notifications.List(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"count":   len(rules),
+		"rules":   rules,
+	})
+}
+
+func (s *Server) handleDeleteNotificationRule(c *gin.Context) {
+	if !notifications.Remove(c.Param("user_id"), c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notification rule not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) handleFeed(c *gin.Context) {
+	userID := c.Param("user_id")
+	feed := notifications.Feed(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"count":   len(feed),
+		"items":   feed,
+	})
+}
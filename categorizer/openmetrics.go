@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// metricUnits are the OpenMetrics units recognised from metric name suffixes
+var metricUnits = []string{"seconds", "bytes", "ratio"}
+
+// metricUnit returns the unit a metric's name ends in, or "" if it has none
+func metricUnit(name string) string {
+	for _, unit := range metricUnits {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// familyName is a metric family's name as OpenMetrics writes it, without a counter's _total
+func familyName(mf *dto.MetricFamily) string {
+	if mf.GetType() == dto.MetricType_COUNTER {
+		return strings.TrimSuffix(mf.GetName(), "_total")
+	}
+	return mf.GetName()
+}
+
+// createdTimestamp returns when a counter, summary or histogram series was created
+func createdTimestamp(mf *dto.MetricFamily, m *dto.Metric) *timestamppb.Timestamp {
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetCreatedTimestamp()
+	case dto.MetricType_SUMMARY:
+		return m.GetSummary().GetCreatedTimestamp()
+	case dto.MetricType_HISTOGRAM:
+		return m.GetHistogram().GetCreatedTimestamp()
+	}
+	return nil
+}
+
+// writeSamples encodes a family's samples without its HELP and TYPE comments
+func writeSamples(buf *bytes.Buffer, mf *dto.MetricFamily) error {
+	var out bytes.Buffer
+	if _, err := expfmt.MetricFamilyToOpenMetrics(&out, mf); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if line := scanner.Text(); !strings.HasPrefix(line, "#") {
+			buf.WriteString(line + "\n")
+		}
+	}
+	return scanner.Err()
+}
+
+// writeOpenMetricsFamily encodes a metric family as OpenMetrics text, adding the UNIT line
+// and the _created samples that the expfmt encoder leaves out
+func writeOpenMetricsFamily(buf *bytes.Buffer, mf *dto.MetricFamily) error {
+	header := &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+	if _, err := expfmt.MetricFamilyToOpenMetrics(buf, header); err != nil {
+		return err
+	}
+	name := familyName(mf)
+	if unit := metricUnit(name); unit != "" {
+		buf.WriteString("# UNIT " + name + " " + unit + "\n")
+	}
+
+	for _, m := range mf.Metric {
+		if err := writeSamples(buf, &dto.MetricFamily{Name: mf.Name, Type: mf.Type, Metric: []*dto.Metric{m}}); err != nil {
+			return err
+		}
+		created := createdTimestamp(mf, m)
+		if created == nil {
+			continue
+		}
+		seconds := float64(created.AsTime().UnixNano()) / 1e9
+		createdFamily := &dto.MetricFamily{
+			Name:   proto.String(name + "_created"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Label: m.Label, Gauge: &dto.Gauge{Value: proto.Float64(seconds)}}},
+		}
+		if err := writeSamples(buf, createdFamily); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metricsHandler serves /metrics in the format the scraper asks for: OpenMetrics text with
+// units and created timestamps when it accepts application/openmetrics-text, and the
+// Prometheus text or protobuf formats otherwise, gathering from m's registry
+func metricsHandler(m *Metrics) gin.HandlerFunc {
+	prometheusHandler := promhttp.InstrumentMetricHandler(m.registry, promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return func(c *gin.Context) {
+		format := expfmt.NegotiateIncludingOpenMetrics(c.Request.Header)
+		if !strings.HasPrefix(string(format), expfmt.OpenMetricsType) {
+			prometheusHandler.ServeHTTP(c.Writer, c.Request)
+			return
+		}
+
+		families, err := m.registry.Gather()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		var buf bytes.Buffer
+		for _, mf := range families {
+			if err := writeOpenMetricsFamily(&buf, mf); err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		expfmt.FinalizeOpenMetrics(&buf)
+		c.Data(http.StatusOK, string(format), buf.Bytes())
+	}
+}
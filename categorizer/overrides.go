@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Override scopes
+const (
+	ScopeUser   = "user"
+	ScopeTenant = "tenant"
+)
+
+// Override pins every transaction at a merchant to a category for one user or tenant.
+// Deletions are kept as tombstones so they replicate between regions like any other write.
+type Override struct {
+	Scope     string    `json:"scope" binding:"required,oneof=user tenant"`
+	ScopeID   string    `json:"scope_id" binding:"required"`
+	Merchant  string    `json:"merchant" binding:"required"`
+	Category  string    `json:"category" binding:"required"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Region    string    `json:"region,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// newerThan reports whether o wins a last-writer-wins merge against other. Ties on the
+// timestamp go to the higher region name so every region picks the same winner.
+func (o Override) newerThan(other Override) bool {
+	if !o.UpdatedAt.Equal(other.UpdatedAt) {
+		return o.UpdatedAt.After(other.UpdatedAt)
+	}
+	return o.Region > other.Region
+}
+
+// key identifies an override by scope and canonical merchant
+func (o Override) key() string {
+	return o.Scope + "|" + o.ScopeID + "|" + o.Merchant
+}
+
+// knownCategories returns every category the active rules, or the built-in stages, can assign
+func (s *Server) knownCategories() map[string]bool {
+	categories := map[string]bool{"Income": true, CategoryTransfers: true, CategoryInvestments: true, "Other": true, CategoryCardVerification: true}
+	for _, rule := range s.activeRules().Rules {
+		categories[rule.Category] = true
+	}
+	return categories
+}
+
+// validate canonicalises the merchant and category and checks the override's fields. The
+// category may be any name the tenant uses: a tenant override's own, or tenantID's for a user
+// override.
+func (o *Override) validate(base map[string]bool, tenantID string) error {
+	if o.Scope == ScopeTenant {
+		tenantID = o.ScopeID
+	}
+	categories := tenantTaxonomies.Categories(tenantID, base)
+	o.Merchant = resolveMerchant(normalizeDescriptor(o.Merchant))
+	switch {
+	case o.Scope != ScopeUser && o.Scope != ScopeTenant:
+		return fmt.Errorf("scope must be %q or %q", ScopeUser, ScopeTenant)
+	case o.ScopeID == "":
+		return errors.New("scope_id is required")
+	case o.Merchant == "":
+		return errors.New("merchant is required")
+	case !categories[o.Category]:
+		return fmt.Errorf("unknown category %q", o.Category)
+	}
+	o.Category = tenantTaxonomies.Canonical(tenantID, o.Category)
+	return nil
+}
+
+// overrideStore keeps user and tenant overrides in memory, with a log of local writes for
+// replication to peer regions
+type overrideStore struct {
+	mu        sync.RWMutex
+	overrides map[string]Override
+	changes   []Override
+	// changesBase is the sequence number of changes[0]
+	changesBase int
+}
+
+// Put creates or replaces an override as a local write in region
+func (s *overrideStore) Put(o Override, region string) Override {
+	o.UpdatedAt = clock.Now()
+	o.Region = region
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[o.key()] = o
+	s.changes = append(s.changes, o)
+	return o
+}
+
+// Merge applies a write replicated from another region if it wins over the stored override,
+// reporting whether it was applied
+func (s *overrideStore) Merge(o Override) bool {
+	clock.Observe(o.UpdatedAt)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.overrides[o.key()]; ok && !o.newerThan(existing) {
+		return false
+	}
+	s.overrides[o.key()] = o
+	return true
+}
+
+// ChangesSince returns local writes from sequence number seq onwards and the next sequence number
+func (s *overrideStore) ChangesSince(seq int) ([]Override, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if seq < s.changesBase {
+		seq = s.changesBase
+	}
+	return append([]Override{}, s.changes[seq-s.changesBase:]...), s.changesBase + len(s.changes)
+}
+
+// TrimChanges drops local writes before seq once every peer has them
+func (s *overrideStore) TrimChanges(seq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := seq - s.changesBase; n > 0 && n <= len(s.changes) {
+		s.changes = append([]Override{}, s.changes[n:]...)
+		s.changesBase = seq
+	}
+}
+
+// Exists reports whether a live override with the same scope and merchant is stored
+func (s *overrideStore) Exists(o Override) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existing, ok := s.overrides[o.key()]
+	return ok && !existing.Deleted
+}
+
+// Delete tombstones an override as a local write in region, reporting whether a live one
+// existed
+func (s *overrideStore) Delete(scope, scopeID, merchant, region string) bool {
+	o := Override{Scope: scope, ScopeID: scopeID, Merchant: resolveMerchant(normalizeDescriptor(merchant))}
+	if !s.Exists(o) {
+		return false
+	}
+	o.Deleted = true
+	s.Put(o, region)
+	return true
+}
+
+// List returns live overrides sorted by scope, scope ID and merchant, optionally for one scope
+func (s *overrideStore) List(scope, scopeID string) []Override {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Override, 0, len(s.overrides))
+	for _, o := range s.overrides {
+		if o.Deleted {
+			continue
+		}
+		if (scope == "" || o.Scope == scope) && (scopeID == "" || o.ScopeID == scopeID) {
+			list = append(list, o)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+	return list
+}
+
+// Restore replaces every override
+func (s *overrideStore) Restore(list []Override) {
+	restored := make(map[string]Override, len(list))
+	for _, o := range list {
+		restored[o.key()] = o
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = restored
+}
+
+// Lookup returns the override for a canonical merchant, preferring the user's over the tenant's
+func (s *overrideStore) Lookup(userID, tenantID, merchant string) (Override, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if userID != "" {
+		if o, ok := s.overrides[Override{Scope: ScopeUser, ScopeID: userID, Merchant: merchant}.key()]; ok && !o.Deleted {
+			return o, true
+		}
+	}
+	if tenantID != "" {
+		if o, ok := s.overrides[Override{Scope: ScopeTenant, ScopeID: tenantID, Merchant: merchant}.key()]; ok && !o.Deleted {
+			return o, true
+		}
+	}
+	return Override{}, false
+}
+
+// Global category overrides
+var overrides = &overrideStore{overrides: map[string]Override{}}
+
+// overrideStage applies a user's or tenant's override for the resolved merchant
+type overrideStage struct{}
+
+func (overrideStage) Name() string { return "overrides" }
+
+func (overrideStage) Backend() string { return "override" }
+
+func (overrideStage) Process(cl *Classification) {
+	if cl.Decided || (cl.UserID == "" && cl.TenantID == "") {
+		return
+	}
+	if o, ok := overrides.Lookup(cl.UserID, cl.TenantID, cl.NormalizedMerchant); ok {
+		cl.Override = &o
+		cl.decide(o.Category)
+	}
+}
+
+// overrideCSVHeader is the column layout of override CSV imports and exports
+var overrideCSVHeader = []string{"scope", "scope_id", "merchant", "category"}
+
+// parseOverridesCSV reads overrides from CSV with a header row
+func parseOverridesCSV(r io.Reader) ([]Override, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty CSV")
+	}
+	if strings.Join(records[0], ",") != strings.Join(overrideCSVHeader, ",") {
+		return nil, fmt.Errorf("CSV header must be %s", strings.Join(overrideCSVHeader, ","))
+	}
+	list := make([]Override, 0, len(records)-1)
+	for _, record := range records[1:] {
+		list = append(list, Override{Scope: record[0], ScopeID: record[1], Merchant: record[2], Category: record[3]})
+	}
+	return list, nil
+}
+
+// OverrideImportError describes an invalid row of a bulk import
+type OverrideImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// OverrideImportResult reports what a bulk import did, or would do in a dry run
+type OverrideImportResult struct {
+	DryRun  bool                  `json:"dry_run"`
+	Total   int                   `json:"total"`
+	Created int                   `json:"created"`
+	Updated int                   `json:"updated"`
+	Errors  []OverrideImportError `json:"errors"`
+}
+
+// importOverrides validates every override and applies them only if all are valid
+func (s *Server) importOverrides(list []Override, dryRun bool) OverrideImportResult {
+	result := OverrideImportResult{DryRun: dryRun, Total: len(list), Errors: []OverrideImportError{}}
+	categories := s.knownCategories()
+	for i := range list {
+		if err := list[i].validate(categories, ""); err != nil {
+			result.Errors = append(result.Errors, OverrideImportError{Row: i + 1, Error: err.Error()})
+			continue
+		}
+		if overrides.Exists(list[i]) {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+	if dryRun || len(result.Errors) > 0 {
+		return result
+	}
+	for _, o := range list {
+		overrides.Put(o, s.config.Region)
+	}
+	return result
+}
+
+// handleOverrideExport serves GET /admin/overrides/export?format=json|csv&scope=&scope_id=
+func (s *Server) handleOverrideExport(c *gin.Context) {
+	list := overrides.List(c.Query("scope"), c.Query("scope_id"))
+	switch strings.ToLower(c.DefaultQuery("format", "json")) {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{"count": len(list), "overrides": list})
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(overrideCSVHeader)
+		for _, o := range list {
+			w.Write([]string{o.Scope, o.ScopeID, o.Merchant, o.Category})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=overrides.csv")
+		c.Data(http.StatusOK, "text/csv", buf.Bytes())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+	}
+}
+
+// handleOverrideImport serves POST /admin/overrides/import?format=json|csv&dry_run=true. An
+// import with any invalid row changes nothing.
+func (s *Server) handleOverrideImport(c *gin.Context) {
+	var list []Override
+	switch strings.ToLower(c.DefaultQuery("format", "json")) {
+	case "json":
+		if err := json.NewDecoder(c.Request.Body).Decode(&list); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case "csv":
+		parsed, err := parseOverridesCSV(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		list = parsed
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	result := s.importOverrides(list, c.Query("dry_run") == "true")
+	status := http.StatusOK
+	if len(result.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, result)
+}
+
+func (s *Server) handleCreateUserOverride(c *gin.Context) {
+	var req struct {
+		Merchant string `json:"merchant" binding:"required"`
+		Category string `json:"category" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID, err := scopeTenant(c, c.Query("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	o := Override{Scope: ScopeUser, ScopeID: c.Param("user_id"), Merchant: req.Merchant, Category: req.Category}
+	if err := o.validate(s.knownCategories(), tenantID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, overrides.Put(o, s.config.Region))
+}
+
+func (s *Server) handleListUserOverrides(c *gin.Context) {
+	userID := c.Param("user_id")
+	list := overrides.List(ScopeUser, userID)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":   userID,
+		"count":     len(list),
+		"overrides": list,
+	})
+}
+
+func (s *Server) handleDeleteUserOverride(c *gin.Context) {
+	if !overrides.Delete(ScopeUser, c.Param("user_id"), c.Query("merchant"), s.config.Region) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "override not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
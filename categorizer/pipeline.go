@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultPipelineStages is the stage order used unless PIPELINE_STAGES overrides it
+var defaultPipelineStages = []string{"normalize", "merchant_resolve", "translate", "merchant_policy", "zero_amount", "overrides", "sticky", "bnpl", "feedback", "investments", "income", "ml", "rules", "post_process"}
+
+// Classification carries a transaction through the categorization pipeline
+type Classification struct {
+	Merchant        string
+	Description     string
+	Amount          float64
+	TransactionType string
+	MCC             string
+	UserID          string
+	TenantID        string
+	// CreatedAt is when the transaction happened, zero when the request didn't say
+	CreatedAt time.Time
+
+	// RuleSet is the rule set the rules stage evaluates
+	RuleSet *RuleSet
+
+	NormalizedMerchant    string
+	NormalizedDescription string
+
+	// Language is the detected language of the description, "" when undetermined
+	Language string
+
+	Category string
+	Policy   *MerchantPolicy
+	Override *Override
+	Sticky   *StickyPin
+	Feedback *FeedbackVote
+	Rule     *Rule
+	Keyword  string
+	Fuzzy    bool
+
+	// IncomeType is the income stage's type for a credit, one of the Income* constants
+	IncomeType string
+	// InvestmentType is the investments stage's type for a credit, one of the Investment* constants
+	InvestmentType string
+	// BNPLProvider is the buy-now-pay-later provider of an installment the bnpl stage linked
+	// to its purchase's category
+	BNPLProvider string
+
+	// Candidates are the categories the rules considered, best first
+	Candidates []CategoryScore
+	// RuleHits are the rules that matched, in the order the rules stage preferred them
+	RuleHits []RuleHit
+
+	// Decided is set by the stage that assigned the final category
+	Decided bool
+
+	// DecidedBy and Backend name the stage that assigned the category and the classifier
+	// backend behind it
+	DecidedBy string
+	Backend   string
+
+	// Carbon is the footprint estimate from the optional carbon stage
+	Carbon *CarbonEstimate
+
+	// Explain asks the pipeline to record a per-stage trace
+	Explain bool
+	Trace   []StageTrace
+}
+
+// StageTrace records the state of a classification after one pipeline stage
+type StageTrace struct {
+	Stage       string  `json:"stage"`
+	DurationUs  float64 `json:"duration_us"`
+	Merchant    string  `json:"merchant"`
+	Description string  `json:"description"`
+	Category    string  `json:"category,omitempty"`
+	Decided     bool    `json:"decided"`
+}
+
+// decide assigns the final category
+func (cl *Classification) decide(category string) {
+	cl.Category = category
+	cl.Decided = true
+}
+
+// Stage is a step of the categorization pipeline
+type Stage interface {
+	Name() string
+	Process(cl *Classification)
+}
+
+// BackendStage is a stage that decides categories with a particular classifier backend,
+// used to label latency metrics. Stages without one report their stage name.
+type BackendStage interface {
+	Backend() string
+}
+
+// stageBackend returns the classifier backend a stage decides with
+func stageBackend(stage Stage) string {
+	if b, ok := stage.(BackendStage); ok {
+		return b.Backend()
+	}
+	return stage.Name()
+}
+
+// Pipeline runs stages in order over a classification
+type Pipeline struct {
+	stages []Stage
+}
+
+// stageRegistry maps configurable stage names to their constructors, which are given the
+// server whose history, configuration and logger the stage consults
+var stageRegistry = map[string]func(s *Server) Stage{
+	"normalize":        func(s *Server) Stage { return normalizeStage{} },
+	"merchant_resolve": func(s *Server) Stage { return merchantResolveStage{} },
+	"overrides":        func(s *Server) Stage { return overrideStage{} },
+	"rules":            func(s *Server) Stage { return rulesStage{} },
+	"ml":               func(s *Server) Stage { return mlStage{} },
+	"ml_fallback":      func(s *Server) Stage { return mlFallbackStage{} },
+	"post_process":     func(s *Server) Stage { return postProcessStage{} },
+	"carbon":           func(s *Server) Stage { return carbonStage{} },
+	"zero_amount":      func(s *Server) Stage { return zeroAmountStage{} },
+	"merchant_policy":  func(s *Server) Stage { return merchantPolicyStage{} },
+	"sticky":           func(s *Server) Stage { return stickyStage{} },
+	"translate":        func(s *Server) Stage { return translateStage{s} },
+	"income":           func(s *Server) Stage { return incomeStage{s} },
+	"investments":      func(s *Server) Stage { return investmentStage{} },
+	"bnpl":             func(s *Server) Stage { return bnplStage{s} },
+	"feedback":         func(s *Server) Stage { return feedbackStage{s} },
+}
+
+// newPipeline builds a pipeline for s from stage names in the order given
+func newPipeline(names []string, s *Server) (*Pipeline, error) {
+	p := &Pipeline{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		constructor, ok := stageRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pipeline stage %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("pipeline stage %q listed twice", name)
+		}
+		seen[name] = true
+		p.stages = append(p.stages, constructor(s))
+	}
+	return p, nil
+}
+
+// Stages returns the names of the pipeline stages in order
+func (p *Pipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, stage := range p.stages {
+		names = append(names, stage.Name())
+	}
+	return names
+}
+
+// Run passes the classification through every stage, recording each stage's latency in m
+func (p *Pipeline) Run(cl *Classification, m *Metrics) {
+	cl.NormalizedMerchant = cl.Merchant
+	cl.NormalizedDescription = cl.Description
+	for _, stage := range p.stages {
+		start := time.Now()
+		decided := cl.Decided
+		stage.Process(cl)
+		duration := time.Since(start)
+		m.recordStageDuration(stage.Name(), duration)
+		if !decided && cl.Decided {
+			cl.DecidedBy = stage.Name()
+			cl.Backend = stageBackend(stage)
+		}
+
+		if cl.Explain {
+			cl.Trace = append(cl.Trace, StageTrace{
+				Stage:       stage.Name(),
+				DurationUs:  float64(duration.Nanoseconds()) / 1e3,
+				Merchant:    cl.NormalizedMerchant,
+				Description: cl.NormalizedDescription,
+				Category:    cl.Category,
+				Decided:     cl.Decided,
+			})
+		}
+	}
+	if cl.Category == "" {
+		cl.Category = "Other"
+	}
+}
+
+// defaultPipeline builds the pipeline for s from the default stages
+func defaultPipeline(s *Server) *Pipeline {
+	p, _ := newPipeline(defaultPipelineStages, s)
+	return p
+}
+
+// newClassification starts a classification of a request against a rule set
+func newClassification(req TransactionRequest, rs *RuleSet) Classification {
+	return Classification{
+		Merchant:        req.Merchant,
+		Description:     req.Description,
+		Amount:          req.Amount,
+		TransactionType: req.TransactionType,
+		MCC:             req.MCC,
+		UserID:          req.UserID,
+		TenantID:        req.TenantID,
+		CreatedAt:       req.CreatedAt,
+		RuleSet:         rs,
+	}
+}
+
+// categorizeWith runs a transaction through the server's classifier pipeline against a
+// specific rule set
+func (s *Server) categorizeWith(rs *RuleSet, merchant, description string, amount float64, transactionType string) Classification {
+	cl := Classification{
+		Merchant:        merchant,
+		Description:     description,
+		Amount:          amount,
+		TransactionType: transactionType,
+		RuleSet:         rs,
+	}
+	s.classifier.Run(&cl)
+	return cl
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxReceiptItems caps the lines on one receipt
+	maxReceiptItems = 200
+	// maxReceiptAttempts bounds how often pushing a receipt to Monzo is tried
+	maxReceiptAttempts = 5
+)
+
+// ReceiptItem is one line of a transaction's receipt, categorized on its own so a basket
+// can be split across categories
+type ReceiptItem struct {
+	Description string  `json:"description" binding:"required"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit,omitempty"`
+	Amount      float64 `json:"amount" binding:"required,gt=0"`
+	Tax         float64 `json:"tax,omitempty" binding:"gte=0"`
+	Category    string  `json:"category,omitempty"`
+}
+
+// Receipt itemizes a transaction. Its external ID is fixed per transaction, so pushing it
+// to Monzo again, whether retried or after an edit, replaces the receipt rather than adding one.
+type Receipt struct {
+	ExternalID string        `json:"external_id"`
+	Currency   string        `json:"currency"`
+	Items      []ReceiptItem `json:"items"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	PushedAt   *time.Time    `json:"pushed_at,omitempty"`
+	PushError  string        `json:"push_error,omitempty"`
+}
+
+// Splits returns the receipt's total in each of its items' categories
+func (r Receipt) Splits() map[string]float64 {
+	splits := map[string]float64{}
+	for _, item := range r.Items {
+		splits[item.Category] = roundPence(splits[item.Category] + item.Amount)
+	}
+	return splits
+}
+
+// toPence converts pounds to minor units
+func toPence(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// monzoReceiptFor builds the Monzo receipt for a transaction
+func monzoReceiptFor(tx StoredTransaction) monzoReceipt {
+	receipt := monzoReceipt{
+		TransactionID: tx.TransactionID,
+		ExternalID:    tx.Receipt.ExternalID,
+		Currency:      tx.Receipt.Currency,
+		Items:         make([]monzoReceiptItem, 0, len(tx.Receipt.Items)),
+	}
+	for _, item := range tx.Receipt.Items {
+		// Monzo's app shows each line's category alongside its description
+		receipt.Items = append(receipt.Items, monzoReceiptItem{
+			Description: item.Description + " (" + item.Category + ")",
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			Amount:      toPence(item.Amount),
+			Currency:    tx.Receipt.Currency,
+			Tax:         toPence(item.Tax),
+		})
+		receipt.Total += toPence(item.Amount)
+	}
+	return receipt
+}
+
+// pushReceipt sends a transaction's receipt to Monzo in the background, when receipts are
+// enabled and the transaction was synced through a connected account. Failures are retried
+// with backoff, or after Monzo's Retry-After when rate limited, and the outcome recorded on
+// the receipt.
+func (s *Server) pushReceipt(tx StoredTransaction) {
+	if !s.config.MonzoReceipts || tx.Receipt == nil {
+		return
+	}
+	conn, ok := monzoTokens.ForAccount(tx.Account)
+	if !ok {
+		return
+	}
+	receipt := monzoReceiptFor(tx)
+	go func() {
+		client := newConnectionClient(s.config, s.metrics, conn)
+		var err error
+		for attempt := 1; attempt <= maxReceiptAttempts; attempt++ {
+			if err = client.PutReceipt(receipt); err == nil || !retryableMonzoError(err) {
+				break
+			}
+			wait := time.Duration(1<<(attempt-1)) * time.Second
+			var apiErr *monzoAPIError
+			if errors.As(err, &apiErr) && apiErr.retryAfter > 0 {
+				wait = apiErr.retryAfter
+			}
+			time.Sleep(wait)
+		}
+		s.recordReceiptPush(tx, receipt.ExternalID, err)
+	}()
+}
+
+// retryableMonzoError reports whether a failed Monzo call may succeed if tried again
+func retryableMonzoError(err error) bool {
+	if errors.Is(err, errReauthRequired) {
+		return false
+	}
+	var apiErr *monzoAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.status == http.StatusTooManyRequests || apiErr.status == http.StatusRequestTimeout || apiErr.status >= 500
+	}
+	return true
+}
+
+// recordReceiptPush notes the outcome of pushing a receipt, unless it has since been replaced
+func (s *Server) recordReceiptPush(tx StoredTransaction, externalID string, err error) {
+	result := "pushed"
+	if err != nil {
+		result = "failed"
+		s.logger.Warn(fmt.Sprintf("Monzo receipt push for transaction %s failed", tx.TransactionID), map[string]interface{}{
+			"event_type":    "monzo_receipt_failed",
+			"error_message": err.Error(),
+		})
+	}
+	s.metrics.recordMonzoReceipt(result)
+
+	pushedAt := time.Now().UTC()
+	s.store.UpdateTransaction(tx.UserID, tx.ID, func(current *StoredTransaction) error {
+		if current.Receipt == nil || current.Receipt.ExternalID != externalID || current.Receipt.UpdatedAt != tx.Receipt.UpdatedAt {
+			return errors.New("receipt changed")
+		}
+		receipt := *current.Receipt
+		if err != nil {
+			receipt.PushError = err.Error()
+		} else {
+			receipt.PushedAt, receipt.PushError = &pushedAt, ""
+		}
+		current.Receipt = &receipt
+		return nil
+	})
+}
+
+// handlePutReceipt serves PUT /users/:user_id/transactions/:id/receipt, itemizing a
+// transaction. Each item is categorized by its description unless given a category, and the
+// items must add up to the transaction's amount. The response splits the amount by category.
+func (s *Server) handlePutReceipt(c *gin.Context) {
+	var req struct {
+		Currency string        `json:"currency"`
+		Items    []ReceiptItem `json:"items" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Items) > maxReceiptItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a receipt holds at most %d items", maxReceiptItems)})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = "GBP"
+	}
+
+	// Items are categorized before the transaction is updated: the pipeline reads the store,
+	// so it can't run under the store's lock
+	current, err := s.store.GetTransaction(c.Param("user_id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	rs := s.activeRules()
+	var total float64
+	items := make([]ReceiptItem, len(req.Items))
+	for i, item := range req.Items {
+		if item.Quantity <= 0 {
+			item.Quantity = 1
+		}
+		if item.Category == "" {
+			item.Category = s.classifier.Classify(TransactionRequest{
+				Merchant:        item.Description,
+				Description:     item.Description,
+				Amount:          item.Amount,
+				TransactionType: current.TransactionType,
+				UserID:          current.UserID,
+				TenantID:        current.TenantID,
+			}, rs).Category
+		}
+		items[i] = item
+		total += item.Amount
+	}
+
+	tx, err := s.store.UpdateTransaction(current.UserID, current.ID, func(tx *StoredTransaction) error {
+		if toPence(total) != toPence(math.Abs(tx.Amount)) {
+			return fmt.Errorf("items add up to %.2f but the transaction is %.2f", total, math.Abs(tx.Amount))
+		}
+		tx.Receipt = &Receipt{
+			ExternalID: "receipt_" + tx.ID,
+			Currency:   strings.ToUpper(req.Currency),
+			Items:      items,
+			UpdatedAt:  time.Now().UTC(),
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errTransactionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.pushReceipt(tx)
+	c.JSON(http.StatusOK, gin.H{"transaction_id": tx.ID, "receipt": tx.Receipt, "splits": tx.Receipt.Splits()})
+}
+
+// handleGetReceipt serves GET /users/:user_id/transactions/:id/receipt
+func (s *Server) handleGetReceipt(c *gin.Context) {
+	tx, err := s.store.GetTransaction(c.Param("user_id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if tx.Receipt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction has no receipt"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"transaction_id": tx.ID, "receipt": tx.Receipt, "splits": tx.Receipt.Splits()})
+}
+
+// handleDeleteReceipt serves DELETE /users/:user_id/transactions/:id/receipt, removing the
+// receipt here and, once pushed, from Monzo
+func (s *Server) handleDeleteReceipt(c *gin.Context) {
+	tx, err := s.store.GetTransaction(c.Param("user_id"), c.Param("id"))
+	if err != nil || tx.Receipt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction has no receipt"})
+		return
+	}
+	if tx.Receipt.PushedAt != nil {
+		conn, ok := monzoTokens.ForAccount(tx.Account)
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "the receipt is in Monzo but the account is no longer connected"})
+			return
+		}
+		if err := newConnectionClient(s.config, s.metrics, conn).withPriority(PriorityInteractive).DeleteReceipt(tx.Receipt.ExternalID); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	s.store.UpdateTransaction(tx.UserID, tx.ID, func(current *StoredTransaction) error {
+		current.Receipt = nil
+		return nil
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// External reference types
+const (
+	RefReceiptURL    = "receipt_url"
+	RefInvoiceNumber = "invoice_number"
+	RefExpenseReport = "expense_report"
+	RefOther         = "other"
+)
+
+// maxReferences caps the references attached to one transaction
+const maxReferences = 20
+
+// ExternalReference links a transaction to a record in another system, such as a receipt
+// image, an invoice or an expense report, so the two can be reconciled
+type ExternalReference struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type" binding:"required,oneof=receipt_url invoice_number expense_report other"`
+	Value     string    `json:"value" binding:"required"`
+	System    string    `json:"system,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// validate checks receipt URLs and trims the value
+func (r *ExternalReference) validate() error {
+	r.Value = strings.TrimSpace(r.Value)
+	if r.Value == "" {
+		return errors.New("value is required")
+	}
+	if r.Type == RefReceiptURL {
+		return validateURL(r.Value)
+	}
+	return nil
+}
+
+// receiptURL returns the first receipt URL attached to a transaction
+func receiptURL(tx StoredTransaction) string {
+	for _, ref := range tx.References {
+		if ref.Type == RefReceiptURL {
+			return ref.Value
+		}
+	}
+	return ""
+}
+
+// handleAddReference serves POST /users/:user_id/transactions/:id/references
+func (s *Server) handleAddReference(c *gin.Context) {
+	var ref ExternalReference
+	if err := c.ShouldBindJSON(&ref); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ref.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ref.ID = newID("ref_")
+	ref.CreatedBy = callerName(c)
+	ref.CreatedAt = time.Now().UTC()
+
+	tx, err := s.store.UpdateTransaction(c.Param("user_id"), c.Param("id"), func(tx *StoredTransaction) error {
+		if len(tx.References) >= maxReferences {
+			return fmt.Errorf("a transaction holds at most %d references", maxReferences)
+		}
+		for _, existing := range tx.References {
+			if existing.Type == ref.Type && existing.Value == ref.Value {
+				return fmt.Errorf("%s %q is already attached", ref.Type, ref.Value)
+			}
+		}
+		// Copy before appending, since snapshots of the transaction share the old slice
+		tx.References = append(append([]ExternalReference{}, tx.References...), ref)
+		return nil
+	})
+	switch {
+	case errors.Is(err, errTransactionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, gin.H{"reference": ref, "transaction": tx})
+	}
+}
+
+// handleListReferences serves GET /users/:user_id/transactions/:id/references
+func (s *Server) handleListReferences(c *gin.Context) {
+	tx, err := s.store.GetTransaction(c.Param("user_id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	references := append([]ExternalReference{}, tx.References...)
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_id": tx.ID,
+		"count":          len(references),
+		"references":     references,
+	})
+}
+
+// handleDeleteReference serves DELETE /users/:user_id/transactions/:id/references/:ref_id
+func (s *Server) handleDeleteReference(c *gin.Context) {
+	refID := c.Param("ref_id")
+	_, err := s.store.UpdateTransaction(c.Param("user_id"), c.Param("id"), func(tx *StoredTransaction) error {
+		for i, ref := range tx.References {
+			if ref.ID == refID {
+				tx.References = append(tx.References[:i:i], tx.References[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("reference not found")
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
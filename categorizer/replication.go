@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hybridClock hands out write timestamps that never go backwards and always sort after any
+// timestamp observed from another region, so last-writer-wins survives clock skew
+type hybridClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// Now returns a timestamp later than every one it has returned or observed
+func (h *hybridClock) Now() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now().UTC()
+	if !now.After(h.last) {
+		now = h.last.Add(time.Nanosecond)
+	}
+	h.last = now
+	return now
+}
+
+// Observe advances the clock past a timestamp written in another region
+func (h *hybridClock) Observe(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t.After(h.last) {
+		h.last = t.UTC()
+	}
+}
+
+// Global write clock
+var clock = &hybridClock{}
+
+// ReplicationBatch is a set of override writes pushed from one region to another
+type ReplicationBatch struct {
+	Region    string     `json:"region" binding:"required"`
+	Overrides []Override `json:"overrides"`
+}
+
+// FeedbackReplicationBatch is a set of feedback writes pushed from one region to another
+type FeedbackReplicationBatch struct {
+	Region   string     `json:"region" binding:"required"`
+	Feedback []Feedback `json:"feedback"`
+}
+
+// replicationStream is a kind of record replicated to peers, each pushed to its own endpoint
+// so a peer that doesn't know the record fails the push and it is retried after upgrading
+type replicationStream struct {
+	// name is the record's path under /internal/replication/
+	name string
+	// batch returns the local writes from seq onwards as a batch from region, the next
+	// sequence number and how many writes the batch holds
+	batch func(region string, seq int) (interface{}, int, int)
+	// trim drops local writes before seq
+	trim func(seq int)
+}
+
+// replicationStreams are the records replicated between regions
+var replicationStreams = []replicationStream{
+	{
+		name: "overrides",
+		batch: func(region string, seq int) (interface{}, int, int) {
+			changes, next := overrides.ChangesSince(seq)
+			return ReplicationBatch{Region: region, Overrides: changes}, next, len(changes)
+		},
+		trim: func(seq int) { overrides.TrimChanges(seq) },
+	},
+	{
+		name: "feedback",
+		batch: func(region string, seq int) (interface{}, int, int) {
+			changes, next := feedback.ChangesSince(seq)
+			return FeedbackReplicationBatch{Region: region, Feedback: changes}, next, len(changes)
+		},
+		trim: func(seq int) { feedback.TrimChanges(seq) },
+	},
+}
+
+// replicator pushes local writes to peer regions, tracking how far each peer has got with
+// each stream
+type replicator struct {
+	region  string
+	peers   []string
+	token   string
+	client  *http.Client
+	metrics *Metrics
+	logger  *StructuredLogger
+
+	mu sync.Mutex
+	// acked is the next sequence number each peer needs, by stream then peer
+	acked map[string]map[string]int
+}
+
+// newReplicator creates a replicator for the configured region and peers, recording pushes
+// in m and logging failures to logger
+func newReplicator(cfg Config, m *Metrics, logger *StructuredLogger) *replicator {
+	return &replicator{
+		region:  cfg.Region,
+		peers:   cfg.PeerURLs,
+		token:   cfg.ReplicationToken,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		metrics: m,
+		logger:  logger,
+		acked:   map[string]map[string]int{},
+	}
+}
+
+// sync pushes every write of each stream each peer hasn't acknowledged, then trims writes all
+// peers have
+func (r *replicator) sync() {
+	for _, stream := range replicationStreams {
+		r.syncStream(stream)
+	}
+}
+
+// syncStream pushes one stream's unacknowledged writes to each peer
+func (r *replicator) syncStream(stream replicationStream) {
+	r.mu.Lock()
+	acked, ok := r.acked[stream.name]
+	if !ok {
+		acked = map[string]int{}
+		r.acked[stream.name] = acked
+	}
+	r.mu.Unlock()
+
+	for _, peer := range r.peers {
+		r.mu.Lock()
+		from := acked[peer]
+		r.mu.Unlock()
+
+		batch, next, count := stream.batch(r.region, from)
+		if count == 0 {
+			continue
+		}
+		if err := r.push(peer, stream.name, batch); err != nil {
+			r.metrics.recordReplicationPush(peer, "error")
+			r.logger.Warn("Replication push failed", map[string]interface{}{
+				"event_type":    "replication_failed",
+				"endpoint":      peer,
+				"record":        stream.name,
+				"error_message": err.Error(),
+			})
+			continue
+		}
+		r.metrics.recordReplicationPush(peer, "ok")
+		r.mu.Lock()
+		acked[peer] = next
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	low := -1
+	for _, peer := range r.peers {
+		if low < 0 || acked[peer] < low {
+			low = acked[peer]
+		}
+	}
+	r.mu.Unlock()
+	stream.trim(low)
+}
+
+// push sends a batch of writes to a peer's replication endpoint for the stream
+func (r *replicator) push(peer, stream string, batch interface{}) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(peer, "/")+"/internal/replication/"+stream, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Replication-Token", r.token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return nil
+}
+
+// startReplication pushes local writes to peer regions every interval. Without peers the
+// service runs as a single region; with them, startup has already required REPLICATION_TOKEN.
+func (s *Server) startReplication() {
+	if len(s.config.PeerURLs) == 0 {
+		return
+	}
+	r := newReplicator(s.config, s.metrics, s.logger)
+	go func() {
+		for range time.Tick(s.config.ReplicationInterval) {
+			r.sync()
+		}
+	}()
+}
+
+// replicationAuthorized checks a peer's replication token, answering 401 when it doesn't match
+func (s *Server) replicationAuthorized(c *gin.Context) bool {
+	if s.config.ReplicationToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "REPLICATION_TOKEN is not configured"})
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Replication-Token")), []byte(s.config.ReplicationToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid replication token"})
+		return false
+	}
+	return true
+}
+
+// handleReplicateOverrides serves POST /internal/replication/overrides, merging a peer's writes
+func (s *Server) handleReplicateOverrides(c *gin.Context) {
+	if !s.replicationAuthorized(c) {
+		return
+	}
+	var batch ReplicationBatch
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Peers send canonical categories, so their writes are checked against the base ones
+	categories := s.knownCategories()
+	applied, stale, rejected := 0, 0, 0
+	for _, o := range batch.Overrides {
+		if err := o.validate(categories, ""); err != nil {
+			rejected++
+			s.metrics.recordReplicationMerge("override", "rejected")
+			s.logger.Warn("Rejected replicated override", map[string]interface{}{
+				"event_type":    "replication_rejected",
+				"region":        batch.Region,
+				"error_message": err.Error(),
+			})
+			continue
+		}
+		if overrides.Merge(o) {
+			applied++
+			s.metrics.recordReplicationMerge("override", "applied")
+		} else {
+			stale++
+			s.metrics.recordReplicationMerge("override", "stale")
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"region":   s.config.Region,
+		"applied":  applied,
+		"stale":    stale,
+		"rejected": rejected,
+	})
+}
+
+// handleReplicateFeedback serves POST /internal/replication/feedback, merging a peer's
+// corrections. Applied corrections feed the vote, precision and stickiness trackers as local
+// ones do.
+func (s *Server) handleReplicateFeedback(c *gin.Context) {
+	if !s.replicationAuthorized(c) {
+		return
+	}
+	var batch FeedbackReplicationBatch
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Peers store the base category a correction maps onto, so it is checked against those
+	categories := s.knownCategories()
+	applied, stale, rejected := 0, 0, 0
+	for _, f := range batch.Feedback {
+		if err := f.validateReplicated(categories); err != nil {
+			rejected++
+			s.metrics.recordReplicationMerge("feedback", "rejected")
+			s.logger.Warn("Rejected replicated feedback", map[string]interface{}{
+				"event_type":    "replication_rejected",
+				"region":        batch.Region,
+				"error_message": err.Error(),
+			})
+			continue
+		}
+		if feedback.Merge(f) {
+			applied++
+			s.metrics.recordReplicationMerge("feedback", "applied")
+			s.observeFeedback(f)
+		} else {
+			stale++
+			s.metrics.recordReplicationMerge("feedback", "stale")
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"region":   s.config.Region,
+		"applied":  applied,
+		"stale":    stale,
+		"rejected": rejected,
+	})
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dateLayout    = "2006-01-02"
+	untaggedGroup = "untagged"
+)
+
+// ExpenseTransaction is a transaction submitted for an expense report
+type ExpenseTransaction struct {
+	ExportTransaction
+	Tags       []string `json:"tags"`
+	ReceiptRef string   `json:"receipt_ref"`
+}
+
+// ExpenseReportRequest is the body accepted by the expense report endpoint. Grouping by tag
+// files each line under its first tag, so group totals add up to the report's.
+type ExpenseReportRequest struct {
+	From         string               `json:"from" binding:"required"`
+	To           string               `json:"to" binding:"required"`
+	Tag          string               `json:"tag"`
+	GroupBy      string               `json:"group_by"`
+	Transactions []ExpenseTransaction `json:"transactions" binding:"required,dive"`
+}
+
+// ExpenseLine is a single categorized expense within a report
+type ExpenseLine struct {
+	Date         string   `json:"date"`
+	Merchant     string   `json:"merchant"`
+	Description  string   `json:"description,omitempty"`
+	Category     string   `json:"category"`
+	Tags         []string `json:"tags,omitempty"`
+	Amount       float64  `json:"amount"`
+	TaxTreatment string   `json:"tax_treatment"`
+	VATEstimate  float64  `json:"vat_estimate"`
+	ReceiptRef   string   `json:"receipt_ref,omitempty"`
+}
+
+// ExpenseGroup totals the expense lines sharing a category or tag
+type ExpenseGroup struct {
+	Name        string        `json:"name"`
+	Count       int           `json:"count"`
+	Total       float64       `json:"total"`
+	VATEstimate float64       `json:"vat_estimate"`
+	Lines       []ExpenseLine `json:"lines"`
+}
+
+// ExpenseReport is a per-period expense report
+type ExpenseReport struct {
+	From            string             `json:"from"`
+	To              string             `json:"to"`
+	GroupBy         string             `json:"group_by"`
+	Groups          []ExpenseGroup     `json:"groups"`
+	Count           int                `json:"count"`
+	Total           float64            `json:"total"`
+	VATEstimate     float64            `json:"vat_estimate"`
+	VATByTreatment  map[string]float64 `json:"vat_by_treatment"`
+	MissingReceipts int                `json:"missing_receipts"`
+}
+
+// roundPence rounds an amount to two decimal places
+func roundPence(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// truncate shortens text to at most n characters so it fits a fixed-width column
+func truncate(text string, n int) string {
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n])
+	}
+	return text
+}
+
+// includedVAT returns the VAT portion of a VAT-inclusive amount
+func includedVAT(amount, rate float64) float64 {
+	return amount * rate / (1 + rate)
+}
+
+// hasTag reports whether tags contains tag, ignoring case
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildExpenseReport categorizes the debits within the period and groups them
+func (s *Server) buildExpenseReport(req ExpenseReportRequest) (ExpenseReport, error) {
+	from, err := time.Parse(dateLayout, req.From)
+	if err != nil {
+		return ExpenseReport{}, fmt.Errorf("invalid from date: %w", err)
+	}
+	to, err := time.Parse(dateLayout, req.To)
+	if err != nil {
+		return ExpenseReport{}, fmt.Errorf("invalid to date: %w", err)
+	}
+	if to.Before(from) {
+		return ExpenseReport{}, fmt.Errorf("to date is before from date")
+	}
+
+	groupBy := strings.ToLower(req.GroupBy)
+	if groupBy == "" {
+		groupBy = "category"
+	}
+	if groupBy != "category" && groupBy != "tag" {
+		return ExpenseReport{}, fmt.Errorf("unsupported group_by %q", req.GroupBy)
+	}
+
+	report := ExpenseReport{From: req.From, To: req.To, GroupBy: groupBy, VATByTreatment: map[string]float64{}}
+	groups := map[string]*ExpenseGroup{}
+	addToGroup := func(name string, line ExpenseLine) {
+		group, ok := groups[name]
+		if !ok {
+			group = &ExpenseGroup{Name: name}
+			groups[name] = group
+		}
+		group.Count++
+		group.Total += line.Amount
+		group.VATEstimate += line.VATEstimate
+		group.Lines = append(group.Lines, line)
+	}
+
+	for _, tx := range req.Transactions {
+		if strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		if req.Tag != "" && !hasTag(tx.Tags, req.Tag) {
+			continue
+		}
+		date, err := time.Parse(dateLayout, tx.Date)
+		if err != nil {
+			return ExpenseReport{}, fmt.Errorf("invalid transaction date %q: %w", tx.Date, err)
+		}
+		if date.Before(from) || date.After(to) {
+			continue
+		}
+
+		category := s.categorizeTransaction(tx.Merchant, tx.Description, tx.Amount, tx.TransactionType)
+		tax := taxInfoFor(category, tx.Merchant, tx.Description, tx.Amount)
+		line := ExpenseLine{
+			Date:         tx.Date,
+			Merchant:     tx.Merchant,
+			Description:  tx.Description,
+			Category:     category,
+			Tags:         tx.Tags,
+			Amount:       tx.Amount,
+			TaxTreatment: tax.Treatment,
+			VATEstimate:  tax.VATEstimate,
+			ReceiptRef:   tx.ReceiptRef,
+		}
+
+		report.Count++
+		report.Total += line.Amount
+		report.VATEstimate += line.VATEstimate
+		report.VATByTreatment[line.TaxTreatment] += line.VATEstimate
+		if line.ReceiptRef == "" {
+			report.MissingReceipts++
+		}
+
+		if groupBy == "category" {
+			addToGroup(line.Category, line)
+			continue
+		}
+		if len(line.Tags) == 0 {
+			addToGroup(untaggedGroup, line)
+			continue
+		}
+		addToGroup(strings.ToLower(line.Tags[0]), line)
+	}
+
+	report.Total = roundPence(report.Total)
+	report.VATEstimate = roundPence(report.VATEstimate)
+	for treatment, vat := range report.VATByTreatment {
+		report.VATByTreatment[treatment] = roundPence(vat)
+	}
+	report.Groups = make([]ExpenseGroup, 0, len(groups))
+	for _, group := range groups {
+		group.Total = roundPence(group.Total)
+		group.VATEstimate = roundPence(group.VATEstimate)
+		report.Groups = append(report.Groups, *group)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Name < report.Groups[j].Name
+	})
+
+	return report, nil
+}
+
+// writeExpenseReportCSV writes one row per expense line, prefixed with its group
+func writeExpenseReportCSV(w *csv.Writer, report ExpenseReport) error {
+	header := []string{"Group", "Date", "Merchant", "Description", "Category", "Tags", "Amount", "Tax Treatment", "VAT Estimate", "Receipt"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, group := range report.Groups {
+		for _, line := range group.Lines {
+			record := []string{
+				csvCell(group.Name),
+				line.Date,
+				csvCell(line.Merchant),
+				csvCell(line.Description),
+				line.Category,
+				csvCell(strings.Join(line.Tags, ";")),
+				strconv.FormatFloat(line.Amount, 'f', 2, 64),
+				line.TaxTreatment,
+				strconv.FormatFloat(line.VATEstimate, 'f', 2, 64),
+				csvCell(line.ReceiptRef),
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expenseReportPDFRow lays out one row of the PDF report's line table
+const expenseReportPDFRow = "%-10s  %-24s  %-18s  %10s  %8s  %-12s  %-14s"
+
+// writeExpenseReportPDF renders the report as a printable document: the period's totals, then
+// each group's lines and subtotal
+func writeExpenseReportPDF(buf *bytes.Buffer, report ExpenseReport) {
+	money := func(amount float64) string { return strconv.FormatFloat(amount, 'f', 2, 64) }
+	lines := []string{
+		fmt.Sprintf("Expense report %s to %s", report.From, report.To),
+		"",
+		fmt.Sprintf("Expenses: %d  Total: %s  VAT estimate: %s  Missing receipts: %d",
+			report.Count, money(report.Total), money(report.VATEstimate), report.MissingReceipts),
+	}
+	for _, group := range report.Groups {
+		lines = append(lines,
+			"",
+			fmt.Sprintf("%s (%s)", group.Name, report.GroupBy),
+			fmt.Sprintf(expenseReportPDFRow, "Date", "Merchant", "Category", "Amount", "VAT", "Tax", "Receipt"),
+		)
+		for _, line := range group.Lines {
+			lines = append(lines, fmt.Sprintf(expenseReportPDFRow,
+				line.Date, truncate(line.Merchant, 24), truncate(line.Category, 18), money(line.Amount),
+				money(line.VATEstimate), truncate(line.TaxTreatment, 12), truncate(line.ReceiptRef, 14)))
+		}
+		lines = append(lines, fmt.Sprintf(expenseReportPDFRow,
+			"", fmt.Sprintf("Subtotal, %d lines", group.Count), "", money(group.Total), money(group.VATEstimate), "", ""))
+	}
+	writeTextPDF(buf, lines)
+}
+
+// handleExpenseReport serves POST /reports/expenses as JSON, or as a CSV or PDF attachment
+// with ?format=csv or ?format=pdf
+func (s *Server) handleExpenseReport(c *gin.Context) {
+	var req ExpenseReportRequest
+	err := c.ShouldBindJSON(&req)
+	for i := 0; err == nil && i < len(req.Transactions); i++ {
+		if err = s.normalizeTransaction(c, &req.Transactions[i].TransactionRequest); err != nil {
+			err = fmt.Errorf("transactions[%d]: %w", i, err)
+		}
+	}
+	if err != nil {
+		s.metrics.recordCategorizationError("bad_request")
+		s.requestLogger(c).logCategorizationError("bad_request", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := s.buildExpenseReport(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	switch strings.ToLower(c.Query("format")) {
+	case "csv":
+	case "pdf":
+		writeExpenseReportPDF(&buf, report)
+		filename := fmt.Sprintf("expenses-%s-%s.pdf", report.From, report.To)
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+		return
+	default:
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	w := csv.NewWriter(&buf)
+	if err := writeExpenseReportCSV(w, report); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("expenses-%s-%s.csv", report.From, report.To)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
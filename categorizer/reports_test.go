@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestExpenseReportTagGroups checks grouping by tag files each line once, under its first tag,
+// so the group totals add up to the report total
+func TestExpenseReportTagGroups(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry(), "test", nil)
+	s := NewServer(Config{}, NewStructuredLogger("test", nil), m, newMemoryStore(0), NewClassifier(defaultRuleSet(), nil, m))
+	s.classifier.SetPipeline(defaultPipeline(s))
+
+	expense := func(merchant string, amount float64, tags ...string) ExpenseTransaction {
+		tx := ExpenseTransaction{Tags: tags}
+		tx.Merchant, tx.Amount, tx.TransactionType, tx.Date = merchant, amount, "debit", "2026-03-02"
+		return tx
+	}
+	report, err := s.buildExpenseReport(ExpenseReportRequest{
+		From:    "2026-03-01",
+		To:      "2026-03-31",
+		GroupBy: "tag",
+		Transactions: []ExpenseTransaction{
+			expense("Trainline", 40, "Travel", "Client A"),
+			expense("Pret", 10, "client a"),
+			expense("Tesco", 5),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totals := map[string]float64{}
+	sum := 0.0
+	for _, group := range report.Groups {
+		totals[group.Name] = group.Total
+		sum += group.Total
+	}
+	if want := map[string]float64{"travel": 40, "client a": 10, untaggedGroup: 5}; len(totals) != len(want) ||
+		totals["travel"] != 40 || totals["client a"] != 10 || totals[untaggedGroup] != 5 {
+		t.Errorf("group totals %v, want %v", totals, want)
+	}
+	if sum != report.Total {
+		t.Errorf("group totals add up to %.2f, want the report total %.2f", sum, report.Total)
+	}
+
+	var buf bytes.Buffer
+	writeExpenseReportPDF(&buf, report)
+	if pdf := buf.String(); !strings.HasPrefix(pdf, "%PDF-") || !strings.Contains(pdf, "Trainline") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Errorf("PDF report is missing its header, a line or its trailer:\n%s", pdf)
+	}
+}
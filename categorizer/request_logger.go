@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries a request's ID. A well-formed ID sent by the caller is kept, so
+// logs can be correlated across services; otherwise one is generated. Either way it is
+// echoed on the response.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID bounds the request IDs accepted from callers
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// loggerKey is the context key holding a request's logger
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying logger
+func ContextWithLogger(ctx context.Context, logger *StructuredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger carried by ctx, or the server's logger when there is none
+func (s *Server) LoggerFromContext(ctx context.Context) *StructuredLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(*StructuredLogger); ok {
+		return logger
+	}
+	return s.logger
+}
+
+// requestLogger returns the logger for a request, carrying its request-scoped fields
+func (s *Server) requestLogger(c *gin.Context) *StructuredLogger {
+	return s.LoggerFromContext(c.Request.Context())
+}
+
+// addLogFields adds fields to every entry later logged for a request, e.g. its tenant once
+// the body has been read
+func (s *Server) addLogFields(c *gin.Context, fields map[string]interface{}) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(ContextWithLogger(ctx, s.LoggerFromContext(ctx).With(fields)))
+}
+
+// RequestLogger gives each request an ID and stores a logger in its context carrying the
+// request ID, the matched route and, when the path or query names one, the tenant
+func RequestLogger(base *StructuredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newID("req_")
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		fields := map[string]interface{}{"request_id": requestID}
+		if route := c.FullPath(); route != "" {
+			fields["route"] = route
+		}
+		if tenantID := c.Param("tenant_id"); tenantID != "" {
+			fields["tenant_id"] = tenantID
+		} else if tenantID := c.Query("tenant_id"); tenantID != "" {
+			fields["tenant_id"] = tenantID
+		}
+		c.Request = c.Request.WithContext(ContextWithLogger(c.Request.Context(), base.With(fields)))
+		c.Next()
+	}
+}
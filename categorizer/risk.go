@@ -0,0 +1,81 @@
+package main
+
+// Risk flags
+const (
+	RiskGambling = "gambling"
+	RiskCrypto   = "crypto_exchange"
+	RiskBNPL     = "bnpl"
+)
+
+// EventTransactionRiskFlagged is emitted when alerts are enabled and a transaction is flagged
+const EventTransactionRiskFlagged = "transaction.risk_flagged"
+
+// defaultRiskRules is the high-risk merchant rule pack. Each rule's category is the flag it raises.
+func defaultRiskRules() *RuleSet {
+	rs := &RuleSet{
+		Version: "builtin-risk",
+		Rules: []Rule{
+			{Name: "gambling", Category: RiskGambling, Keywords: []string{
+				"bet365", "betfair", "william hill", "paddy power", "ladbrokes", "coral", "sky bet", "skybet",
+				"betway", "betfred", "pokerstars", "casino", "bingo", "betting", "bookmaker", "lottery", "lotto",
+			}},
+			{Name: "crypto_exchange", Category: RiskCrypto, Keywords: []string{
+				"coinbase", "binance", "kraken", "crypto.com", "bitstamp", "gemini", "bitcoin", "blockchain.com", "uphold",
+			}},
+			{Name: "bnpl", Category: RiskBNPL, Keywords: []string{
+				"klarna", "clearpay", "afterpay", "laybuy", "zilch", "paypal pay in 3", "affirm",
+			}},
+		},
+	}
+	for i := range rs.Rules {
+		rs.Rules[i].normalize()
+	}
+	return rs
+}
+
+// Active high-risk merchant rules
+var riskRules = defaultRiskRules()
+
+// riskFlagsFor returns every risk flag raised by a transaction's merchant or description
+func riskFlagsFor(merchant, description string, amount float64) []string {
+	merchantNormalized := resolveMerchant(normalizeDescriptor(merchant))
+	descriptionNormalized := normalizeDescriptor(description)
+
+	var flags []string
+	for _, hit := range riskRules.MatchAll(merchantNormalized, descriptionNormalized, amount) {
+		flag := hit.Rule.Category
+		if !containsString(flags, flag) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// flagRiskyTransaction records risk flag metrics and, when alerts are enabled, emits an alert event
+func (s *Server) flagRiskyTransaction(req TransactionRequest, category string, flags []string) {
+	for _, flag := range flags {
+		s.metrics.recordRiskFlag(flag)
+	}
+	if !s.config.RiskAlerts {
+		return
+	}
+	s.emitEvent(EventTransactionRiskFlagged, req.TenantID, map[string]interface{}{
+		"user_id":        req.UserID,
+		"transaction_id": req.TransactionID,
+		"merchant":       req.Merchant,
+		"amount":         req.Amount,
+		"category":       category,
+		"risk_flags":     flags,
+		"status":         req.Status,
+	})
+}
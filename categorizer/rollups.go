@@ -0,0 +1,535 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DailyRollup totals one user's transactions in one category on one UTC day
+type DailyRollup struct {
+	Day        string  `json:"day"`
+	Category   string  `json:"category"`
+	Debits     int     `json:"debits"`
+	Spending   float64 `json:"spending"`
+	Credits    int     `json:"credits"`
+	Income     float64 `json:"income"`
+	Duplicates int     `json:"duplicates"`
+	Declined   int     `json:"declined"`
+
+	// Merchants breaks Debits and Spending down by normalized merchant
+	Merchants map[string]*MerchantRollup `json:"merchants,omitempty"`
+	// Providers breaks down the Debits paid in installments through buy-now-pay-later providers
+	Providers map[string]*MerchantRollup `json:"bnpl_providers,omitempty"`
+}
+
+// MerchantRollup totals one merchant's debits within a daily rollup row
+type MerchantRollup struct {
+	Debits   int     `json:"debits"`
+	Spending float64 `json:"spending"`
+}
+
+// matches reports whether two rollups agree to the penny
+func (r DailyRollup) matches(other DailyRollup) bool {
+	return r.Debits == other.Debits && r.Credits == other.Credits &&
+		r.Duplicates == other.Duplicates && r.Declined == other.Declined &&
+		math.Abs(r.Spending-other.Spending) < 0.005 && math.Abs(r.Income-other.Income) < 0.005 &&
+		merchantRollupsMatch(r.Merchants, other.Merchants) && merchantRollupsMatch(r.Providers, other.Providers)
+}
+
+// merchantRollupsMatch reports whether two merchant or provider breakdowns agree to the penny
+func merchantRollupsMatch(a, b map[string]*MerchantRollup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for merchant, x := range a {
+		y, ok := b[merchant]
+		if !ok || x.Debits != y.Debits || math.Abs(x.Spending-y.Spending) >= 0.005 {
+			return false
+		}
+	}
+	return true
+}
+
+// dayRollups maps day and then category to a rollup row
+type dayRollups map[string]map[string]*DailyRollup
+
+// rollupTransactions builds daily rollups from raw transactions, counting them the way
+// buildSummary does
+func rollupTransactions(transactions []StoredTransaction) dayRollups {
+	days := dayRollups{}
+	for _, tx := range transactions {
+		day := tx.CreatedAt.UTC().Format(dateLayout)
+		if days[day] == nil {
+			days[day] = map[string]*DailyRollup{}
+		}
+		row := days[day][tx.Category]
+		if row == nil {
+			row = &DailyRollup{Day: day, Category: tx.Category}
+			days[day][tx.Category] = row
+		}
+		switch {
+		case tx.Duplicate:
+			row.Duplicates++
+		case tx.Status == StatusDeclined:
+			row.Declined++
+		case strings.ToLower(tx.TransactionType) == "credit":
+			row.Credits++
+			row.Income += tx.Amount
+		default:
+			row.Debits++
+			row.Spending += tx.Amount
+			if row.Merchants == nil {
+				row.Merchants = map[string]*MerchantRollup{}
+			}
+			merchant := resolveMerchant(normalizeDescriptor(tx.Merchant))
+			if row.Merchants[merchant] == nil {
+				row.Merchants[merchant] = &MerchantRollup{}
+			}
+			row.Merchants[merchant].Debits++
+			row.Merchants[merchant].Spending += tx.Amount
+			if tx.BNPLProvider != "" {
+				if row.Providers == nil {
+					row.Providers = map[string]*MerchantRollup{}
+				}
+				if row.Providers[tx.BNPLProvider] == nil {
+					row.Providers[tx.BNPLProvider] = &MerchantRollup{}
+				}
+				row.Providers[tx.BNPLProvider].Debits++
+				row.Providers[tx.BNPLProvider].Spending += tx.Amount
+			}
+		}
+	}
+	return days
+}
+
+// dayBounds returns the start of the day containing t and the following day
+func dayBounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// isDayBoundary reports whether t is zero or midnight UTC, so a range edge falls between rollup rows
+func isDayBoundary(t time.Time) bool {
+	if t.IsZero() {
+		return true
+	}
+	start, _ := dayBounds(t)
+	return t.Equal(start)
+}
+
+// rollupStore keeps per-user daily rollups. Writes mark the user's day dirty and the
+// background job recomputes dirty days; reads fall back to raw history for a user with
+// dirty days, so rollups never serve stale totals. A day stays dirty until its recomputed
+// rows are swapped in, and a day written to again while it was being recomputed stays
+// dirty for the next refresh.
+type rollupStore struct {
+	mu    sync.RWMutex
+	users map[string]dayRollups
+	// dirty holds the generation each dirty day was last marked at
+	dirty      map[string]map[string]uint64
+	generation uint64
+	// backfillPending is set when all history was replaced and rollups must be rebuilt;
+	// resetAt is the generation it was last set at
+	backfillPending bool
+	resetAt         uint64
+}
+
+// newRollupStore creates a rollup store that needs a backfill before serving reads
+func newRollupStore() *rollupStore {
+	return &rollupStore{
+		users:           map[string]dayRollups{},
+		dirty:           map[string]map[string]uint64{},
+		backfillPending: true,
+	}
+}
+
+// MarkDirty flags the day containing t for recomputation
+func (s *rollupStore) MarkDirty(userID string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty[userID] == nil {
+		s.dirty[userID] = map[string]uint64{}
+	}
+	s.generation++
+	s.dirty[userID][t.UTC().Format(dateLayout)] = s.generation
+}
+
+// clean clears the dirty days recomputed as of a generation, leaving those marked since.
+// Callers must hold the lock.
+func (s *rollupStore) clean(userID, day string, generation uint64) {
+	if marked, ok := s.dirty[userID][day]; ok && marked <= generation {
+		delete(s.dirty[userID], day)
+		if len(s.dirty[userID]) == 0 {
+			delete(s.dirty, userID)
+		}
+	}
+}
+
+// Reset drops every rollup until the next backfill
+func (s *rollupStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = map[string]dayRollups{}
+	s.dirty = map[string]map[string]uint64{}
+	s.generation++
+	s.backfillPending, s.resetAt = true, s.generation
+}
+
+// Refresh recomputes dirty days from history, or every day if a backfill is pending. The
+// recomputed days are swapped in together, and only then stop being dirty.
+func (s *rollupStore) Refresh(history Store) {
+	s.mu.Lock()
+	if s.backfillPending {
+		s.mu.Unlock()
+		s.Backfill(history)
+		return
+	}
+	generation := s.generation
+	dirty := make(map[string][]string, len(s.dirty))
+	for userID, days := range s.dirty {
+		for day := range days {
+			dirty[userID] = append(dirty[userID], day)
+		}
+	}
+	s.mu.Unlock()
+
+	recomputed := make(map[string]dayRollups, len(dirty))
+	for userID, days := range dirty {
+		recomputed[userID] = dayRollups{}
+		for _, day := range days {
+			start, _ := time.Parse(dateLayout, day)
+			recomputed[userID][day] = rollupTransactions(history.ListTransactions(userID, start, start.AddDate(0, 0, 1)))[day]
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backfillPending {
+		// history was replaced while recomputing; the backfill rebuilds everything
+		return
+	}
+	for userID, days := range recomputed {
+		if s.users[userID] == nil {
+			s.users[userID] = dayRollups{}
+		}
+		for day, rows := range days {
+			if len(rows) == 0 {
+				delete(s.users[userID], day)
+			} else {
+				s.users[userID][day] = rows
+			}
+			s.clean(userID, day, generation)
+		}
+	}
+}
+
+// Backfill rebuilds every user's rollups from raw history, returning the users and days
+// covered. Reads keep falling back to raw history until the rebuilt rollups are swapped in.
+func (s *rollupStore) Backfill(history Store) (int, int) {
+	s.mu.RLock()
+	generation := s.generation
+	s.mu.RUnlock()
+
+	rebuilt := map[string]dayRollups{}
+	days := 0
+	for _, userID := range history.Users() {
+		rebuilt[userID] = rollupTransactions(history.ListTransactions(userID, time.Time{}, time.Time{}))
+		days += len(rebuilt[userID])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = rebuilt
+	for userID, dirtyDays := range s.dirty {
+		for day := range dirtyDays {
+			s.clean(userID, day, generation)
+		}
+	}
+	if s.resetAt <= generation {
+		s.backfillPending = false
+	}
+	return len(rebuilt), days
+}
+
+// Stats reports how many users and days have rollups and how many days await recomputation
+func (s *rollupStore) Stats() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	days, dirty := 0, 0
+	for _, userDays := range s.users {
+		days += len(userDays)
+	}
+	for _, userDays := range s.dirty {
+		dirty += len(userDays)
+	}
+	return map[string]interface{}{
+		"users":            len(s.users),
+		"days":             days,
+		"dirty_days":       dirty,
+		"backfill_pending": s.backfillPending,
+	}
+}
+
+// rows returns a copy of a user's rollups within [from, to) if none of them are dirty
+func (s *rollupStore) rows(userID string, from, to time.Time) ([]DailyRollup, bool) {
+	if !isDayBoundary(from) || !isDayBoundary(to) {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.backfillPending || len(s.dirty[userID]) > 0 {
+		return nil, false
+	}
+
+	var rows []DailyRollup
+	for day, categories := range s.users[userID] {
+		t, _ := time.Parse(dateLayout, day)
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+			continue
+		}
+		for _, row := range categories {
+			rows = append(rows, *row)
+		}
+	}
+	return rows, true
+}
+
+// Summary builds a user's summary over [from, to) from rollups. ok is false when the range
+// doesn't fall on day boundaries or the user's rollups are being recomputed.
+func (s *rollupStore) Summary(userID string, from, to time.Time) (Summary, bool) {
+	rows, ok := s.rows(userID, from, to)
+	if !ok {
+		return Summary{}, false
+	}
+
+	summary := Summary{UserID: userID}
+	categories := map[string]*CategorySummary{}
+	for _, row := range rows {
+		summary.Transactions += row.Debits + row.Credits
+		summary.Income += row.Income
+		summary.Spending += row.Spending
+		summary.DuplicatesExcluded += row.Duplicates
+		summary.DeclinedExcluded += row.Declined
+		if row.Debits == 0 {
+			continue
+		}
+		category, ok := categories[row.Category]
+		if !ok {
+			category = &CategorySummary{Category: row.Category}
+			categories[row.Category] = category
+		}
+		category.Count += row.Debits
+		category.Total += row.Spending
+		for provider, via := range row.Providers {
+			category.addVia(provider, via.Debits, via.Spending)
+		}
+	}
+
+	summary.Income = roundPence(summary.Income)
+	summary.Spending = roundPence(summary.Spending)
+	summary.Categories = make([]CategorySummary, 0, len(categories))
+	for _, category := range categories {
+		category.roundTotals()
+		summary.Categories = append(summary.Categories, *category)
+	}
+	sort.Slice(summary.Categories, func(i, j int) bool {
+		return summary.Categories[i].Total > summary.Categories[j].Total
+	})
+	return summary, true
+}
+
+// MonthlySpending totals a user's debits per month and category over [from, to) from
+// rollups, in the shape monthlySpending returns
+func (s *rollupStore) MonthlySpending(userID string, from, to time.Time) (map[string]map[string]float64, bool) {
+	rows, ok := s.rows(userID, from, to)
+	if !ok {
+		return nil, false
+	}
+	months := map[string]map[string]float64{}
+	for _, row := range rows {
+		if row.Debits == 0 {
+			continue
+		}
+		month := row.Day[:len(monthLayout)]
+		if months[month] == nil {
+			months[month] = map[string]float64{}
+		}
+		months[month][row.Category] += row.Spending
+	}
+	return months, true
+}
+
+// MerchantSpending totals a user's debits per merchant over [from, to) from rollups,
+// optionally within one category, in the shape merchantSpending returns
+func (s *rollupStore) MerchantSpending(userID, category string, from, to time.Time) (map[string]*MerchantRollup, bool) {
+	rows, ok := s.rows(userID, from, to)
+	if !ok {
+		return nil, false
+	}
+	merchants := map[string]*MerchantRollup{}
+	for _, row := range rows {
+		if category != "" && row.Category != category {
+			continue
+		}
+		for merchant, m := range row.Merchants {
+			if merchants[merchant] == nil {
+				merchants[merchant] = &MerchantRollup{}
+			}
+			merchants[merchant].Debits += m.Debits
+			merchants[merchant].Spending += m.Spending
+		}
+	}
+	return merchants, true
+}
+
+// RollupMismatch is a rollup row that disagrees with raw history
+type RollupMismatch struct {
+	UserID   string       `json:"user_id"`
+	Day      string       `json:"day"`
+	Category string       `json:"category"`
+	Expected *DailyRollup `json:"expected,omitempty"`
+	Actual   *DailyRollup `json:"actual,omitempty"`
+}
+
+// RollupCheck reports the result of comparing rollups with raw history
+type RollupCheck struct {
+	Users       int              `json:"users"`
+	Days        int              `json:"days"`
+	SkippedDays int              `json:"skipped_days"`
+	Mismatches  []RollupMismatch `json:"mismatches"`
+}
+
+// Check recomputes every user's rollups from raw history and compares them with the stored
+// ones. Days waiting to be recomputed are skipped.
+func (s *rollupStore) Check(history Store) RollupCheck {
+	check := RollupCheck{Mismatches: []RollupMismatch{}}
+	for _, userID := range history.Users() {
+		expected := rollupTransactions(history.ListTransactions(userID, time.Time{}, time.Time{}))
+		check.Users++
+
+		s.mu.RLock()
+		actual := s.users[userID]
+		days := map[string]bool{}
+		for day := range expected {
+			days[day] = true
+		}
+		for day := range actual {
+			days[day] = true
+		}
+		for day := range days {
+			if s.backfillPending || s.dirty[userID][day] > 0 {
+				check.SkippedDays++
+				continue
+			}
+			check.Days++
+			categories := map[string]bool{}
+			for category := range expected[day] {
+				categories[category] = true
+			}
+			for category := range actual[day] {
+				categories[category] = true
+			}
+			for category := range categories {
+				want, got := expected[day][category], actual[day][category]
+				if want != nil && got != nil && want.matches(*got) {
+					continue
+				}
+				mismatch := RollupMismatch{UserID: userID, Day: day, Category: category, Expected: want}
+				if got != nil {
+					copied := *got
+					mismatch.Actual = &copied
+				}
+				check.Mismatches = append(check.Mismatches, mismatch)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	sort.Slice(check.Mismatches, func(i, j int) bool {
+		a, b := check.Mismatches[i], check.Mismatches[j]
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		return a.Category < b.Category
+	})
+	return check
+}
+
+// Global daily rollups
+var rollups = newRollupStore()
+
+// loadSummary builds a summary from rollups where it can and from raw history otherwise
+func (s *Server) loadSummary(userID string, from, to time.Time) Summary {
+	if summary, ok := rollups.Summary(userID, from, to); ok {
+		s.metrics.recordRollupRead("rollup")
+		applyCashAllocations(&summary, cash.InRange(userID, from, to))
+		return summary
+	}
+	s.metrics.recordRollupRead("scan")
+	summary := buildSummary(userID, s.store.ListTransactions(userID, from, to))
+	applyCashAllocations(&summary, cash.InRange(userID, from, to))
+	return summary
+}
+
+// loadMonthlySpending totals monthly spending from rollups where it can and from raw history otherwise
+func (s *Server) loadMonthlySpending(userID string, from, to time.Time) map[string]map[string]float64 {
+	if months, ok := rollups.MonthlySpending(userID, from, to); ok {
+		s.metrics.recordRollupRead("rollup")
+		applyCashAllocationsMonthly(months, cash.InRange(userID, from, to))
+		return months
+	}
+	s.metrics.recordRollupRead("scan")
+	months := monthlySpending(s.store.ListTransactions(userID, from, to))
+	applyCashAllocationsMonthly(months, cash.InRange(userID, from, to))
+	return months
+}
+
+// loadMerchantSpending totals a user's spending per merchant from rollups where it can and
+// from raw history otherwise
+func (s *Server) loadMerchantSpending(userID, category string, from, to time.Time) map[string]*MerchantRollup {
+	if merchants, ok := rollups.MerchantSpending(userID, category, from, to); ok {
+		s.metrics.recordRollupRead("rollup")
+		return merchants
+	}
+	s.metrics.recordRollupRead("scan")
+	return merchantSpending(s.store.ListTransactions(userID, from, to), category)
+}
+
+// startRollupJob recomputes dirty rollups every interval, backfilling on start
+func (s *Server) startRollupJob(interval time.Duration) {
+	go func() {
+		rollups.Refresh(s.store)
+		for range time.Tick(interval) {
+			rollups.Refresh(s.store)
+		}
+	}()
+}
+
+// handleRollupBackfill serves POST /admin/rollups/backfill
+func (s *Server) handleRollupBackfill(c *gin.Context) {
+	users, days := rollups.Backfill(s.store)
+	s.requestLogger(c).Info("Rollups backfilled", map[string]interface{}{
+		"event_type": "rollups_backfilled",
+	})
+	c.JSON(http.StatusOK, gin.H{"users": users, "days": days})
+}
+
+// handleRollupCheck serves GET /admin/rollups/check, answering 409 when rollups disagree
+// with raw history
+func (s *Server) handleRollupCheck(c *gin.Context) {
+	check := rollups.Check(s.store)
+	status := http.StatusOK
+	if len(check.Mismatches) > 0 {
+		status = http.StatusConflict
+	}
+	c.JSON(status, check)
+}
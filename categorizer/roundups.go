@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roundUpFor returns the change from rounding a debit up to the next pound, scaled by the
+// configured multiplier. Credits and whole-pound debits round up nothing.
+func (s *Server) roundUpFor(amount float64, transactionType string) float64 {
+	if strings.ToLower(transactionType) == "credit" || amount <= 0 {
+		return 0
+	}
+	pence := int64(math.Round(amount * 100))
+	change := (100 - pence%100) % 100
+	return roundPence(float64(change) / 100 * s.config.RoundUpMultiplier)
+}
+
+// RoundUpSummary is a user's running round-up total
+type RoundUpSummary struct {
+	UserID       string  `json:"user_id"`
+	Multiplier   float64 `json:"multiplier"`
+	Transactions int     `json:"transactions"`
+	Total        float64 `json:"total"`
+	PotID        string  `json:"pot_id,omitempty"`
+}
+
+// buildRoundUpSummary totals round-ups over settled debits, skipping duplicates
+func (s *Server) buildRoundUpSummary(userID string, transactions []StoredTransaction) RoundUpSummary {
+	summary := RoundUpSummary{
+		UserID:     userID,
+		Multiplier: s.config.RoundUpMultiplier,
+		PotID:      s.config.RoundUpPotID,
+	}
+	for _, tx := range transactions {
+		if tx.Duplicate || tx.Status != StatusSettled {
+			continue
+		}
+		if roundUp := s.roundUpFor(tx.Amount, tx.TransactionType); roundUp > 0 {
+			summary.Transactions++
+			summary.Total += roundUp
+		}
+	}
+	summary.Total = roundPence(summary.Total)
+	return summary
+}
+
+// PotDepositor moves money into a savings pot
+type PotDepositor interface {
+	DepositToPot(potID, dedupeID string, amount float64) error
+}
+
+// potDepositor receives round-up deposits; nil unless ROUNDUP_POT_ID is set
+var potDepositor PotDepositor
+
+// roundUpDedupeID identifies a payment's round-up so a resubmitted payment deposits once. It
+// comes from the caller's transaction ID or dedupe hash, which are stable across
+// submissions; the stored ID is assigned per submission, so without either the payment
+// can't be told apart from a new one and gets no deposit.
+func roundUpDedupeID(tx StoredTransaction) (string, bool) {
+	switch {
+	case tx.TransactionID != "":
+		return "roundup_" + tx.UserID + "_" + tx.TransactionID, true
+	case tx.DedupeHash != "":
+		return "roundup_" + tx.UserID + "_" + tx.DedupeHash, true
+	}
+	return "", false
+}
+
+// depositRoundUp moves a settled debit's round-up into the configured pot in the background
+func (s *Server) depositRoundUp(tx StoredTransaction) {
+	if potDepositor == nil || tx.Duplicate || tx.Status != StatusSettled {
+		return
+	}
+	roundUp := s.roundUpFor(tx.Amount, tx.TransactionType)
+	if roundUp <= 0 {
+		return
+	}
+
+	dedupeID, ok := roundUpDedupeID(tx)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := potDepositor.DepositToPot(s.config.RoundUpPotID, dedupeID, roundUp); err != nil {
+			s.metrics.recordRoundUpDeposit("error")
+			s.logger.Warn("Round-up deposit failed", map[string]interface{}{
+				"merchant":      tx.Merchant,
+				"amount":        roundUp,
+				"error_message": err.Error(),
+				"event_type":    "roundup_deposit_failed",
+			})
+			return
+		}
+		s.metrics.recordRoundUpDeposit("success")
+	}()
+}
+
+func (s *Server) handleRoundUps(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, s.buildRoundUpSummary(userID, s.store.ListTransactions(userID, from, to)))
+}
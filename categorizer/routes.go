@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route groups. Every endpoint is registered on one of these, each with its own middleware
+// chain, so the group a route is added to decides how it is protected:
+//
+//   - public: probes, metrics and build info, open to anyone
+//   - api: user-facing endpoints behind CORS, rate limiting, a body size limit and API keys
+//   - internal: service-to-service endpoints, restricted to private networks
+//   - admin: operator endpoints behind admin API keys
+func (s *Server) registerRoutes(r *gin.Engine) {
+	public := r.Group("")
+	api := r.Group("", s.apiMiddleware()...)
+	internal := r.Group("/internal", internalOnly())
+	admin := r.Group("/admin", s.adminMiddleware()...)
+
+	// Preflight requests for any API route
+	api.OPTIONS("/*path", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	// Prometheus metrics endpoint
+	public.GET("/metrics", metricsHandler(s.metrics))
+
+	// Build and version details
+	public.GET("/version", s.handleVersion)
+
+	// Health check
+	public.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "healthy",
+			"service": "categorizer",
+			"version": version,
+		})
+	})
+
+	// Dependency readiness and its recent history
+	public.GET("/healthz/ready", s.handleReady)
+	public.GET("/healthz/history", s.handleHealthHistory)
+
+	// Categorization endpoint
+	api.POST("/categorize", s.handleCategorize)
+	api.POST("/categorize/batch", s.handleCategorizeBatch)
+	api.POST("/categorize/explain", s.handleExplain)
+	api.GET("/taxonomies", s.handleListTaxonomies)
+
+	// Accounting exports
+	api.POST("/export/accounting", s.handleAccountingExport)
+	api.GET("/export/monzo", s.handleMonzoExport)
+
+	// Connected Monzo accounts. Monzo returns users to the callback without an API key, so
+	// it is public and authenticated by the state it issued.
+	api.GET("/connect/monzo", s.handleConnectMonzo)
+	public.GET("/connect/monzo/callback", s.handleMonzoCallback)
+	api.GET("/connections", s.handleListConnections)
+	api.DELETE("/connections/:id", s.handleDeleteConnection)
+
+	// Expense reports
+	api.POST("/reports/expenses", s.handleExpenseReport)
+
+	// Transaction history
+	api.GET("/history", s.handleHistory)
+	api.GET("/history/search", s.handleHistorySearch)
+	api.GET("/summary", s.handleSummary)
+	api.GET("/summary/gift-aid", s.handleGiftAidSummary)
+	api.GET("/summary/carbon", s.handleCarbonSummary)
+	api.GET("/summary/card", s.handleSummaryCard)
+	api.GET("/roundups", s.handleRoundUps)
+	api.GET("/cashback/missed", s.handleMissedCashback)
+	api.GET("/cashback/offers", s.handleListCashbackOffers)
+
+	// Self-exclusion blocks
+	api.POST("/users/:user_id/blocks", s.handleCreateBlock)
+	api.GET("/users/:user_id/blocks", s.handleListBlocks)
+	api.DELETE("/users/:user_id/blocks/:id", s.handleDeleteBlock)
+
+	// External references on transactions
+	api.POST("/users/:user_id/transactions/:id/references", s.handleAddReference)
+	api.GET("/users/:user_id/transactions/:id/references", s.handleListReferences)
+	api.DELETE("/users/:user_id/transactions/:id/references/:ref_id", s.handleDeleteReference)
+
+	// Itemized receipts, splitting transactions across categories
+	api.PUT("/users/:user_id/transactions/:id/receipt", s.handlePutReceipt)
+	api.GET("/users/:user_id/transactions/:id/receipt", s.handleGetReceipt)
+	api.DELETE("/users/:user_id/transactions/:id/receipt", s.handleDeleteReceipt)
+
+	// Saved views
+	api.POST("/users/:user_id/views", s.handleCreateView)
+	api.GET("/users/:user_id/views", s.handleListViews)
+	api.GET("/users/:user_id/views/:id", s.handleGetView)
+	api.DELETE("/users/:user_id/views/:id", s.handleDeleteView)
+
+	// Notification rules and feed
+	api.POST("/users/:user_id/notification-rules", s.handleCreateNotificationRule)
+	api.GET("/users/:user_id/notification-rules", s.handleListNotificationRules)
+	api.DELETE("/users/:user_id/notification-rules/:id", s.handleDeleteNotificationRule)
+	api.GET("/users/:user_id/feed", s.handleFeed)
+
+	// Category overrides
+	api.POST("/users/:user_id/overrides", s.handleCreateUserOverride)
+	api.GET("/users/:user_id/overrides", s.handleListUserOverrides)
+	api.DELETE("/users/:user_id/overrides", s.handleDeleteUserOverride)
+
+	// Merchants pinned by consistent feedback
+	api.GET("/users/:user_id/sticky-merchants", s.handleListUserStickyMerchants)
+	api.DELETE("/users/:user_id/sticky-merchants", s.handleUnpinUserMerchant)
+
+	// Tenant merchant policies
+	api.PUT("/tenants/:tenant_id/merchant-policies", s.handlePutMerchantPolicy)
+	api.GET("/tenants/:tenant_id/merchant-policies", s.handleListMerchantPolicies)
+	api.DELETE("/tenants/:tenant_id/merchant-policies", s.handleDeleteMerchantPolicy)
+	api.PUT("/tenants/:tenant_id/confidence-threshold", s.handlePutConfidenceThreshold)
+	api.GET("/tenants/:tenant_id/confidence-threshold", s.handleGetConfidenceThreshold)
+	api.DELETE("/tenants/:tenant_id/confidence-threshold", s.handleDeleteConfidenceThreshold)
+	api.PUT("/tenants/:tenant_id/taxonomy", s.handlePutTenantCategory)
+	api.GET("/tenants/:tenant_id/taxonomy", s.handleGetTenantTaxonomy)
+	api.DELETE("/tenants/:tenant_id/taxonomy", s.handleDeleteTenantCategory)
+
+	// Email digests
+	api.PUT("/users/:user_id/digest", s.handleSetDigest)
+	api.GET("/users/:user_id/digest", s.handleGetDigest)
+	api.DELETE("/users/:user_id/digest", s.handleDeleteDigest)
+	api.GET("/users/:user_id/digest/preview", s.handleDigestPreview)
+
+	// Webhook subscriptions and the events they receive
+	api.GET("/events", s.handleListEvents)
+	api.GET("/events/schema", s.handleEventSchema)
+	api.POST("/subscriptions", s.handleCreateWebhookSubscription)
+	api.GET("/subscriptions", s.handleListWebhookSubscriptions)
+	api.DELETE("/subscriptions/:id", s.handleDeleteWebhookSubscription)
+	api.POST("/subscriptions/:id/test", s.handleTestWebhookSubscription)
+
+	// Cash wallet
+	api.POST("/cash-allocations", s.handleCreateCashAllocation)
+	api.DELETE("/cash-allocations/:id", s.handleDeleteCashAllocation)
+	api.GET("/users/:user_id/cash-wallet", s.handleCashWallet)
+
+	// Loan and credit card repayments
+	api.POST("/loan-terms", s.handleCreateLoanTerms)
+	api.DELETE("/loan-terms/:id", s.handleDeleteLoanTerms)
+	api.GET("/users/:user_id/loans", s.handleLoans)
+
+	// Spending analytics
+	api.GET("/analytics/trends", s.handleTrends)
+	api.GET("/analytics/top-merchants", s.handleTopMerchants)
+	api.GET("/analytics/top-categories", s.handleTopCategories)
+	api.PUT("/users/:user_id/analytics-consent", s.handleAnalyticsConsent)
+
+	// Merchant statistics
+	api.GET("/merchants/:name/stats", s.handleMerchantStats)
+
+	// Decline monitoring
+	api.GET("/stats/declines", s.handleDeclineStats)
+	api.GET("/stats/categories", s.handleCategoryStats)
+
+	// Category corrections collected by integrators
+	api.POST("/feedback", s.handleFeedback)
+	api.POST("/feedback/batch", s.handleFeedbackBatch)
+	api.GET("/feedback", s.handleListFeedback)
+
+	// Internal anonymized aggregates and replication
+	internal.GET("/aggregates", s.handleAggregates)
+	internal.POST("/aggregates/run", s.handleRunAggregation)
+	internal.POST("/replication/overrides", s.handleReplicateOverrides)
+	internal.POST("/replication/feedback", s.handleReplicateFeedback)
+
+	// Admin endpoints
+	admin.POST("/seed", s.handleSeed)
+	admin.POST("/rules/test", s.handleRuleTest)
+	admin.POST("/rules/reload", s.handleReloadRules)
+	admin.GET("/rules/conflicts", s.handleRuleConflicts)
+	admin.POST("/model/train", s.handleTrainModel)
+	admin.GET("/rules/precision", s.handleRulePrecision)
+	admin.GET("/rules/versions", s.handleListRuleSetVersions)
+	admin.POST("/rules/changesets", s.handleCreateChangeset)
+	admin.GET("/rules/changesets", s.handleListChangesets)
+	admin.GET("/rules/changesets/:id", s.handleGetChangeset)
+	admin.POST("/rules/changesets/:id/comments", s.handleCommentChangeset)
+	admin.POST("/rules/changesets/:id/approve", s.handleApproveChangeset)
+	admin.POST("/rules/changesets/:id/reject", s.handleRejectChangeset)
+	admin.POST("/rules/changesets/:id/cancel", s.handleCancelChangeset)
+	admin.GET("/audit", s.handleListAudit)
+	admin.GET("/events", s.handleListAllEvents)
+	admin.GET("/calls", s.handleListAdminCalls)
+	admin.GET("/calls/verify", s.handleVerifyAdminCalls)
+	admin.POST("/transactions/bulk", s.handleBulkTransactions)
+	admin.GET("/jobs", s.handleListJobs)
+	admin.GET("/jobs/:id", s.handleGetJob)
+	admin.GET("/overrides/export", s.handleOverrideExport)
+	admin.GET("/sticky-merchants", s.handleListGlobalStickyMerchants)
+	admin.DELETE("/sticky-merchants", s.handleUnpinGlobalMerchant)
+	admin.POST("/overrides/import", s.handleOverrideImport)
+	admin.POST("/backup", s.handleBackup)
+	admin.POST("/restore", s.handleRestore)
+	admin.POST("/rollups/backfill", s.handleRollupBackfill)
+	admin.GET("/rollups/check", s.handleRollupCheck)
+	admin.GET("/diagnostics", s.handleDiagnostics)
+	admin.GET("/errors", s.handleListErrors)
+	admin.DELETE("/errors", s.handleClearErrors)
+	admin.GET("/deprecations", s.handleDeprecations)
+	admin.POST("/connections/webhooks/reconcile", s.handleReconcileWebhooks)
+	admin.POST("/connections/:id/sync", s.handleSyncConnection)
+}
+
+// apiMiddleware is the api group's chain. Without API_KEYS configured the API refuses every
+// request, unless INSECURE_NO_AUTH opens it for local development.
+func (s *Server) apiMiddleware() []gin.HandlerFunc {
+	var limiter *rateLimiter
+	if s.config.RateLimitRPS > 0 {
+		limiter = newRateLimiter(s.config.RateLimitRPS, s.config.RateLimitBurst)
+	}
+	chain := []gin.HandlerFunc{
+		cors(s.config.CORSOrigins),
+		s.rateLimit("api", limiter),
+		s.maxBodySize("api", s.config.MaxBodyBytes),
+	}
+	switch keys := parseAPIKeys(s.config.APIKeys); {
+	case len(keys) > 0:
+		chain = append(chain, s.requireAPIKey("api", keys))
+	case s.config.InsecureNoAuth:
+		s.logger.Warn("INSECURE_NO_AUTH is set, the API accepts unauthenticated requests", map[string]interface{}{
+			"event_type": "auth_disabled",
+		})
+	default:
+		s.logger.Error("API_KEYS is not set, the API refuses every request", map[string]interface{}{
+			"event_type": "auth_unconfigured",
+		})
+		chain = append(chain, s.requireConfiguredKeys("api", "API_KEYS"))
+	}
+	return chain
+}
+
+// adminMiddleware is the admin group's chain: every call is recorded in the admin call log,
+// then checked against admin API keys. Without them admin refuses every request, unless
+// INSECURE_NO_AUTH opens it to private networks for local development. Admin requests aren't
+// size limited so large snapshots can be restored.
+func (s *Server) adminMiddleware() []gin.HandlerFunc {
+	switch keys := parseAPIKeys(s.config.AdminAPIKeys); {
+	case len(keys) > 0:
+		return []gin.HandlerFunc{s.recordAdminCalls(adminCalls), s.requireAPIKey("admin", keys)}
+	case s.config.InsecureNoAuth:
+		return []gin.HandlerFunc{s.recordAdminCalls(adminCalls), internalOnly()}
+	}
+	return []gin.HandlerFunc{s.recordAdminCalls(adminCalls), s.requireConfiguredKeys("admin", "ADMIN_API_KEYS")}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minRuleFeedback is how many judgements a rule needs before its precision reorders matches;
+// until then it keeps its place in the rule order
+const minRuleFeedback = 3
+
+// RulePrecision is how often a rule's category was right for the transactions feedback
+// covers that it matches. Precision is smoothed towards 0.5 so a handful of judgements
+// can't swing it to either end.
+type RulePrecision struct {
+	Rule      string  `json:"rule"`
+	Category  string  `json:"category"`
+	Correct   int     `json:"correct"`
+	Incorrect int     `json:"incorrect"`
+	Precision float64 `json:"precision"`
+}
+
+// Judged returns how many feedback judgements the score rests on
+func (p RulePrecision) Judged() int {
+	return p.Correct + p.Incorrect
+}
+
+// ruleID identifies a rule across rule set versions: by name, or by category and keywords
+// for unnamed rules
+func ruleID(r Rule) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Category + ":" + strings.Join(r.Keywords, ",")
+}
+
+// maxScoredRuleSets bounds how many rule set versions keep their scores, so requests pinned
+// to older versions and candidate rule sets don't recompute on every call
+const maxScoredRuleSets = 8
+
+// rulePrecisionScorer scores the rules of rule sets against the accumulated feedback. Each
+// version's scores are computed once and then updated as corrections are stored.
+type rulePrecisionScorer struct {
+	mu sync.Mutex
+	// versions holds the scores of recently used rule set versions. Score maps are
+	// replaced rather than changed, so callers can read them without the lock.
+	versions map[string]*ruleScores
+	uses     uint64
+	// recorded is each stored correction by feedback key, so a replacement can take back
+	// what the correction it replaces counted
+	recorded map[string]Feedback
+}
+
+// ruleScores are one rule set's scores and when they were last used
+type ruleScores struct {
+	rules    *RuleSet
+	scores   map[string]RulePrecision
+	lastUsed uint64
+}
+
+// newRulePrecisionScorer creates a scorer with no feedback
+func newRulePrecisionScorer() *rulePrecisionScorer {
+	return &rulePrecisionScorer{versions: map[string]*ruleScores{}, recorded: map[string]Feedback{}}
+}
+
+// Scores returns the precision of every rule in rs, by rule ID. The map must not be changed.
+func (s *rulePrecisionScorer) Scores(rs *RuleSet) map[string]RulePrecision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uses++
+	if entry, ok := s.versions[rs.Version]; ok && entry.rules == rs {
+		entry.lastUsed = s.uses
+		return entry.scores
+	}
+	if len(s.versions) >= maxScoredRuleSets {
+		oldest := ""
+		for version, entry := range s.versions {
+			if oldest == "" || entry.lastUsed < s.versions[oldest].lastUsed {
+				oldest = version
+			}
+		}
+		delete(s.versions, oldest)
+	}
+	scores := make(map[string]RulePrecision, len(rs.Rules))
+	for _, rule := range rs.Rules {
+		scores[ruleID(rule)] = RulePrecision{Rule: ruleID(rule), Category: rule.Category, Precision: smoothedPrecision(0, 0)}
+	}
+	for _, f := range s.recorded {
+		judgeRules(rs, scores, f, 1)
+	}
+	s.versions[rs.Version] = &ruleScores{rules: rs, scores: scores, lastUsed: s.uses}
+	return scores
+}
+
+// Record counts a stored correction towards the scores kept, replacing any earlier
+// correction of the same transaction
+func (s *rulePrecisionScorer) Record(f Feedback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, replaced := s.recorded[f.key()]
+	s.recorded[f.key()] = f
+	for _, entry := range s.versions {
+		scores := make(map[string]RulePrecision, len(entry.scores))
+		for id, score := range entry.scores {
+			scores[id] = score
+		}
+		if replaced {
+			judgeRules(entry.rules, scores, previous, -1)
+		}
+		judgeRules(entry.rules, scores, f, 1)
+		entry.scores = scores
+	}
+}
+
+// judgeRules counts n judgements of each rule matching a corrected transaction: right when
+// its category is the one the feedback gives, wrong otherwise
+func judgeRules(rs *RuleSet, scores map[string]RulePrecision, f Feedback, n int) {
+	if f.Merchant == "" {
+		return
+	}
+	merchant := resolveMerchant(normalizeDescriptor(f.Merchant))
+	for _, hit := range rs.MatchAll(merchant, normalizeDescriptor(f.Description), f.Amount) {
+		score := scores[ruleID(*hit.Rule)]
+		if hit.Rule.Category == f.Category {
+			score.Correct += n
+		} else {
+			score.Incorrect += n
+		}
+		score.Precision = smoothedPrecision(score.Correct, score.Judged())
+		scores[ruleID(*hit.Rule)] = score
+	}
+}
+
+// smoothedPrecision is correct out of judged, smoothed towards 0.5
+func smoothedPrecision(correct, judged int) float64 {
+	return math.Round(float64(correct+1)/float64(judged+2)*1000) / 1000
+}
+
+// Prefer reorders rule hits so rules feedback shows to be more accurate come first. Rules
+// without enough feedback count as neutral, and ties keep the rule order.
+func (s *rulePrecisionScorer) Prefer(rs *RuleSet, hits []RuleHit) []RuleHit {
+	if len(hits) < 2 {
+		return hits
+	}
+	scores := s.Scores(rs)
+	weight := func(hit RuleHit) float64 {
+		score := scores[ruleID(*hit.Rule)]
+		if score.Judged() < minRuleFeedback {
+			return 0.5
+		}
+		return score.Precision
+	}
+	preferred := append([]RuleHit{}, hits...)
+	sort.SliceStable(preferred, func(i, j int) bool {
+		return weight(preferred[i]) > weight(preferred[j])
+	})
+	return preferred
+}
+
+// Global rule precision scores
+var rulePrecision = newRulePrecisionScorer()
+
+// handleRulePrecision serves GET /admin/rules/precision?limit=&min_feedback=, the active rules
+// ranked worst first by their precision against feedback. Rules judged fewer than
+// min_feedback times (default 1) are left out.
+func (s *Server) handleRulePrecision(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	minFeedback, err := strconv.Atoi(c.DefaultQuery("min_feedback", "1"))
+	if err != nil || minFeedback < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_feedback must be a non-negative integer"})
+		return
+	}
+
+	rs := s.activeRules()
+	rules := []RulePrecision{}
+	for _, score := range rulePrecision.Scores(rs) {
+		if score.Judged() >= minFeedback {
+			rules = append(rules, score)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Precision != rules[j].Precision {
+			return rules[i].Precision < rules[j].Precision
+		}
+		if rules[i].Judged() != rules[j].Judged() {
+			return rules[i].Judged() > rules[j].Judged()
+		}
+		return rules[i].Rule < rules[j].Rule
+	})
+	total := len(rules)
+	if len(rules) > limit {
+		rules = rules[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ruleset_version": rs.Version,
+		"feedback":        len(feedback.List("", "")),
+		"total":           total,
+		"rules":           rules,
+	})
+}
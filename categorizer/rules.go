@@ -0,0 +1,193 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rule fields a keyword can be matched against
+const (
+	FieldMerchant    = "merchant"
+	FieldDescription = "description"
+)
+
+// Rule assigns a category when any of its keywords appears in a transaction, or any of its
+// patterns, regular expressions matched case-insensitively, matches it. Amounts must be over
+// MinAmount and, when set, at most MaxAmount.
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Category  string   `json:"category" yaml:"category" binding:"required"`
+	Keywords  []string `json:"keywords" yaml:"keywords" binding:"required_without=Patterns"`
+	Patterns  []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+	Fields    []string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	MinAmount float64  `json:"min_amount,omitempty" yaml:"min_amount,omitempty"`
+	MaxAmount float64  `json:"max_amount,omitempty" yaml:"max_amount,omitempty"`
+
+	// compiled holds Patterns compiled by normalize, skipping any that don't compile
+	compiled []rulePattern
+}
+
+// rulePattern is a compiled rule pattern and the source it was compiled from
+type rulePattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// RuleSet is an ordered list of rules where the first matching rule wins
+type RuleSet struct {
+	Version string `json:"version" yaml:"version"`
+	Rules   []Rule `json:"rules" yaml:"rules"`
+}
+
+// compileRulePattern compiles a rule pattern to match case-insensitively
+func compileRulePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// normalize lowercases keywords so matching can compare against lowercased input, and
+// compiles patterns. Patterns that don't compile are left out; validateRuleSet reports them.
+func (r *Rule) normalize() {
+	for i, keyword := range r.Keywords {
+		r.Keywords[i] = strings.ToLower(strings.TrimSpace(keyword))
+	}
+	r.compiled = nil
+	for _, pattern := range r.Patterns {
+		if re, err := compileRulePattern(pattern); err == nil {
+			r.compiled = append(r.compiled, rulePattern{source: pattern, re: re})
+		}
+	}
+}
+
+// matchesAmount reports whether an amount is within the rule's thresholds
+func (r Rule) matchesAmount(amount float64) bool {
+	if r.MinAmount > 0 && amount <= r.MinAmount {
+		return false
+	}
+	return r.MaxAmount <= 0 || amount <= r.MaxAmount
+}
+
+// matchesField reports whether the rule inspects the given field
+func (r Rule) matchesField(field string) bool {
+	if len(r.Fields) == 0 {
+		return true
+	}
+	for _, f := range r.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the first keyword of the rule found in the lowercased merchant or description,
+// or failing that the first pattern matching either
+func (r Rule) Match(merchantLower, descriptionLower string, amount float64) (string, bool) {
+	if !r.matchesAmount(amount) {
+		return "", false
+	}
+	for _, keyword := range r.Keywords {
+		if keyword == "" {
+			continue
+		}
+		if r.matchesField(FieldMerchant) && strings.Contains(merchantLower, keyword) {
+			return keyword, true
+		}
+		if r.matchesField(FieldDescription) && strings.Contains(descriptionLower, keyword) {
+			return keyword, true
+		}
+	}
+	for _, p := range r.compiled {
+		if r.matchesField(FieldMerchant) && p.re.MatchString(merchantLower) {
+			return p.source, true
+		}
+		if r.matchesField(FieldDescription) && p.re.MatchString(descriptionLower) {
+			return p.source, true
+		}
+	}
+	return "", false
+}
+
+// RuleHit is a rule that matched a transaction and the keyword that fired
+type RuleHit struct {
+	Rule    *Rule
+	Keyword string
+}
+
+// MatchAll returns every rule matching the transaction in rule order
+func (rs *RuleSet) MatchAll(merchant, description string, amount float64) []RuleHit {
+	merchantLower := strings.ToLower(merchant)
+	descriptionLower := strings.ToLower(description)
+	var hits []RuleHit
+	for i := range rs.Rules {
+		if keyword, ok := rs.Rules[i].Match(merchantLower, descriptionLower, amount); ok {
+			hits = append(hits, RuleHit{Rule: &rs.Rules[i], Keyword: keyword})
+		}
+	}
+	return hits
+}
+
+// withRule returns a copy of the rule set with rule inserted at position
+func (rs *RuleSet) withRule(rule Rule, position int) *RuleSet {
+	if position < 0 || position > len(rs.Rules) {
+		position = len(rs.Rules)
+	}
+	rules := make([]Rule, 0, len(rs.Rules)+1)
+	rules = append(rules, rs.Rules[:position]...)
+	rules = append(rules, rule)
+	rules = append(rules, rs.Rules[position:]...)
+	return &RuleSet{Version: rs.Version + "+candidate", Rules: rules}
+}
+
+// defaultRuleSet returns the built-in keyword rules
+func defaultRuleSet() *RuleSet {
+	rs := &RuleSet{
+		Version: "builtin",
+		Rules: []Rule{
+			// Bank charges come first so a "late payment fee" from a retailer isn't Shopping
+			{Name: "fees", Category: CategoryFees, Keywords: feeRuleKeywords()},
+			{Name: "card_verification", Category: CategoryCardVerification, Keywords: append([]string{}, cardVerificationKeywords...)},
+			// Charities come first so "charity shop" or "WaterAid" don't fall into Shopping or Bills
+			{Name: "donations", Category: "Donations", Keywords: append([]string{}, charityKeywords...)},
+			// Payments into investments and pensions are saving, not Shopping or Bills
+			{Name: "investments", Category: CategoryInvestments, Keywords: investmentRuleKeywords()},
+			// Card bills and loan repayments pay for spending that happened earlier
+			{Name: "debt_repayments", Category: CategoryDebtRepayments, Keywords: loanRuleKeywords()},
+			{Name: "income", Category: "Income", Keywords: []string{"salary", "deposit", "income", "gift"}},
+			{Name: "transport", Category: "Transport", Keywords: []string{"uber", "lyft", "taxi", "transport", "tfl", "bus", "train", "metro", "subway"}},
+			{Name: "food_and_drink", Category: "Food & Drink", Keywords: []string{"starbucks", "costa", "cafe", "restaurant", "mcdonalds", "kfc", "pizza", "food", "coffee", "tea"}},
+			{Name: "shopping", Category: "Shopping", Keywords: []string{"amazon", "ebay", "shop", "store", "retail", "market", "mall", "clothing", "fashion"}},
+			{Name: "groceries", Category: "Groceries", Keywords: []string{"tesco", "sainsbury", "asda", "morrisons", "waitrose", "aldi", "lidl", "grocery", "supermarket"}},
+			{Name: "entertainment", Category: "Entertainment", Keywords: []string{"cinema", "movie", "netflix", "spotify", "apple music", "game", "entertainment", "theatre"}},
+			{Name: "bills", Category: "Bills & Utilities", Keywords: []string{"electric", "gas", "water", "internet", "phone", "insurance", "council tax", "utility", "energy"}},
+			{Name: "atm", Category: "ATM", Keywords: []string{"atm", "cash"}, Fields: []string{FieldMerchant}},
+			// Large amounts might be rent/salary
+			{Name: "large_income", Category: "Income", Keywords: []string{"salary", "wages"}, Fields: []string{FieldDescription}, MinAmount: 700},
+			{Name: "large_housing", Category: "Housing", Keywords: []string{"rent", "mortgage"}, Fields: []string{FieldDescription}, MinAmount: 700},
+		},
+	}
+	for i := range rs.Rules {
+		rs.Rules[i].normalize()
+	}
+	return rs
+}
+
+// activeRules returns the rule set the server's classifier currently evaluates
+func (s *Server) activeRules() *RuleSet {
+	return s.classifier.Rules()
+}
+
+// setActiveRules replaces the server's classifier rule set. Callers must not modify rs
+// afterwards.
+func (s *Server) setActiveRules(rs *RuleSet) {
+	previous := s.classifier.SwapRules(rs)
+	ruleHistory.Activate(rs, time.Now())
+	s.metrics.recordRulesLoaded()
+	s.metrics.recordBuildInfo(s.currentBuildInfo())
+	s.logRuleConflicts(rs)
+	s.emitEvent(EventRulesReloaded, "", map[string]interface{}{
+		"version":          rs.Version,
+		"previous_version": previous.Version,
+		"rules":            len(rs.Rules),
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RuleTestRequest is a candidate rule with sample transactions to evaluate it against
+type RuleTestRequest struct {
+	Rule     Rule                 `json:"rule" binding:"required"`
+	Position *int                 `json:"position"`
+	Samples  []TransactionRequest `json:"samples" binding:"required,min=1,dive"`
+}
+
+// RuleTestResult describes how a candidate rule affects one sample
+type RuleTestResult struct {
+	Index           int    `json:"index"`
+	Merchant        string `json:"merchant"`
+	Matched         bool   `json:"matched"`
+	Keyword         string `json:"keyword,omitempty"`
+	ShadowedBy      string `json:"shadowed_by,omitempty"`
+	CurrentCategory string `json:"current_category"`
+	NewCategory     string `json:"new_category"`
+	Changed         bool   `json:"changed"`
+}
+
+func (s *Server) handleRuleTest(c *gin.Context) {
+	var req RuleTestRequest
+	err := c.ShouldBindJSON(&req)
+	for i := 0; err == nil && i < len(req.Samples); i++ {
+		if err = s.normalizeTransaction(c, &req.Samples[i]); err != nil {
+			err = fmt.Errorf("samples[%d]: %w", i, err)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	candidate := req.Rule
+	candidate.Keywords = append([]string(nil), candidate.Keywords...)
+	candidate.normalize()
+	if candidate.Name == "" {
+		candidate.Name = "candidate"
+	}
+
+	// Candidates are evaluated ahead of every existing rule unless a position is given
+	position := 0
+	if req.Position != nil {
+		position = *req.Position
+	}
+	current := s.activeRules()
+	if position < 0 || position > len(current.Rules) {
+		position = len(current.Rules)
+	}
+	proposed := current.withRule(candidate, position)
+	candidateRule := &proposed.Rules[position]
+
+	results := make([]RuleTestResult, 0, len(req.Samples))
+	matched, changed := 0, 0
+	for i, sample := range req.Samples {
+		currentMatch := s.categorizeWith(current, sample.Merchant, sample.Description, sample.Amount, sample.TransactionType)
+		newMatch := s.categorizeWith(proposed, sample.Merchant, sample.Description, sample.Amount, sample.TransactionType)
+		currentCategory, newCategory, rule := currentMatch.Category, newMatch.Category, newMatch.Rule
+
+		result := RuleTestResult{
+			Index:           i,
+			Merchant:        sample.Merchant,
+			CurrentCategory: currentCategory,
+			NewCategory:     newCategory,
+			Changed:         currentCategory != newCategory,
+		}
+		keyword, ok := candidate.Match(strings.ToLower(sample.Merchant), strings.ToLower(sample.Description), sample.Amount)
+		if ok {
+			result.Keyword = keyword
+			if rule == candidateRule {
+				result.Matched = true
+				matched++
+			} else if rule != nil {
+				result.ShadowedBy = rule.Name
+			}
+		}
+		if result.Changed {
+			changed++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rule":            candidate,
+		"ruleset_version": current.Version,
+		"samples":         len(req.Samples),
+		"matched":         matched,
+		"changed":         changed,
+		"results":         results,
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errNoRulesFile is returned when a reload is asked for without RULES_FILE set
+var errNoRulesFile = errors.New("RULES_FILE is not set; there is no rules file to reload")
+
+// errChangesetNotInFile is returned when reloading would discard rules an approved changeset
+// applied
+var errChangesetNotInFile = errors.New("the active rules came from an approved changeset the rules file doesn't include yet; add its rules to the file first")
+
+// includesChangeset reports whether a rule set keeps every rule a changeset applied, as the
+// changeset defined it
+func includesChangeset(rs *RuleSet, cs RuleChangeset) bool {
+	diff := diffRuleSets(cs.ruleSet(), rs)
+	return len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// RulesReload reports a rule set swapped in by a reload
+type RulesReload struct {
+	Version         string `json:"version"`
+	PreviousVersion string `json:"previous_version"`
+	Rules           int    `json:"rules"`
+}
+
+// rulesReloadMu serializes reloads, so two at once can't leave the older file active
+var rulesReloadMu sync.Mutex
+
+// reloadRules re-reads RULES_FILE and swaps it in as the active rule set once it validates.
+// Requests already running keep the rule set they started with; an invalid file leaves the
+// active rules untouched. While the active rules are an approved changeset's, the file must
+// include them, so a reload can't quietly revert a reviewed change.
+func (s *Server) reloadRules(actor string) (RulesReload, error) {
+	if s.config.RulesFile == "" {
+		return RulesReload{}, errNoRulesFile
+	}
+	rulesReloadMu.Lock()
+	defer rulesReloadMu.Unlock()
+
+	previous := s.activeRules()
+	rs, err := readRulesFile(s.config.RulesFile)
+	if err != nil {
+		s.recordAudit(actor, "rules.reload_failed", s.config.RulesFile, map[string]interface{}{"reason": err.Error()})
+		return RulesReload{}, err
+	}
+	if cs, ok := changesets.Applied(previous.Version); ok && !includesChangeset(rs, cs) {
+		s.recordAudit(actor, "rules.reload_failed", s.config.RulesFile, map[string]interface{}{
+			"reason":    errChangesetNotInFile.Error(),
+			"changeset": cs.ID,
+		})
+		return RulesReload{}, errChangesetNotInFile
+	}
+	s.setActiveRules(rs)
+	reload := RulesReload{Version: rs.Version, PreviousVersion: previous.Version, Rules: len(rs.Rules)}
+	s.recordAudit(actor, "rules.reload", s.config.RulesFile, map[string]interface{}{
+		"version":          reload.Version,
+		"previous_version": reload.PreviousVersion,
+		"rules":            reload.Rules,
+	})
+	return reload, nil
+}
+
+// startRulesReloadSignal reloads the rules file whenever the process receives SIGHUP
+func (s *Server) startRulesReloadSignal() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if _, err := s.reloadRules("sighup"); err != nil {
+				s.logger.Error("Rules reload failed", map[string]interface{}{
+					"event_type":    "rules_reload",
+					"error_type":    "invalid_rules",
+					"error_message": err.Error(),
+				})
+			}
+		}
+	}()
+}
+
+// handleReloadRules serves POST /admin/rules/reload
+func (s *Server) handleReloadRules(c *gin.Context) {
+	reload, err := s.reloadRules(callerName(c))
+	switch {
+	case errors.Is(err, errNoRulesFile), errors.Is(err, errChangesetNotInFile):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, reload)
+	}
+}
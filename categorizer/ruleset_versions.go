@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RulesetVersionHeader lets a request pin the rule set version it is evaluated against
+const RulesetVersionHeader = "X-Ruleset-Version"
+
+// RuleSetVersion is a rule set that has been active, kept after it is replaced so requests
+// can still pin it until it expires
+type RuleSetVersion struct {
+	Version     string     `json:"version"`
+	Rules       int        `json:"rules"`
+	Active      bool       `json:"active"`
+	ActivatedAt time.Time  `json:"activated_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	ruleSet *RuleSet
+}
+
+// ruleSetHistory keeps the active rule set and those retired within the retention window
+type ruleSetHistory struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	versions  []RuleSetVersion
+}
+
+// newRuleSetHistory starts a history with the rule set active at startup
+func newRuleSetHistory(retention time.Duration, active *RuleSet) *ruleSetHistory {
+	return &ruleSetHistory{
+		retention: retention,
+		versions:  []RuleSetVersion{{Version: active.Version, Rules: len(active.Rules), Active: true, ActivatedAt: time.Now().UTC(), ruleSet: active}},
+	}
+}
+
+// Activate retires the active rule set in favour of rs and drops expired versions
+func (h *ruleSetHistory) Activate(rs *RuleSet, now time.Time) {
+	now = now.UTC()
+	expires := now.Add(h.retention)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kept := h.versions[:0]
+	for _, v := range h.versions {
+		if v.Active {
+			v.Active = false
+			v.RetiredAt, v.ExpiresAt = &now, &expires
+		}
+		if v.ExpiresAt != nil && !v.ExpiresAt.After(now) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	h.versions = append(kept, RuleSetVersion{Version: rs.Version, Rules: len(rs.Rules), Active: true, ActivatedAt: now, ruleSet: rs})
+}
+
+// Lookup returns the newest rule set with a version, unless it has expired
+func (h *ruleSetHistory) Lookup(version string, now time.Time) (*RuleSet, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		v := h.versions[i]
+		if v.Version != version {
+			continue
+		}
+		if v.ExpiresAt != nil && !v.ExpiresAt.After(now) {
+			return nil, false
+		}
+		return v.ruleSet, true
+	}
+	return nil, false
+}
+
+// Known reports whether a version is active or still retained
+func (h *ruleSetHistory) Known(version string) bool {
+	_, ok := h.Lookup(version, time.Now())
+	return ok
+}
+
+// List returns the unexpired versions, newest first
+func (h *ruleSetHistory) List(now time.Time) []RuleSetVersion {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]RuleSetVersion, 0, len(h.versions))
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		if v := h.versions[i]; v.ExpiresAt == nil || v.ExpiresAt.After(now) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Global rule set version history, started at startup with the default rule set
+var ruleHistory *ruleSetHistory
+
+// requestRuleSet returns the rule set a request pins with X-Ruleset-Version, or the one cl
+// evaluates when it pins none, and echoes the version evaluated in the response header
+func requestRuleSet(c *gin.Context, cl *Classifier) (*RuleSet, error) {
+	rs, err := pinnedRuleSet(cl, c.GetHeader(RulesetVersionHeader))
+	if err != nil {
+		return nil, err
+	}
+	c.Header(RulesetVersionHeader, rs.Version)
+	return rs, nil
+}
+
+// pinnedRuleSet returns the retained rule set version, or cl's active rules when version is empty
+func pinnedRuleSet(cl *Classifier, version string) (*RuleSet, error) {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return cl.Rules(), nil
+	}
+	pinned, ok := ruleHistory.Lookup(version, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("rule set version %q is unknown or no longer retained", version)
+	}
+	return pinned, nil
+}
+
+// handleListRuleSetVersions serves GET /admin/rules/versions with the versions requests can pin
+func (s *Server) handleListRuleSetVersions(c *gin.Context) {
+	versions := ruleHistory.List(time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"retention": s.config.RulesetRetention.String(),
+		"count":     len(versions),
+		"versions":  versions,
+	})
+}
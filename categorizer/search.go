@@ -0,0 +1,195 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchFields are the transaction fields full-text search covers, with the weight a term
+// matching in each contributes to a result's relevance
+var searchFields = []struct {
+	name   string
+	weight float64
+	value  func(StoredTransaction) string
+}{
+	{"merchant", 3, func(tx StoredTransaction) string { return tx.Merchant }},
+	{"description", 2, func(tx StoredTransaction) string { return tx.Description }},
+	{"category", 1, func(tx StoredTransaction) string { return tx.Category }},
+	{"location", 1, func(tx StoredTransaction) string { return tx.Location }},
+	{"references", 2, func(tx StoredTransaction) string {
+		values := make([]string, 0, len(tx.References))
+		for _, ref := range tx.References {
+			values = append(values, ref.Value)
+		}
+		return strings.Join(values, " ")
+	}},
+}
+
+// Highlight markers wrapped around matched terms. Highlights are HTML: the field text
+// around the markers is escaped, so a value like "<script>" can't inject markup.
+const (
+	highlightStart = "<em>"
+	highlightEnd   = "</em>"
+)
+
+// SearchResult is a transaction matching a search, with its relevance and the matched fields
+// highlighted
+type SearchResult struct {
+	Transaction StoredTransaction `json:"transaction"`
+	Score       float64           `json:"score"`
+	Highlights  map[string]string `json:"highlights"`
+}
+
+// searchTerms splits text into lowercased words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// wordSpan is the byte range of one word in a field value
+type wordSpan struct {
+	start, end int
+	word       string
+}
+
+// wordSpans returns the words of a field value with their positions, for highlighting
+func wordSpans(text string) []wordSpan {
+	var spans []wordSpan
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			spans = append(spans, wordSpan{start, i, strings.ToLower(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, wordSpan{start, len(text), strings.ToLower(text[start:])})
+	}
+	return spans
+}
+
+// matchTransaction scores a transaction against query terms. Every term must match a word in
+// some field, either exactly or as a prefix, which counts for half; ok is false otherwise.
+func matchTransaction(tx StoredTransaction, terms []string) (SearchResult, bool) {
+	result := SearchResult{Transaction: tx, Highlights: map[string]string{}}
+	matched := make([]bool, len(terms))
+	for _, field := range searchFields {
+		value := field.value(tx)
+		if value == "" {
+			continue
+		}
+		var highlighted strings.Builder
+		last, hits := 0, 0
+		for _, span := range wordSpans(value) {
+			best := 0.0
+			for i, term := range terms {
+				weight := 0.0
+				if span.word == term {
+					weight = field.weight
+				} else if strings.HasPrefix(span.word, term) {
+					weight = field.weight / 2
+				}
+				if weight > 0 {
+					matched[i] = true
+					if weight > best {
+						best = weight
+					}
+				}
+			}
+			if best == 0 {
+				continue
+			}
+			result.Score += best
+			hits++
+			highlighted.WriteString(html.EscapeString(value[last:span.start]))
+			highlighted.WriteString(highlightStart + html.EscapeString(value[span.start:span.end]) + highlightEnd)
+			last = span.end
+		}
+		if hits > 0 {
+			highlighted.WriteString(html.EscapeString(value[last:]))
+			result.Highlights[field.name] = highlighted.String()
+		}
+	}
+	for _, ok := range matched {
+		if !ok {
+			return SearchResult{}, false
+		}
+	}
+	return result, true
+}
+
+// searchTransactions ranks the transactions matching every query term by relevance, most
+// recent first among equals
+func searchTransactions(transactions []StoredTransaction, query string) []SearchResult {
+	terms := searchTerms(query)
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results
+	}
+	for _, tx := range transactions {
+		if result, ok := matchTransaction(tx, terms); ok {
+			results = append(results, result)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Transaction.CreatedAt.After(results[j].Transaction.CreatedAt)
+	})
+	return results
+}
+
+// handleHistorySearch serves GET /history/search?user_id=&q=, searching a user's history by
+// merchant, description, category, location and external references. It takes the same from
+// and to as /history and ?limit= (default 20, at most 100).
+func (s *Server) handleHistorySearch(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	query := c.Query("q")
+	if len(searchTerms(query)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one word"})
+		return
+	}
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := 20
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	results := searchTransactions(s.store.ListTransactions(userID, from, to), query)
+	total := len(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"query":   query,
+		"total":   total,
+		"count":   len(results),
+		"results": results,
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server is the categorization service with the dependencies its handlers and background jobs
+// are given. main builds one from the configuration; tests and embedders can build their own
+// with a separate config, logger, metrics registry, store and classifier.
+type Server struct {
+	config     Config
+	logger     *StructuredLogger
+	metrics    *Metrics
+	store      Store
+	classifier *Classifier
+}
+
+// NewServer creates a server from its dependencies
+func NewServer(cfg Config, logger *StructuredLogger, metrics *Metrics, store Store, classifier *Classifier) *Server {
+	return &Server{
+		config:     cfg,
+		logger:     logger,
+		metrics:    metrics,
+		store:      store,
+		classifier: classifier,
+	}
+}
+
+// Handler returns the server's routes behind the request logger and metrics middleware
+func (s *Server) Handler() http.Handler {
+	r := s.engine()
+	s.registerRoutes(r)
+	return r
+}
+
+// engine returns a gin engine with no routes behind the request logger and metrics
+// middleware, trusting the configured proxies
+func (s *Server) engine() *gin.Engine {
+	r := gin.Default()
+	// Only configured proxies may set the client address through forwarding headers; with
+	// none, it is always the connection's peer
+	if err := r.SetTrustedProxies(s.config.TrustedProxies); err != nil {
+		s.logger.Error("Invalid TRUSTED_PROXIES, trusting no proxies", map[string]interface{}{
+			"event_type":    "config_error",
+			"error_message": err.Error(),
+		})
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(RequestLogger(s.logger))
+	r.Use(s.MetricsMiddleware())
+	return r
+}
+
+// Run serves HTTP on addr until it fails
+func (s *Server) Run(addr string) error {
+	s.logger.Info("Server started and listening", map[string]interface{}{
+		"port":       strings.TrimPrefix(addr, ":"),
+		"event_type": "server_ready",
+	})
+	return http.ListenAndServe(addr, s.Handler())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Amount sign conventions a caller can send transactions in. Internally every transaction is
+// typed: a non-negative amount and a transaction type of debit or credit.
+const (
+	SignTyped  = "typed"
+	SignSigned = "signed"
+)
+
+// Transaction types
+const (
+	TypeDebit  = "debit"
+	TypeCredit = "credit"
+)
+
+// freeTextTypeDeprecation covers transaction types other than debit and credit, which have
+// always been treated as debits
+var freeTextTypeDeprecation = Deprecation{
+	Surface:     "transaction_type values other than debit or credit",
+	Since:       time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC),
+	Replacement: "transaction_type debit or credit, or sign_convention signed",
+}
+
+func init() {
+	deprecations.Register(freeTextTypeDeprecation)
+}
+
+// normalizeSign rewrites a transaction sent in either sign convention into the typed one,
+// reporting whether it used a free-text transaction type
+func normalizeSign(req *TransactionRequest, defaultConvention string) (freeText bool, err error) {
+	convention := req.SignConvention
+	if convention == "" {
+		convention = defaultConvention
+	}
+	transactionType := strings.ToLower(strings.TrimSpace(req.TransactionType))
+	known := transactionType == TypeDebit || transactionType == TypeCredit
+
+	switch convention {
+	case SignSigned:
+		signType := TypeCredit
+		if req.Amount < 0 || (req.Amount == 0 && transactionType != TypeCredit) {
+			signType = TypeDebit
+		}
+		if known && transactionType != signType {
+			return false, fmt.Errorf("transaction_type %q contradicts the sign of amount %g under the signed convention", req.TransactionType, req.Amount)
+		}
+		req.Amount, req.TransactionType = abs(req.Amount), signType
+		return false, nil
+	case SignTyped:
+		if transactionType == "" {
+			return false, errors.New("transaction_type is required unless sign_convention is signed")
+		}
+		if req.Amount < 0 {
+			return false, errors.New("amount must not be negative under the typed convention; send sign_convention signed for signed amounts")
+		}
+		if !known {
+			freeText, transactionType = true, TypeDebit
+		}
+		req.TransactionType = transactionType
+		return freeText, nil
+	}
+	return false, fmt.Errorf("unknown sign convention %q", convention)
+}
+
+// normalizeTransaction scopes the request to the caller's tenant and applies its sign
+// convention, or the configured default, counting free-text transaction types against the
+// caller
+func (s *Server) normalizeTransaction(c *gin.Context, req *TransactionRequest) error {
+	tenantID, err := scopeTenant(c, req.TenantID)
+	if err != nil {
+		return err
+	}
+	req.TenantID = tenantID
+	freeText, err := normalizeSign(req, s.config.SignConvention)
+	if err != nil {
+		return err
+	}
+	if freeText {
+		s.markDeprecated(c, freeTextTypeDeprecation)
+	}
+	return nil
+}
+
+// abs returns the magnitude of an amount
+func abs(amount float64) float64 {
+	if amount < 0 {
+		return -amount
+	}
+	return amount
+}
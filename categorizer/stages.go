@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strings"
+)
+
+// normalizeStage strips card-network noise from the merchant and description
+type normalizeStage struct{}
+
+func (normalizeStage) Name() string { return "normalize" }
+
+func (normalizeStage) Process(cl *Classification) {
+	cl.NormalizedMerchant = normalizeDescriptor(cl.NormalizedMerchant)
+	cl.NormalizedDescription = normalizeDescriptor(cl.NormalizedDescription)
+}
+
+// processorPrefixes are payment processors that prefix the real merchant name
+var processorPrefixes = []string{"sq", "sumup", "zettle", "iz", "paypal", "pp"}
+
+// merchantAliases maps abbreviated descriptor merchants to their canonical names
+var merchantAliases = map[string]string{
+	"amzn":              "amazon",
+	"amzn mktp":         "amazon",
+	"amazon.co.uk":      "amazon",
+	"sbux":              "starbucks",
+	"mcdonald's":        "mcdonalds",
+	"tfl travel charge": "tfl",
+	"sainsburys":        "sainsbury",
+	"trading212":        "trading 212",
+}
+
+// merchantResolveStage drops processor prefixes and maps merchant aliases to canonical names
+type merchantResolveStage struct{}
+
+func (merchantResolveStage) Name() string { return "merchant_resolve" }
+
+func (merchantResolveStage) Process(cl *Classification) {
+	cl.NormalizedMerchant = resolveMerchant(cl.NormalizedMerchant)
+}
+
+// resolveMerchant returns the canonical form of a normalized merchant name
+func resolveMerchant(merchant string) string {
+	tokens := strings.Fields(strings.ToLower(merchant))
+	if len(tokens) > 1 {
+		for _, prefix := range processorPrefixes {
+			if tokens[0] == prefix {
+				tokens = tokens[1:]
+				break
+			}
+		}
+	}
+
+	// Prefer the longest alias that covers the leading tokens
+	for n := len(tokens); n > 0; n-- {
+		if canonical, ok := merchantAliases[strings.Join(tokens[:n], " ")]; ok {
+			return strings.Join(append([]string{canonical}, tokens[n:]...), " ")
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// rulesStage applies the keyword rules, with fuzzy matching as a fallback when enabled. Of
+// several matching rules, the one feedback shows to be most accurate wins. A match less
+// certain than the tenant's confidence threshold is left Uncategorized.
+type rulesStage struct{}
+
+func (rulesStage) Name() string { return "rules" }
+
+func (rulesStage) Backend() string { return "keyword" }
+
+func (rulesStage) Process(cl *Classification) {
+	if cl.Decided {
+		return
+	}
+	if strings.ToLower(cl.TransactionType) == "credit" {
+		cl.decide(CategoryIncome)
+		return
+	}
+
+	rs := cl.RuleSet
+	hits := rs.MatchAll(cl.NormalizedMerchant, cl.NormalizedDescription, cl.Amount)
+	fuzzy := false
+	if len(hits) == 0 && fuzzyMatching.Enabled() {
+		hits = rs.MatchFuzzyAll(cl.NormalizedMerchant, cl.NormalizedDescription, cl.Amount, fuzzyMatching)
+		fuzzy = true
+	}
+	if len(hits) == 0 {
+		return
+	}
+	hits = rulePrecision.Prefer(rs, hits)
+
+	cl.RuleHits = hits
+	cl.Rule, cl.Keyword, cl.Fuzzy = hits[0].Rule, hits[0].Keyword, fuzzy
+	cl.Candidates = scoreRuleHits(hits, fuzzy)
+	if belowTenantConfidence(cl) {
+		cl.decide(CategoryUncategorized)
+		return
+	}
+	cl.decide(hits[0].Rule.Category)
+}
+
+// Categorizer is a learned classifier the pipeline runs alongside the keyword rules. Classify
+// returns a category only when it is sure enough to decide one.
+type Categorizer interface {
+	Classify(cl *Classification) (string, bool)
+}
+
+// Classifier consulted by the ml and ml_fallback stages, selected by CLASSIFIER; nil when the
+// keyword rules run alone
+var fallbackClassifier Categorizer
+
+// mlStage lets the classifier decide ahead of the keyword rules, which categorize whatever it
+// isn't sure of, or is less sure of than the tenant requires
+type mlStage struct{}
+
+func (mlStage) Name() string { return "ml" }
+
+func (mlStage) Backend() string { return "ml" }
+
+func (mlStage) Process(cl *Classification) {
+	if cl.Decided || fallbackClassifier == nil {
+		return
+	}
+	if category, ok := fallbackClassifier.Classify(cl); ok && !mlBelowTenantConfidence(cl, category) {
+		cl.decide(category)
+	}
+}
+
+// mlFallbackStage asks the fallback classifier about transactions the rules left undecided.
+// Like the rules, it leaves a category less certain than the tenant requires uncategorized.
+type mlFallbackStage struct{}
+
+func (mlFallbackStage) Name() string { return "ml_fallback" }
+
+func (mlFallbackStage) Backend() string { return "ml" }
+
+func (mlFallbackStage) Process(cl *Classification) {
+	if cl.Decided || fallbackClassifier == nil {
+		return
+	}
+	category, ok := fallbackClassifier.Classify(cl)
+	if !ok {
+		return
+	}
+	if mlBelowTenantConfidence(cl, category) {
+		cl.decide(CategoryUncategorized)
+		return
+	}
+	cl.decide(category)
+}
+
+// postProcessStage assigns Other to anything still undecided
+type postProcessStage struct{}
+
+func (postProcessStage) Name() string { return "post_process" }
+
+func (postProcessStage) Backend() string { return "default" }
+
+func (postProcessStage) Process(cl *Classification) {
+	if !cl.Decided {
+		cl.decide("Other")
+	}
+}
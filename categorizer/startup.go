@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Startup phases
+const (
+	startupWaiting = "waiting"
+	startupReady   = "ready"
+	startupFailed  = "failed"
+)
+
+// Backoff between startup dependency checks
+const (
+	startupInitialBackoff = 500 * time.Millisecond
+	startupMaxBackoff     = 10 * time.Second
+)
+
+// StartupState reports progress waiting for required dependencies at startup
+type StartupState struct {
+	Phase     string     `json:"phase"`
+	Attempts  int        `json:"attempts"`
+	Pending   []string   `json:"pending,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+}
+
+// startupTracker holds the startup state for readiness to report
+type startupTracker struct {
+	mu    sync.Mutex
+	state StartupState
+}
+
+// State returns a copy of the current startup state
+func (t *startupTracker) State() StartupState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// set replaces the startup state
+func (t *startupTracker) set(state StartupState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = state
+}
+
+// Global startup state
+var startup = &startupTracker{state: StartupState{Phase: startupWaiting}}
+
+// requiredChecks returns the dependency checks the service waits for at startup
+func (s *Server) requiredChecks() []DependencyCheck {
+	var required []DependencyCheck
+	for _, check := range s.dependencyChecks() {
+		if check.Required {
+			required = append(required, check)
+		}
+	}
+	return required
+}
+
+// waitForDependencies checks the required dependencies until they all answer, backing off
+// between attempts, and gives up once timeout has passed. A zero timeout skips the wait.
+func (s *Server) waitForDependencies(checks []DependencyCheck, timeout time.Duration) error {
+	state := StartupState{Phase: startupWaiting}
+	if timeout <= 0 || len(checks) == 0 {
+		now := time.Now().UTC()
+		state.Phase, state.ReadyAt = startupReady, &now
+		startup.set(state)
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout).UTC()
+	state.Deadline = &deadline
+	backoff := startupInitialBackoff
+	for {
+		state.Attempts++
+		result := runReadinessChecks(checks)
+		if result.Ready {
+			now := time.Now().UTC()
+			state.Phase, state.Pending, state.LastError, state.ReadyAt = startupReady, nil, "", &now
+			startup.set(state)
+			s.logger.Info(fmt.Sprintf("Dependencies ready after %d attempt(s)", state.Attempts), map[string]interface{}{
+				"event_type": "startup_dependencies_ready",
+			})
+			return nil
+		}
+
+		state.Pending = nil
+		var failures []string
+		for _, check := range result.Checks {
+			if !check.Healthy {
+				state.Pending = append(state.Pending, check.Name)
+				failures = append(failures, check.Name+": "+check.Error)
+			}
+		}
+		state.LastError = strings.Join(failures, "; ")
+		if time.Now().Add(backoff).After(deadline) {
+			state.Phase = startupFailed
+			startup.set(state)
+			return fmt.Errorf("dependencies not ready after %s: %s", timeout, state.LastError)
+		}
+		startup.set(state)
+		s.logger.Warn(fmt.Sprintf("Waiting for dependencies, retrying in %s", backoff), map[string]interface{}{
+			"event_type":    "startup_dependencies_waiting",
+			"error_message": state.LastError,
+		})
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > startupMaxBackoff {
+			backoff = startupMaxBackoff
+		}
+	}
+}
+
+// startDependencyWait waits for the required dependencies in the background, so readiness
+// can report progress meanwhile, and exits once the deadline passes without them
+func (s *Server) startDependencyWait(timeout time.Duration) {
+	go func() {
+		if err := s.waitForDependencies(s.requiredChecks(), timeout); err != nil {
+			s.logStartupError("dependencies", err)
+			os.Exit(1)
+		}
+		s.checkReadiness()
+	}()
+}
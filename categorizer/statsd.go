@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdExporter mirrors the service's Prometheus metrics to a DogStatsD agent for
+// environments that don't scrape. Counters are sent as deltas and gauges as their current
+// value on every flush; histogram observations are sent as they happen so the agent can
+// compute its own distributions.
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+// newStatsdExporter connects to a DogStatsD agent over UDP
+func newStatsdExporter(addr, prefix string) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdExporter{conn: conn, prefix: prefix, last: map[string]float64{}}, nil
+}
+
+// newMetricsExporter returns the configured push exporter, or nil when metrics are only
+// scraped from /metrics
+func newMetricsExporter(cfg Config) (*statsdExporter, error) {
+	switch cfg.MetricsExporter {
+	case "", "prometheus":
+		return nil, nil
+	case "dogstatsd":
+		return newStatsdExporter(cfg.StatsdAddr, cfg.StatsdPrefix)
+	}
+	return nil, fmt.Errorf("unknown metrics exporter %q", cfg.MetricsExporter)
+}
+
+// DogStatsD exporter; nil unless METRICS_EXPORTER=dogstatsd
+var statsd *statsdExporter
+
+// dogstatsdTags formats label pairs as DogStatsD tags, leaving out empty values
+func dogstatsdTags(labels []*dto.LabelPair) string {
+	tags := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if label.GetValue() != "" {
+			tags = append(tags, label.GetName()+":"+label.GetValue())
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// send writes one DogStatsD line; UDP delivery is best effort
+func (s *statsdExporter) send(name string, value float64, kind, tags string) {
+	line := s.prefix + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + tags
+	s.conn.Write([]byte(line))
+}
+
+// Flush sends counter deltas and gauge values gathered from a Prometheus registry
+func (s *statsdExporter) Flush(g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			tags := dogstatsdTags(m.Label)
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				key := mf.GetName() + tags
+				value := m.GetCounter().GetValue()
+				delta := value - s.last[key]
+				if delta < 0 {
+					// The counter was reset, e.g. by a restart of the collector
+					delta = value
+				}
+				s.last[key] = value
+				if delta > 0 {
+					s.send(mf.GetName(), delta, "c", tags)
+				}
+			case dto.MetricType_GAUGE:
+				s.send(mf.GetName(), m.GetGauge().GetValue(), "g", tags)
+			}
+		}
+	}
+	return nil
+}
+
+// Observe sends one histogram observation with its labels as tags
+func (s *statsdExporter) Observe(name string, value float64, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	pairs := make([]*dto.LabelPair, 0, len(names))
+	for _, label := range names {
+		label, value := label, labels[label]
+		pairs = append(pairs, &dto.LabelPair{Name: &label, Value: &value})
+	}
+	s.send(name, value, "h", dogstatsdTags(pairs))
+}
+
+// observeStatsd forwards a histogram observation to DogStatsD when it is enabled, tagged with
+// the environment as the gathered metrics are
+func (m *Metrics) observeStatsd(name string, value float64, labels map[string]string) {
+	if statsd != nil {
+		labels["environment"] = m.environment
+		statsd.Observe(name, value, labels)
+	}
+}
+
+// startStatsdFlush flushes counters and gauges to DogStatsD every interval
+func (s *Server) startStatsdFlush(interval time.Duration) {
+	if statsd == nil {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := statsd.Flush(s.metrics.Registry()); err != nil {
+				s.logger.Warn("DogStatsD flush failed", map[string]interface{}{
+					"event_type":    "statsd_flush_failed",
+					"error_message": err.Error(),
+				})
+			}
+		}
+	}()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScopeGlobal pins a merchant for every user of a tenant, from the tenant's whole corpus of
+// feedback. Its scope ID is the tenant's, empty for users outside any tenant.
+const ScopeGlobal = "global"
+
+// StickyPin fixes a merchant's category for a user, or for everyone in a tenant, once
+// feedback has confirmed it enough times in a row that descriptor variations shouldn't be
+// able to flip it
+type StickyPin struct {
+	Scope         string    `json:"scope"`
+	ScopeID       string    `json:"scope_id,omitempty"`
+	Merchant      string    `json:"merchant"`
+	Category      string    `json:"category"`
+	Confirmations int       `json:"confirmations"`
+	PinnedAt      time.Time `json:"pinned_at"`
+}
+
+// key identifies a pin by scope and canonical merchant
+func (p StickyPin) key() string {
+	return p.Scope + "|" + p.ScopeID + "|" + p.Merchant
+}
+
+// stickyStreak counts consecutive confirmations of one category for a merchant. A shared
+// streak counts each user once, in users.
+type stickyStreak struct {
+	category string
+	count    int
+	users    map[string]bool
+}
+
+// stickinessStore tracks feedback streaks per user and merchant, and across a tenant's users
+// per merchant, pinning the merchant once a streak reaches the configured confirmations
+type stickinessStore struct {
+	mu      sync.RWMutex
+	streaks map[string]*stickyStreak
+	pins    map[string]StickyPin
+}
+
+// newStickinessStore creates an empty store
+func newStickinessStore() *stickinessStore {
+	return &stickinessStore{streaks: map[string]*stickyStreak{}, pins: map[string]StickyPin{}}
+}
+
+// Observe counts a piece of feedback towards the user's streak and their tenant's for its
+// merchant, returning the pins it created. A different category restarts a streak, and the
+// tenant's streak only grows with users who haven't yet confirmed it, so one user repeating
+// themselves can't pin a merchant for everyone. A user's streak pins at userThreshold and the
+// tenant's at tenantThreshold. Feedback without a user counts for neither.
+func (s *stickinessStore) Observe(f Feedback, userThreshold, tenantThreshold int) []StickyPin {
+	merchant := resolveMerchant(normalizeDescriptor(f.Merchant))
+	if merchant == "" || f.UserID == "" {
+		return nil
+	}
+	type stickyScope struct {
+		pin       StickyPin
+		threshold int
+		shared    bool
+	}
+	scopes := []stickyScope{
+		{StickyPin{Scope: ScopeGlobal, ScopeID: f.TenantID, Merchant: merchant}, tenantThreshold, true},
+		{StickyPin{Scope: ScopeUser, ScopeID: f.UserID, Merchant: merchant}, userThreshold, false},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pinned []StickyPin
+	for _, scope := range scopes {
+		if scope.threshold <= 0 {
+			continue
+		}
+		key := scope.pin.key()
+		streak := s.streaks[key]
+		if streak == nil || streak.category != f.Category {
+			streak = &stickyStreak{category: f.Category, users: map[string]bool{}}
+			s.streaks[key] = streak
+		}
+		if scope.shared {
+			if streak.users[f.UserID] {
+				continue
+			}
+			streak.users[f.UserID] = true
+		}
+		streak.count++
+		if streak.count < scope.threshold {
+			continue
+		}
+		if existing, ok := s.pins[key]; ok && existing.Category == f.Category {
+			continue
+		}
+		pin := scope.pin
+		pin.Category, pin.Confirmations, pin.PinnedAt = f.Category, streak.count, time.Now().UTC()
+		s.pins[key] = pin
+		pinned = append(pinned, pin)
+	}
+	return pinned
+}
+
+// Lookup returns the pin for a canonical merchant, preferring the user's over their tenant's
+func (s *stickinessStore) Lookup(userID, tenantID, merchant string) (StickyPin, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if userID != "" {
+		if p, ok := s.pins[StickyPin{Scope: ScopeUser, ScopeID: userID, Merchant: merchant}.key()]; ok {
+			return p, true
+		}
+	}
+	p, ok := s.pins[StickyPin{Scope: ScopeGlobal, ScopeID: tenantID, Merchant: merchant}.key()]
+	return p, ok
+}
+
+// Unpin removes a pin and the streak behind it, so it takes a fresh run of confirmations to
+// pin the merchant again. It returns the removed pin.
+func (s *stickinessStore) Unpin(scope, scopeID, merchant string) (StickyPin, bool) {
+	key := StickyPin{Scope: scope, ScopeID: scopeID, Merchant: resolveMerchant(normalizeDescriptor(merchant))}.key()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pins[key]
+	if !ok {
+		return StickyPin{}, false
+	}
+	delete(s.pins, key)
+	delete(s.streaks, key)
+	return p, true
+}
+
+// List returns a scope's pins sorted by merchant
+func (s *stickinessStore) List(scope, scopeID string) []StickyPin {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := []StickyPin{}
+	for _, p := range s.pins {
+		if p.Scope == scope && p.ScopeID == scopeID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Merchant < list[j].Merchant })
+	return list
+}
+
+// Snapshot returns a copy of every pin
+func (s *stickinessStore) Snapshot() []StickyPin {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]StickyPin, 0, len(s.pins))
+	for _, p := range s.pins {
+		list = append(list, p)
+	}
+	return list
+}
+
+// Restore replaces every pin. Streaks start afresh.
+func (s *stickinessStore) Restore(list []StickyPin) {
+	pins := make(map[string]StickyPin, len(list))
+	for _, p := range list {
+		pins[p.key()] = p
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pins = pins
+	s.streaks = map[string]*stickyStreak{}
+}
+
+// Global merchant stickiness
+var stickiness = newStickinessStore()
+
+// observeStickiness counts stored feedback towards stickiness, auditing any merchant it pins
+func (s *Server) observeStickiness(f Feedback) {
+	for _, pin := range stickiness.Observe(f, s.config.StickyConfirmations, s.config.StickyGlobal) {
+		s.recordAudit("stickiness", "merchant.pin", pin.key(), map[string]interface{}{
+			"scope":         pin.Scope,
+			"scope_id":      pin.ScopeID,
+			"merchant":      pin.Merchant,
+			"category":      pin.Category,
+			"confirmations": pin.Confirmations,
+		})
+	}
+}
+
+// stickyStage applies a pinned category for the resolved merchant
+type stickyStage struct{}
+
+func (stickyStage) Name() string { return "sticky" }
+
+func (stickyStage) Backend() string { return "sticky" }
+
+func (stickyStage) Process(cl *Classification) {
+	if cl.Decided {
+		return
+	}
+	if p, ok := stickiness.Lookup(cl.UserID, cl.TenantID, cl.NormalizedMerchant); ok {
+		cl.Sticky = &p
+		cl.decide(p.Category)
+	}
+}
+
+// unpin removes a pin for the handlers, auditing who removed it
+func (s *Server) unpin(c *gin.Context, scope, scopeID string) {
+	p, ok := stickiness.Unpin(scope, scopeID, c.Query("merchant"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "merchant is not pinned"})
+		return
+	}
+	s.recordAudit(callerName(c), "merchant.unpin", p.key(), map[string]interface{}{
+		"scope":    p.Scope,
+		"scope_id": p.ScopeID,
+		"merchant": p.Merchant,
+		"category": p.Category,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// handleListUserStickyMerchants serves GET /users/:user_id/sticky-merchants
+func (s *Server) handleListUserStickyMerchants(c *gin.Context) {
+	userID := c.Param("user_id")
+	list := stickiness.List(ScopeUser, userID)
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "count": len(list), "pins": list})
+}
+
+// handleUnpinUserMerchant serves DELETE /users/:user_id/sticky-merchants?merchant=
+func (s *Server) handleUnpinUserMerchant(c *gin.Context) {
+	s.unpin(c, ScopeUser, c.Param("user_id"))
+}
+
+// handleListGlobalStickyMerchants serves GET /admin/sticky-merchants?tenant_id=, the
+// merchants pinned for everyone in a tenant, or outside any tenant when none is given
+func (s *Server) handleListGlobalStickyMerchants(c *gin.Context) {
+	tenantID := c.Query("tenant_id")
+	list := stickiness.List(ScopeGlobal, tenantID)
+	c.JSON(http.StatusOK, gin.H{"tenant_id": tenantID, "count": len(list), "pins": list})
+}
+
+// handleUnpinGlobalMerchant serves DELETE /admin/sticky-merchants?merchant=&tenant_id=
+func (s *Server) handleUnpinGlobalMerchant(c *gin.Context) {
+	s.unpin(c, ScopeGlobal, c.Query("tenant_id"))
+}
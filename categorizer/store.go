@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StoredTransaction is a categorized transaction kept in a user's history
+type StoredTransaction struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	Merchant        string     `json:"merchant"`
+	Description     string     `json:"description,omitempty"`
+	Amount          float64    `json:"amount"`
+	TransactionType string     `json:"transaction_type"`
+	MCC             string     `json:"mcc,omitempty"`
+	Category        string     `json:"category"`
+	CreatedAt       time.Time  `json:"created_at"`
+	TransactionID   string     `json:"transaction_id,omitempty"`
+	DedupeHash      string     `json:"dedupe_hash,omitempty"`
+	Duplicate       bool       `json:"duplicate,omitempty"`
+	DuplicateOf     string     `json:"duplicate_of,omitempty"`
+	Status          string     `json:"status"`
+	SettledAt       *time.Time `json:"settled_at,omitempty"`
+	DeclineReason   string     `json:"decline_reason,omitempty"`
+	Location        string     `json:"location,omitempty"`
+	Country         string     `json:"country,omitempty"`
+	TenantID        string     `json:"tenant_id,omitempty"`
+	// BNPLProvider is set on an installment paid through a buy-now-pay-later provider that
+	// was linked to its purchase's category
+	BNPLProvider string `json:"bnpl_provider,omitempty"`
+	// TenantCategory is the tenant's name for Category when its taxonomy shows it differently
+	TenantCategory string `json:"tenant_category,omitempty"`
+	// DecidedBy is the pipeline stage that assigned Category
+	DecidedBy string `json:"decided_by,omitempty"`
+	// Account is the bank account the transaction was synced from, which categories and
+	// receipts are written back to
+	Account *BankAccount `json:"account,omitempty"`
+
+	// References link the transaction to receipts, invoices and expense reports elsewhere
+	References []ExternalReference `json:"references,omitempty"`
+	// Receipt itemizes the transaction, splitting it across categories
+	Receipt *Receipt `json:"receipt,omitempty"`
+}
+
+// Transaction statuses
+const (
+	StatusPending  = "pending"
+	StatusSettled  = "settled"
+	StatusDeclined = "declined"
+)
+
+// Store is the per-user transaction history. Handlers and jobs go through it, so the history
+// can live in memory or be split between a primary that takes writes and a replica that serves
+// heavy reads.
+type Store interface {
+	AddTransaction(tx StoredTransaction) StoredTransaction
+	ResolvePending(tx StoredTransaction) (updated, previous StoredTransaction, ok bool)
+	ListTransactions(userID string, from, to time.Time) []StoredTransaction
+	Users() []string
+	RemoveWhere(userID string, match func(StoredTransaction) bool) []StoredTransaction
+	UpdateWhere(userID string, update func(*StoredTransaction) bool) int
+	UpdateTransaction(userID, id string, update func(*StoredTransaction) error) (StoredTransaction, error)
+	GetTransaction(userID, id string) (StoredTransaction, error)
+	Snapshot() map[string][]StoredTransaction
+	Restore(transactions map[string][]StoredTransaction)
+}
+
+// memoryStore keeps per-user transaction history in memory
+type memoryStore struct {
+	mu           sync.RWMutex
+	transactions map[string][]StoredTransaction
+	// dedupeWindow is how far apart identical merchant and amount pairs are still treated as
+	// resubmissions
+	dedupeWindow time.Duration
+}
+
+// newMemoryStore creates an empty in-memory store that flags resubmissions within
+// dedupeWindow as duplicates
+func newMemoryStore(dedupeWindow time.Duration) *memoryStore {
+	return &memoryStore{
+		transactions: map[string][]StoredTransaction{},
+		dedupeWindow: dedupeWindow,
+	}
+}
+
+// AddTransaction appends a transaction to its user's history, assigning an ID if missing.
+// Transactions that repeat an earlier one are kept but flagged as duplicates.
+func (s *memoryStore) AddTransaction(tx StoredTransaction) StoredTransaction {
+	if tx.ID == "" {
+		tx.ID = newID("tx_")
+	}
+	if tx.CreatedAt.IsZero() {
+		tx.CreatedAt = time.Now().UTC()
+	}
+	if tx.Status == "" {
+		tx.Status = StatusSettled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if original := s.findDuplicate(tx); original != nil {
+		tx.Duplicate = true
+		tx.DuplicateOf = original.ID
+	}
+	s.transactions[tx.UserID] = append(s.transactions[tx.UserID], tx)
+	summaries.Invalidate(tx.UserID)
+	rollups.MarkDirty(tx.UserID, tx.CreatedAt)
+	return tx
+}
+
+// ResolvePending applies a settlement or decline to the user's pending transaction with the
+// same transaction ID, returning the updated and previous versions. ok is false when there is
+// no such pending transaction.
+func (s *memoryStore) ResolvePending(tx StoredTransaction) (updated, previous StoredTransaction, ok bool) {
+	if tx.TransactionID == "" || tx.Status == StatusPending {
+		return StoredTransaction{}, StoredTransaction{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.transactions[tx.UserID]
+	for i := len(history) - 1; i >= 0; i-- {
+		existing := &history[i]
+		if existing.TransactionID != tx.TransactionID || existing.Status != StatusPending || existing.Duplicate {
+			continue
+		}
+
+		previous = *existing
+		existing.Merchant = tx.Merchant
+		existing.Description = tx.Description
+		existing.Amount = tx.Amount
+		existing.Category = tx.Category
+		existing.DecidedBy = tx.DecidedBy
+		existing.Status = tx.Status
+		existing.DeclineReason = tx.DeclineReason
+		if tx.Account != nil {
+			existing.Account = tx.Account
+		}
+		settledAt := tx.CreatedAt
+		if settledAt.IsZero() {
+			settledAt = time.Now().UTC()
+		}
+		existing.SettledAt = &settledAt
+		summaries.Invalidate(tx.UserID)
+		rollups.MarkDirty(tx.UserID, existing.CreatedAt)
+		return *existing, previous, true
+	}
+	return StoredTransaction{}, StoredTransaction{}, false
+}
+
+// findDuplicate returns the earlier transaction tx repeats: one with the same transaction ID
+// or dedupe hash, or failing those the same merchant, amount and type within the dedupe
+// window. Transactions carrying different transaction IDs are never duplicates.
+// Callers must hold the lock.
+func (s *memoryStore) findDuplicate(tx StoredTransaction) *StoredTransaction {
+	history := s.transactions[tx.UserID]
+	merchant := normalizeDescriptor(tx.Merchant)
+	for i := len(history) - 1; i >= 0; i-- {
+		prev := &history[i]
+		if prev.Duplicate {
+			continue
+		}
+		if tx.TransactionID != "" && prev.TransactionID == tx.TransactionID {
+			return prev
+		}
+		if tx.DedupeHash != "" && prev.DedupeHash == tx.DedupeHash {
+			return prev
+		}
+		if tx.TransactionID != "" && prev.TransactionID != "" {
+			continue
+		}
+		if math.Abs(prev.Amount-tx.Amount) >= 0.005 || prev.TransactionType != tx.TransactionType {
+			continue
+		}
+		gap := tx.CreatedAt.Sub(prev.CreatedAt)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= s.dedupeWindow && normalizeDescriptor(prev.Merchant) == merchant {
+			return prev
+		}
+	}
+	return nil
+}
+
+// ListTransactions returns a user's transactions created within [from, to), oldest first.
+// A zero from or to leaves that end of the range open.
+func (s *memoryStore) ListTransactions(userID string, from, to time.Time) []StoredTransaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []StoredTransaction{}
+	for _, tx := range s.transactions[userID] {
+		if !from.IsZero() && tx.CreatedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !tx.CreatedAt.Before(to) {
+			continue
+		}
+		result = append(result, tx)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Users returns the IDs of all users with history, sorted
+func (s *memoryStore) Users() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]string, 0, len(s.transactions))
+	for userID := range s.transactions {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// RemoveWhere deletes a user's transactions that match, returning them
+func (s *memoryStore) RemoveWhere(userID string, match func(StoredTransaction) bool) []StoredTransaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed []StoredTransaction
+	kept := s.transactions[userID][:0]
+	for _, tx := range s.transactions[userID] {
+		if !match(tx) {
+			kept = append(kept, tx)
+			continue
+		}
+		removed = append(removed, tx)
+		rollups.MarkDirty(userID, tx.CreatedAt)
+	}
+	s.transactions[userID] = kept
+	if len(removed) > 0 {
+		summaries.Invalidate(userID)
+	}
+	return removed
+}
+
+// UpdateWhere applies update to each of a user's transactions, returning how many it changed
+func (s *memoryStore) UpdateWhere(userID string, update func(*StoredTransaction) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := 0
+	history := s.transactions[userID]
+	for i := range history {
+		if update(&history[i]) {
+			changed++
+			rollups.MarkDirty(userID, history[i].CreatedAt)
+		}
+	}
+	if changed > 0 {
+		summaries.Invalidate(userID)
+	}
+	return changed
+}
+
+// errTransactionNotFound is returned for unknown transaction IDs
+var errTransactionNotFound = errors.New("transaction not found")
+
+// UpdateTransaction applies update to one of a user's transactions, found by its ID or the
+// caller's transaction ID, and returns the result. Nothing is changed if update fails.
+func (s *memoryStore) UpdateTransaction(userID, id string, update func(*StoredTransaction) error) (StoredTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(userID, id)
+	if i < 0 {
+		return StoredTransaction{}, errTransactionNotFound
+	}
+	tx := s.transactions[userID][i]
+	if err := update(&tx); err != nil {
+		return StoredTransaction{}, err
+	}
+	s.transactions[userID][i] = tx
+	return tx, nil
+}
+
+// GetTransaction returns one of a user's transactions by its ID or the caller's transaction ID
+func (s *memoryStore) GetTransaction(userID, id string) (StoredTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.find(userID, id)
+	if i < 0 {
+		return StoredTransaction{}, errTransactionNotFound
+	}
+	return s.transactions[userID][i], nil
+}
+
+// find returns the index of the latest of a user's transactions with the given ID or
+// transaction ID, or -1. Callers must hold the lock.
+func (s *memoryStore) find(userID, id string) int {
+	history := s.transactions[userID]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == id || history[i].TransactionID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// newID returns a random identifier with the given prefix
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return prefix + time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// Snapshot returns a copy of every user's history
+func (s *memoryStore) Snapshot() map[string][]StoredTransaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string][]StoredTransaction, len(s.transactions))
+	for userID, transactions := range s.transactions {
+		snapshot[userID] = append([]StoredTransaction{}, transactions...)
+	}
+	return snapshot
+}
+
+// history returns a copy of a user's transactions in the order they were stored
+func (s *memoryStore) history(userID string) []StoredTransaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]StoredTransaction(nil), s.transactions[userID]...)
+}
+
+// setHistory replaces one user's transactions, dropping the user when there are none
+func (s *memoryStore) setHistory(userID string, transactions []StoredTransaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(transactions) == 0 {
+		delete(s.transactions, userID)
+		return
+	}
+	s.transactions[userID] = transactions
+}
+
+// Restore replaces every user's history
+func (s *memoryStore) Restore(transactions map[string][]StoredTransaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transactions = transactions
+	summaries.Clear()
+	rollups.Reset()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// replicatedStore splits the history between a primary that takes every write and a read
+// replica that serves the heavy range reads behind history, summaries, analytics and
+// exports. Writes reach the replica asynchronously, a user at a time, when sync copies the
+// users written since it last ran. Until a user's writes have been copied their reads go to
+// the primary, so the replica never serves a stale history.
+type replicatedStore struct {
+	primary *memoryStore
+	replica *memoryStore
+	metrics *Metrics
+
+	mu sync.Mutex
+	// pending are the users with writes not yet on the replica
+	pending map[string]*pendingUser
+}
+
+// pendingUser tracks a user's writes that the replica hasn't caught up with
+type pendingUser struct {
+	// writing counts writes in progress on the primary
+	writing int
+	// version counts writes, so sync can tell whether more arrived while it copied
+	version uint64
+	// since is when the oldest write not yet copied started
+	since time.Time
+}
+
+// newReplicatedStore creates a store writing to primary and reading from replica, recording
+// which one each query went to in m
+func newReplicatedStore(primary, replica *memoryStore, m *Metrics) *replicatedStore {
+	return &replicatedStore{
+		primary: primary,
+		replica: replica,
+		metrics: m,
+		pending: map[string]*pendingUser{},
+	}
+}
+
+// write runs a write against the primary, marking the user as pending while it runs and
+// until sync copies the result. Reads of the user go to the primary meanwhile.
+func (s *replicatedStore) write(userID, operation string, fn func()) {
+	s.mu.Lock()
+	p, ok := s.pending[userID]
+	if !ok {
+		p = &pendingUser{since: time.Now()}
+		s.pending[userID] = p
+	}
+	p.writing++
+	p.version++
+	s.mu.Unlock()
+
+	s.metrics.recordStoreQuery("primary", operation)
+	fn()
+
+	s.mu.Lock()
+	p.writing--
+	s.mu.Unlock()
+}
+
+// readTarget returns the store to serve a user's read from and its metrics label
+func (s *replicatedStore) readTarget(userID string) (*memoryStore, string) {
+	s.mu.Lock()
+	_, pending := s.pending[userID]
+	s.mu.Unlock()
+	if pending {
+		return s.primary, "primary"
+	}
+	return s.replica, "replica"
+}
+
+func (s *replicatedStore) AddTransaction(tx StoredTransaction) StoredTransaction {
+	var stored StoredTransaction
+	s.write(tx.UserID, "add", func() { stored = s.primary.AddTransaction(tx) })
+	return stored
+}
+
+func (s *replicatedStore) ResolvePending(tx StoredTransaction) (updated, previous StoredTransaction, ok bool) {
+	s.write(tx.UserID, "resolve_pending", func() { updated, previous, ok = s.primary.ResolvePending(tx) })
+	return updated, previous, ok
+}
+
+func (s *replicatedStore) RemoveWhere(userID string, match func(StoredTransaction) bool) []StoredTransaction {
+	var removed []StoredTransaction
+	s.write(userID, "remove", func() { removed = s.primary.RemoveWhere(userID, match) })
+	return removed
+}
+
+func (s *replicatedStore) UpdateWhere(userID string, update func(*StoredTransaction) bool) int {
+	var changed int
+	s.write(userID, "update", func() { changed = s.primary.UpdateWhere(userID, update) })
+	return changed
+}
+
+func (s *replicatedStore) UpdateTransaction(userID, id string, update func(*StoredTransaction) error) (StoredTransaction, error) {
+	var (
+		tx  StoredTransaction
+		err error
+	)
+	s.write(userID, "update", func() { tx, err = s.primary.UpdateTransaction(userID, id, update) })
+	return tx, err
+}
+
+// ListTransactions serves a user's range read from the replica once it holds their writes
+func (s *replicatedStore) ListTransactions(userID string, from, to time.Time) []StoredTransaction {
+	target, label := s.readTarget(userID)
+	s.metrics.recordStoreQuery(label, "list")
+	return target.ListTransactions(userID, from, to)
+}
+
+// GetTransaction reads from the primary: single lookups usually follow a write the caller
+// just made, and are cheap
+func (s *replicatedStore) GetTransaction(userID, id string) (StoredTransaction, error) {
+	s.metrics.recordStoreQuery("primary", "get")
+	return s.primary.GetTransaction(userID, id)
+}
+
+// Users reads from the primary, which knows users the replica hasn't been sent yet
+func (s *replicatedStore) Users() []string {
+	s.metrics.recordStoreQuery("primary", "users")
+	return s.primary.Users()
+}
+
+// Snapshot reads from the primary so a backup holds every acknowledged write
+func (s *replicatedStore) Snapshot() map[string][]StoredTransaction {
+	s.metrics.recordStoreQuery("primary", "snapshot")
+	return s.primary.Snapshot()
+}
+
+// Restore replaces the history on both stores at once, leaving nothing to sync
+func (s *replicatedStore) Restore(transactions map[string][]StoredTransaction) {
+	copied := make(map[string][]StoredTransaction, len(transactions))
+	for userID, list := range transactions {
+		copied[userID] = append([]StoredTransaction(nil), list...)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.recordStoreQuery("primary", "restore")
+	s.primary.Restore(transactions)
+	s.replica.Restore(copied)
+	s.pending = map[string]*pendingUser{}
+}
+
+// sync copies the history of every user written since the last sync from the primary to the
+// replica. A user stays pending while a write is in progress or if another arrives during the
+// copy, and is copied again next time.
+func (s *replicatedStore) sync() {
+	s.mu.Lock()
+	versions := make(map[string]uint64, len(s.pending))
+	for userID, p := range s.pending {
+		if p.writing == 0 {
+			versions[userID] = p.version
+		}
+	}
+	s.mu.Unlock()
+
+	for userID, version := range versions {
+		s.replica.setHistory(userID, s.primary.history(userID))
+		s.mu.Lock()
+		if p, ok := s.pending[userID]; ok && p.writing == 0 && p.version == version {
+			delete(s.pending, userID)
+		}
+		s.mu.Unlock()
+	}
+	s.metrics.setStoreReplicaLag(s.Lag())
+}
+
+// Lag returns the age of the oldest write the replica doesn't hold yet
+func (s *replicatedStore) Lag() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var oldest time.Time
+	for _, p := range s.pending {
+		if oldest.IsZero() || p.since.Before(oldest) {
+			oldest = p.since
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// startReplicaSync copies writes to the read replica every interval when the history is split
+func (s *Server) startReplicaSync(interval time.Duration) {
+	replicated, ok := s.store.(*replicatedStore)
+	if !ok {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			replicated.sync()
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestReplicatedStoreReads checks a user's reads go to the primary until their writes have
+// been synced to the replica, and to the replica after
+func TestReplicatedStoreReads(t *testing.T) {
+	summaries = newSummaryCache(0)
+	primary, replica := newMemoryStore(0), newMemoryStore(0)
+	s := newReplicatedStore(primary, replica, NewMetrics(prometheus.NewRegistry(), "test", nil))
+
+	s.AddTransaction(StoredTransaction{UserID: "u1", Merchant: "Tesco", Amount: 12.5, CreatedAt: time.Now()})
+	if got := len(s.ListTransactions("u1", time.Time{}, time.Time{})); got != 1 {
+		t.Fatalf("before sync: listed %d transactions, want 1 from the primary", got)
+	}
+	if got := len(replica.ListTransactions("u1", time.Time{}, time.Time{})); got != 0 {
+		t.Fatalf("before sync: replica holds %d transactions, want 0", got)
+	}
+
+	s.sync()
+	if _, label := s.readTarget("u1"); label != "replica" {
+		t.Errorf("after sync: reads go to the %s, want the replica", label)
+	}
+	if got := len(s.ListTransactions("u1", time.Time{}, time.Time{})); got != 1 {
+		t.Errorf("after sync: listed %d transactions, want 1", got)
+	}
+	if lag := s.Lag(); lag != 0 {
+		t.Errorf("after sync: lag %s, want 0", lag)
+	}
+
+	s.UpdateWhere("u1", func(tx *StoredTransaction) bool {
+		tx.Category = "Groceries"
+		return true
+	})
+	if _, label := s.readTarget("u1"); label != "primary" {
+		t.Errorf("after a write: reads go to the %s, want the primary", label)
+	}
+	if got := s.ListTransactions("u1", time.Time{}, time.Time{})[0].Category; got != "Groceries" {
+		t.Errorf("after a write: category %q, want the primary's Groceries", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Subscription is a merchant charging a user a similar amount roughly every month
+type Subscription struct {
+	Merchant    string    `json:"merchant"`
+	Category    string    `json:"category"`
+	Amount      float64   `json:"amount"`
+	Charges     int       `json:"charges"`
+	FirstCharge time.Time `json:"first_charge"`
+	LastCharge  time.Time `json:"last_charge"`
+}
+
+// detectSubscriptions finds merchants with at least two debits of similar amounts
+// (within 10%) that are 25 to 35 days apart
+func detectSubscriptions(transactions []StoredTransaction) []Subscription {
+	byMerchant := map[string][]StoredTransaction{}
+	for _, tx := range transactions {
+		if tx.Duplicate || tx.Status == StatusDeclined || strings.ToLower(tx.TransactionType) == "credit" {
+			continue
+		}
+		merchant := resolveMerchant(normalizeDescriptor(tx.Merchant))
+		byMerchant[merchant] = append(byMerchant[merchant], tx)
+	}
+
+	var subscriptions []Subscription
+	for merchant, charges := range byMerchant {
+		if len(charges) < 2 {
+			continue
+		}
+		sort.Slice(charges, func(i, j int) bool {
+			return charges[i].CreatedAt.Before(charges[j].CreatedAt)
+		})
+
+		recurring := 1
+		for i := 1; i < len(charges); i++ {
+			days := charges[i].CreatedAt.Sub(charges[i-1].CreatedAt).Hours() / 24
+			similar := math.Abs(charges[i].Amount-charges[i-1].Amount) <= 0.1*charges[i-1].Amount
+			if days >= 25 && days <= 35 && similar {
+				recurring++
+			}
+		}
+		if recurring < 2 {
+			continue
+		}
+
+		last := charges[len(charges)-1]
+		subscriptions = append(subscriptions, Subscription{
+			Merchant:    merchant,
+			Category:    last.Category,
+			Amount:      last.Amount,
+			Charges:     recurring,
+			FirstCharge: charges[0].CreatedAt,
+			LastCharge:  last.CreatedAt,
+		})
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Merchant < subscriptions[j].Merchant
+	})
+	return subscriptions
+}
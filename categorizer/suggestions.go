@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// suggestionMaxDistance is the furthest a keyword may be from a token to be suggested,
+	// looser than fuzzy matching since suggestions only prompt the user
+	suggestionMaxDistance = 2
+	// suggestionMinLength is the shortest token or keyword compared for suggestions
+	suggestionMinLength = 4
+	maxSuggestions      = 3
+)
+
+// Suggestion is a category offered for a transaction that fell through to Other, for UIs to
+// ask "Did you mean Groceries?" and turn the answer into feedback
+type Suggestion struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+	// Source is what produced the suggestion: keyword for a near-miss rule keyword, rule for a
+	// candidate below the tenant's confidence, ml for the fallback classifier's scores
+	Source  string `json:"source"`
+	Keyword string `json:"keyword,omitempty"`
+}
+
+// FallbackScorer is implemented by fallback classifiers that can score categories, including
+// those below the confidence they need to decide one
+type FallbackScorer interface {
+	Scores(cl *Classification) []CategoryScore
+}
+
+// suggestCategories returns the best few soft suggestions for a classification the rules
+// couldn't settle. When no rule matched they are rule keywords within a small edit distance
+// of the merchant or description; when the match fell short of the tenant's confidence they
+// are the rules' candidates. Whatever the fallback classifier scored is added to either. Each
+// category is suggested once, at its best score.
+func suggestCategories(cl Classification) []Suggestion {
+	uncategorized := cl.Category == CategoryUncategorized && cl.DecidedBy == "rules"
+	unmatched := cl.Category == "Other" && cl.Rule == nil && cl.Override == nil && cl.Policy == nil && cl.Sticky == nil
+	if !uncategorized && !unmatched || cl.RuleSet == nil {
+		return nil
+	}
+
+	best := map[string]Suggestion{}
+	offer := func(s Suggestion) {
+		if s.Category == "Other" || s.Score <= 0 {
+			return
+		}
+		if current, ok := best[s.Category]; !ok || s.Score > current.Score {
+			best[s.Category] = s
+		}
+	}
+
+	if uncategorized {
+		for _, cs := range cl.Candidates {
+			offer(Suggestion{Category: cs.Category, Score: cs.Score, Source: "rule"})
+		}
+	} else {
+		for _, s := range nearKeywordSuggestions(cl) {
+			offer(s)
+		}
+	}
+
+	if scorer, ok := fallbackClassifier.(FallbackScorer); ok {
+		for _, cs := range scorer.Scores(&cl) {
+			offer(Suggestion{Category: cs.Category, Score: cs.Score, Source: "ml"})
+		}
+	}
+
+	suggestions := make([]Suggestion, 0, len(best))
+	for _, s := range best {
+		s.Score = math.Round(s.Score*1000) / 1000
+		suggestions = append(suggestions, s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Category < suggestions[j].Category
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// nearKeywordSuggestions suggests the category of every rule keyword close to a token of the
+// merchant or description
+func nearKeywordSuggestions(cl Classification) []Suggestion {
+	merchantTokens := tokenize(strings.ToLower(cl.NormalizedMerchant))
+	descriptionTokens := tokenize(strings.ToLower(cl.NormalizedDescription))
+	var suggestions []Suggestion
+	for _, rule := range cl.RuleSet.Rules {
+		if !rule.matchesAmount(cl.Amount) {
+			continue
+		}
+		var tokens []string
+		if rule.matchesField(FieldMerchant) {
+			tokens = append(tokens, merchantTokens...)
+		}
+		if rule.matchesField(FieldDescription) {
+			tokens = append(tokens, descriptionTokens...)
+		}
+		for _, keyword := range rule.Keywords {
+			if score, ok := keywordSimilarity(tokens, keyword); ok {
+				suggestions = append(suggestions, Suggestion{Category: rule.Category, Score: score, Source: "keyword", Keyword: keyword})
+			}
+		}
+	}
+	return suggestions
+}
+
+// keywordSimilarity scores how close the nearest token is to a single-word keyword, between 0
+// and 1, discounted like fuzzy candidates. A keyword qualifies when it is within
+// suggestionMaxDistance edits of a token and fewer than a third of its letters differ.
+func keywordSimilarity(tokens []string, keyword string) (float64, bool) {
+	length := len([]rune(keyword))
+	if length < suggestionMinLength || strings.ContainsRune(keyword, ' ') {
+		return 0, false
+	}
+	nearest := -1
+	for _, token := range tokens {
+		if len([]rune(token)) < suggestionMinLength {
+			continue
+		}
+		if d := levenshtein(token, keyword); nearest < 0 || d < nearest {
+			nearest = d
+		}
+	}
+	if nearest < 0 || nearest > suggestionMaxDistance || nearest*3 >= length {
+		return 0, false
+	}
+	similarity := 1 - float64(nearest)/float64(length)
+	return similarity * keywordStrength(keyword) * fuzzyCandidateWeight, true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CategorySummary totals a user's spending in one category
+type CategorySummary struct {
+	Category string  `json:"category"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+	// Via is the part of the spending paid in installments through buy-now-pay-later providers
+	Via []BNPLSummary `json:"via,omitempty"`
+}
+
+// BNPLSummary totals the installments paid through one buy-now-pay-later provider within a
+// category, labelled as "Shopping (via Klarna)"
+type BNPLSummary struct {
+	Provider string  `json:"provider"`
+	Label    string  `json:"label"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+}
+
+// addVia counts installments paid through a provider towards the category
+func (c *CategorySummary) addVia(provider string, count int, total float64) {
+	for i := range c.Via {
+		if c.Via[i].Provider == provider {
+			c.Via[i].Count += count
+			c.Via[i].Total += total
+			return
+		}
+	}
+	c.Via = append(c.Via, BNPLSummary{Provider: provider, Label: bnplLabel(c.Category, provider), Count: count, Total: total})
+}
+
+// roundTotals rounds the category's totals to the penny and orders its providers by total
+func (c *CategorySummary) roundTotals() {
+	c.Total = roundPence(c.Total)
+	for i := range c.Via {
+		c.Via[i].Total = roundPence(c.Via[i].Total)
+	}
+	sort.Slice(c.Via, func(i, j int) bool {
+		return c.Via[i].Total > c.Via[j].Total
+	})
+}
+
+// Summary totals a user's income and spending per category over a period
+type Summary struct {
+	UserID             string            `json:"user_id"`
+	Transactions       int               `json:"transactions"`
+	Income             float64           `json:"income"`
+	Spending           float64           `json:"spending"`
+	Categories         []CategorySummary `json:"categories"`
+	DuplicatesExcluded int               `json:"duplicates_excluded"`
+	DeclinedExcluded   int               `json:"declined_excluded"`
+}
+
+// buildSummary totals transactions per category, skipping flagged duplicates and declined
+// transactions, which never moved money
+func buildSummary(userID string, transactions []StoredTransaction) Summary {
+	summary := Summary{UserID: userID}
+	categories := map[string]*CategorySummary{}
+	for _, tx := range transactions {
+		if tx.Duplicate {
+			summary.DuplicatesExcluded++
+			continue
+		}
+		if tx.Status == StatusDeclined {
+			summary.DeclinedExcluded++
+			continue
+		}
+		summary.Transactions++
+		if strings.ToLower(tx.TransactionType) == "credit" {
+			summary.Income += tx.Amount
+			continue
+		}
+
+		summary.Spending += tx.Amount
+		category, ok := categories[tx.Category]
+		if !ok {
+			category = &CategorySummary{Category: tx.Category}
+			categories[tx.Category] = category
+		}
+		category.Count++
+		category.Total += tx.Amount
+		if tx.BNPLProvider != "" {
+			category.addVia(tx.BNPLProvider, 1, tx.Amount)
+		}
+	}
+
+	summary.Income = roundPence(summary.Income)
+	summary.Spending = roundPence(summary.Spending)
+	summary.Categories = make([]CategorySummary, 0, len(categories))
+	for _, category := range categories {
+		category.roundTotals()
+		summary.Categories = append(summary.Categories, *category)
+	}
+	sort.Slice(summary.Categories, func(i, j int) bool {
+		return summary.Categories[i].Total > summary.Categories[j].Total
+	})
+	return summary
+}
+
+func (s *Server) handleSummary(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	view, err := requestView(c, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if view != nil {
+		// Views filter raw history, so their summaries bypass rollups, the cache and cash allocations
+		c.JSON(http.StatusOK, buildSummary(userID, view.Filter(s.store.ListTransactions(userID, from, to))))
+		return
+	}
+	c.JSON(http.StatusOK, summaries.Summary(s, userID, from, to))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxSummaryCacheEntries bounds the cache; past it expired entries are swept, and if that
+// isn't enough the cache is emptied
+const maxSummaryCacheEntries = 10000
+
+// summaryCacheEntry is a cached summary and when it stops being served
+type summaryCacheEntry struct {
+	summary   Summary
+	expiresAt time.Time
+}
+
+// summaryCache keeps recently built summaries for a short TTL. Each user has a generation
+// that every write bumps, and the cache has an epoch bumped when all history is replaced.
+// Both are part of the cache key, so a write makes the affected summaries unreachable
+// straight away, including ones being built while it happened.
+type summaryCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	entries     map[string]summaryCacheEntry
+	generations map[string]uint64
+	epoch       uint64
+}
+
+// newSummaryCache creates a cache serving entries for ttl; a zero ttl disables it
+func newSummaryCache(ttl time.Duration) *summaryCache {
+	return &summaryCache{
+		ttl:         ttl,
+		entries:     map[string]summaryCacheEntry{},
+		generations: map[string]uint64{},
+	}
+}
+
+// key identifies a user's summary over a range under a rule set version at their current
+// generation; callers hold c.mu
+func (c *summaryCache) key(userID string, from, to time.Time, rulesVersion string) string {
+	return fmt.Sprintf("%s|%d|%d|%s|%d.%d", userID, from.UnixNano(), to.UnixNano(), rulesVersion, c.epoch, c.generations[userID])
+}
+
+// Summary returns the user's summary over [from, to) from srv's history, building and caching
+// it on a miss
+func (c *summaryCache) Summary(srv *Server, userID string, from, to time.Time) Summary {
+	if c.ttl <= 0 {
+		return srv.loadSummary(userID, from, to)
+	}
+
+	c.mu.Lock()
+	key := c.key(userID, from, to, srv.activeRules().Version)
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		srv.metrics.recordSummaryCache("hit")
+		return entry.summary
+	}
+
+	srv.metrics.recordSummaryCache("miss")
+	summary := srv.loadSummary(userID, from, to)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxSummaryCacheEntries {
+		c.sweep()
+	}
+	c.entries[key] = summaryCacheEntry{summary: summary, expiresAt: time.Now().Add(c.ttl)}
+	return summary
+}
+
+// sweep drops expired entries, emptying the cache if it is still full; callers hold c.mu
+func (c *summaryCache) sweep() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) >= maxSummaryCacheEntries {
+		c.entries = map[string]summaryCacheEntry{}
+	}
+}
+
+// Stats reports the cache's size and settings
+func (c *summaryCache) Stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":     c.ttl > 0,
+		"ttl":         c.ttl.String(),
+		"entries":     len(c.entries),
+		"max_entries": maxSummaryCacheEntries,
+		"users":       len(c.generations),
+	}
+}
+
+// Invalidate makes every cached summary for a user stale
+func (c *summaryCache) Invalidate(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[userID]++
+}
+
+// Clear drops every cached summary, for when the whole history is replaced
+func (c *summaryCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]summaryCacheEntry{}
+	c.generations = map[string]uint64{}
+	c.epoch++
+}
+
+// Global summary cache, set up from the configuration at startup
+var summaries *summaryCache
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SummaryCard is a shareable snapshot of a user's month for client apps and wallet passes
+type SummaryCard struct {
+	UserID        string            `json:"user_id"`
+	Month         string            `json:"month"`
+	Title         string            `json:"title"`
+	Income        float64           `json:"income"`
+	Spending      float64           `json:"spending"`
+	Transactions  int               `json:"transactions"`
+	SpendingDelta *float64          `json:"spending_change_pct,omitempty"`
+	TopCategories []CategorySummary `json:"top_categories"`
+}
+
+// buildSummaryCard summarises a month and compares its spending with the month before
+func (s *Server) buildSummaryCard(userID string, month time.Time) SummaryCard {
+	current := summaries.Summary(s, userID, month, month.AddDate(0, 1, 0))
+	previous := summaries.Summary(s, userID, month.AddDate(0, -1, 0), month)
+
+	card := SummaryCard{
+		UserID:        userID,
+		Month:         month.Format(monthLayout),
+		Title:         month.Format("January 2006"),
+		Income:        current.Income,
+		Spending:      current.Spending,
+		Transactions:  current.Transactions,
+		TopCategories: current.Categories,
+	}
+	if len(card.TopCategories) > 3 {
+		card.TopCategories = card.TopCategories[:3]
+	}
+	if previous.Spending > 0 {
+		delta := math.Round((current.Spending-previous.Spending)/previous.Spending*1000) / 10
+		card.SpendingDelta = &delta
+	}
+	return card
+}
+
+// SummaryRenderer renders a summary card in one output format
+type SummaryRenderer interface {
+	ContentType() string
+	Render(w io.Writer, card SummaryCard) error
+}
+
+// summaryRenderers are the supported ?format= values; add PNG or PDF renderers here
+var summaryRenderers = map[string]SummaryRenderer{
+	"json": jsonSummaryRenderer{},
+	"svg":  svgSummaryRenderer{},
+}
+
+// jsonSummaryRenderer returns the card as JSON
+type jsonSummaryRenderer struct{}
+
+func (jsonSummaryRenderer) ContentType() string { return "application/json" }
+
+func (jsonSummaryRenderer) Render(w io.Writer, card SummaryCard) error {
+	return json.NewEncoder(w).Encode(card)
+}
+
+// svgSummaryRenderer draws the card as a simple SVG with a bar per top category
+type svgSummaryRenderer struct{}
+
+func (svgSummaryRenderer) ContentType() string { return "image/svg+xml" }
+
+func (svgSummaryRenderer) Render(w io.Writer, card SummaryCard) error {
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="400" height="240" viewBox="0 0 400 240" font-family="sans-serif">`)
+	b.WriteString(`<rect width="400" height="240" rx="16" fill="#14233c"/>`)
+	fmt.Fprintf(&b, `<text x="24" y="40" font-size="20" fill="#ffffff">%s</text>`, html.EscapeString(card.Title))
+	fmt.Fprintf(&b, `<text x="24" y="72" font-size="14" fill="#a9b4c6">Spent £%.2f · In £%.2f</text>`, card.Spending, card.Income)
+	if card.SpendingDelta != nil {
+		fmt.Fprintf(&b, `<text x="24" y="94" font-size="12" fill="#a9b4c6">%+.1f%% vs last month</text>`, *card.SpendingDelta)
+	}
+	for i, category := range card.TopCategories {
+		y := 124 + i*36
+		width := 0.0
+		if card.Spending > 0 {
+			width = category.Total / card.Spending * 352
+		}
+		fmt.Fprintf(&b, `<text x="24" y="%d" font-size="12" fill="#ffffff">%s · £%.2f</text>`, y, html.EscapeString(category.Category), category.Total)
+		fmt.Fprintf(&b, `<rect x="24" y="%d" width="%.1f" height="8" rx="4" fill="#ff4f40"/>`, y+6, width)
+	}
+	b.WriteString(`</svg>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// handleSummaryCard serves GET /summary/card?user_id=...&month=2024-05&format=json|svg,
+// defaulting to the previous month as JSON
+func (s *Server) handleSummaryCard(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	renderer, ok := summaryRenderers[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported card format %q", format)})
+		return
+	}
+
+	month := monthStart(time.Now()).AddDate(0, -1, 0)
+	if value := c.Query("month"); value != "" {
+		parsed, err := time.Parse(monthLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "month must be YYYY-MM"})
+			return
+		}
+		month = parsed
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", renderer.ContentType())
+	if err := renderer.Render(c.Writer, s.buildSummaryCard(userID, month)); err != nil {
+		c.Error(err)
+	}
+}
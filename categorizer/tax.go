@@ -0,0 +1,89 @@
+package main
+
+import "strings"
+
+// UK VAT treatments
+const (
+	TaxStandard     = "standard"
+	TaxReduced      = "reduced"
+	TaxZero         = "zero"
+	TaxExempt       = "exempt"
+	TaxOutsideScope = "outside_scope"
+)
+
+// TaxInfo is the estimated VAT treatment of a transaction
+type TaxInfo struct {
+	Treatment   string  `json:"treatment"`
+	Rate        float64 `json:"rate"`
+	VATEstimate float64 `json:"vat_estimate"`
+}
+
+// taxRates maps each treatment to its VAT rate
+var taxRates = map[string]float64{
+	TaxStandard:     0.20,
+	TaxReduced:      0.05,
+	TaxZero:         0,
+	TaxExempt:       0,
+	TaxOutsideScope: 0,
+}
+
+// categoryTaxTreatments is the default treatment for each category
+var categoryTaxTreatments = map[string]string{
+	"Income":            TaxOutsideScope,
+	"Transport":         TaxZero,
+	"Food & Drink":      TaxStandard,
+	"Shopping":          TaxStandard,
+	"Groceries":         TaxZero,
+	"Entertainment":     TaxStandard,
+	"Bills & Utilities": TaxReduced,
+	"ATM":               TaxOutsideScope,
+	"Housing":           TaxExempt,
+	"Donations":         TaxOutsideScope,
+	"Transfers":         TaxOutsideScope,
+	"Investments":       TaxExempt,
+	"Debt Repayments":   TaxOutsideScope,
+	"Other":             TaxStandard,
+}
+
+// merchantTaxTreatments overrides the category treatment for known merchants and keywords,
+// checked in order
+var merchantTaxTreatments = []struct {
+	keyword   string
+	treatment string
+}{
+	{"council tax", TaxOutsideScope},
+	{"wateraid", TaxOutsideScope},
+	{"insurance", TaxExempt},
+	{"water", TaxZero},
+	{"internet", TaxStandard},
+	{"phone", TaxStandard},
+	{"uber", TaxStandard},
+	{"taxi", TaxStandard},
+}
+
+// taxTreatmentFor picks the VAT treatment for a categorized transaction, preferring
+// merchant-level heuristics over the category default
+func taxTreatmentFor(category, merchant, description string) string {
+	merchantLower := strings.ToLower(merchant)
+	descriptionLower := strings.ToLower(description)
+	for _, m := range merchantTaxTreatments {
+		if strings.Contains(merchantLower, m.keyword) || strings.Contains(descriptionLower, m.keyword) {
+			return m.treatment
+		}
+	}
+	if treatment, ok := categoryTaxTreatments[category]; ok {
+		return treatment
+	}
+	return TaxStandard
+}
+
+// taxInfoFor estimates the VAT contained in a VAT-inclusive amount
+func taxInfoFor(category, merchant, description string, amount float64) TaxInfo {
+	treatment := taxTreatmentFor(category, merchant, description)
+	rate := taxRates[treatment]
+	return TaxInfo{
+		Treatment:   treatment,
+		Rate:        rate,
+		VATEstimate: roundPence(includedVAT(amount, rate)),
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TaxonomyCode is the code a category maps to in an external taxonomy
+type TaxonomyCode struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// defaultTaxonomies map our categories onto external taxonomies: Plaid's personal finance
+// categories (detailed level), card scheme MCC groups and UK SIC 2007 sections. Categories a
+// taxonomy has no sensible code for are left out of it.
+var defaultTaxonomies = map[string]map[string]TaxonomyCode{
+	"plaid_pfc": {
+		"Income":            {Code: "INCOME_OTHER_INCOME", Name: "Other Income"},
+		"Transport":         {Code: "TRANSPORTATION_OTHER_TRANSPORTATION", Name: "Other Transportation"},
+		"Food & Drink":      {Code: "FOOD_AND_DRINK_RESTAURANT", Name: "Restaurant"},
+		"Shopping":          {Code: "GENERAL_MERCHANDISE_OTHER_GENERAL_MERCHANDISE", Name: "Other General Merchandise"},
+		"Groceries":         {Code: "FOOD_AND_DRINK_GROCERIES", Name: "Groceries"},
+		"Entertainment":     {Code: "ENTERTAINMENT_OTHER_ENTERTAINMENT", Name: "Other Entertainment"},
+		"Bills & Utilities": {Code: "RENT_AND_UTILITIES_OTHER_UTILITIES", Name: "Other Utilities"},
+		"ATM":               {Code: "TRANSFER_OUT_WITHDRAWAL", Name: "Withdrawal"},
+		"Housing":           {Code: "RENT_AND_UTILITIES_RENT", Name: "Rent"},
+		"Fees":              {Code: "BANK_FEES_OTHER_BANK_FEES", Name: "Other Bank Fees"},
+		"Card Verification": {Code: "BANK_FEES_OTHER_BANK_FEES", Name: "Other Bank Fees"},
+		"Donations":         {Code: "GOVERNMENT_AND_NON_PROFIT_DONATIONS", Name: "Donations"},
+		"Transfers":         {Code: "TRANSFER_IN_ACCOUNT_TRANSFER", Name: "Account Transfer"},
+		"Investments":       {Code: "TRANSFER_OUT_INVESTMENT_AND_RETIREMENT_FUNDS", Name: "Investment and Retirement Funds"},
+		"Debt Repayments":   {Code: "LOAN_PAYMENTS_OTHER_PAYMENT", Name: "Other Payment"},
+	},
+	"mcc_group": {
+		"Transport":         {Code: "4000-4799", Name: "Transportation Services"},
+		"Food & Drink":      {Code: "5811-5814", Name: "Eating Places and Restaurants"},
+		"Shopping":          {Code: "5200-5999", Name: "Retail Outlet Services"},
+		"Groceries":         {Code: "5411", Name: "Grocery Stores and Supermarkets"},
+		"Entertainment":     {Code: "7800-7999", Name: "Amusement and Entertainment"},
+		"Bills & Utilities": {Code: "4900", Name: "Utilities"},
+		"ATM":               {Code: "6011", Name: "Automated Cash Disbursements"},
+		"Housing":           {Code: "6513", Name: "Real Estate Agents and Managers - Rentals"},
+		"Fees":              {Code: "6012", Name: "Financial Institutions"},
+		"Card Verification": {Code: "6012", Name: "Financial Institutions"},
+		"Donations":         {Code: "8398", Name: "Charitable and Social Service Organizations"},
+		"Investments":       {Code: "6211", Name: "Security Brokers/Dealers"},
+		"Debt Repayments":   {Code: "6012", Name: "Financial Institutions"},
+	},
+	"uk_sic": {
+		"Transport":         {Code: "H", Name: "Transportation and storage"},
+		"Food & Drink":      {Code: "I", Name: "Accommodation and food service activities"},
+		"Shopping":          {Code: "G", Name: "Wholesale and retail trade"},
+		"Groceries":         {Code: "G", Name: "Wholesale and retail trade"},
+		"Entertainment":     {Code: "R", Name: "Arts, entertainment and recreation"},
+		"Bills & Utilities": {Code: "D", Name: "Electricity, gas, steam and air conditioning supply"},
+		"ATM":               {Code: "K", Name: "Financial and insurance activities"},
+		"Housing":           {Code: "L", Name: "Real estate activities"},
+		"Fees":              {Code: "K", Name: "Financial and insurance activities"},
+		"Card Verification": {Code: "K", Name: "Financial and insurance activities"},
+		"Donations":         {Code: "S", Name: "Other service activities"},
+		"Investments":       {Code: "K", Name: "Financial and insurance activities"},
+		"Debt Repayments":   {Code: "K", Name: "Financial and insurance activities"},
+	},
+}
+
+// taxonomies are the active category mappings, by taxonomy name
+var taxonomies = defaultTaxonomies
+
+// loadTaxonomies overlays mappings from a JSON file of taxonomy name to category to code onto
+// the defaults. A file can extend a built-in taxonomy or add a new one.
+func loadTaxonomies(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	overrides := map[string]map[string]TaxonomyCode{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	merged := make(map[string]map[string]TaxonomyCode, len(defaultTaxonomies)+len(overrides))
+	for name, mapping := range defaultTaxonomies {
+		merged[name] = make(map[string]TaxonomyCode, len(mapping))
+		for category, code := range mapping {
+			merged[name][category] = code
+		}
+	}
+	for name, mapping := range overrides {
+		if merged[name] == nil {
+			merged[name] = make(map[string]TaxonomyCode, len(mapping))
+		}
+		for category, code := range mapping {
+			if code.Code == "" {
+				return fmt.Errorf("taxonomy %q maps category %q to an empty code", name, category)
+			}
+			merged[name][category] = code
+		}
+	}
+
+	taxonomies = merged
+	return nil
+}
+
+// taxonomyCodesFor returns a category's code in every taxonomy that maps it
+func taxonomyCodesFor(category string) map[string]TaxonomyCode {
+	codes := map[string]TaxonomyCode{}
+	for name, mapping := range taxonomies {
+		if code, ok := mapping[category]; ok {
+			codes[name] = code
+		}
+	}
+	return codes
+}
+
+// handleListTaxonomies serves GET /taxonomies with every taxonomy and its category mappings
+func (s *Server) handleListTaxonomies(c *gin.Context) {
+	names := make([]string, 0, len(taxonomies))
+	for name := range taxonomies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, gin.H{"names": names, "taxonomies": taxonomies})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant taxonomy actions
+const (
+	TaxonomyRename = "rename"
+	TaxonomyHide   = "hide"
+	TaxonomyAdd    = "add"
+)
+
+// TenantCategory customizes a tenant's view of the base taxonomy: rename shows a base
+// category under the tenant's Name, hide folds a base category into Other, and add creates a
+// category of the tenant's own under the base category it belongs to. Every tenant category
+// maps back onto a base category, so history, metrics and global analytics stay canonical.
+type TenantCategory struct {
+	TenantID  string    `json:"tenant_id"`
+	Action    string    `json:"action" binding:"required,oneof=rename hide add"`
+	Category  string    `json:"category" binding:"required"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// key identifies a customization within its tenant: the base category renamed or hidden, or
+// the added category's name
+func (t TenantCategory) key() string {
+	if t.Action == TaxonomyAdd {
+		return t.Name
+	}
+	return t.Category
+}
+
+// TenantTaxonomyEntry is one category as a tenant sees it
+type TenantTaxonomyEntry struct {
+	Name      string `json:"name"`
+	Canonical string `json:"canonical"`
+	Renamed   bool   `json:"renamed,omitempty"`
+	Hidden    bool   `json:"hidden,omitempty"`
+	Custom    bool   `json:"custom,omitempty"`
+}
+
+// tenantTaxonomyStore keeps each tenant's customizations in memory, by key
+type tenantTaxonomyStore struct {
+	mu         sync.RWMutex
+	categories map[string]map[string]TenantCategory
+}
+
+// newTenantTaxonomyStore creates an empty store
+func newTenantTaxonomyStore() *tenantTaxonomyStore {
+	return &tenantTaxonomyStore{categories: map[string]map[string]TenantCategory{}}
+}
+
+// validate checks a customization against the base categories and the tenant's other
+// customizations: names must be new, and Other can be renamed but not hidden
+func (s *tenantTaxonomyStore) validate(t TenantCategory, base map[string]bool) error {
+	switch {
+	case t.TenantID == "":
+		return errors.New("tenant_id is required")
+	case !base[t.Category]:
+		return fmt.Errorf("unknown category %q", t.Category)
+	case t.Action == TaxonomyHide && t.Name != "":
+		return errors.New("hide doesn't take a name")
+	case t.Action == TaxonomyHide && t.Category == "Other":
+		return errors.New("Other can't be hidden; hidden categories fold into it")
+	case t.Action != TaxonomyHide && t.Name == "":
+		return fmt.Errorf("%s needs a name", t.Action)
+	case t.Action != TaxonomyHide && base[t.Name]:
+		return fmt.Errorf("%q is a base category", t.Name)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, existing := range s.categories[t.TenantID] {
+		if key != t.key() && existing.Name != "" && existing.Name == t.Name {
+			return fmt.Errorf("tenant already has a category named %q", t.Name)
+		}
+	}
+	return nil
+}
+
+// Put creates or replaces a customization. A base category is either renamed or hidden, so
+// one replaces the other.
+func (s *tenantTaxonomyStore) Put(t TenantCategory) TenantCategory {
+	t.CreatedAt = time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.categories[t.TenantID] == nil {
+		s.categories[t.TenantID] = map[string]TenantCategory{}
+	}
+	s.categories[t.TenantID][t.key()] = t
+	return t
+}
+
+// Delete removes the customization of a base category, or a category the tenant added,
+// reporting whether it existed
+func (s *tenantTaxonomyStore) Delete(tenantID, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.categories[tenantID][key]; !ok {
+		return false
+	}
+	delete(s.categories[tenantID], key)
+	return true
+}
+
+// List returns a tenant's customizations sorted by key
+func (s *tenantTaxonomyStore) List(tenantID string) []TenantCategory {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]TenantCategory, 0, len(s.categories[tenantID]))
+	for _, t := range s.categories[tenantID] {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+	return list
+}
+
+// Canonical maps a category a tenant may use, renamed or added, onto its base category.
+// Base categories map onto themselves.
+func (s *tenantTaxonomyStore) Canonical(tenantID, category string) string {
+	if tenantID == "" {
+		return category
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.categories[tenantID] {
+		if t.Name != "" && t.Name == category {
+			return t.Category
+		}
+	}
+	return category
+}
+
+// Display returns the name a tenant shows a category under: a renamed base category's new
+// name, Other for a hidden one, and everything else as it is
+func (s *tenantTaxonomyStore) Display(tenantID, category string) string {
+	if tenantID == "" {
+		return category
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.display(tenantID, category)
+}
+
+// display implements Display. Callers must hold the lock.
+func (s *tenantTaxonomyStore) display(tenantID, category string) string {
+	t, ok := s.categories[tenantID][category]
+	switch {
+	case !ok || t.Action == TaxonomyAdd:
+		return category
+	case t.Action == TaxonomyHide:
+		return s.display(tenantID, "Other")
+	}
+	return t.Name
+}
+
+// Categories returns the names a tenant may categorize into: the base categories, their new
+// names and the categories the tenant added
+func (s *tenantTaxonomyStore) Categories(tenantID string, base map[string]bool) map[string]bool {
+	categories := make(map[string]bool, len(base))
+	for category := range base {
+		categories[category] = true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.categories[tenantID] {
+		if t.Name != "" {
+			categories[t.Name] = true
+		}
+	}
+	return categories
+}
+
+// View returns the tenant's taxonomy: every base category under the name the tenant shows it,
+// then the categories it added, each with the base category it maps onto
+func (s *tenantTaxonomyStore) View(tenantID string, base map[string]bool) []TenantTaxonomyEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]TenantTaxonomyEntry, 0, len(base)+len(s.categories[tenantID]))
+	for category := range base {
+		entry := TenantTaxonomyEntry{Name: category, Canonical: category}
+		if t, ok := s.categories[tenantID][category]; ok {
+			entry.Name = s.display(tenantID, category)
+			entry.Renamed = t.Action == TaxonomyRename
+			entry.Hidden = t.Action == TaxonomyHide
+		}
+		entries = append(entries, entry)
+	}
+	for _, t := range s.categories[tenantID] {
+		if t.Action == TaxonomyAdd {
+			entries = append(entries, TenantTaxonomyEntry{Name: t.Name, Canonical: t.Category, Custom: true})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Custom != entries[j].Custom {
+			return !entries[i].Custom
+		}
+		return entries[i].Canonical+"|"+entries[i].Name < entries[j].Canonical+"|"+entries[j].Name
+	})
+	return entries
+}
+
+// Snapshot returns a copy of every tenant's customizations
+func (s *tenantTaxonomyStore) Snapshot() map[string][]TenantCategory {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string][]TenantCategory, len(s.categories))
+	for tenantID, categories := range s.categories {
+		for _, t := range categories {
+			snapshot[tenantID] = append(snapshot[tenantID], t)
+		}
+	}
+	return snapshot
+}
+
+// Restore replaces every tenant's customizations
+func (s *tenantTaxonomyStore) Restore(snapshot map[string][]TenantCategory) {
+	categories := make(map[string]map[string]TenantCategory, len(snapshot))
+	for tenantID, list := range snapshot {
+		categories[tenantID] = make(map[string]TenantCategory, len(list))
+		for _, t := range list {
+			categories[tenantID][t.key()] = t
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.categories = categories
+}
+
+// Global tenant taxonomy customizations
+var tenantTaxonomies = newTenantTaxonomyStore()
+
+// tenantCategoryScores shows scored categories under the tenant's names, keeping the best
+// score where hidden categories fold into one another
+func tenantCategoryScores(tenantID string, scores []CategoryScore) []CategoryScore {
+	if tenantID == "" || len(scores) == 0 {
+		return scores
+	}
+	shown := make([]CategoryScore, 0, len(scores))
+	seen := map[string]bool{}
+	for _, cs := range scores {
+		cs.Category = tenantTaxonomies.Display(tenantID, cs.Category)
+		if !seen[cs.Category] {
+			seen[cs.Category] = true
+			shown = append(shown, cs)
+		}
+	}
+	return shown
+}
+
+// handlePutTenantCategory serves PUT /tenants/:tenant_id/taxonomy
+func (s *Server) handlePutTenantCategory(c *gin.Context) {
+	var t TenantCategory
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.TenantID = c.Param("tenant_id")
+	if err := tenantTaxonomies.validate(t, s.knownCategories()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t = tenantTaxonomies.Put(t)
+	s.recordAudit(callerName(c), "taxonomy."+t.Action, t.TenantID, map[string]interface{}{
+		"category": t.Category,
+		"name":     t.Name,
+	})
+	c.JSON(http.StatusOK, t)
+}
+
+// handleGetTenantTaxonomy serves GET /tenants/:tenant_id/taxonomy
+func (s *Server) handleGetTenantTaxonomy(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id":      tenantID,
+		"categories":     tenantTaxonomies.View(tenantID, s.knownCategories()),
+		"customizations": tenantTaxonomies.List(tenantID),
+	})
+}
+
+// handleDeleteTenantCategory serves DELETE /tenants/:tenant_id/taxonomy?category=, restoring a
+// renamed or hidden base category or removing an added one
+func (s *Server) handleDeleteTenantCategory(c *gin.Context) {
+	tenantID, category := c.Param("tenant_id"), c.Query("category")
+	if !tenantTaxonomies.Delete(tenantID, category) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "category is not customized"})
+		return
+	}
+	s.recordAudit(callerName(c), "taxonomy.reset", tenantID, map[string]interface{}{"category": category})
+	c.Status(http.StatusNoContent)
+}
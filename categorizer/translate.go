@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// translationTarget is the language keywords are written in
+const translationTarget = "en"
+
+// maxTranslationCache bounds the cached translations; the oldest are evicted first
+const maxTranslationCache = 10000
+
+// Translation failures
+const (
+	// translationRetryAfter is how long a descriptor that failed to translate waits before
+	// it is sent to the provider again
+	translationRetryAfter = 10 * time.Minute
+	// translationBreakerFailures consecutive failures stop all translation for
+	// translationBreakerCooldown, after which one request tries the provider again
+	translationBreakerFailures = 5
+	translationBreakerCooldown = 30 * time.Second
+)
+
+// errTranslationSkipped is returned when a translation isn't attempted because it failed
+// recently or the provider is failing
+var errTranslationSkipped = errors.New("translation skipped after recent failures")
+
+// languageWords are common words of descriptors in each detectable language. Detection is a
+// vote between them, good enough to tell a French bakery from an English one, not to
+// identify arbitrary text.
+var languageWords = map[string][]string{
+	"en": {"the", "and", "of", "shop", "store", "bakery", "pharmacy", "restaurant", "coffee", "station", "payment", "ltd"},
+	"fr": {"le", "la", "les", "du", "des", "et", "boulangerie", "pharmacie", "tabac", "epicerie", "épicerie", "librairie", "marché", "gare", "péage", "supermarché", "sarl"},
+	"de": {"der", "die", "das", "und", "bäckerei", "baeckerei", "apotheke", "bahnhof", "tankstelle", "buchhandlung", "markt", "gmbh", "zahlung"},
+	"es": {"el", "los", "las", "del", "y", "panadería", "panaderia", "farmacia", "supermercado", "estación", "gasolinera", "librería", "tienda"},
+	"it": {"il", "lo", "gli", "della", "e", "panetteria", "farmacia", "supermercato", "stazione", "tabacchi", "libreria", "negozio", "srl"},
+	"nl": {"de", "het", "en", "van", "bakkerij", "apotheek", "supermarkt", "station", "tankstation", "boekhandel", "winkel"},
+	"pt": {"o", "os", "da", "do", "e", "padaria", "farmácia", "farmacia", "supermercado", "estação", "posto", "livraria", "loja"},
+}
+
+// languageOf maps each word to the languages it belongs to
+var languageOf = func() map[string][]string {
+	index := map[string][]string{}
+	for language, words := range languageWords {
+		for _, word := range words {
+			index[word] = append(index[word], language)
+		}
+	}
+	return index
+}()
+
+// detectLanguage returns the language of lowercased descriptor text, or "" when no language
+// clearly wins. Words shared between languages count for each.
+func detectLanguage(text string) string {
+	votes := map[string]int{}
+	for _, token := range tokenize(text) {
+		for _, language := range languageOf[token] {
+			votes[language]++
+		}
+	}
+	best, bestVotes, tied := "", 0, false
+	for language, n := range votes {
+		switch {
+		case n > bestVotes:
+			best, bestVotes, tied = language, n, false
+		case n == bestVotes:
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return best
+}
+
+// Translator translates descriptor text into the keywords' language
+type Translator interface {
+	Name() string
+	Translate(text, source, target string) (string, error)
+}
+
+// newTranslator returns the configured translation provider, or nil when translation is off
+func newTranslator(cfg Config) (Translator, error) {
+	switch cfg.TranslationProvider {
+	case "":
+		return nil, nil
+	case "libretranslate":
+		if cfg.TranslationURL == "" {
+			return nil, fmt.Errorf("%s translation provider requires TRANSLATION_URL", cfg.TranslationProvider)
+		}
+		return libreTranslator{url: strings.TrimRight(cfg.TranslationURL, "/"), apiKey: cfg.TranslationAPIKey,
+			client: &http.Client{Timeout: cfg.TranslationTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unknown translation provider %q", cfg.TranslationProvider)
+}
+
+// libreTranslator calls a LibreTranslate-compatible /translate endpoint
+type libreTranslator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (libreTranslator) Name() string { return "libretranslate" }
+
+func (t libreTranslator) Translate(text, source, target string) (string, error) {
+	body, err := json.Marshal(map[string]string{"q": text, "source": source, "target": target, "format": "text", "api_key": t.apiKey})
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Post(t.url+"/translate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("translate: %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("translate: %w", err)
+	}
+	return result.TranslatedText, nil
+}
+
+// Translation provider for the translate stage; nil when translation is off
+var translator Translator
+
+// translationCache keeps translations by descriptor hash, so a descriptor seen before isn't
+// sent to the provider again. Failures are kept too, for a while, along with a circuit
+// breaker that stops calling a provider that keeps failing.
+type translationCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+	order   []string
+	// failed holds when each descriptor that failed to translate may be retried
+	failed map[string]time.Time
+	// failures counts consecutive provider failures; openUntil is when the breaker they
+	// opened lets a request through again, and probing that one is in flight
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// newTranslationCache creates an empty cache
+func newTranslationCache() *translationCache {
+	return &translationCache{entries: map[string]string{}, failed: map[string]time.Time{}}
+}
+
+// Allow reports whether a descriptor should be sent to the provider at now: not while its
+// last failure is recent, nor while the breaker is open other than for a single probe once
+// the cooldown is over
+func (tc *translationCache) Allow(key string, now time.Time) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if now.Before(tc.failed[key]) {
+		return false
+	}
+	if tc.failures < translationBreakerFailures {
+		return true
+	}
+	if tc.probing || now.Before(tc.openUntil) {
+		return false
+	}
+	tc.probing = true
+	return true
+}
+
+// Fail records a failed translation at now, reporting whether it opened the breaker
+func (tc *translationCache) Fail(key string, now time.Time) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if len(tc.failed) >= maxTranslationCache {
+		for k, retry := range tc.failed {
+			if !now.Before(retry) {
+				delete(tc.failed, k)
+			}
+		}
+		if len(tc.failed) >= maxTranslationCache {
+			tc.failed = map[string]time.Time{}
+		}
+	}
+	tc.failed[key] = now.Add(translationRetryAfter)
+	tc.failures++
+	tc.probing = false
+	if tc.failures < translationBreakerFailures {
+		return false
+	}
+	tc.openUntil = now.Add(translationBreakerCooldown)
+	return true
+}
+
+// descriptorHash keys a descriptor's translation from one language
+func descriptorHash(text, source string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns a cached translation
+func (tc *translationCache) Get(key string) (string, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	translated, ok := tc.entries[key]
+	return translated, ok
+}
+
+// Put caches a translation, evicting the oldest when full
+func (tc *translationCache) Put(key, translated string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if _, ok := tc.entries[key]; ok {
+		return
+	}
+	if len(tc.order) >= maxTranslationCache {
+		delete(tc.entries, tc.order[0])
+		tc.order = tc.order[1:]
+	}
+	tc.entries[key] = translated
+	tc.order = append(tc.order, key)
+	delete(tc.failed, key)
+	tc.failures, tc.probing = 0, false
+}
+
+// Global descriptor translations
+var translations = newTranslationCache()
+
+// translateDescriptor translates lowercased text from source through the provider, using the
+// cache when it can. Descriptors that failed recently, and every descriptor while the
+// provider keeps failing, are skipped rather than holding up categorization.
+func (s *Server) translateDescriptor(t Translator, text, source string) (string, error) {
+	key := descriptorHash(text, source)
+	if translated, ok := translations.Get(key); ok {
+		s.metrics.recordTranslation(source, "cached")
+		return translated, nil
+	}
+	if !translations.Allow(key, time.Now()) {
+		s.metrics.recordTranslation(source, "skipped")
+		return "", errTranslationSkipped
+	}
+	translated, err := t.Translate(text, source, translationTarget)
+	if err != nil {
+		s.metrics.recordTranslation(source, "failed")
+		if translations.Fail(key, time.Now()) {
+			s.logger.Warn("Translation provider failing, pausing translation", map[string]interface{}{
+				"event_type":    "translation_paused",
+				"provider":      t.Name(),
+				"error_message": err.Error(),
+			})
+		}
+		return "", err
+	}
+	translated = strings.ToLower(strings.TrimSpace(translated))
+	translations.Put(key, translated)
+	s.metrics.recordTranslation(source, "translated")
+	return translated, nil
+}
+
+// translateStage detects the language of the description and, when a translation provider is
+// configured, translates non-English descriptions so English keywords can match them. The
+// original description is kept after the translation so keywords in either language match.
+type translateStage struct {
+	s *Server
+}
+
+func (translateStage) Name() string { return "translate" }
+
+func (st translateStage) Process(cl *Classification) {
+	if cl.Decided || cl.NormalizedDescription == "" {
+		return
+	}
+	cl.Language = detectLanguage(cl.NormalizedDescription)
+	if translator == nil || cl.Language == "" || cl.Language == translationTarget {
+		return
+	}
+	translated, err := st.s.translateDescriptor(translator, cl.NormalizedDescription, cl.Language)
+	if errors.Is(err, errTranslationSkipped) {
+		return
+	}
+	if err != nil {
+		st.s.logger.Warn("Description translation failed", map[string]interface{}{
+			"event_type":    "translation_failed",
+			"provider":      translator.Name(),
+			"language":      cl.Language,
+			"error_message": err.Error(),
+		})
+		return
+	}
+	if translated != "" && translated != cl.NormalizedDescription {
+		cl.NormalizedDescription = translated + " " + cl.NormalizedDescription
+	}
+}
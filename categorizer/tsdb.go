@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// TSDBSample is one point of a business-metric time series
+type TSDBSample struct {
+	Metric    string
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// TSDBExporter pushes samples to a time-series database
+type TSDBExporter interface {
+	Export(samples []TSDBSample) error
+}
+
+// newTSDBExporter returns the configured exporter, or nil when TSDB export is off
+func newTSDBExporter(cfg Config) (TSDBExporter, error) {
+	switch cfg.TSDBExporter {
+	case "":
+		return nil, nil
+	case "remote_write", "influxdb":
+		if cfg.TSDBURL == "" {
+			return nil, fmt.Errorf("%s TSDB exporter requires TSDB_URL", cfg.TSDBExporter)
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		if cfg.TSDBExporter == "influxdb" {
+			return influxExporter{url: cfg.TSDBURL, token: cfg.TSDBToken, client: client}, nil
+		}
+		return remoteWriteExporter{url: cfg.TSDBURL, token: cfg.TSDBToken, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown TSDB exporter %q", cfg.TSDBExporter)
+}
+
+// TSDB exporter for business metrics; nil when disabled
+var tsdbExporter TSDBExporter
+
+// aggregateSamples turns an anonymized aggregate snapshot into per-category time series.
+// Only the noised, cohort-filtered statistics are exported, never per-user spending.
+func aggregateSamples(snapshot AggregateSnapshot) []TSDBSample {
+	samples := []TSDBSample{{
+		Metric:    "categorizer_aggregate_categories_suppressed",
+		Labels:    map[string]string{"month": snapshot.Month},
+		Value:     float64(snapshot.Suppressed),
+		Timestamp: snapshot.GeneratedAt,
+	}}
+	for _, stat := range snapshot.Categories {
+		labels := map[string]string{"month": snapshot.Month, "category": stat.Category}
+		samples = append(samples,
+			TSDBSample{Metric: "categorizer_category_mean_spend_gbp", Labels: labels, Value: stat.Mean, Timestamp: snapshot.GeneratedAt},
+			TSDBSample{Metric: "categorizer_category_spenders", Labels: labels, Value: float64(stat.Spenders), Timestamp: snapshot.GeneratedAt},
+		)
+	}
+	return samples
+}
+
+// exportAggregates pushes aggregate snapshots to the TSDB if an exporter is configured
+func (s *Server) exportAggregates(snapshots []AggregateSnapshot) {
+	if tsdbExporter == nil {
+		return
+	}
+	var samples []TSDBSample
+	for _, snapshot := range snapshots {
+		samples = append(samples, aggregateSamples(snapshot)...)
+	}
+	if err := tsdbExporter.Export(samples); err != nil {
+		s.metrics.recordTSDBExport("error")
+		s.logger.Warn("TSDB export failed", map[string]interface{}{
+			"event_type":    "tsdb_export_failed",
+			"error_message": err.Error(),
+		})
+		return
+	}
+	s.metrics.recordTSDBExport("ok")
+}
+
+// postTSDB sends an encoded batch and checks for a 2xx answer
+func postTSDB(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("TSDB returned %s", resp.Status)
+	}
+	return nil
+}
+
+// remoteWriteExporter pushes samples with the Prometheus remote-write protocol
+type remoteWriteExporter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (e remoteWriteExporter) Export(samples []TSDBSample) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(snappyEncode(encodeWriteRequest(samples))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	return postTSDB(e.client, req)
+}
+
+// encodeWriteRequest encodes samples as a remote-write WriteRequest protobuf, one series per
+// sample with labels sorted by name as the protocol requires
+func encodeWriteRequest(samples []TSDBSample) []byte {
+	var request []byte
+	for _, s := range samples {
+		labels := map[string]string{"__name__": s.Metric}
+		for name, value := range s.Labels {
+			labels[name] = value
+		}
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, labels[name])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp.UnixMilli()))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
+
+// snappyEncode wraps data in a snappy block made only of literals. It doesn't compress,
+// but any snappy decoder reads it, and batches are small enough not to matter.
+func snappyEncode(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		// Literal tag 61: the length minus one follows in two little-endian bytes
+		out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+// influxExporter pushes samples as InfluxDB line protocol to a write endpoint, e.g.
+// http://influx:8086/api/v2/write?org=ORG&bucket=BUCKET&precision=ms
+type influxExporter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (e influxExporter) Export(samples []TSDBSample) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, strings.NewReader(encodeLineProtocol(samples)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	return postTSDB(e.client, req)
+}
+
+// lineProtocolEscaper escapes measurement names and tag keys and values
+var lineProtocolEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// encodeLineProtocol formats samples as InfluxDB line protocol with millisecond timestamps
+func encodeLineProtocol(samples []TSDBSample) string {
+	var b strings.Builder
+	for _, s := range samples {
+		b.WriteString(lineProtocolEscaper.Replace(s.Metric))
+		names := make([]string, 0, len(s.Labels))
+		for name := range s.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.WriteString("," + lineProtocolEscaper.Replace(name) + "=" + lineProtocolEscaper.Replace(s.Labels[name]))
+		}
+		b.WriteString(" value=" + strconv.FormatFloat(s.Value, 'f', -1, 64))
+		b.WriteString(" " + strconv.FormatInt(s.Timestamp.UnixMilli(), 10) + "\n")
+	}
+	return b.String()
+}
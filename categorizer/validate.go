@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationReport collects the problems found validating config or rules. Errors fail the
+// validation; warnings are printed but don't.
+type ValidationReport struct {
+	Errors   []string
+	Warnings []string
+}
+
+// errorf records a problem that fails validation
+func (r *ValidationReport) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// warnf records a problem worth fixing that doesn't fail validation
+func (r *ValidationReport) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// check records err as a validation error, if there is one
+func (r *ValidationReport) check(what string, err error) {
+	if err != nil {
+		r.errorf("%s: %v", what, err)
+	}
+}
+
+// print writes the report and returns an error when it has any errors
+func (r *ValidationReport) print(subject string) error {
+	for _, e := range r.Errors {
+		fmt.Printf("error: %s\n", e)
+	}
+	for _, w := range r.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	if len(r.Errors) > 0 {
+		return fmt.Errorf("%d error(s), %d warning(s)", len(r.Errors), len(r.Warnings))
+	}
+	fmt.Printf("ok: %s valid (%d warning(s))\n", subject, len(r.Warnings))
+	return nil
+}
+
+// validateURL checks that a configured URL is absolute http(s)
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return nil
+}
+
+// validateConfig checks the configuration the way startup would use it, loading every
+// referenced file and checking URLs, addresses and intervals
+func validateConfig(cfg Config) ValidationReport {
+	var report ValidationReport
+	for _, env := range invalidEnv {
+		report.errorf("invalid value %s", env)
+	}
+
+	files := []struct {
+		env, path string
+		load      func(string) error
+	}{
+		{"RULES_FILE", cfg.RulesFile, checkRulesFile},
+		{"ACCOUNTING_CODES_FILE", cfg.AccountingCodesFile, loadChartOfAccounts},
+		{"CARBON_FACTORS_FILE", cfg.CarbonFactorsFile, loadCarbonFactors},
+		{"CASHBACK_OFFERS_FILE", cfg.CashbackOffersFile, loadCashbackOffers},
+		{"INCOME_RULES_FILE", cfg.IncomeRulesFile, loadIncomeRules},
+		{"TAXONOMY_MAPPINGS_FILE", cfg.TaxonomyFile, loadTaxonomies},
+		{"NOISE_PATTERNS_FILE", cfg.NoisePatternsFile, loadNoisePatterns},
+		{"DIGEST_TEMPLATES_DIR", cfg.DigestTemplatesDir, loadDigestTemplates},
+	}
+	for _, f := range files {
+		if f.path != "" {
+			report.check(f.env, f.load(f.path))
+		}
+	}
+
+	_, err := newPipeline(cfg.PipelineStages, nil)
+	report.check("PIPELINE_STAGES", err)
+	_, err = newEmailSender(cfg, nil)
+	report.check("DIGEST_PROVIDER", err)
+	_, err = newTSDBExporter(cfg)
+	report.check("TSDB_EXPORTER", err)
+	_, err = newTranslator(cfg)
+	report.check("TRANSLATION_PROVIDER", err)
+	_, err = newCategorizer(cfg)
+	report.check("CLASSIFIER", err)
+	_, err = newBankProviders(cfg.BankProviders, cfg, nil)
+	report.check("BANK_PROVIDERS", err)
+
+	if cfg.RoundUpPotID != "" && (cfg.MonzoAccessToken == "" || cfg.MonzoAccountID == "") {
+		report.errorf("ROUNDUP_POT_ID requires MONZO_ACCESS_TOKEN and MONZO_ACCOUNT_ID")
+	}
+	if err := checkEnvironment(cfg); err != nil {
+		report.errorf("%v", err)
+	}
+	report.check("MONZO_API_URL", validateURL(cfg.MonzoAPIURL))
+	report.check("MONZO_AUTH_URL", validateURL(cfg.MonzoAuthURL))
+	if cfg.MonzoRedirectURL != "" {
+		report.check("MONZO_REDIRECT_URL", validateURL(cfg.MonzoRedirectURL))
+	}
+	if cfg.MonzoWebhookURL != "" {
+		report.check("MONZO_WEBHOOK_URL", validateURL(cfg.MonzoWebhookURL))
+	}
+	if cfg.MonzoTokenFile != "" && cfg.MonzoClientID == "" {
+		report.warnf("MONZO_TOKEN_FILE is set without MONZO_CLIENT_ID, so Monzo tokens can't be refreshed")
+	}
+	if cfg.TSDBURL != "" {
+		report.check("TSDB_URL", validateURL(cfg.TSDBURL))
+	}
+	for _, u := range cfg.WebhookURLs {
+		report.check("WEBHOOK_URLS", validateURL(u))
+	}
+	for _, u := range cfg.PeerURLs {
+		report.check("PEER_URLS", validateURL(u))
+	}
+	if len(cfg.PeerURLs) > 0 && cfg.ReplicationToken == "" {
+		report.errorf("PEER_URLS requires REPLICATION_TOKEN")
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			report.errorf("TRUSTED_PROXIES: %q is neither an IP address nor a CIDR range", proxy)
+		}
+	}
+	switch {
+	case cfg.InsecureNoAuth:
+		report.warnf("INSECURE_NO_AUTH is set, so the API and admin endpoints accept unauthenticated requests")
+	case len(parseAPIKeys(cfg.APIKeys)) == 0:
+		report.errorf("API_KEYS is not set, so the API refuses every request; set INSECURE_NO_AUTH for local development")
+	case len(parseAPIKeys(cfg.AdminAPIKeys)) == 0:
+		report.errorf("ADMIN_API_KEYS is not set, so admin endpoints refuse every request; set INSECURE_NO_AUTH for local development")
+	}
+	if cfg.MonzoRateLimit <= 0 {
+		report.warnf("MONZO_RATE_LIMIT_RPS is not positive, so Monzo API calls are only held back after a 429")
+	}
+	if cfg.RateLimitRPS <= 0 {
+		report.warnf("RATE_LIMIT_RPS is not positive, so the API isn't rate limited")
+	}
+
+	if cfg.SignConvention != SignTyped && cfg.SignConvention != SignSigned {
+		report.errorf("AMOUNT_SIGN_CONVENTION: unknown sign convention %q", cfg.SignConvention)
+	}
+
+	switch cfg.MetricsExporter {
+	case "", "prometheus":
+	case "dogstatsd":
+		_, err := net.ResolveUDPAddr("udp", cfg.StatsdAddr)
+		report.check("STATSD_ADDR", err)
+	default:
+		report.errorf("METRICS_EXPORTER: unknown metrics exporter %q", cfg.MetricsExporter)
+	}
+	if cfg.GRPCAddr != "" {
+		_, _, err := net.SplitHostPort(cfg.GRPCAddr)
+		report.check("GRPC_ADDR", err)
+	}
+	if cfg.SMTPAddr != "" {
+		_, _, err := net.SplitHostPort(cfg.SMTPAddr)
+		report.check("SMTP_ADDR", err)
+	}
+
+	intervals := []struct {
+		env   string
+		value time.Duration
+	}{
+		{"DEDUPE_WINDOW", cfg.DedupeWindow},
+		{"AGGREGATION_INTERVAL", cfg.AggregationInterval},
+		{"DIGEST_CHECK_INTERVAL", cfg.DigestCheckInterval},
+		{"REPLICATION_INTERVAL", cfg.ReplicationInterval},
+		{"ROLLUP_INTERVAL", cfg.RollupInterval},
+		{"STORE_REPLICA_SYNC_INTERVAL", cfg.ReplicaSyncInterval},
+		{"ML_TRAIN_INTERVAL", cfg.MLTrainInterval},
+		{"STATSD_FLUSH_INTERVAL", cfg.StatsdFlushInterval},
+		{"READINESS_INTERVAL", cfg.ReadinessInterval},
+		{"RULE_SCHEDULE_INTERVAL", cfg.RuleScheduleCheck},
+		{"RULESET_RETENTION", cfg.RulesetRetention},
+		{"MONZO_TOKEN_REFRESH_INTERVAL", cfg.MonzoTokenRefresh},
+		{"MONZO_REAUTH_INTERVAL", cfg.MonzoReauthInterval},
+		{"MONZO_WEBHOOK_RECONCILE_INTERVAL", cfg.MonzoWebhookCheck},
+		{"MONZO_WRITEBACK_INTERVAL", cfg.MonzoWritebackEvery},
+		{"MONZO_SYNC_INTERVAL", cfg.MonzoSyncInterval},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			report.errorf("%s must be positive, got %s", interval.env, interval.value)
+		}
+	}
+	if cfg.DPEpsilon <= 0 {
+		report.errorf("DP_EPSILON must be positive, got %g", cfg.DPEpsilon)
+	}
+	if cfg.MonzoWriteback && cfg.MonzoWritebackBatch < 1 {
+		report.errorf("MONZO_WRITEBACK_BATCH must be positive, got %d", cfg.MonzoWritebackBatch)
+	}
+	if cfg.StickyConfirmations < 0 || cfg.StickyGlobal < 0 {
+		report.errorf("STICKY_CONFIRMATIONS and STICKY_GLOBAL_CONFIRMATIONS must not be negative; 0 turns stickiness off")
+	}
+	if cfg.FeedbackMinVotes < 0 {
+		report.errorf("FEEDBACK_MIN_VOTES must not be negative; 0 turns tenant and global feedback votes off")
+	}
+	if cfg.MLMinConfidence <= 0 || cfg.MLMinConfidence > 1 {
+		report.errorf("ML_MIN_CONFIDENCE must be in (0, 1], got %g", cfg.MLMinConfidence)
+	}
+	if cfg.IncomeRegularity < 0 {
+		report.errorf("INCOME_REGULAR_PAYMENTS must not be negative; 0 turns regularity detection off")
+	}
+	if cfg.RoundUpMultiplier <= 0 {
+		report.errorf("ROUNDUP_MULTIPLIER must be positive, got %g", cfg.RoundUpMultiplier)
+	}
+	return report
+}
+
+// loadRuleSetFile reads a rule set from YAML (.yaml or .yml) or JSON, normalizing its keywords
+// and compiling its patterns as the built-in rules are
+func loadRuleSetFile(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rs RuleSet
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&rs)
+	default:
+		err = json.Unmarshal(data, &rs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range rs.Rules {
+		rs.Rules[i].normalize()
+	}
+	return &rs, nil
+}
+
+// readRulesFile loads a rule set file, failing if it doesn't validate
+func readRulesFile(path string) (*RuleSet, error) {
+	rs, err := loadRuleSetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if report := validateRuleSet(rs); len(report.Errors) > 0 {
+		return nil, errors.New(strings.Join(report.Errors, "; "))
+	}
+	return rs, nil
+}
+
+// checkRulesFile loads a rule set file and fails if it doesn't validate
+func checkRulesFile(path string) error {
+	_, err := readRulesFile(path)
+	return err
+}
+
+// loadRules replaces the active rules with a validated rule set file
+func (s *Server) loadRules(path string) error {
+	rs, err := readRulesFile(path)
+	if err != nil {
+		return err
+	}
+	s.setActiveRules(rs)
+	return nil
+}
+
+// validateRuleSet checks every rule can fire as written and reports conflicts between rules
+// as warnings
+func validateRuleSet(rs *RuleSet) ValidationReport {
+	var report ValidationReport
+	if rs.Version == "" {
+		report.warnf("rule set has no version")
+	}
+	if len(rs.Rules) == 0 {
+		report.errorf("rule set has no rules")
+	}
+
+	names := map[string]bool{}
+	for i, rule := range rs.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			report.warnf("rule %s has no name", name)
+		} else if names[name] {
+			report.errorf("rule %q is defined twice", name)
+		}
+		names[rule.Name] = true
+
+		if strings.TrimSpace(rule.Category) == "" {
+			report.errorf("rule %q has no category", name)
+		}
+		if len(rule.Keywords) == 0 && len(rule.Patterns) == 0 {
+			report.errorf("rule %q has no keywords or patterns", name)
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := compileRulePattern(pattern); err != nil {
+				report.errorf("rule %q has an invalid pattern %q: %v", name, pattern, err)
+			}
+		}
+		keywords := map[string]bool{}
+		for _, keyword := range rule.Keywords {
+			if keyword == "" {
+				report.errorf("rule %q has an empty keyword", name)
+			} else if keywords[keyword] {
+				report.warnf("rule %q lists keyword %q twice", name, keyword)
+			}
+			keywords[keyword] = true
+		}
+		for _, field := range rule.Fields {
+			if field != FieldMerchant && field != FieldDescription {
+				report.errorf("rule %q matches unknown field %q", name, field)
+			}
+		}
+		if rule.MinAmount < 0 {
+			report.errorf("rule %q has a negative min_amount", name)
+		}
+		if rule.MaxAmount < 0 {
+			report.errorf("rule %q has a negative max_amount", name)
+		} else if rule.MaxAmount > 0 && rule.MaxAmount <= rule.MinAmount {
+			report.errorf("rule %q can never fire: max_amount is not above min_amount", name)
+		}
+	}
+
+	for _, conflict := range detectRuleConflicts(rs) {
+		report.warnf("%s", conflict.Message)
+	}
+	return report
+}
+
+// runValidateConfigCommand validates the configuration from the environment
+func runValidateConfigCommand(args []string) error {
+	fs := flag.NewFlagSet("--validate-config", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	report := validateConfig(loadConfig())
+	return report.print("config")
+}
+
+// runValidateRulesCommand validates a YAML or JSON rule set file, or the built-in rules without one
+func runValidateRulesCommand(args []string) error {
+	fs := flag.NewFlagSet("--validate-rules", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rs, subject := defaultRuleSet(), "built-in rules"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		loaded, err := loadRuleSetFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		rs, subject = loaded, fs.Arg(0)
+	default:
+		return errors.New("usage: --validate-rules [rules.yaml|rules.json]")
+	}
+	report := validateRuleSet(rs)
+	return report.print(subject)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build details, injected at build time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.gitSHA=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "1.0.0"
+	gitSHA    = "unknown"
+	buildTime = "unknown"
+)
+
+// BuildInfo describes the running binary and the classification data it serves
+type BuildInfo struct {
+	Version        string `json:"version"`
+	GitSHA         string `json:"git_sha"`
+	BuildTime      string `json:"build_time"`
+	GoVersion      string `json:"go_version"`
+	RulesetVersion string `json:"ruleset_version"`
+	ModelVersion   string `json:"model_version"`
+}
+
+// VersionedClassifier is a fallback classifier that reports its model version
+type VersionedClassifier interface {
+	ModelVersion() string
+}
+
+// currentBuildInfo returns the build details and the active ruleset and model versions
+func (s *Server) currentBuildInfo() BuildInfo {
+	model := "none"
+	if fallbackClassifier != nil {
+		model = "unversioned"
+		if versioned, ok := fallbackClassifier.(VersionedClassifier); ok {
+			model = versioned.ModelVersion()
+		}
+	}
+	return BuildInfo{
+		Version:        version,
+		GitSHA:         gitSHA,
+		BuildTime:      buildTime,
+		GoVersion:      runtime.Version(),
+		RulesetVersion: s.activeRules().Version,
+		ModelVersion:   model,
+	}
+}
+
+func (s *Server) handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, s.currentBuildInfo())
+}
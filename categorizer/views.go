@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SavedView is a named filter over a user's transactions. Each condition set must hold for
+// a transaction to match; within Categories or Merchants any one entry is enough. Views are
+// referenced by ID with ?view= on /history, /summary and /export/monzo and with view_id on
+// notification rules.
+type SavedView struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name" binding:"required"`
+	Categories []string  `json:"categories,omitempty"`
+	Merchants  []string  `json:"merchants,omitempty"`
+	MinAmount  *float64  `json:"min_amount,omitempty"`
+	MaxAmount  *float64  `json:"max_amount,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// validate checks the amount range and normalizes merchants the way incoming ones are. A
+// merchant that normalizes to nothing is rejected rather than dropped, since dropping every
+// merchant would widen the view to all of them.
+func (v *SavedView) validate() error {
+	if v.MinAmount != nil && v.MaxAmount != nil && *v.MinAmount > *v.MaxAmount {
+		return errors.New("min_amount must not be above max_amount")
+	}
+	merchants := make([]string, 0, len(v.Merchants))
+	for _, merchant := range v.Merchants {
+		normalized := resolveMerchant(normalizeDescriptor(merchant))
+		if normalized == "" {
+			return fmt.Errorf("merchant %q has no name left once normalized", merchant)
+		}
+		merchants = append(merchants, normalized)
+	}
+	v.Merchants = merchants
+	return nil
+}
+
+// Matches reports whether a transaction falls within the view
+func (v SavedView) Matches(merchant, category string, amount float64) bool {
+	if len(v.Categories) > 0 && !containsFold(v.Categories, category) {
+		return false
+	}
+	if len(v.Merchants) > 0 {
+		normalized := resolveMerchant(normalizeDescriptor(merchant))
+		matched := false
+		for _, m := range v.Merchants {
+			if strings.Contains(normalized, m) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if v.MinAmount != nil && amount < *v.MinAmount {
+		return false
+	}
+	if v.MaxAmount != nil && amount > *v.MaxAmount {
+		return false
+	}
+	return true
+}
+
+// Filter returns the transactions within the view
+func (v SavedView) Filter(transactions []StoredTransaction) []StoredTransaction {
+	kept := make([]StoredTransaction, 0, len(transactions))
+	for _, tx := range transactions {
+		if v.Matches(tx.Merchant, tx.Category, tx.Amount) {
+			kept = append(kept, tx)
+		}
+	}
+	return kept
+}
+
+// containsFold reports whether list holds s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// errViewNotFound is returned for unknown view IDs
+var errViewNotFound = errors.New("view not found")
+
+// errViewNameTaken is returned when a user already has a view with the same name
+var errViewNameTaken = errors.New("a view with this name already exists")
+
+// viewStore keeps each user's saved views in memory
+type viewStore struct {
+	mu    sync.RWMutex
+	views map[string][]SavedView
+}
+
+// newViewStore creates an empty view store
+func newViewStore() *viewStore {
+	return &viewStore{views: map[string][]SavedView{}}
+}
+
+// Add saves a view for its user. Names are unique per user, ignoring case.
+func (s *viewStore) Add(view SavedView) (SavedView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.views[view.UserID] {
+		if strings.EqualFold(existing.Name, view.Name) {
+			return SavedView{}, errViewNameTaken
+		}
+	}
+	view.ID = newID("view_")
+	view.CreatedAt = time.Now().UTC()
+	s.views[view.UserID] = append(s.views[view.UserID], view)
+	return view, nil
+}
+
+// Get returns one of a user's views
+func (s *viewStore) Get(userID, id string) (SavedView, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, view := range s.views[userID] {
+		if view.ID == id {
+			return view, nil
+		}
+	}
+	return SavedView{}, errViewNotFound
+}
+
+// List returns a user's views
+func (s *viewStore) List(userID string) []SavedView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]SavedView{}, s.views[userID]...)
+}
+
+// Remove deletes a user's view, reporting whether it existed
+func (s *viewStore) Remove(userID, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	views := s.views[userID]
+	for i, view := range views {
+		if view.ID == id {
+			s.views[userID] = append(views[:i:i], views[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns a copy of every user's views
+func (s *viewStore) Snapshot() map[string][]SavedView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string][]SavedView, len(s.views))
+	for userID, views := range s.views {
+		snapshot[userID] = append([]SavedView{}, views...)
+	}
+	return snapshot
+}
+
+// Restore replaces every user's views
+func (s *viewStore) Restore(views map[string][]SavedView) {
+	if views == nil {
+		views = map[string][]SavedView{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.views = views
+}
+
+// Global saved views
+var views = newViewStore()
+
+// requestView returns the view named by ?view=, or nil when the request has none
+func requestView(c *gin.Context, userID string) (*SavedView, error) {
+	id := c.Query("view")
+	if id == "" {
+		return nil, nil
+	}
+	view, err := views.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// handleCreateView serves POST /users/:user_id/views
+func (s *Server) handleCreateView(c *gin.Context) {
+	var view SavedView
+	if err := c.ShouldBindJSON(&view); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := view.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	view.UserID = c.Param("user_id")
+	saved, err := views.Add(view)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, saved)
+}
+
+// handleListViews serves GET /users/:user_id/views
+func (s *Server) handleListViews(c *gin.Context) {
+	userID := c.Param("user_id")
+	list := views.List(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"count":   len(list),
+		"views":   list,
+	})
+}
+
+// handleGetView serves GET /users/:user_id/views/:id
+func (s *Server) handleGetView(c *gin.Context) {
+	view, err := views.Get(c.Param("user_id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+// handleDeleteView serves DELETE /users/:user_id/views/:id, refusing to delete a view a
+// notification rule still uses
+func (s *Server) handleDeleteView(c *gin.Context) {
+	userID, id := c.Param("user_id"), c.Param("id")
+	for _, rule := range notifications.List(userID) {
+		if rule.ViewID == id {
+			c.JSON(http.StatusConflict, gin.H{"error": "view is used by notification rule " + rule.ID})
+			return
+		}
+	}
+	if !views.Remove(userID, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": errViewNotFound.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
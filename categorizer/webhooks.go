@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookSignatureHeader carries the HMAC-SHA256 of a delivery, as t=<unix time>,v1=<hex>
+// where the MAC covers "<unix time>.<body>" keyed with the subscription's secret
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// signWebhook signs a delivery body at a time with a subscription secret
+func signWebhook(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSubscription is a URL a tenant registered to receive its events. EventTypes filters
+// the events delivered; a type ending in ".*" matches every type with that prefix, and an
+// empty filter matches everything.
+type WebhookSubscription struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id" binding:"required"`
+	URL        string    `json:"url" binding:"required"`
+	EventTypes []string  `json:"event_types,omitempty"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Delivery outcomes, for tenants debugging their endpoint
+	Delivered    int        `json:"delivered"`
+	Failed       int        `json:"failed"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// redacted returns the subscription without its secret, which is only shown when created
+func (s WebhookSubscription) redacted() WebhookSubscription {
+	s.Secret = ""
+	s.EventTypes = append([]string(nil), s.EventTypes...)
+	return s
+}
+
+// validate checks the URL and event filters. URLs naming a host inside the network are
+// rejected here; the subscriber client rejects them again at dial time, after DNS.
+func (s WebhookSubscription) validate() error {
+	if err := validateURL(s.URL); err != nil {
+		return err
+	}
+	u, _ := url.Parse(s.URL)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); ip != nil && !publicAddress(ip) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPrivateAddress
+	}
+	for _, filter := range s.EventTypes {
+		if !containsString(knownEventTypes(), filter) && !isEventPrefix(strings.TrimSuffix(filter, "*")) {
+			return fmt.Errorf("unknown event type %q", filter)
+		}
+	}
+	return nil
+}
+
+// isEventPrefix reports whether a filter like "transaction." is a prefix of a known event type
+func isEventPrefix(prefix string) bool {
+	if !strings.HasSuffix(prefix, ".") {
+		return false
+	}
+	for _, eventType := range knownEventTypes() {
+		if strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the subscription wants an event
+func (s WebhookSubscription) matches(event Event) bool {
+	if event.TenantID != s.TenantID {
+		return false
+	}
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, filter := range s.EventTypes {
+		if filter == event.Type || (strings.HasSuffix(filter, ".*") && strings.HasPrefix(event.Type, strings.TrimSuffix(filter, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// errSubscriptionNotFound is returned for unknown subscription IDs
+var errSubscriptionNotFound = errors.New("subscription not found")
+
+// webhookRegistry keeps webhook subscriptions in memory
+type webhookRegistry struct {
+	mu            sync.RWMutex
+	subscriptions map[string]*WebhookSubscription
+}
+
+// newWebhookRegistry creates an empty registry
+func newWebhookRegistry() *webhookRegistry {
+	return &webhookRegistry{subscriptions: map[string]*WebhookSubscription{}}
+}
+
+// Add registers a subscription, generating a secret when none is given
+func (r *webhookRegistry) Add(sub WebhookSubscription) WebhookSubscription {
+	sub.ID = newID("whs_")
+	if sub.Secret == "" {
+		sub.Secret = newID("whsec_")
+	}
+	sub.CreatedAt = time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscriptions[sub.ID] = &sub
+	return sub
+}
+
+// Get returns a subscription, secret included
+func (r *webhookRegistry) Get(id string) (WebhookSubscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sub, ok := r.subscriptions[id]
+	if !ok {
+		return WebhookSubscription{}, errSubscriptionNotFound
+	}
+	return *sub, nil
+}
+
+// List returns a tenant's subscriptions, or every subscription when tenantID is empty, oldest first
+func (r *webhookRegistry) List(tenantID string) []WebhookSubscription {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := []WebhookSubscription{}
+	for _, sub := range r.subscriptions {
+		if tenantID == "" || sub.TenantID == tenantID {
+			list = append(list, sub.redacted())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Remove deletes a subscription, reporting whether it existed
+func (r *webhookRegistry) Remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subscriptions[id]; !ok {
+		return false
+	}
+	delete(r.subscriptions, id)
+	return true
+}
+
+// Matching returns the subscriptions an event should be delivered to
+func (r *webhookRegistry) Matching(event Event) []WebhookSubscription {
+	if event.TenantID == "" {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var matched []WebhookSubscription
+	for _, sub := range r.subscriptions {
+		if sub.matches(event) {
+			matched = append(matched, *sub)
+		}
+	}
+	return matched
+}
+
+// Deliver sends an event to a subscription through srv and records the outcome against it
+func (r *webhookRegistry) Deliver(srv *Server, sub WebhookSubscription, event Event) error {
+	err := srv.deliverEvent(subscriberClient, sub.URL, sub.Secret, event)
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current, ok := r.subscriptions[sub.ID]; ok {
+		current.LastDelivery = &now
+		if err != nil {
+			current.Failed++
+			current.LastError = err.Error()
+		} else {
+			current.Delivered++
+			current.LastError = ""
+		}
+	}
+	return err
+}
+
+// errPrivateAddress is returned for subscriber URLs that reach into the service's own network
+var errPrivateAddress = errors.New("webhook URL must not resolve to a private, loopback or link-local address")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice though not RFC1918
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddress reports whether ip is reachable on the public internet
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// subscriberClient delivers to tenant subscriptions. Its dialer checks every address it
+// connects to, after DNS resolution and on redirects, so a subscription can't be used to
+// reach or probe hosts inside the network, cloud metadata included. It ignores proxy
+// settings, which would hide the destination from that check.
+var subscriberClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// ownSubscription returns a subscription when the caller may act for its tenant. Another
+// tenant's subscription is reported as not found, so IDs can't be probed.
+func ownSubscription(c *gin.Context, id string) (WebhookSubscription, error) {
+	sub, err := webhooks.Get(id)
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	if _, err := scopeTenant(c, sub.TenantID); err != nil {
+		return WebhookSubscription{}, errSubscriptionNotFound
+	}
+	return sub, nil
+}
+
+// Global webhook subscriptions
+var webhooks = newWebhookRegistry()
+
+// handleCreateWebhookSubscription serves POST /subscriptions. The response is the only time
+// the secret is returned.
+func (s *Server) handleCreateWebhookSubscription(c *gin.Context) {
+	var sub WebhookSubscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := scopeTenant(c, sub.TenantID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sub.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sub.CreatedBy = callerName(c)
+	sub.Delivered, sub.Failed, sub.LastDelivery, sub.LastError = 0, 0, nil, ""
+	c.JSON(http.StatusCreated, webhooks.Add(sub))
+}
+
+// handleListWebhookSubscriptions serves GET /subscriptions?tenant_id=
+func (s *Server) handleListWebhookSubscriptions(c *gin.Context) {
+	tenantID := c.Query("tenant_id")
+	if tenantID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id is required"})
+		return
+	}
+	list := webhooks.List(tenantID)
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id":     tenantID,
+		"count":         len(list),
+		"subscriptions": list,
+	})
+}
+
+// handleDeleteWebhookSubscription serves DELETE /subscriptions/:id
+func (s *Server) handleDeleteWebhookSubscription(c *gin.Context) {
+	if _, err := ownSubscription(c, c.Param("id")); err != nil || !webhooks.Remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": errSubscriptionNotFound.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleTestWebhookSubscription serves POST /subscriptions/:id/test, delivering a webhook.test
+// event synchronously so the caller sees whether their endpoint accepted it
+func (s *Server) handleTestWebhookSubscription(c *gin.Context) {
+	sub, err := ownSubscription(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	event := Event{
+		ID:        newID("evt_"),
+		Type:      EventWebhookTest,
+		Version:   eventVersion(EventWebhookTest),
+		TenantID:  sub.TenantID,
+		CreatedAt: time.Now().UTC(),
+		Data:      gin.H{"subscription_id": sub.ID},
+	}
+	if err := webhooks.Deliver(s, sub, event); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"delivered": false, "event": event, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivered": true, "event": event})
+}